@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"github.com/jendahorak/bangerid/internal/annotations"
+	"github.com/jendahorak/bangerid/internal/apitokens"
+	"github.com/jendahorak/bangerid/internal/bots"
+	"github.com/jendahorak/bangerid/internal/eventlog"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/history"
+	"github.com/jendahorak/bangerid/internal/jobs"
+	"github.com/jendahorak/bangerid/internal/kiosks"
+	"github.com/jendahorak/bangerid/internal/library"
+	"github.com/jendahorak/bangerid/internal/prefs"
+	"github.com/jendahorak/bangerid/internal/share"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+	"github.com/jendahorak/bangerid/internal/timeline"
+)
+
+// usageRow is one user's API usage as shown on the admin page
+type usageRow struct {
+	spotifyClient.Usage
+	Classes   []classCount
+	Remaining int
+	Percent   int // share of the daily budget used, capped at 100
+}
+
+type classCount struct {
+	Class string
+	Count int
+}
+
+// adminHandler renders the admin page with per-user Spotify API usage and the state of
+// the background jobs
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	var rows []usageRow
+	for _, u := range spotifyClient.UsageReport() {
+		row := usageRow{Usage: u}
+		for class, n := range u.Today {
+			row.Classes = append(row.Classes, classCount{Class: class, Count: n})
+		}
+		sort.Slice(row.Classes, func(i, j int) bool {
+			return row.Classes[i].Count > row.Classes[j].Count
+		})
+
+		if cfg.APIDailyBudget > 0 {
+			row.Remaining = max(cfg.APIDailyBudget-u.TodayTotal, 0)
+			row.Percent = min(u.TodayTotal*100/cfg.APIDailyBudget, 100)
+		}
+		rows = append(rows, row)
+	}
+
+	data := struct {
+		LoggedIn bool
+		Budget   int
+		Usage    []usageRow
+		Jobs     jobs.Status
+	}{
+		LoggedIn: true,
+		Budget:   cfg.APIDailyBudget,
+		Usage:    rows,
+		Jobs:     jobs.Report(),
+	}
+
+	renderTemplate(w, data, "web/templates/admin.html", "web/templates/header.html")
+}
+
+// accountsHandler renders the accounts admins can suspend or delete
+func accountsHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		CurrentID string
+		Accounts  []handlers.Account
+	}{
+		CurrentID: handlers.CurrentSession(r).UserID,
+		Accounts:  handlers.Accounts(),
+	}
+	renderTemplate(w, data, "web/templates/accounts.html")
+}
+
+// suspendHandler blocks a user from signing in and pauses background work for them,
+// then re-renders the accounts
+func suspendHandler(w http.ResponseWriter, r *http.Request) {
+	admin := handlers.CurrentSession(r).UserID
+	userID := r.PathValue("id")
+	if userID == admin {
+		http.Error(w, "You can't suspend yourself", http.StatusBadRequest)
+		return
+	}
+
+	if err := handlers.Suspend(userID); err != nil {
+		slog.Error("failed to suspend user", "user", userID, slog.Any("error", err))
+		http.Error(w, "Failed to suspend user", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("user suspended", "user", userID, "admin", admin)
+	accountsHandler(w, r)
+}
+
+// unsuspendHandler lets a suspended user sign in again and re-renders the accounts
+func unsuspendHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	if err := handlers.Unsuspend(userID); err != nil {
+		slog.Error("failed to unsuspend user", "user", userID, slog.Any("error", err))
+		http.Error(w, "Failed to unsuspend user", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("user unsuspended", "user", userID, "admin", handlers.CurrentSession(r).UserID)
+	accountsHandler(w, r)
+}
+
+// deleteUserHandler signs a user out everywhere and deletes everything we keep about
+// them, then re-renders the accounts. A suspension stays in place, so a user can be
+// deleted and kept out at once.
+func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	admin := handlers.CurrentSession(r).UserID
+	userID := r.PathValue("id")
+	if userID == admin {
+		http.Error(w, "You can't delete yourself", http.StatusBadRequest)
+		return
+	}
+
+	handlers.LogoutEverywhere(userID)
+	// Drop the refresh tokens from disk now rather than with the next periodic save
+	err := errors.Join(handlers.SaveSessions(), deleteUserData(userID))
+	if err != nil {
+		slog.Error("failed to delete user data", "user", userID, slog.Any("error", err))
+		http.Error(w, "Failed to delete user data", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("user data deleted", "user", userID, "admin", admin)
+	accountsHandler(w, r)
+}
+
+// deleteUserData removes the user from every store. API usage counters are kept, they
+// are only in memory and tell admins where the calls went.
+func deleteUserData(userID string) error {
+	return errors.Join(
+		library.Delete(userID),
+		annotations.Delete(userID),
+		timeline.Delete(userID),
+		history.Delete(userID),
+		prefs.Delete(userID),
+		share.Delete(userID),
+		apitokens.Delete(userID),
+		bots.Delete(userID),
+		kiosks.Delete(userID),
+		eventlog.Delete(userID),
+	)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/applemusic"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/harmony"
+	"github.com/jendahorak/bangerid/internal/prefs"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// appleMusicResult is what the Apple Music export template shows
+type appleMusicResult struct {
+	Name    string
+	Matched int
+	Missing int // tracks the catalog doesn't have, or without an ISRC to look them up by
+}
+
+// appleMusicSongs converts tracks for the Apple Music export
+func appleMusicSongs(tracks []spotifyClient.Track) []applemusic.Song {
+	songs := make([]applemusic.Song, len(tracks))
+	for i, t := range tracks {
+		songs[i] = applemusic.Song{
+			Title:    t.Name,
+			Artist:   t.ArtistNames(),
+			Album:    t.Album,
+			ISRC:     t.ISRC,
+			Duration: t.Duration,
+		}
+	}
+	return songs
+}
+
+// appleMusicCSVHandler downloads the liked songs grid, with the same filters and sort
+// order as /grid, as a CSV track list for the tools that import into Apple Music
+func appleMusicCSVHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid query", http.StatusBadRequest)
+		return
+	}
+	tracks, err := exportTracks(r, session.UserID, accessToken)
+	if errors.Is(err, harmony.ErrInvalidKey) || errors.Is(err, errInvalidFilter) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load tracks", http.StatusInternalServerError)
+		return
+	}
+
+	filename := "bangerid-" + time.Now().In(prefs.Get(session.UserID).Location()).Format("2006-01-02") + ".csv"
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	if err := applemusic.WriteCSV(w, appleMusicSongs(tracks)); err != nil {
+		slog.Warn("failed to write CSV export", slog.Any("error", err))
+	}
+}
+
+// appleMusicExportHandler saves tracks as a new Apple Music playlist, matched by ISRC.
+// The page signs the user in to Apple Music with MusicKit JS and posts the user token it
+// got as music_user_token; the tracks are picked like exportHandler's.
+func appleMusicExportHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	userToken := r.PostFormValue("music_user_token")
+	if userToken == "" {
+		renderToast(w, http.StatusBadRequest, "Sign in to Apple Music first.")
+		return
+	}
+
+	tracks, err := exportTracks(r, session.UserID, accessToken)
+	if errors.Is(err, harmony.ErrInvalidKey) || errors.Is(err, errInvalidFilter) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load tracks", http.StatusInternalServerError)
+		return
+	}
+
+	var isrcs []string
+	for _, t := range tracks {
+		if t.ISRC != "" {
+			isrcs = append(isrcs, strings.ToUpper(t.ISRC))
+		}
+	}
+	catalogIDs, err := appleMusic.CatalogIDs(r.Context(), isrcs)
+	if err != nil {
+		slog.Error("apple music lookup failed", slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Apple Music didn't answer, try again in a bit.")
+		return
+	}
+
+	var songIDs []string
+	seen := make(map[string]bool)
+	for _, isrc := range isrcs {
+		if id, ok := catalogIDs[isrc]; ok && !seen[id] {
+			seen[id] = true
+			songIDs = append(songIDs, id)
+		}
+	}
+	if len(songIDs) == 0 {
+		renderToast(w, http.StatusUnprocessableEntity, "None of these songs were found on Apple Music.")
+		return
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" {
+		name = "Bangerid " + time.Now().In(prefs.Get(session.UserID).Location()).Format("2006-01-02")
+	}
+	if len([]rune(name)) > maxPlaylistName {
+		name = string([]rune(name)[:maxPlaylistName])
+	}
+
+	playlistID, err := appleMusic.CreatePlaylist(r.Context(), userToken, name, "Exported from Bangerid", songIDs)
+	if errors.Is(err, applemusic.ErrUnauthorized) {
+		renderToast(w, http.StatusForbidden, "Apple Music didn't accept the sign-in, sign in again.")
+		return
+	}
+	if err != nil {
+		slog.Error("apple music export failed", slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Failed to create the Apple Music playlist.")
+		return
+	}
+
+	slog.Info("apple music playlist exported", "playlist", playlistID, "tracks", len(songIDs), "tracks_missing", len(tracks)-len(songIDs))
+	renderTemplate(w, appleMusicResult{
+		Name:    name,
+		Matched: len(songIDs),
+		Missing: len(tracks) - len(songIDs),
+	}, "web/templates/applemusic.html")
+}
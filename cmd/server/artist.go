@@ -0,0 +1,216 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/history"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// likedByArtist is one of the user's liked tracks on the artist page
+type likedByArtist struct {
+	Track      spotifyClient.Track
+	LastPlayed time.Time // zero if we never saw it played
+}
+
+// discographyAlbum is one release on the artist page
+type discographyAlbum struct {
+	Album spotifyClient.Album
+	Liked int  // the user's liked tracks from it
+	Saved bool // the album itself is in the user's library
+}
+
+// Unexplored reports whether the user has nothing from the album yet
+func (a discographyAlbum) Unexplored() bool {
+	return a.Liked == 0 && !a.Saved
+}
+
+// artistHandler renders the deep dive into one artist: the user's liked tracks by them
+// with when they were liked and last played, and the artist's discography with the
+// albums the user hasn't explored yet highlighted.
+func artistHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+	artistID := r.PathValue("id")
+	if !validSpotifyID(artistID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	tracks, err := library.Tracks(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load artist", http.StatusInternalServerError)
+		return
+	}
+	saved, err := library.Albums(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch albums", slog.Any("error", err))
+		http.Error(w, "Failed to load artist", http.StatusInternalServerError)
+		return
+	}
+	followed, err := library.Artists(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch artists", slog.Any("error", err))
+		http.Error(w, "Failed to load artist", http.StatusInternalServerError)
+		return
+	}
+
+	artist := spotifyClient.Artist{ID: artistID, URI: "spotify:artist:" + artistID}
+	if i := slices.IndexFunc(followed, func(a spotifyClient.Artist) bool { return a.ID == artistID }); i >= 0 {
+		artist = followed[i]
+	}
+	if known, ok := library.TrackArtists(session.UserID)[artistID]; ok && len(artist.Genres) == 0 {
+		// Followed artists synced before we kept genres, or artists the user only has tracks of
+		artist.Genres, artist.Popularity = known.Genres, known.Popularity
+	}
+
+	// What the user has from the artist, from our own data
+	lastPlayed := history.LastPlayed(session.UserID)
+	var liked []likedByArtist
+	likedPerAlbum := make(map[string]int)
+	for _, track := range tracks {
+		if !track.HasArtist(artistID) {
+			continue
+		}
+		liked = append(liked, likedByArtist{
+			Track:      track,
+			LastPlayed: lastPlayed[spotifyClient.IDFromURI(track.ID)],
+		})
+		likedPerAlbum[track.AlbumID]++
+		if artist.Name == "" {
+			for _, a := range track.Artists {
+				if a.ID == artistID {
+					artist.Name = a.Name
+				}
+			}
+		}
+	}
+	savedAlbums := make(map[string]bool, len(saved))
+	for _, album := range saved {
+		savedAlbums[album.ID] = true
+	}
+
+	// What the artist has released, from Spotify. The demo has no Spotify to ask, so it
+	// makes do with the saved albums.
+	var discography []discographyAlbum
+	if cfg.DemoMode {
+		for _, album := range saved {
+			if album.Artist == artist.Name {
+				discography = append(discography, discographyAlbum{Album: album, Liked: likedPerAlbum[album.ID], Saved: true})
+			}
+		}
+	} else {
+		if artist.Name == "" {
+			a, err := spotifyClient.GetArtist(r.Context(), accessToken, artistID)
+			if err != nil {
+				slog.Error("failed to fetch artist", "artist", artistID, slog.Any("error", err))
+				http.Error(w, "Failed to load artist", http.StatusInternalServerError)
+				return
+			}
+			artist = *a
+		}
+
+		albums, err := spotifyClient.FetchArtistAlbums(r.Context(), accessToken, artistID)
+		if err != nil {
+			// The liked tracks are worth showing on their own
+			slog.Warn("failed to fetch discography", "artist", artistID, slog.Any("error", err))
+		}
+		for _, album := range albums {
+			discography = append(discography, discographyAlbum{
+				Album: album,
+				Liked: likedPerAlbum[album.ID],
+				Saved: savedAlbums[album.ID],
+			})
+		}
+	}
+	if artist.Name == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Newest releases first, like Spotify shows a discography
+	slices.SortStableFunc(discography, func(a, b discographyAlbum) int {
+		return cmp.Compare(b.Album.Year, a.Album.Year)
+	})
+
+	data := struct {
+		Artist      spotifyClient.Artist
+		Liked       []likedByArtist
+		Tiles       []gridTile
+		Discography []discographyAlbum
+		Unexplored  int
+	}{
+		Artist:      artist,
+		Liked:       liked,
+		Discography: discography,
+	}
+	likedTracks := make([]spotifyClient.Track, len(liked))
+	for i, l := range liked {
+		likedTracks[i] = l.Track
+	}
+	data.Tiles = trackTiles(session.UserID, likedTracks)
+	for _, album := range discography {
+		if album.Unexplored() {
+			data.Unexplored++
+		}
+	}
+
+	renderTemplate(w, data, "web/templates/artist.html", "web/templates/grid.html")
+}
+
+// relatedArtist is an artist similar to the one on the artist page
+type relatedArtist struct {
+	Artist spotifyClient.Artist
+	Liked  int // the user's liked tracks crediting them
+}
+
+// relatedArtistsHandler renders the artists Spotify considers similar to one, for the
+// artist page. Those the user has no liked songs by yet are highlighted, they're the
+// discoveries. The demo has no Spotify to ask and renders nothing.
+func relatedArtistsHandler(w http.ResponseWriter, r *http.Request) {
+	artistID := r.PathValue("id")
+	if !validSpotifyID(artistID) {
+		http.NotFound(w, r)
+		return
+	}
+	if cfg.DemoMode {
+		renderTemplate(w, []relatedArtist(nil), "web/templates/related.html")
+		return
+	}
+
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	artists, err := spotifyClient.GetRelatedArtists(r.Context(), accessToken, artistID)
+	if err != nil {
+		slog.Error("failed to fetch related artists", "artist", artistID, slog.Any("error", err))
+		http.Error(w, "Failed to load related artists", http.StatusInternalServerError)
+		return
+	}
+	tracks, err := library.Tracks(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load related artists", http.StatusInternalServerError)
+		return
+	}
+
+	liked := make(map[string]int)
+	for _, track := range tracks {
+		for _, a := range track.Artists {
+			liked[a.ID]++
+		}
+	}
+	related := make([]relatedArtist, len(artists))
+	for i, a := range artists {
+		related[i] = relatedArtist{Artist: a, Liked: liked[a.ID]}
+	}
+
+	renderTemplate(w, related, "web/templates/related.html")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/library"
+	"github.com/jendahorak/bangerid/internal/prompt"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// askQueueSize is how many of the matching tracks a free-text request queues
+const askQueueSize = 50
+
+// askHandler renders the liked tracks a free-text request (?q=) asks for, like "sad 90s
+// bangers for a rainy run", with a shuffled queue of them to play. A language model turns
+// the request into a filter, the library never leaves the server.
+func askHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	filter, err := interpreter.Interpret(r.Context(), r.FormValue("q"))
+	if errors.Is(err, prompt.ErrEmptyPrompt) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to interpret request", slog.Any("error", err))
+		http.Error(w, "Failed to understand the request", http.StatusBadGateway)
+		return
+	}
+
+	matching, err := likedMatching(r.Context(), session.UserID, accessToken, filter)
+	if err != nil {
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load tracks", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Prompt string
+		Filter prompt.Filter
+		Tiles  []gridTile
+		Queue  []string
+	}{
+		Prompt: r.FormValue("q"),
+		Filter: filter,
+		Tiles:  trackTiles(session.UserID, matching),
+		Queue:  shuffledQueue(matching, askQueueSize),
+	}
+	renderTemplate(w, data, "web/templates/ask.html", "web/templates/grid.html")
+}
+
+// likedMatching returns the user's liked tracks a filter matches, in library order
+func likedMatching(ctx context.Context, userID, accessToken string, filter prompt.Filter) ([]spotifyClient.Track, error) {
+	tracks, err := library.Tracks(ctx, userID, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	spotifyClient.MergeAudioFeatures(tracks, library.Features(userID))
+	artists := library.TrackArtists(userID)
+
+	return slices.DeleteFunc(tracks, func(t spotifyClient.Track) bool {
+		var genres []string
+		for _, a := range t.Artists {
+			genres = append(genres, artists[a.ID].Genres...)
+		}
+		return !filter.Matches(t, genres)
+	}), nil
+}
+
+// shuffledQueue returns the URIs of up to n of the tracks, in random order
+func shuffledQueue(tracks []spotifyClient.Track, n int) []string {
+	queue := make([]string, len(tracks))
+	for i, t := range tracks {
+		queue[i] = t.ID
+	}
+	rand.Shuffle(len(queue), func(i, j int) { queue[i], queue[j] = queue[j], queue[i] })
+	if len(queue) > n {
+		queue = queue[:n]
+	}
+	return queue
+}
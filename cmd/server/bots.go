@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/bots"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/library"
+	"github.com/jendahorak/bangerid/internal/playback"
+	"github.com/jendahorak/bangerid/internal/prefs"
+	"github.com/jendahorak/bangerid/internal/prompt"
+)
+
+const (
+	// digestInterval is how often connected users get a digest of their new likes
+	digestInterval = 7 * 24 * time.Hour
+	// digestHour is when on Mondays digests are sent, in the user's time zone
+	digestHour = 9
+	// digestCheckInterval is how often it is checked whose digest is due
+	digestCheckInterval = time.Hour
+	// maxDigestTracks is how many new likes a digest lists by name
+	maxDigestTracks = 10
+)
+
+var (
+	telegramBot *bots.TelegramBot // nil unless a Telegram bot is configured
+	discordBot  *bots.DiscordBot  // nil unless a Discord bot is configured
+)
+
+// botHelp lists what the bots understand, the same on both services
+var botHelp = "Commands:\n" +
+	"/now - what is playing\n" +
+	"/play <mood or genre> - shuffle liked songs, moods are " + strings.Join(moodNames(), ", ") + "\n" +
+	"/digest - the songs you liked this week\n" +
+	"/disconnect - stop the digests and commands"
+
+// moodNames lists the moods that can be played, sorted
+func moodNames() []string {
+	return slices.Sorted(maps.Keys(moods))
+}
+
+// startBots sets up the configured chat bots: registers the Telegram webhook with a fresh
+// secret and the Discord slash commands, and starts sending the weekly digests
+func startBots(ctx context.Context) error {
+	if cfg.TelegramBotToken != "" {
+		secret := make([]byte, 24)
+		rand.Read(secret)
+		telegramBot = bots.NewTelegramBot(cfg.TelegramBotToken, hex.EncodeToString(secret))
+		if err := telegramBot.SetWebhook(ctx, cfg.TelegramWebhookURL); err != nil {
+			return err
+		}
+		slog.Info("telegram bot enabled", "webhook", cfg.TelegramWebhookURL)
+	}
+
+	if cfg.DiscordAppID != "" {
+		var err error
+		if discordBot, err = bots.NewDiscordBot(cfg.DiscordAppID, cfg.DiscordPublicKey, cfg.DiscordBotToken); err != nil {
+			return err
+		}
+		err = discordBot.RegisterCommands(ctx, []bots.DiscordCommand{
+			{Name: "link", Description: "Connect your Bangerid account", Option: "code", OptionHelp: "The code from the Bangerid settings"},
+			{Name: "now", Description: "What is playing"},
+			{Name: "play", Description: "Shuffle liked songs of a mood or genre", Option: "mood", OptionHelp: "A mood, like " + strings.Join(moodNames(), ", ") + ", or a genre"},
+			{Name: "digest", Description: "The songs you liked this week"},
+			{Name: "disconnect", Description: "Stop the digests and commands"},
+		})
+		if err != nil {
+			return err
+		}
+		slog.Info("discord bot enabled", "application", cfg.DiscordAppID)
+	}
+
+	if telegramBot != nil || discordBot != nil {
+		go sendDigests(ctx)
+	}
+	return nil
+}
+
+// telegramWebhookHandler answers the messages sent to the Telegram bot. The answer goes
+// back in the webhook response, which the Bot API takes as a sendMessage call.
+func telegramWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	message, err := telegramBot.ReadUpdate(r)
+	if errors.Is(err, bots.ErrUnverified) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		// Telegram retries failed deliveries, a broken update won't get better
+		slog.Warn("failed to read telegram update", slog.Any("error", err))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if message == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	reply := "Talk to me in a private chat."
+	if message.Private {
+		command := message.Command
+		if command == "start" && message.Args != "" {
+			command = "link"
+		}
+		reply = runBotCommand(r.Context(), bots.Telegram, message.ChatID, command, message.Args)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"method": "sendMessage", "chat_id": message.ChatID, "text": reply})
+}
+
+// discordInteractionsHandler answers the slash commands run with the Discord bot
+func discordInteractionsHandler(w http.ResponseWriter, r *http.Request) {
+	interaction, err := discordBot.ReadInteraction(r)
+	if errors.Is(err, bots.ErrUnverified) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		slog.Warn("failed to read discord interaction", slog.Any("error", err))
+		http.Error(w, "Invalid interaction", http.StatusBadRequest)
+		return
+	}
+	if interaction == nil {
+		http.Error(w, "Unsupported interaction", http.StatusBadRequest)
+		return
+	}
+
+	var response any
+	if interaction.Ping {
+		response = bots.PongResponse()
+	} else {
+		response = bots.MessageResponse(runBotCommand(r.Context(), bots.Discord, interaction.UserID, interaction.Command, interaction.Option))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// runBotCommand carries out a command sent from a chat and returns the answer
+func runBotCommand(ctx context.Context, service bots.Service, chat, command, args string) string {
+	if command == "link" {
+		if _, ok := bots.Connect(service, chat, args); !ok {
+			return "That code didn't work. Create a new one in the Bangerid settings, it is valid for 15 minutes."
+		}
+		slog.Info("chat bot connected", "service", service)
+		return "Connected! You'll get a digest of your new likes every week.\n\n" + botHelp
+	}
+
+	userID, ok := bots.User(service, chat)
+	if !ok {
+		return "Connect your account first: create a code on the Bangerid settings page and send it here with /link <code>."
+	}
+	if command == "disconnect" {
+		if err := bots.Disconnect(userID, service); err != nil {
+			slog.Error("failed to disconnect chat bot", slog.Any("error", err))
+			return "Something went wrong, try again in a bit."
+		}
+		return "Disconnected. Connect again from the Bangerid settings anytime."
+	}
+
+	ctx, err := handlers.UserContext(ctx, oauthApps, userID)
+	if err != nil {
+		slog.Warn("no session for chat bot", "user", userID, slog.Any("error", err))
+		return "Sign in to Bangerid again, your session expired."
+	}
+
+	switch command {
+	case "now":
+		return nowPlayingText(ctx)
+	case "play":
+		return playText(ctx, args)
+	case "digest":
+		text, _, err := digestText(ctx, userID, time.Now().Add(-digestInterval))
+		if err != nil {
+			slog.Error("failed to build digest", slog.Any("error", err))
+			return "Couldn't load your likes, try again in a bit."
+		}
+		return text
+	default:
+		return botHelp
+	}
+}
+
+// nowPlayingText says what the user of ctx is playing
+func nowPlayingText(ctx context.Context) string {
+	session := ctx.Value(handlers.SessionKey).(handlers.Session)
+	if !featureAvailable("player-state", session) {
+		return "Sign in to Bangerid again to let me see what is playing."
+	}
+	state, err := playerStates.Get(ctx, session.UserID)
+	if err != nil {
+		slog.Error("failed to fetch playback state", slog.Any("error", err))
+		return "Spotify didn't answer, try again in a bit."
+	}
+	if state == nil || state.Track == nil {
+		return "Nothing is playing."
+	}
+
+	verb := "Playing"
+	if !state.Playing {
+		verb = "Paused"
+	}
+	return fmt.Sprintf("%s: %s by %s, on %s", verb, state.Track.Name, state.Track.ArtistNames(), state.Device.Name)
+}
+
+// playText plays a mood or genre for the user of ctx on their active device, see /play
+func playText(ctx context.Context, what string) string {
+	what = strings.ToLower(strings.TrimSpace(what))
+	if what == "" {
+		return "Play what? Try /play " + moodNames()[0] + " or /play jazz."
+	}
+	filter, ok := moods[what]
+	if !ok {
+		filter = prompt.Filter{Genres: []string{what}}
+	}
+
+	session := ctx.Value(handlers.SessionKey).(handlers.Session)
+	target := playback.Target{
+		AccessToken: ctx.Value(handlers.AccessTokenKey).(string),
+		FindDevice:  session.HasScope("user-read-playback-state"),
+	}
+	queued, err := playMatching(ctx, session.UserID, target, filter)
+	switch {
+	case errors.Is(err, errNothingMatches):
+		return "None of your liked songs are " + what + "."
+	case errors.Is(err, playback.ErrNoDevice):
+		return "No device to play on, open Spotify somewhere first."
+	case err != nil:
+		slog.Error("chat bot playback failed", slog.Any("error", err))
+		return "Spotify didn't start playing, is it still open somewhere?"
+	}
+	slog.Info("chat bot started playback", "tracks", queued)
+	return fmt.Sprintf("Shuffling %d %s songs.", queued, what)
+}
+
+// digestText lists the songs the user of ctx liked since a time. It reports false when
+// there are none, so no digest needs to be sent.
+func digestText(ctx context.Context, userID string, since time.Time) (string, bool, error) {
+	accessToken := ctx.Value(handlers.AccessTokenKey).(string)
+	tracks, err := library.RecentlyAdded(ctx, userID, accessToken, since)
+	if err != nil {
+		return "", false, err
+	}
+	if len(tracks) == 0 {
+		return "No new likes this week.", false, nil
+	}
+
+	var b strings.Builder
+	if len(tracks) == 1 {
+		b.WriteString("1 new banger liked this week:\n")
+	} else {
+		fmt.Fprintf(&b, "%d new bangers liked this week:\n", len(tracks))
+	}
+	for _, t := range tracks[:min(len(tracks), maxDigestTracks)] {
+		fmt.Fprintf(&b, "- %s by %s\n", t.Name, t.ArtistNames())
+	}
+	if len(tracks) > maxDigestTracks {
+		fmt.Fprintf(&b, "and %d more", len(tracks)-maxDigestTracks)
+	}
+	return strings.TrimSpace(b.String()), true, nil
+}
+
+// sendDigests sends the weekly digests of the connected users as they come due, see
+// digestDue, until ctx is done. A week without new likes sends nothing.
+func sendDigests(ctx context.Context) {
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, userID := range bots.Users() {
+			link, ok := bots.Get(userID)
+			if !ok || !digestDue(link, prefs.Get(userID).Location(), time.Now()) {
+				continue
+			}
+			if err := sendDigest(ctx, userID, link); err != nil {
+				slog.Warn("failed to send digest", "user", userID, slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// digestDue reports whether a user's digest is due: on Monday mornings in their time
+// zone, once a week
+func digestDue(link bots.Link, loc *time.Location, now time.Time) bool {
+	now = now.In(loc)
+	daysSinceMonday := (int(now.Weekday()) + 6) % 7
+	monday := time.Date(now.Year(), now.Month(), now.Day()-daysSinceMonday, digestHour, 0, 0, 0, loc)
+	if monday.After(now) {
+		monday = monday.AddDate(0, 0, -7)
+	}
+	return link.LastDigest.Before(monday)
+}
+
+// sendDigest sends a user the songs they liked since the last digest, to every chat they
+// connected
+func sendDigest(ctx context.Context, userID string, link bots.Link) error {
+	ctx, err := handlers.UserContext(ctx, oauthApps, userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	text, liked, err := digestText(ctx, userID, link.LastDigest)
+	if err != nil {
+		return err
+	}
+
+	if liked {
+		if link.TelegramChatID != "" && telegramBot != nil {
+			if err := telegramBot.SendMessage(ctx, link.TelegramChatID, text); err != nil {
+				return err
+			}
+		}
+		if link.DiscordUserID != "" && discordBot != nil {
+			if err := discordBot.SendDirectMessage(ctx, link.DiscordUserID, text); err != nil {
+				return err
+			}
+		}
+	}
+	return bots.DigestSent(userID, now)
+}
+
+// createBotCodeHandler creates a code for connecting a chat bot and shows it on the
+// settings page
+func createBotCodeHandler(w http.ResponseWriter, r *http.Request) {
+	code := bots.NewCode(handlers.CurrentSession(r).UserID)
+	renderSettings(w, r, settingsMessage{BotCode: code})
+}
+
+// disconnectBotHandler disconnects the user's chat on a service (service)
+func disconnectBotHandler(w http.ResponseWriter, r *http.Request) {
+	service := bots.Service(r.PostFormValue("service"))
+	if service != bots.Telegram && service != bots.Discord {
+		http.Error(w, "Unknown service", http.StatusBadRequest)
+		return
+	}
+	if err := bots.Disconnect(handlers.CurrentSession(r).UserID, service); err != nil {
+		slog.Error("failed to disconnect chat bot", slog.Any("error", err))
+		http.Error(w, "Failed to disconnect", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
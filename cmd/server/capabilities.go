@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// capabilities is what the current account can do here, so pages only offer controls
+// that will work instead of failing when clicked
+type capabilities struct {
+	Premium  bool     `json:"premium"`
+	Product  string   `json:"product"`  // Spotify subscription, empty if unknown
+	Playback bool     `json:"playback"` // the Web Playback SDK can stream
+	Scopes   []string `json:"scopes"`   // granted OAuth scopes, empty if unknown
+	Features features `json:"features"`
+}
+
+// features are the optional parts of the app, enabled by configuration and granted scopes,
+// see featureRegistry
+type features struct {
+	Audiobooks     bool `json:"audiobooks"`
+	Shows          bool `json:"shows"`
+	Episodes       bool `json:"episodes"`
+	Playlists      bool `json:"playlists"`
+	Export         bool `json:"export"`
+	PublicExport   bool `json:"public_export"`
+	CoverUpload    bool `json:"cover_upload"`
+	RecentlyPlayed bool `json:"recently_played"`
+	Top            bool `json:"top"`
+	PlayerState    bool `json:"player_state"`
+	Control        bool `json:"control"` // playing, pausing, skipping and the like
+	AppleMusic     bool `json:"apple_music"`
+	Demo           bool `json:"demo"`
+}
+
+// capabilitiesOf works out the capabilities of a session. Sessions from before we recorded
+// the subscription look it up on Spotify.
+func capabilitiesOf(ctx context.Context, session handlers.Session) capabilities {
+	product := session.Product
+	if product == "" && !cfg.DemoMode {
+		if user, err := spotifyClient.GetCurrentUser(ctx, session.Token.AccessToken); err != nil {
+			slog.Warn("failed to look up Spotify subscription", "user", session.UserID, slog.Any("error", err))
+		} else {
+			product = user.Product
+		}
+	}
+
+	premium := spotifyClient.User{Product: product}.Premium()
+	// The demo has no Spotify account behind it, so nothing that talks to Spotify works
+	live := !cfg.DemoMode
+	return capabilities{
+		Premium:  premium,
+		Product:  product,
+		Playback: live && premium && session.HasScope("streaming"),
+		Scopes:   session.Scopes,
+		Features: features{
+			Audiobooks:     featureAvailable("audiobooks", session),
+			Shows:          featureAvailable("shows", session),
+			Episodes:       featureAvailable("episodes", session),
+			Playlists:      live && featureAvailable("playlists", session),
+			Export:         live && featureAvailable("export", session),
+			PublicExport:   live && featureAvailable("export", session) && featureAvailable("public-playlists", session),
+			CoverUpload:    live && featureAvailable("cover-upload", session),
+			RecentlyPlayed: live && featureAvailable("recently-played", session),
+			Top:            live && featureAvailable("top", session),
+			PlayerState:    live && featureAvailable("player-state", session),
+			Control:        live && featureAvailable("playback-control", session),
+			AppleMusic:     live && featureAvailable("apple-music", session),
+			Demo:           cfg.DemoMode,
+		},
+	}
+}
+
+// capabilitiesHandler reports the capabilities of the current account as JSON
+func capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	caps := capabilitiesOf(r.Context(), handlers.CurrentSession(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(caps)
+}
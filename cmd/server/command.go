@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jendahorak/bangerid/internal/annotations"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/harmony"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+const (
+	commandQueueSize  = 50 // most tracks a play or shuffle result queues
+	commandMaxResults = 8  // most results the palette lists
+)
+
+// commandResult is one thing the palette can do for a typed command. Action is the URL it
+// requests: a POST to /play, or a GET of the grid with filters applied.
+type commandResult struct {
+	Label  string
+	Hint   string
+	Action string
+	Play   bool // Action starts playback rather than replacing the grid
+}
+
+// commandHandler resolves what was typed into the command palette (?q=) against the user's
+// library and renders the matching actions, best first:
+//
+//	play daft punk       plays the liked songs by a matching artist, or matching songs
+//	filter year:1998     shows the grid filtered, see filterCommand for the terms
+//	shuffle gym          plays a matching playlist shuffled, or all liked songs if empty
+//	ask sad 90s bangers  hands the request to the language model, see askHandler
+//
+// Text without a command tries both playing and filtering by it, and asking when a
+// language model is configured.
+func commandHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	input := strings.TrimSpace(r.FormValue("q"))
+	if input == "" {
+		renderTemplate(w, []commandResult(nil), "web/templates/command.html")
+		return
+	}
+	verb, rest, _ := strings.Cut(input, " ")
+	rest = strings.TrimSpace(rest)
+
+	tracks, err := library.Tracks(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load tracks", http.StatusInternalServerError)
+		return
+	}
+	// The demo has no Spotify account to play on
+	canPlay := !cfg.DemoMode && session.HasScope("streaming")
+
+	var results []commandResult
+	switch strings.ToLower(verb) {
+	case "play":
+		if canPlay {
+			results = playCommand(tracks, rest)
+		}
+	case "filter":
+		var result commandResult
+		if rest == "" {
+			break
+		}
+		if result, err = filterCommand(tracks, rest); err == nil {
+			results = []commandResult{result}
+		}
+	case "shuffle":
+		if canPlay {
+			results, err = shuffleCommand(r, session, accessToken, tracks, rest)
+		}
+	case "ask":
+		if featureAvailable("ask", session) && rest != "" {
+			results = []commandResult{askResult(rest)}
+		}
+	default:
+		if canPlay {
+			// Leave room for filtering and asking
+			play := playCommand(tracks, input)
+			results = play[:min(commandMaxResults-2, len(play))]
+		}
+		results = append(results, commandResult{
+			Label:  "Filter songs by “" + input + "”",
+			Action: "/grid?" + url.Values{"q": {input}}.Encode(),
+		})
+		if featureAvailable("ask", session) {
+			results = append(results, askResult(input))
+		}
+	}
+	if errors.Is(err, errInvalidFilter) || errors.Is(err, harmony.ErrInvalidKey) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to resolve command", slog.Any("error", err))
+		http.Error(w, "Failed to resolve command", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, results[:min(commandMaxResults, len(results))], "web/templates/command.html")
+}
+
+// playCommand plays the liked songs of artists whose name contains the text, then single
+// liked songs whose title does
+func playCommand(tracks []spotifyClient.Track, text string) []commandResult {
+	query := strings.ToLower(text)
+	if query == "" {
+		return nil
+	}
+
+	var results []commandResult
+	byArtist := make(map[string][]string) // artist name to their liked track URIs
+	var artists []string                  // in library order
+	for _, t := range tracks {
+		for _, a := range t.Artists {
+			if !strings.Contains(strings.ToLower(a.Name), query) {
+				continue
+			}
+			if _, ok := byArtist[a.Name]; !ok {
+				artists = append(artists, a.Name)
+			}
+			byArtist[a.Name] = append(byArtist[a.Name], t.ID)
+		}
+	}
+	for _, name := range artists {
+		uris := byArtist[name]
+		results = append(results, commandResult{
+			Label:  "Play " + name,
+			Hint:   strconv.Itoa(len(uris)) + " liked songs",
+			Action: playAction(uris),
+			Play:   true,
+		})
+	}
+
+	for _, t := range tracks {
+		if strings.Contains(strings.ToLower(t.Name), query) {
+			results = append(results, commandResult{
+				Label:  "Play " + t.Name,
+				Hint:   t.ArtistNames(),
+				Action: playAction([]string{t.ID}),
+				Play:   true,
+			})
+		}
+	}
+	return results
+}
+
+// filterCommand turns filter terms into a grid request. Terms are key:value pairs
+// (year:1998 or year:1990-1999, key:8A, rating:4, artist:daft punk, sort:tempo,
+// color:energy), anything else is searched for. artist: takes the rest of the input, so
+// it goes last.
+func filterCommand(tracks []spotifyClient.Track, terms string) (commandResult, error) {
+	params := url.Values{}
+	var words, labels []string
+
+	fields := strings.Fields(terms)
+scan:
+	for i, term := range fields {
+		name, value, ok := strings.Cut(term, ":")
+		if !ok || value == "" && name != "artist" {
+			words = append(words, term)
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "year":
+			if _, _, err := parseYears(value); err != nil {
+				return commandResult{}, err
+			}
+			params.Set("year", value)
+			labels = append(labels, "from "+value)
+		case "key", "mix":
+			key, err := harmony.Parse(value)
+			if err != nil {
+				return commandResult{}, err
+			}
+			params.Set("mix", key.String())
+			labels = append(labels, "mixable with "+key.String())
+		case "rating":
+			if n, err := strconv.Atoi(value); err != nil || n < 1 || n > annotations.MaxRating {
+				return commandResult{}, errInvalidFilter
+			}
+			params.Set("min_rating", value)
+			labels = append(labels, "rated "+value+"+")
+		case "sort":
+			if !slices.Contains(gridSorts, value) {
+				return commandResult{}, errInvalidFilter
+			}
+			params.Set("sort", value)
+			labels = append(labels, "sorted by "+value)
+		case "color":
+			if _, ok := tileColors[value]; !ok {
+				return commandResult{}, errInvalidFilter
+			}
+			params.Set("color", value)
+			labels = append(labels, "colored by "+value)
+		case "artist":
+			name := strings.Join(append([]string{value}, fields[i+1:]...), " ")
+			id, found := artistNamed(tracks, strings.TrimSpace(name))
+			if found == "" {
+				return commandResult{}, errInvalidFilter
+			}
+			params.Set("artist", id)
+			labels = append(labels, "by "+found)
+			break scan
+		default:
+			words = append(words, term)
+		}
+	}
+	if len(words) > 0 {
+		q := strings.Join(words, " ")
+		params.Set("q", q)
+		labels = append(labels, "matching “"+q+"”")
+	}
+	if len(params) == 0 {
+		return commandResult{}, errInvalidFilter
+	}
+	return commandResult{
+		Label:  "Show songs " + strings.Join(labels, ", "),
+		Action: "/grid?" + params.Encode(),
+	}, nil
+}
+
+// artistNamed finds the ID and name of the first credited artist in the library whose name
+// contains the text, preferring an exact match
+func artistNamed(tracks []spotifyClient.Track, text string) (id, name string) {
+	query := strings.ToLower(text)
+	if query == "" {
+		return "", ""
+	}
+	for _, t := range tracks {
+		for _, a := range t.Artists {
+			if strings.ToLower(a.Name) == query {
+				return a.ID, a.Name
+			}
+			if name == "" && strings.Contains(strings.ToLower(a.Name), query) {
+				id, name = a.ID, a.Name
+			}
+		}
+	}
+	return id, name
+}
+
+// shuffleCommand plays the playlists whose name contains the text shuffled, or all liked
+// songs shuffled without text. Only the best matching playlist's tracks are fetched, the
+// others are listed to play in Spotify's order.
+func shuffleCommand(r *http.Request, session handlers.Session, accessToken string, tracks []spotifyClient.Track, text string) ([]commandResult, error) {
+	if text == "" {
+		uris := make([]string, len(tracks))
+		for i, t := range tracks {
+			uris[i] = t.ID
+		}
+		return []commandResult{{
+			Label:  "Shuffle liked songs",
+			Hint:   strconv.Itoa(len(uris)) + " songs",
+			Action: playAction(shuffled(uris)),
+			Play:   true,
+		}}, nil
+	}
+	if !featureAvailable("playlists", session) {
+		return nil, nil
+	}
+
+	playlists, err := spotifyClient.FetchUserPlaylists(r.Context(), accessToken)
+	if err != nil {
+		return nil, err
+	}
+	query := strings.ToLower(text)
+	playlists = slices.DeleteFunc(playlists, func(p spotifyClient.Playlist) bool {
+		return !strings.Contains(strings.ToLower(p.Name), query)
+	})
+	// Names starting with the text first, "gym" is more likely "Gym" than "Pre-gym stretch"
+	slices.SortStableFunc(playlists, func(a, b spotifyClient.Playlist) int {
+		return boolRank(!strings.HasPrefix(strings.ToLower(a.Name), query)) -
+			boolRank(!strings.HasPrefix(strings.ToLower(b.Name), query))
+	})
+
+	var results []commandResult
+	for i, p := range playlists {
+		if i > 0 {
+			results = append(results, commandResult{
+				Label:  "Play " + p.Name,
+				Hint:   "playlist",
+				Action: "/play?" + url.Values{"context_uri": {"spotify:playlist:" + p.ID}}.Encode(),
+				Play:   true,
+			})
+			continue
+		}
+		playlistTracks, err := spotifyClient.FetchPlaylistTracks(r.Context(), accessToken, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		uris := make([]string, len(playlistTracks))
+		for i, t := range playlistTracks {
+			uris[i] = t.ID
+		}
+		results = append(results, commandResult{
+			Label:  "Shuffle " + p.Name,
+			Hint:   strconv.Itoa(len(uris)) + " songs",
+			Action: playAction(shuffled(uris)),
+			Play:   true,
+		})
+	}
+	return results, nil
+}
+
+// askResult shows the liked songs a free-text request asks for
+func askResult(text string) commandResult {
+	return commandResult{
+		Label:  "Ask for “" + text + "”",
+		Action: "/ask?" + url.Values{"q": {text}}.Encode(),
+	}
+}
+
+// shuffled shuffles the track URIs in place
+func shuffled(uris []string) []string {
+	rand.Shuffle(len(uris), func(i, j int) { uris[i], uris[j] = uris[j], uris[i] })
+	return uris
+}
+
+// playAction is the /play request that queues up to commandQueueSize tracks
+func playAction(uris []string) string {
+	return "/play?" + url.Values{"track_uri": uris[:min(commandQueueSize, len(uris))]}.Encode()
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// playlistDupe is a track of a playlist the cleanup offers to remove
+type playlistDupe struct {
+	Track  spotifyClient.Track
+	Copies int  // how often the track is in the playlist
+	Liked  bool // it's in the liked songs already, the same recording counts
+	// Of is the track earlier in the playlist that is the same recording, released
+	// again on another album. Nil when only the track itself repeats.
+	Of *spotifyClient.Track
+}
+
+// removesAll reports whether removing the dupe takes out every copy of the track. Only
+// repeats of a track keep the first copy.
+func (d playlistDupe) removesAll() bool {
+	return d.Liked || d.Of != nil
+}
+
+// recordingKey tells tracks that are the same recording apart from others: by ISRC, the
+// same song on a single and an album has two track IDs but one ISRC. Tracks without one
+// only match themselves.
+func recordingKey(t spotifyClient.Track) string {
+	if t.ISRC != "" {
+		return "isrc:" + strings.ToUpper(t.ISRC)
+	}
+	return t.ID
+}
+
+// findPlaylistDupes lists the tracks of a playlist that are in the liked songs or in the
+// playlist more than once, in playlist order. Of recordings that are in it as several
+// tracks the first one is kept.
+func findPlaylistDupes(tracks, liked []spotifyClient.Track) []playlistDupe {
+	likedKeys := make(map[string]bool, len(liked))
+	for _, t := range liked {
+		likedKeys[recordingKey(t)] = true
+		likedKeys[t.ID] = true
+	}
+
+	copies := make(map[string]int)
+	for _, t := range tracks {
+		copies[t.ID]++
+	}
+
+	var dupes []playlistDupe
+	first := make(map[string]spotifyClient.Track) // recording key -> first track of it
+	seen := make(map[string]bool)
+	for _, t := range tracks {
+		if seen[t.ID] {
+			continue
+		}
+		seen[t.ID] = true
+
+		d := playlistDupe{Track: t, Copies: copies[t.ID], Liked: likedKeys[t.ID] || likedKeys[recordingKey(t)]}
+		if f, ok := first[recordingKey(t)]; ok {
+			d.Of = &f
+		} else {
+			first[recordingKey(t)] = t
+		}
+		if d.Liked || d.Copies > 1 || d.Of != nil {
+			dupes = append(dupes, d)
+		}
+	}
+	return dupes
+}
+
+// playlistInsert puts a track back at a position of the playlist
+type playlistInsert struct {
+	URI      string
+	Position int
+}
+
+// planDedupe works out how to remove dupes from a playlist with the given items, see
+// spotifyClient.FetchPlaylistItems: Spotify only removes every copy of a track, so the
+// tracks of which one copy stays are removed too and put back where their first copy
+// was. Inserting in order of position lands each where it belongs, everything before it
+// is in place by then.
+func planDedupe(items []string, dupes []playlistDupe) (remove []string, inserts []playlistInsert) {
+	removeAll := make(map[string]bool)
+	keepFirst := make(map[string]bool)
+	for _, d := range dupes {
+		if d.removesAll() {
+			removeAll[d.Track.ID] = true
+		} else {
+			keepFirst[d.Track.ID] = true
+		}
+	}
+
+	position := 0 // in the playlist after the dedupe
+	planned := make(map[string]bool)
+	for _, uri := range items {
+		if !removeAll[uri] && !keepFirst[uri] {
+			position++
+			continue
+		}
+		if planned[uri] {
+			continue
+		}
+		planned[uri] = true
+		remove = append(remove, uri)
+		if keepFirst[uri] {
+			inserts = append(inserts, playlistInsert{URI: uri, Position: position})
+			position++
+		}
+	}
+	return remove, inserts
+}
+
+// playlistDupesView is what the playlist cleanup template shows
+type playlistDupesView struct {
+	PlaylistID string
+	Dupes      []playlistDupe
+	Removed    int // tracks just removed, 0 before cleaning up
+}
+
+// playlistDupes lists the dupes of one of the user's playlists
+func playlistDupes(r *http.Request, accessToken, userID, playlistID string) ([]playlistDupe, error) {
+	tracks, err := spotifyClient.FetchPlaylistTracks(r.Context(), accessToken, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	liked, err := library.Tracks(r.Context(), userID, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return findPlaylistDupes(tracks, liked), nil
+}
+
+// playlistDupesHandler lists the tracks of a playlist that are in the liked songs
+// already, or in the playlist more than once, offering to remove them
+func playlistDupesHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+	playlistID := r.PathValue("id")
+	if !validSpotifyID(playlistID) {
+		http.Error(w, "Invalid playlist", http.StatusBadRequest)
+		return
+	}
+
+	dupes, err := playlistDupes(r, accessToken, session.UserID, playlistID)
+	if err != nil {
+		slog.Error("failed to find playlist duplicates", "playlist", playlistID, slog.Any("error", err))
+		http.Error(w, "Failed to load playlist", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, playlistDupesView{PlaylistID: playlistID, Dupes: dupes}, "web/templates/playlist-dupes.html")
+}
+
+// removePlaylistDupesHandler removes the dupes picked (uri, repeatable) from a playlist.
+// They are found again first: what the list showed may have changed since.
+func removePlaylistDupesHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+	playlistID := r.PathValue("id")
+	if !validSpotifyID(playlistID) {
+		http.Error(w, "Invalid playlist", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	playlist, items, err := spotifyClient.FetchPlaylistItems(r.Context(), accessToken, playlistID)
+	if err != nil {
+		slog.Error("failed to fetch playlist", "playlist", playlistID, slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Couldn't load the playlist, try again in a bit.")
+		return
+	}
+	if playlist.Owner.ID != session.UserID && !playlist.Collaborative {
+		renderToast(w, http.StatusForbidden, "Only playlists you own or collaborate on can be cleaned up.")
+		return
+	}
+
+	dupes, err := playlistDupes(r, accessToken, session.UserID, playlistID)
+	if err != nil {
+		slog.Error("failed to find playlist duplicates", "playlist", playlistID, slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Couldn't load the playlist, try again in a bit.")
+		return
+	}
+	picked := make(map[string]bool)
+	for _, uri := range r.PostForm["uri"] {
+		picked[uri] = true
+	}
+	var selected []playlistDupe
+	for _, d := range dupes {
+		if picked[d.Track.ID] {
+			selected = append(selected, d)
+		}
+	}
+
+	remove, inserts := planDedupe(items, selected)
+	if len(remove) > 0 {
+		if _, err := spotifyClient.RemovePlaylistTracks(r.Context(), accessToken, playlistID, playlist.SnapshotID, remove); err != nil {
+			slog.Error("failed to remove playlist duplicates", "playlist", playlistID, slog.Any("error", err))
+			renderToast(w, http.StatusBadGateway, "Spotify didn't remove the tracks, try again in a bit.")
+			return
+		}
+	}
+	for _, insert := range inserts {
+		if err := spotifyClient.InsertPlaylistTrack(r.Context(), accessToken, playlistID, insert.URI, insert.Position); err != nil {
+			// The copy that should have stayed is gone with the others, say which it is
+			slog.Error("failed to restore playlist track", "playlist", playlistID, "track", insert.URI, slog.Any("error", err))
+			name := insert.URI
+			for _, d := range selected {
+				if d.Track.ID == insert.URI {
+					name = d.Track.Name + " by " + d.Track.ArtistNames()
+				}
+			}
+			renderToast(w, http.StatusBadGateway, "Removed the duplicates, but putting back one copy of "+name+" failed. Add it again in Spotify.")
+			return
+		}
+	}
+
+	removedURIs := make(map[string]bool, len(remove))
+	for _, uri := range remove {
+		removedURIs[uri] = true
+	}
+	removed := -len(inserts)
+	for _, uri := range items {
+		if removedURIs[uri] {
+			removed++
+		}
+	}
+
+	dupes, err = playlistDupes(r, accessToken, session.UserID, playlistID)
+	if err != nil {
+		slog.Warn("failed to find playlist duplicates", "playlist", playlistID, slog.Any("error", err))
+	}
+	slog.Info("playlist cleaned up", "playlist", playlistID, "removed", removed)
+	renderTemplate(w, playlistDupesView{PlaylistID: playlistID, Dupes: dupes, Removed: removed}, "web/templates/playlist-dupes.html")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+)
+
+// demoUserID is the made-up user every visitor is signed in as in demo mode
+const demoUserID = "demo"
+
+// demoAllowed lists the routes besides reads that stay available in demo mode,
+// because they only compute something or report back without changing anything.
+var demoAllowed = map[string]bool{
+	"POST /sets":      true,
+	"POST /events":    true,
+	"POST /heartbeat": true,
+}
+
+// demoHidden lists read-only routes that make no sense without a real account.
+var demoHidden = map[string]bool{
+	"/login":            true,
+	"/spotify-auth":     true,
+	"/logout":           true,
+	"GET /settings":     true,
+	"GET /sessions":     true,
+	"GET /admin":        true,
+	"GET /admin/users":  true,
+	"GET /player/token": true,
+	"GET /kiosk/pair":   true,
+}
+
+// demoMiddleware keeps the demo read-only: playback, exports, notes, ratings and
+// everything else that would write is refused, account pages lead back home.
+func demoMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+
+		if demoHidden[pattern] {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !demoAllowed[pattern] {
+			http.Error(w, "Not available in the demo", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
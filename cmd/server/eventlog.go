@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/eventlog"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// startEventLog turns the event log on when it is configured, uploading it to the bucket
+// in the background if there is one
+func startEventLog(ctx context.Context) error {
+	if cfg.EventLogDir == "" {
+		return nil
+	}
+	if err := eventlog.Open(cfg.EventLogDir); err != nil {
+		return err
+	}
+	slog.Info("writing event log", "dir", cfg.EventLogDir)
+
+	if cfg.EventLogBucketURL == "" {
+		return nil
+	}
+	uploader, err := eventlog.NewUploader(cfg.EventLogBucketURL, cfg.EventLogBucketRegion, cfg.EventLogAccessKey, cfg.EventLogSecretKey)
+	if err != nil {
+		return err
+	}
+	go uploader.Run(ctx, cfg.EventLogUploadInterval)
+	slog.Info("uploading event log", "bucket", cfg.EventLogBucketURL, "interval", cfg.EventLogUploadInterval)
+	return nil
+}
+
+// logEvents appends events to the event log, if it is on
+func logEvents(events ...eventlog.Event) {
+	if err := eventlog.Record(events...); err != nil {
+		slog.Warn("failed to write event log", slog.Any("error", err))
+	}
+}
+
+// trackEvent is an event about one of the user's tracks
+func trackEvent(kind, userID string, track spotifyClient.Track) eventlog.Event {
+	return eventlog.Event{Type: kind, User: userID, Track: track.ID, Name: track.Name, Artist: track.ArtistNames()}
+}
+
+// logPlays appends the plays Spotify listed that the event log doesn't have yet
+func logPlays(userID string, plays []spotifyClient.Play) {
+	if !eventlog.Enabled() {
+		return
+	}
+	events := make([]eventlog.Event, 0, len(plays))
+	for _, p := range plays {
+		e := trackEvent(eventlog.Play, userID, p.Track)
+		if e.Track == "" {
+			// Tracks without album art have no Track, the ID is all there is
+			e.Track = "spotify:track:" + p.TrackID
+		}
+		e.Time = p.PlayedAt
+		events = append(events, e)
+	}
+	if err := eventlog.RecordPlays(userID, events); err != nil {
+		slog.Warn("failed to write event log", slog.Any("error", err))
+	}
+}
+
+// logSync appends a finished sync of a section of the user's library, and for the liked
+// songs the likes and unlikes it noticed: the ones made outside the app, the app's own
+// are logged as they happen. before are the liked songs from before the sync, see
+// syncObserver.
+func logSync(userID string, s library.Section, before []spotifyClient.Track) {
+	if !eventlog.Enabled() {
+		return
+	}
+
+	var events []eventlog.Event
+	sync := eventlog.Event{Type: eventlog.Sync, User: userID, Section: string(s), Time: time.Now()}
+	if s == library.SectionTracks {
+		after := library.CachedTracks(userID)
+		added, removed := likedChanges(before, after)
+		sync.Total, sync.Added, sync.Removed = len(after), len(added), len(removed)
+
+		// Oldest first, the way they happened
+		for i := len(added) - 1; i >= 0; i-- {
+			e := trackEvent(eventlog.Like, userID, added[i])
+			e.Source, e.Time = eventlog.SourceSpotify, added[i].AddedAt
+			events = append(events, e)
+		}
+		for _, t := range removed {
+			e := trackEvent(eventlog.Unlike, userID, t)
+			e.Source, e.Time = eventlog.SourceSpotify, sync.Time
+			events = append(events, e)
+		}
+	}
+	logEvents(append(events, sync)...)
+}
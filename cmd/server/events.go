@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/jendahorak/bangerid/internal/errorreport"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/metrics"
+)
+
+const (
+	maxEventBodyBytes = 16 << 10 // a batch of small events, nothing more
+	maxEventsPerBatch = 20
+	maxEventMessage   = 500
+)
+
+var (
+	frontendEvents = metrics.NewCounter(
+		"bangerid_frontend_events_total",
+		"Events reported by the browser, by type.",
+		"type",
+	)
+	tileRenderSeconds = metrics.NewCounter(
+		"bangerid_grid_render_seconds_total",
+		"Summed time from requesting the grid to its tiles being on screen.",
+	)
+)
+
+// frontendEvent is one event reported by the browser
+type frontendEvent struct {
+	Type       string  `json:"type"`
+	Kind       string  `json:"kind,omitempty"`        // sdk_error: initialization, authentication, account, playback
+	Message    string  `json:"message,omitempty"`     // sdk_error: the SDK's message
+	DeviceID   string  `json:"device_id,omitempty"`   // device_ready, device_offline
+	DurationMs float64 `json:"duration_ms,omitempty"` // grid_rendered
+	Tiles      int     `json:"tiles,omitempty"`       // grid_rendered
+}
+
+// validEvent checks that an event is one we know and that its fields are sane
+func validEvent(e frontendEvent) bool {
+	if len(e.Message) > maxEventMessage || len(e.Kind) > 32 || len(e.DeviceID) > 100 {
+		return false
+	}
+
+	switch e.Type {
+	case "sdk_error":
+		switch e.Kind {
+		case "initialization", "authentication", "account", "playback":
+			return true
+		}
+		return false
+	case "device_ready", "device_offline":
+		return e.DeviceID != ""
+	case "grid_rendered":
+		return e.DurationMs >= 0 && e.DurationMs < 10*60*1000 && e.Tiles >= 0
+	}
+	return false
+}
+
+// eventsHandler ingests a JSON array of events from the browser (player SDK errors,
+// device readiness, grid render timings) and logs and meters them, so problems that
+// only show up client-side become visible in the server logs and metrics
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+
+	var events []frontendEvent
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEventBodyBytes))
+	if err := decoder.Decode(&events); err != nil {
+		http.Error(w, "Invalid events", http.StatusBadRequest)
+		return
+	}
+	if len(events) > maxEventsPerBatch {
+		http.Error(w, "Too many events", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	for _, e := range events {
+		if !validEvent(e) {
+			frontendEvents.Inc("invalid")
+			continue
+		}
+		frontendEvents.Inc(e.Type)
+
+		switch e.Type {
+		case "sdk_error":
+			slog.Warn("player sdk error", "user", session.UserID, "kind", e.Kind, "message", e.Message)
+			errorreport.Message(errorreport.LevelWarning, "player sdk error: "+e.Message, errorreport.Tags{"source": "player", "kind": e.Kind})
+		case "grid_rendered":
+			tileRenderSeconds.Add(e.DurationMs / 1000)
+			slog.Info("grid rendered", "user", session.UserID, "duration_ms", e.DurationMs, "tiles", e.Tiles)
+		default:
+			slog.Info("player event", "user", session.UserID, "type", e.Type, "device", e.DeviceID)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/cover"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/harmony"
+	"github.com/jendahorak/bangerid/internal/library"
+	"github.com/jendahorak/bangerid/internal/prefs"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// maxPlaylistName is the longest playlist name we pass on to Spotify
+const maxPlaylistName = 100
+
+// exportResult is what the export template shows
+type exportResult struct {
+	Playlist    *spotifyClient.Playlist
+	Tracks      int
+	CoverFailed bool
+}
+
+// exportHandler saves tracks as a new playlist with a collage of their album art as the
+// cover, private unless public=on. It exports the given tracks (track_id, repeatable) in
+// order, e.g. a DJ set, or else the liked songs grid with the same filters and sort order
+// as /grid.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	public := r.PostFormValue("public") == "on"
+	if public {
+		// Sessions from before public playlists were offered only granted private ones
+		f := featureRegistry["public-playlists"]
+		if missing := f.missingScopes(session); len(missing) > 0 {
+			renderFeatureDisabled(w, r, f, missing)
+			return
+		}
+	}
+
+	tracks, err := exportTracks(r, session.UserID, accessToken)
+	if errors.Is(err, harmony.ErrInvalidKey) || errors.Is(err, errInvalidFilter) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load tracks", http.StatusInternalServerError)
+		return
+	}
+	if len(tracks) == 0 {
+		http.Error(w, "Nothing to export", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" {
+		name = "Bangerid " + time.Now().In(prefs.Get(session.UserID).Location()).Format("2006-01-02")
+		if mix := r.PostFormValue("mix"); mix != "" {
+			name += " · mixable with " + strings.ToUpper(mix)
+		}
+	}
+	if len([]rune(name)) > maxPlaylistName {
+		name = string([]rune(name)[:maxPlaylistName])
+	}
+
+	playlist, err := spotifyClient.CreatePlaylist(r.Context(), accessToken, session.UserID, name, "Exported from Bangerid", public)
+	if err != nil {
+		slog.Error("playlist export failed", slog.Any("error", err))
+		http.Error(w, "Failed to create playlist", http.StatusBadGateway)
+		return
+	}
+
+	uris := make([]string, len(tracks))
+	for i, t := range tracks {
+		uris[i] = t.ID
+	}
+	snapshotID, err := spotifyClient.AddPlaylistTracks(r.Context(), accessToken, playlist.ID, uris)
+	if err != nil {
+		slog.Error("playlist export failed", "playlist", playlist.ID, slog.Any("error", err))
+		http.Error(w, "Created the playlist but failed to add tracks", http.StatusBadGateway)
+		return
+	}
+
+	// The cover is a nice-to-have: the playlist is already complete without it
+	result := exportResult{Playlist: playlist, Tracks: len(uris)}
+	if err := uploadCollage(r, accessToken, playlist.ID, tracks); err != nil {
+		slog.Warn("failed to upload playlist cover", "playlist", playlist.ID, slog.Any("error", err))
+		result.CoverFailed = true
+	}
+
+	slog.Info("playlist exported", "playlist", playlist.ID, "snapshot", snapshotID, "tracks", len(uris))
+	renderTemplate(w, result, "web/templates/export.html")
+}
+
+// exportTracks resolves the tracks an export request asks for
+func exportTracks(r *http.Request, userID, accessToken string) ([]spotifyClient.Track, error) {
+	ids := r.PostForm["track_id"]
+	if len(ids) == 0 {
+		tiles, err := gridTiles(r)
+		if err != nil {
+			return nil, err
+		}
+		tracks := make([]spotifyClient.Track, len(tiles))
+		for i, tile := range tiles {
+			tracks[i] = tile.Track
+		}
+		return tracks, nil
+	}
+
+	liked, err := library.Tracks(r.Context(), userID, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]spotifyClient.Track, len(liked))
+	for _, t := range liked {
+		byID[spotifyClient.IDFromURI(t.ID)] = t
+	}
+
+	var tracks []spotifyClient.Track
+	for _, id := range ids {
+		if t, ok := byID[id]; ok {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks, nil
+}
+
+// uploadCollage sets a collage of the first distinct album covers as the playlist cover
+func uploadCollage(r *http.Request, accessToken, playlistID string, tracks []spotifyClient.Track) error {
+	seen := make(map[string]bool)
+	var urls []string
+	for _, t := range tracks {
+		// A few spare covers in case some fail to download
+		if len(urls) == 8 {
+			break
+		}
+		if t.CoverImage != "" && !seen[t.CoverImage] {
+			seen[t.CoverImage] = true
+			urls = append(urls, t.CoverImage)
+		}
+	}
+
+	jpeg, err := cover.Collage(r.Context(), urls, spotifyClient.MaxCoverBytes)
+	if err != nil {
+		return fmt.Errorf("failed to render cover: %w", err)
+	}
+	return spotifyClient.UploadPlaylistCover(r.Context(), accessToken, playlistID, jpeg)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/library"
+)
+
+// feature is an optional part of the app and what it takes to use it: server
+// configuration that turns it on and OAuth scopes the user has to grant
+type feature struct {
+	Title  string   // what the enable page calls it, e.g. "Your playlists"
+	Config string   // how to turn it on, e.g. "LIBRARY_AUDIOBOOKS=true", empty if always on
+	Scopes []string // OAuth scopes it needs, all of them
+
+	// enabled reports whether the configuration turned the feature on, nil if always on
+	enabled func() bool
+}
+
+// featureRegistry lists the optional features by name. Their routes are registered with
+// requireFeature, and capabilities report them so pages only offer what works.
+var featureRegistry = map[string]feature{
+	"audiobooks": {
+		Title:   "Audiobooks",
+		Config:  "LIBRARY_AUDIOBOOKS=true",
+		enabled: func() bool { return library.Enabled(library.SectionAudiobooks) },
+	},
+	"shows": {
+		Title:   "Podcasts",
+		Config:  "LIBRARY_SHOWS=true",
+		enabled: func() bool { return library.Enabled(library.SectionShows) },
+	},
+	"episodes": {
+		Title:   "Saved podcast episodes",
+		Config:  "LIBRARY_EPISODES=true",
+		Scopes:  []string{"user-read-playback-position"},
+		enabled: func() bool { return library.Enabled(library.SectionEpisodes) },
+	},
+	"playlists": {
+		Title:  "Your playlists",
+		Scopes: []string{"playlist-read-private"},
+	},
+	"export": {
+		Title:  "Exporting playlists",
+		Scopes: []string{"playlist-modify-private"},
+	},
+	"public-playlists": {
+		Title:  "Exporting public playlists",
+		Scopes: []string{"playlist-modify-public"},
+	},
+	"cover-upload": {
+		Title:  "Playlist covers",
+		Scopes: []string{"ugc-image-upload"},
+	},
+	"recently-played": {
+		Title:  "Recently played",
+		Scopes: []string{"user-read-recently-played"},
+	},
+	"top": {
+		Title:  "Your top tracks and artists",
+		Scopes: []string{"user-top-read"},
+	},
+	"player-state": {
+		Title:  "What's playing on your devices",
+		Scopes: []string{"user-read-playback-state"},
+	},
+	"playback-control": {
+		Title:  "Playing, pausing and skipping",
+		Scopes: []string{"user-modify-playback-state"},
+	},
+	"now-playing": {
+		Title:  "What's playing, in the header",
+		Scopes: []string{"user-read-currently-playing"},
+	},
+	"likes": {
+		Title:  "Liking songs and saving albums",
+		Scopes: []string{"user-library-modify"},
+	},
+	"apple-music": {
+		Title:   "Saving playlists to Apple Music",
+		Config:  "APPLE_MUSIC_DEVELOPER_TOKEN=<MusicKit JWT> (and APPLE_MUSIC_STOREFRONT, e.g. us)",
+		enabled: func() bool { return appleMusic != nil },
+	},
+	"ask": {
+		Title:   "Free-text requests",
+		Config:  "LLM_MODEL=<model> (and LLM_BASE_URL, LLM_API_KEY for your provider)",
+		enabled: func() bool { return interpreter != nil },
+	},
+}
+
+// configured reports whether the server configuration turned the feature on
+func (f feature) configured() bool {
+	return f.enabled == nil || f.enabled()
+}
+
+// missingScopes lists the scopes the feature needs that the session wasn't granted
+func (f feature) missingScopes(session handlers.Session) []string {
+	var missing []string
+	for _, scope := range f.Scopes {
+		if !session.HasScope(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// featureAvailable reports whether the named feature works for a session
+func featureAvailable(name string, session handlers.Session) bool {
+	f := featureRegistry[name]
+	return f.configured() && len(f.missingScopes(session)) == 0
+}
+
+// requireFeature gates a route on an optional feature, so a route that can't work shows
+// how to enable it instead of failing. It goes inside requireAuth, it needs the session.
+// Whether the server configuration turned the feature on is decided once, when the
+// route is registered; scopes are checked on every request, users grant different ones.
+func requireFeature(name string, next http.HandlerFunc) http.HandlerFunc {
+	f, ok := featureRegistry[name]
+	if !ok {
+		panic("unknown feature " + name)
+	}
+	if !f.configured() {
+		slog.Info("feature turned off", "feature", name, "enable_with", f.Config)
+		return func(w http.ResponseWriter, r *http.Request) {
+			renderFeatureDisabled(w, r, f, nil)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if missing := f.missingScopes(handlers.CurrentSession(r)); len(missing) > 0 {
+			renderFeatureDisabled(w, r, f, missing)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// renderFeatureDisabled explains how to turn on a feature: to the operator through the
+// configuration, or to the user by logging in again to grant the missing scopes. Reads
+// get a page in place of what they asked for, anything else an error toast since htmx
+// doesn't swap those in.
+func renderFeatureDisabled(w http.ResponseWriter, r *http.Request, f feature, missingScopes []string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		message := f.Title + " is turned off on this server."
+		if len(missingScopes) > 0 {
+			message = f.Title + " needs a permission you haven't granted yet, log in again to grant it."
+		}
+		renderToast(w, http.StatusForbidden, message)
+		return
+	}
+
+	data := struct {
+		Feature       feature
+		MissingScopes string
+	}{
+		Feature:       f,
+		MissingScopes: strings.Join(slices.Sorted(slices.Values(missingScopes)), ", "),
+	}
+	renderTemplate(w, data, "web/templates/feature.html")
+}
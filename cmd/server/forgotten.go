@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/harmony"
+	"github.com/jendahorak/bangerid/internal/history"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// blastQueueSize is how many forgotten favorites the "blast from the past" queue plays
+const blastQueueSize = 30
+
+// forgottenHandler renders the liked tracks the user added long ago and hasn't played
+// for a while, with a shuffled queue of them to play
+func forgottenHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	// Top up our history with Spotify's; without it tracks played in other apps would
+	// keep showing up here. The demo has no account to ask.
+	if !cfg.DemoMode {
+		recordRecentlyPlayed(r.Context(), session.UserID, accessToken)
+	}
+
+	tracks, err := library.Tracks(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load tracks", http.StatusInternalServerError)
+		return
+	}
+	features := library.Features(session.UserID)
+	lastPlayed := history.LastPlayed(session.UserID)
+
+	now := time.Now()
+	likedBefore := now.Add(-cfg.ForgottenLikedAgo)
+	playedBefore := now.Add(-cfg.ForgottenUnplayedFor)
+
+	var tiles []gridTile
+	for _, track := range tracks {
+		id := spotifyClient.IDFromURI(track.ID)
+		// Tracks synced before we kept added_at have a zero time, don't guess about those
+		if track.AddedAt.IsZero() || track.AddedAt.After(likedBefore) || lastPlayed[id].After(playedBefore) {
+			continue
+		}
+
+		tile := gridTile{Track: track}
+		if f, ok := features[id]; ok {
+			tile.Key = harmony.FromKey(f.Key, f.Mode).String()
+		}
+		tiles = append(tiles, tile)
+	}
+	userTilePreset(session.UserID).apply(tiles, 0)
+
+	queue := make([]string, len(tiles))
+	for i, tile := range tiles {
+		queue[i] = tile.Track.ID
+	}
+	rand.Shuffle(len(queue), func(i, j int) { queue[i], queue[j] = queue[j], queue[i] })
+	if len(queue) > blastQueueSize {
+		queue = queue[:blastQueueSize]
+	}
+
+	data := struct {
+		Tiles []gridTile
+		Queue []string
+	}{
+		Tiles: tiles,
+		Queue: queue,
+	}
+	renderTemplate(w, data, "web/templates/forgotten.html", "web/templates/grid.html")
+}
+
+// recordRecentlyPlayed adds the user's recently played tracks from Spotify to our history
+func recordRecentlyPlayed(ctx context.Context, userID, accessToken string) {
+	plays, err := spotifyClient.FetchRecentlyPlayed(ctx, accessToken)
+	if err != nil {
+		slog.Warn("failed to fetch recently played tracks", slog.Any("error", err))
+		return
+	}
+
+	recordPlays(userID, plays)
+}
+
+// recordPlays adds plays fetched from Spotify to our history and the event log
+func recordPlays(userID string, plays []spotifyClient.Play) {
+	logPlays(userID, plays)
+
+	recent := make(map[string]time.Time, len(plays))
+	for _, p := range plays {
+		if p.PlayedAt.After(recent[p.TrackID]) {
+			recent[p.TrackID] = p.PlayedAt
+		}
+	}
+	if err := history.Record(userID, recent); err != nil {
+		slog.Warn("failed to record plays", slog.Any("error", err))
+	}
+}
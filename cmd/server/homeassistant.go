@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/jendahorak/bangerid/internal/apitokens"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/playback"
+	"github.com/jendahorak/bangerid/internal/prompt"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// haQueueSize is how many liked songs playing a mood or genre queues, like /ask
+const haQueueSize = 50
+
+// moods are what home automation can ask to play by name, e.g. a "calm" scene at night
+var moods = map[string]prompt.Filter{
+	"happy":     {MinValence: 0.65},
+	"sad":       {MaxValence: 0.35},
+	"energetic": {MinEnergy: 0.7},
+	"calm":      {MaxEnergy: 0.4},
+	"party":     {MinDanceability: 0.7},
+}
+
+// haState is what is playing, as /api/ha/now answers and MQTT publishes it. Home
+// Assistant's RESTful and MQTT sensors read the fields with value templates.
+type haState struct {
+	State      string `json:"state"` // "playing", "paused" or "idle"
+	Track      string `json:"track,omitempty"`
+	Artist     string `json:"artist,omitempty"`
+	Album      string `json:"album,omitempty"`
+	Image      string `json:"image,omitempty"`
+	URI        string `json:"uri,omitempty"`
+	ProgressMs int64  `json:"progress_ms,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Device     string `json:"device,omitempty"`
+	Volume     *int   `json:"volume,omitempty"` // percent, nil for devices without volume control
+}
+
+// newHAState converts a playback state, nil when nothing plays
+func newHAState(state *spotifyClient.PlaybackState) haState {
+	if state == nil {
+		return haState{State: "idle"}
+	}
+	s := haState{State: "paused", Device: state.Device.Name, ProgressMs: state.Progress.Milliseconds()}
+	if state.Playing {
+		s.State = "playing"
+	}
+	if state.Device.SupportsVolume {
+		volume := state.Device.VolumePercent
+		s.Volume = &volume
+	}
+	if t := state.Track; t != nil {
+		s.Track, s.Artist, s.Album = t.Name, t.ArtistNames(), t.Album
+		s.Image, s.URI = t.CoverImage, t.ID
+		s.DurationMs = t.Duration.Milliseconds()
+	}
+	return s
+}
+
+// requireAPIToken authenticates requests of home automation by the API token the user
+// created in the settings, sent as "Authorization: Bearer <token>". The handler runs as
+// the token's user, with what requireAuth would have put in the context.
+func requireAPIToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || cfg.DemoMode {
+			writeAPIError(w, http.StatusUnauthorized, "missing API token")
+			return
+		}
+		userID, ok := apitokens.Lookup(strings.TrimSpace(token))
+		if !ok {
+			writeAPIError(w, http.StatusUnauthorized, "invalid API token")
+			return
+		}
+
+		ctx, err := handlers.UserContext(r.Context(), oauthApps, userID)
+		if errors.Is(err, handlers.ErrSuspended) {
+			writeAPIError(w, http.StatusForbidden, "account suspended")
+			return
+		}
+		if err != nil {
+			// Every session expired or was revoked, the user has to sign in again
+			slog.Warn("no session for API token", "user", userID, slog.Any("error", err))
+			writeAPIError(w, http.StatusUnauthorized, "sign in to Bangerid again to use the API token")
+			return
+		}
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// writeAPIError answers an API request with {"error": message}
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// haNowHandler answers what the user's Spotify is playing, for a RESTful sensor
+func haNowHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := playerStates.Get(r.Context(), handlers.CurrentSession(r).UserID)
+	if err != nil {
+		slog.Error("failed to fetch playback state", slog.Any("error", err))
+		writeAPIError(w, http.StatusBadGateway, "Spotify didn't answer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(newHAState(state))
+}
+
+// haControlHandler runs a transport control for a RESTful command: play resumes, pause,
+// toggle does whichever of the two applies, next skips. An optional device_id picks the
+// device, otherwise it is the active one.
+func haControlHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := playbackTarget(r)
+		var err error
+		switch action {
+		case "play":
+			err = player.Resume(r.Context(), target)
+		case "pause":
+			err = player.Pause(r.Context(), target)
+		case "next":
+			err = player.Next(r.Context(), target)
+		case "toggle":
+			var state *spotifyClient.PlaybackState
+			state, err = playerStates.Get(r.Context(), handlers.CurrentSession(r).UserID)
+			switch {
+			case err != nil:
+			case state != nil && state.Playing:
+				err = player.Pause(r.Context(), target)
+			default:
+				err = player.Resume(r.Context(), target)
+			}
+		}
+		if err != nil {
+			slog.Error("home assistant control failed", "action", action, slog.Any("error", err))
+			writeAPIError(w, http.StatusBadGateway, "Spotify didn't "+action+", is it still open somewhere?")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// haPlayMoodHandler shuffles liked songs of a mood, one of moods, on the active device
+func haPlayMoodHandler(w http.ResponseWriter, r *http.Request) {
+	filter, ok := moods[strings.ToLower(r.PathValue("mood"))]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "unknown mood, try happy, sad, energetic, calm or party")
+		return
+	}
+	playFiltered(w, r, filter)
+}
+
+// haPlayGenreHandler shuffles liked songs of a genre, matched like /ask matches them so
+// "rock" plays indie rock too, on the active device
+func haPlayGenreHandler(w http.ResponseWriter, r *http.Request) {
+	genre := strings.TrimSpace(strings.ToLower(r.PathValue("genre")))
+	if genre == "" {
+		writeAPIError(w, http.StatusNotFound, "missing genre")
+		return
+	}
+	playFiltered(w, r, prompt.Filter{Genres: []string{genre}})
+}
+
+// playFiltered plays up to haQueueSize of the liked songs the filter matches, shuffled
+func playFiltered(w http.ResponseWriter, r *http.Request, filter prompt.Filter) {
+	queued, err := playMatching(r.Context(), handlers.CurrentSession(r).UserID, playbackTarget(r), filter)
+	switch {
+	case errors.Is(err, errNothingMatches):
+		writeAPIError(w, http.StatusNotFound, "no liked songs match")
+	case errors.Is(err, playback.ErrNoDevice):
+		writeAPIError(w, http.StatusConflict, "no device to play on, open Spotify somewhere")
+	case err != nil:
+		slog.Error("home assistant playback failed", slog.Any("error", err))
+		writeAPIError(w, http.StatusBadGateway, "failed to start playback")
+	default:
+		slog.Info("home assistant started playback", "tracks", queued)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// errNothingMatches is returned by playMatching when no liked song matches the filter
+var errNothingMatches = errors.New("no liked songs match")
+
+// playMatching plays up to haQueueSize of the user's liked songs the filter matches,
+// shuffled, and returns how many it queued
+func playMatching(ctx context.Context, userID string, target playback.Target, filter prompt.Filter) (int, error) {
+	matching, err := likedMatching(ctx, userID, target.AccessToken, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch tracks: %w", err)
+	}
+	if len(matching) == 0 {
+		return 0, errNothingMatches
+	}
+
+	queue := shuffledQueue(matching, haQueueSize)
+	if err := player.Play(ctx, target, playback.Items{URIs: queue}); err != nil {
+		return 0, err
+	}
+	return len(queue), nil
+}
+
+// createAPITokenHandler creates the user's API token, replacing the one they had, and
+// shows it on the settings page this once
+func createAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	token, err := apitokens.Create(session.UserID)
+	if err != nil {
+		slog.Error("failed to create API token", slog.Any("error", err))
+		http.Error(w, "Failed to create API token", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("api token created")
+	renderSettings(w, r, settingsMessage{APIToken: token})
+}
+
+// deleteAPITokenHandler revokes the user's API token, locking home automation out
+func deleteAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	if err := apitokens.Delete(session.UserID); err != nil {
+		slog.Error("failed to delete API token", slog.Any("error", err))
+		http.Error(w, "Failed to delete API token", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/importer"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// maxImportBytes bounds uploaded exports, a thousand favorites are ~100 KB
+const maxImportBytes = 5 << 20
+
+// importSources are the services favorites can be imported from, by form value
+var importSources = map[string]string{
+	"deezer": "Deezer",
+	"tidal":  "Tidal",
+}
+
+// importRow is one favorite of the review
+type importRow struct {
+	importer.Match
+	Liked bool // the match already is a liked song
+}
+
+// importView is what the import template shows: the review of the matches, or after
+// they were confirmed how many were liked
+type importView struct {
+	LoggedIn bool
+	Source   string
+	Rows     []importRow
+	Missing  int // favorites nothing was found for
+	Liked    int // songs liked after the review, 0 while reviewing
+}
+
+// importFavoritesHandler looks up favorites exported from Deezer or Tidal (file, a CSV)
+// or those of a public Deezer profile (deezer_profile) on Spotify, and renders the matches
+// for review. Nothing is liked yet, see importLikeHandler.
+func importFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	if err := r.ParseMultipartForm(maxImportBytes); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			renderSettings(w, r, settingsMessage{ImportError: "the file is too large"})
+			return
+		}
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	source, ok := importSources[r.PostFormValue("source")]
+	if !ok {
+		http.Error(w, "Unknown source", http.StatusBadRequest)
+		return
+	}
+
+	var entries []importer.Entry
+	var err error
+	if profile := r.PostFormValue("deezer_profile"); profile != "" && source == "Deezer" {
+		deezerUser, ok := importer.DeezerUserID(profile)
+		if !ok {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			renderSettings(w, r, settingsMessage{ImportError: "that isn't a Deezer profile link"})
+			return
+		}
+		entries, err = importer.FetchDeezer(r.Context(), deezerUser)
+	} else {
+		file, _, fileErr := r.FormFile("file")
+		if fileErr != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			renderSettings(w, r, settingsMessage{ImportError: "pick an export file or enter a Deezer profile"})
+			return
+		}
+		defer file.Close()
+		entries, err = importer.ParseCSV(file)
+	}
+	switch {
+	case errors.Is(err, importer.ErrNoColumns), errors.Is(err, importer.ErrNoFavorites), errors.Is(err, importer.ErrPrivateProfile):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderSettings(w, r, settingsMessage{ImportError: err.Error()})
+		return
+	case err != nil:
+		slog.Error("failed to read favorites", "source", source, slog.Any("error", err))
+		w.WriteHeader(http.StatusBadGateway)
+		renderSettings(w, r, settingsMessage{ImportError: "the favorites couldn't be read, try again"})
+		return
+	}
+
+	matches, err := importer.Resolve(r.Context(), accessToken, entries)
+	if err != nil {
+		slog.Error("failed to match favorites", "source", source, "favorites", len(entries), slog.Any("error", err))
+		w.WriteHeader(http.StatusBadGateway)
+		renderSettings(w, r, settingsMessage{ImportError: "Spotify didn't answer, try again in a bit"})
+		return
+	}
+
+	view := importView{LoggedIn: true, Source: source}
+	seen := make(map[string]bool)
+	for _, m := range matches {
+		if m.Track == nil {
+			view.Missing++
+			continue
+		}
+		// Exports repeat songs that are on several albums, they are one track here
+		if seen[m.Track.ID] {
+			continue
+		}
+		seen[m.Track.ID] = true
+		view.Rows = append(view.Rows, importRow{Match: m, Liked: library.Liked(session.UserID, m.Track.BareID())})
+	}
+
+	slog.Info("favorites matched", "source", source, "favorites", len(entries), "matched", len(view.Rows), "missing", view.Missing)
+	renderTemplate(w, view, "web/templates/import.html", "web/templates/header.html")
+}
+
+// importLikeHandler likes the tracks confirmed in the review (track_id, repeatable)
+func importLikeHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	var ids []string
+	for _, id := range r.PostForm["track_id"] {
+		if validSpotifyID(id) && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	err := spotifyClient.SaveTracks(r.Context(), accessToken, ids)
+	// The sync picks the new likes up, records them in the timeline and fills the grid.
+	// Batches liked before a failure count too.
+	scheduleReconcile(session.UserID, library.SectionTracks)
+	if err != nil {
+		slog.Error("failed to like imported tracks", "tracks", len(ids), slog.Any("error", err))
+		http.Error(w, "Spotify refused to like the songs, try again in a moment", http.StatusBadGateway)
+		return
+	}
+
+	slog.Info("imported favorites liked", "tracks", len(ids))
+	renderTemplate(w, importView{LoggedIn: true, Liked: len(ids)}, "web/templates/import.html", "web/templates/header.html")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/metrics"
+	"github.com/jendahorak/bangerid/internal/share"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// staleTempAfter is how old a temporary file in the data directory must be to count as
+// left behind by a crash rather than being written right now
+const staleTempAfter = time.Hour
+
+var (
+	janitorReclaimed = metrics.NewCounter(
+		"bangerid_janitor_reclaimed_total",
+		"Things the janitor removed, by kind.",
+		"kind",
+	)
+	janitorReclaimedBytes = metrics.NewCounter(
+		"bangerid_janitor_reclaimed_bytes_total",
+		"Memory and disk space the janitor freed, by kind.",
+		"kind",
+	)
+)
+
+// runJanitor sweeps every interval until ctx is done. See sweep for what it cleans up.
+func runJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		sweep()
+	}
+}
+
+// sweep removes what nothing will use again: sessions idle past their lifetime, expired
+// cached Spotify responses, share collages that went stale or whose link is gone, and
+// temporary files crashed writes left in the data directory. What it freed is logged and
+// counted in the janitor metrics.
+func sweep() {
+	var freed int64
+	reclaimed := func(kind string, n int, bytes int64) {
+		janitorReclaimed.Add(float64(n), kind)
+		janitorReclaimedBytes.Add(float64(bytes), kind)
+		freed += bytes
+	}
+
+	sessions := handlers.PruneSessions()
+	reclaimed("sessions", sessions, 0)
+
+	cached, cachedBytes := spotifyClient.PruneCache()
+	reclaimed("cache", cached, cachedBytes)
+
+	collageCount, collageBytes := pruneCollages()
+	reclaimed("collages", collageCount, collageBytes)
+
+	var tempFiles int
+	if cfg.DataDir != "" {
+		var tempBytes int64
+		tempFiles, tempBytes = removeStaleTempFiles(cfg.DataDir)
+		reclaimed("temp-files", tempFiles, tempBytes)
+	}
+
+	slog.Info("janitor swept",
+		"sessions", sessions,
+		"cache_entries", cached,
+		"collages", collageCount,
+		"temp_files", tempFiles,
+		"freed_bytes", freed,
+	)
+}
+
+// pruneCollages drops the cached share collages that are stale or whose share link was
+// deleted since, and returns how many and their size
+func pruneCollages() (int, int64) {
+	collagesMu.Lock()
+	defer collagesMu.Unlock()
+
+	var n int
+	var bytes int64
+	for token, cached := range collages {
+		if _, ok := share.Lookup(token); ok && time.Since(cached.drawnAt) <= collageTTL {
+			continue
+		}
+		n++
+		bytes += int64(len(cached.jpeg))
+		delete(collages, token)
+	}
+	return n, bytes
+}
+
+// removeStaleTempFiles deletes the temporary files of atomic writes below dir that are
+// older than staleTempAfter, and returns how many and their size
+func removeStaleTempFiles(dir string) (int, int64) {
+	var n int
+	var bytes int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		if !strings.HasSuffix(name, ".tmp") && !fileutil.IsTemp(name) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || time.Since(info.ModTime()) < staleTempAfter {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			slog.Warn("failed to remove stale temporary file", "path", path, slog.Any("error", err))
+			return nil
+		}
+		n++
+		bytes += info.Size()
+		return nil
+	})
+	return n, bytes
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/eventlog"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/jobs"
+	"github.com/jendahorak/bangerid/internal/library"
+	"github.com/jendahorak/bangerid/internal/metrics"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// Kinds of background jobs
+const (
+	jobSync   = "sync"   // refetch one library section of a user
+	jobEnrich = "enrich" // fetch extra data, like audio features, for a synced section
+)
+
+// Background syncs fail silently, users just see an older library. These gauges tell
+// operators about the users background sync is meant to keep fresh, the ones with the app
+// open, so they can alert on it.
+var (
+	_ = metrics.NewGaugeFunc(
+		"bangerid_library_sync_age_seconds",
+		"Seconds since the last successful sync of a section, for the active user synced longest ago.",
+		func(set func(float64, ...string)) {
+			for s, status := range syncStatuses() {
+				if !status.oldest.IsZero() {
+					set(time.Since(status.oldest).Seconds(), string(s))
+				}
+			}
+		},
+		"section",
+	)
+	_ = metrics.NewGaugeFunc(
+		"bangerid_library_sync_failing_users",
+		"Active users whose most recent sync of a section failed.",
+		func(set func(float64, ...string)) {
+			for s, status := range syncStatuses() {
+				set(float64(status.failing), string(s))
+			}
+		},
+		"section",
+	)
+	_ = metrics.NewGaugeFunc(
+		"bangerid_library_last_sync_error_timestamp_seconds",
+		"Unix time of the latest failed sync of a section among active users still failing.",
+		func(set func(float64, ...string)) {
+			for s, status := range syncStatuses() {
+				if !status.lastFailure.IsZero() {
+					set(float64(status.lastFailure.Unix()), string(s))
+				}
+			}
+		},
+		"section",
+	)
+)
+
+// syncStatus sums up how the syncs of a section go for the active users
+type syncStatus struct {
+	oldest      time.Time // the oldest last successful sync, zero if none synced yet
+	failing     int       // users whose most recent sync failed
+	lastFailure time.Time // the latest of those failures
+}
+
+// syncStatuses sums up the syncs of every section for the active users. It is empty
+// without background sync, nothing keeps libraries fresh then.
+func syncStatuses() map[library.Section]syncStatus {
+	statuses := make(map[library.Section]syncStatus)
+	if cfg == nil || cfg.SyncInterval <= 0 || cfg.DemoMode {
+		return statuses
+	}
+
+	users := handlers.ActiveUsers(cfg.ActiveWindow)
+	for _, s := range library.EnabledSections() {
+		var status syncStatus
+		for _, userID := range users {
+			syncedAt, failedAt := library.LastSync(userID, s)
+			if !syncedAt.IsZero() && (status.oldest.IsZero() || syncedAt.Before(status.oldest)) {
+				status.oldest = syncedAt
+			}
+			if !failedAt.IsZero() {
+				status.failing++
+				if failedAt.After(status.lastFailure) {
+					status.lastFailure = failedAt
+				}
+			}
+		}
+		statuses[s] = status
+	}
+	return statuses
+}
+
+// startJobs registers the job handlers, hands enrichment after syncs to the queue and
+// starts the workers. Background syncs are scheduled every cfg.SyncInterval, if set.
+func startJobs(ctx context.Context) {
+	jobs.Register(jobSync, runSyncJob)
+	jobs.Register(jobEnrich, runEnrichJob)
+
+	library.DeferEnrichment(func(userID string, s library.Section) {
+		jobs.Enqueue(jobEnrich, jobEnrich+"/"+string(s)+"/"+userID, map[string]string{"user": userID, "section": string(s)})
+	})
+
+	jobs.Start(ctx, cfg.JobWorkers)
+	if cfg.SyncInterval > 0 {
+		go scheduleSyncs(ctx, cfg.SyncInterval)
+	}
+}
+
+// scheduleSyncs queues a sync of every section for the users with the app open, every
+// interval until ctx is done, keeping their caches fresh without them waiting for a fetch
+func scheduleSyncs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, userID := range handlers.ActiveUsers(cfg.ActiveWindow) {
+			for _, s := range library.EnabledSections() {
+				jobs.Enqueue(jobSync, jobSync+"/"+string(s)+"/"+userID, map[string]string{"user": userID, "section": string(s)})
+			}
+		}
+	}
+}
+
+// runSyncJob syncs the section of the user named by the job, announces it over MQTT and
+// logs it. With the event log on, syncing the liked songs also picks up the latest plays
+// for it.
+func runSyncJob(ctx context.Context, job jobs.Job) error {
+	userID, s, accessToken, err := jobAccount(ctx, job)
+	if err != nil {
+		return err
+	}
+	ctx = spotifyClient.WithUser(ctx, userID)
+	before := syncObserver(userID, s)
+	if err := library.Sync(ctx, userID, accessToken, s); err != nil {
+		return err
+	}
+	publishSync(ctx, userID, s, before)
+	logSync(userID, s, before)
+	if s == library.SectionTracks && eventlog.Enabled() {
+		recordRecentlyPlayed(ctx, userID, accessToken)
+	}
+	return nil
+}
+
+// runEnrichJob enriches the section of the user named by the job
+func runEnrichJob(ctx context.Context, job jobs.Job) error {
+	userID, s, accessToken, err := jobAccount(ctx, job)
+	if err != nil {
+		return err
+	}
+	return library.Enrich(spotifyClient.WithUser(ctx, userID), userID, accessToken, s)
+}
+
+// jobAccount resolves the user and library section of a job and gets a fresh access
+// token for the user. Tokens aren't stored with jobs, they would expire in the queue.
+func jobAccount(ctx context.Context, job jobs.Job) (string, library.Section, string, error) {
+	userID, s := job.Args["user"], library.Section(job.Args["section"])
+	if !library.KnownSection(s) {
+		return "", "", "", jobs.Permanent(fmt.Errorf("unknown library section %q", s))
+	}
+
+	accessToken, _, err := handlers.UserAccessToken(ctx, oauthApps, userID)
+	if errors.Is(err, handlers.ErrSuspended) {
+		return "", "", "", jobs.Permanent(err)
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	return userID, s, accessToken, nil
+}
+
+// retryJobHandler puts a dead job back into the queue and re-renders the admin page
+func retryJobHandler(w http.ResponseWriter, r *http.Request) {
+	if !jobs.Retry(r.PathValue("id")) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
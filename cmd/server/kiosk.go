@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/kiosks"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// A kiosk is a display the user paired to show what they play, like a tablet on the
+// wall: /kiosk shows a pairing code until the user enters it at /kiosk/pair, and from
+// then on what is playing over the album art of the liked songs, without anything to
+// click. The display's cookie opens that page and nothing else, it can't change a thing.
+
+const (
+	// kioskCookieName holds a paired display's token
+	kioskCookieName = "bangerid_kiosk"
+	// kioskPairingCookieName holds the secret of a display waiting to be paired
+	kioskPairingCookieName = "bangerid_kiosk_pairing"
+	// kioskCookieLifetime is how long a display stays paired without being unpaired, the
+	// cookie is renewed whenever the display loads the page
+	kioskCookieLifetime = 365 * 24 * time.Hour
+
+	// kioskPairingRateLimit is how many pairings a client may start per kioskPairingWindow,
+	// a few displays behind one address reloading now and then stay well below it
+	kioskPairingRateLimit = 10
+	// kioskPairingWindow is how long the rate limit counts the pairings a client started
+	kioskPairingWindow = 10 * time.Minute
+
+	// kioskArtTiles is how many album covers the art wall shows
+	kioskArtTiles = 24
+	// kioskArtInterval is how often one cover of the wall is swapped for another
+	kioskArtInterval = 20 * time.Second
+)
+
+// kioskPairingHits counts the pairings each client started in the current window of the
+// rate limit
+var (
+	kioskPairingMu          sync.Mutex
+	kioskPairingWindowStart time.Time
+	kioskPairingHits        = make(map[string]int)
+)
+
+// allowKioskPairing counts a pairing a client starts against the rate limit. When it's
+// over the limit it returns how long until it may start another.
+func allowKioskPairing(client string) (time.Duration, bool) {
+	kioskPairingMu.Lock()
+	defer kioskPairingMu.Unlock()
+
+	now := time.Now()
+	if now.Sub(kioskPairingWindowStart) >= kioskPairingWindow {
+		clear(kioskPairingHits)
+		kioskPairingWindowStart = now
+	}
+	kioskPairingHits[client]++
+	if kioskPairingHits[client] > kioskPairingRateLimit {
+		return kioskPairingWindowStart.Add(kioskPairingWindow).Sub(now), false
+	}
+	return 0, true
+}
+
+// kioskUser returns the user whose display made the request, false for displays that
+// aren't paired and for suspended users
+func kioskUser(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(kioskCookieName)
+	if err != nil {
+		return "", false
+	}
+	userID, ok := kiosks.Lookup(cookie.Value)
+	if !ok || handlers.Suspended(userID) {
+		return "", false
+	}
+	return userID, true
+}
+
+// setKioskCookie pairs the browser as a display with its token
+func setKioskCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     kioskCookieName,
+		Value:    token,
+		Path:     "/kiosk",
+		Expires:  time.Now().Add(kioskCookieLifetime),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// kioskArt picks album covers of the user's liked songs at random for the art wall,
+// each one once
+func kioskArt(userID string, n int) []string {
+	seen := make(map[string]bool)
+	var covers []string
+	for _, t := range library.CachedTracks(userID) {
+		if t.AlbumImage != "" && !seen[t.AlbumImage] {
+			seen[t.AlbumImage] = true
+			covers = append(covers, t.AlbumImage)
+		}
+	}
+	rand.Shuffle(len(covers), func(i, j int) { covers[i], covers[j] = covers[j], covers[i] })
+	return covers[:min(n, len(covers))]
+}
+
+// kioskHandler renders the kiosk page of a paired display, or the pairing code for the
+// user to enter on a display that isn't paired yet. Every display without a pairing
+// cookie starts a pairing, so clients are rate limited in doing so, by address.
+func kioskHandler(w http.ResponseWriter, r *http.Request) {
+	if userID, ok := kioskUser(r); ok {
+		cookie, _ := r.Cookie(kioskCookieName)
+		setKioskCookie(w, cookie.Value)
+		renderTemplate(w, kioskArt(userID, kioskArtTiles), "web/templates/kiosk.html")
+		return
+	}
+
+	var code, secret string
+	if cookie, err := r.Cookie(kioskPairingCookieName); err == nil {
+		secret = cookie.Value
+		code, _ = kiosks.PendingCode(secret)
+	}
+	if code == "" {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if wait, ok := allowKioskPairing(client); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		code, secret = kiosks.RequestPairing()
+		http.SetCookie(w, &http.Cookie{
+			Name:     kioskPairingCookieName,
+			Value:    secret,
+			Path:     "/kiosk",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	data := struct {
+		Code    string
+		PairURL string
+	}{
+		Code:    code[:4] + "-" + code[4:],
+		PairURL: baseURL(r) + "/kiosk/pair",
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplate(w, data, "web/templates/kiosk-pairing.html")
+}
+
+// kioskPairingHandler tells a display waiting to be paired whether its code was entered,
+// which the pairing page polls for. Once it was it pairs the display and sends it to the
+// kiosk page, and once the code expired it reloads the page for a new one.
+func kioskPairingHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(kioskPairingCookieName)
+	if err != nil {
+		w.Header().Set("HX-Refresh", "true")
+		return
+	}
+
+	token, err := kiosks.Collect(cookie.Value)
+	if err != nil {
+		// Paired all the same, until a restart
+		slog.Error("failed to save kiosk", slog.Any("error", err))
+	}
+	switch {
+	case token != "":
+		setKioskCookie(w, token)
+		http.SetCookie(w, &http.Cookie{Name: kioskPairingCookieName, Path: "/kiosk", MaxAge: -1})
+		w.Header().Set("HX-Redirect", "/kiosk")
+	case !pendingPairing(cookie.Value):
+		w.Header().Set("HX-Refresh", "true")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pendingPairing reports whether the display with the secret still waits for its code
+func pendingPairing(secret string) bool {
+	_, ok := kiosks.PendingCode(secret)
+	return ok
+}
+
+// kioskPairPage is what the page for entering a display's code shows
+type kioskPairPage struct {
+	LoggedIn bool
+	Code     string
+	Name     string
+	Error    string
+}
+
+// kioskPairHandler renders the form for entering the code a display shows, ?code=
+// filled in
+func kioskPairHandler(w http.ResponseWriter, r *http.Request) {
+	data := kioskPairPage{LoggedIn: true, Code: r.FormValue("code")}
+	renderTemplate(w, data, "web/templates/kiosk-pair.html", "web/templates/header.html")
+}
+
+// approveKioskHandler pairs the display showing the code entered with the user
+func approveKioskHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	code := r.PostFormValue("code")
+	name := r.PostFormValue("name")
+	if name == "" {
+		name = "Display"
+	}
+	if len([]rune(name)) > 40 {
+		name = string([]rune(name)[:40])
+	}
+
+	if !kiosks.Approve(session.UserID, code, name) {
+		data := kioskPairPage{
+			LoggedIn: true,
+			Code:     code,
+			Name:     r.PostFormValue("name"),
+			Error:    "No display shows this code. Codes work for 10 minutes, reload the display for a new one.",
+		}
+		renderTemplate(w, data, "web/templates/kiosk-pair.html", "web/templates/header.html")
+		return
+	}
+	slog.Info("kiosk paired", "user", session.UserID)
+	http.Redirect(w, r, "/settings#kiosks", http.StatusSeeOther)
+}
+
+// unpairKioskHandler unpairs one of the user's displays
+func unpairKioskHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	if err := kiosks.Unpair(session.UserID, r.PathValue("id")); err != nil {
+		slog.Error("failed to unpair kiosk", slog.Any("error", err))
+		http.Error(w, "Failed to unpair the display", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings#kiosks", http.StatusSeeOther)
+}
+
+// kioskEventsHandler streams a paired display's page as server-sent events: a "now"
+// event with what is playing whenever it changes, and every kioskArtInterval an "art"
+// event swapping one cover of the art wall for another
+func kioskEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := kioskUser(r)
+	if !ok {
+		http.Error(w, "Display is not paired", http.StatusUnauthorized)
+		return
+	}
+	tmpl, err := template.New("kiosk.html").Funcs(templateFuncs()).ParseFiles("web/templates/kiosk.html")
+	if err != nil {
+		slog.Error("template parse error", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	rc := http.NewResponseController(w)
+
+	// The demo has no Spotify to ask what is playing, only the art wall moves
+	var updates <-chan *spotifyClient.CurrentlyPlaying
+	if !cfg.DemoMode {
+		var unsubscribe func()
+		updates, unsubscribe = nowPlaying.Subscribe(userID)
+		defer unsubscribe()
+	}
+	art := time.NewTicker(kioskArtInterval)
+	defer art.Stop()
+	keepAlive := time.NewTicker(statusKeepAlive)
+	defer keepAlive.Stop()
+
+	var sent *string // the now-playing card sent last, nil before the first
+	for {
+		var event string
+		select {
+		case <-r.Context().Done():
+			return
+		case current := <-updates:
+			var card bytes.Buffer
+			if err := tmpl.ExecuteTemplate(&card, "kiosk-now", current); err != nil {
+				slog.Error("template execute error", slog.Any("error", err))
+				return
+			}
+			if sent != nil && *sent == card.String() {
+				continue
+			}
+			s := card.String()
+			sent = &s
+			event = sseEvent("now", strings.TrimSpace(s))
+		case <-art.C:
+			// The wall has fewer tiles than kioskArtTiles when the library has fewer covers
+			covers := kioskArt(userID, kioskArtTiles)
+			if len(covers) == 0 {
+				continue
+			}
+			tile := struct {
+				Index int
+				Cover string
+			}{rand.IntN(len(covers)), covers[0]}
+			var b bytes.Buffer
+			if err := tmpl.ExecuteTemplate(&b, "kiosk-tile", tile); err != nil {
+				slog.Error("template execute error", slog.Any("error", err))
+				return
+			}
+			event = sseEvent("art", strings.TrimSpace(b.String()))
+		case <-keepAlive.C:
+			event = sseKeepAlive
+		}
+
+		if _, err := w.Write([]byte(event)); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
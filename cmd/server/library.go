@@ -0,0 +1,187 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// albumsHandler renders the user's saved albums as a grid
+func albumsHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	albums, err := library.Albums(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch albums", slog.Any("error", err))
+		http.Error(w, "Failed to load albums", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, albums, "web/templates/albums.html")
+}
+
+// artistsHandler renders the artists the user follows as a grid
+func artistsHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	artists, err := library.Artists(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch artists", slog.Any("error", err))
+		http.Error(w, "Failed to load artists", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, artists, "web/templates/artists.html")
+}
+
+// playlistsHandler renders the playlists the user owns or follows as a grid
+func playlistsHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.DemoMode {
+		// The sample library has no playlists and there is no Spotify account to ask
+		renderTemplate(w, []spotifyClient.Playlist(nil), "web/templates/playlists.html")
+		return
+	}
+
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+
+	playlists, err := spotifyClient.FetchUserPlaylists(r.Context(), accessToken)
+	if err != nil {
+		slog.Error("failed to fetch playlists", slog.Any("error", err))
+		http.Error(w, "Failed to load playlists", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, playlists, "web/templates/playlists.html")
+}
+
+// audiobooksHandler renders the user's saved audiobooks as a grid
+func audiobooksHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	audiobooks, err := library.Audiobooks(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch audiobooks", slog.Any("error", err))
+		http.Error(w, "Failed to load audiobooks", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, audiobooks, "web/templates/audiobooks.html")
+}
+
+// showsHandler renders the podcasts the user follows as a grid
+func showsHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	shows, err := library.Shows(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch shows", slog.Any("error", err))
+		http.Error(w, "Failed to load podcasts", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, shows, "web/templates/shows.html")
+}
+
+// showEpisodesShown caps the episode list of a show, long running ones have thousands
+const showEpisodesShown = 100
+
+// showEpisodesHandler renders the episodes of a podcast the user follows, newest first,
+// to play one by one
+func showEpisodesHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+	showID := r.PathValue("id")
+	if !validSpotifyID(showID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	shows, err := library.Shows(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch shows", slog.Any("error", err))
+		http.Error(w, "Failed to load podcast", http.StatusInternalServerError)
+		return
+	}
+	i := slices.IndexFunc(shows, func(s spotifyClient.Show) bool { return s.ID == showID })
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	show := shows[i]
+
+	var episodes []spotifyClient.Episode
+	if !cfg.DemoMode {
+		if episodes, err = spotifyClient.GetShowEpisodes(r.Context(), accessToken, showID, showEpisodesShown); err != nil {
+			slog.Error("failed to fetch show episodes", "show", showID, slog.Any("error", err))
+			http.Error(w, "Failed to load podcast", http.StatusInternalServerError)
+			return
+		}
+	}
+	for i := range episodes {
+		episodes[i].Show = show.Name
+		episodes[i].Image = cmp.Or(episodes[i].Image, show.Image)
+	}
+
+	data := struct {
+		Show     spotifyClient.Show
+		Episodes []spotifyClient.Episode
+	}{
+		Show:     show,
+		Episodes: episodes,
+	}
+	renderTemplate(w, data, "web/templates/show.html")
+}
+
+// episodesHandler renders the podcast episodes the user saved as a grid
+func episodesHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	episodes, err := library.Episodes(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch episodes", slog.Any("error", err))
+		http.Error(w, "Failed to load episodes", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, episodes, "web/templates/episodes.html")
+}
+
+// maxRecentTracks caps the "New this week" strip and its play-all queue
+const maxRecentTracks = 50
+
+// recentHandler renders the strip of liked tracks added within the configured window
+func recentHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	since := time.Now().Add(-cfg.NewTracksWindow)
+	tracks, err := library.RecentlyAdded(r.Context(), session.UserID, accessToken, since)
+	if err != nil {
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load tracks", http.StatusInternalServerError)
+		return
+	}
+	if len(tracks) > maxRecentTracks {
+		tracks = tracks[:maxRecentTracks]
+	}
+
+	data := struct {
+		Tracks []spotifyClient.Track
+		Days   int
+	}{
+		Tracks: tracks,
+		Days:   int(cfg.NewTracksWindow.Hours() / 24),
+	}
+	renderTemplate(w, data, "web/templates/recent.html")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/eventlog"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/jobs"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// reconcileDelay is how long after the last like, unlike or save the library is synced
+// again, so flipping through a few tracks costs one sync instead of one each
+const reconcileDelay = time.Minute
+
+// likeView is what the like template shows
+type likeView struct {
+	TrackID string
+	Liked   bool
+	Tile    bool // the heart on a grid tile instead of the detail panel's button
+}
+
+// likableTiles offers to like or unlike the tiles' tracks, for grids of tracks that
+// aren't all liked. Spotify is asked which are liked, the cache may not have caught up
+// with likes from elsewhere; if it doesn't answer the cache has to do. Accounts that
+// can't change their liked songs get no hearts.
+func likableTiles(r *http.Request, tiles []gridTile) []gridTile {
+	session := handlers.CurrentSession(r)
+	if cfg.DemoMode || !featureAvailable("likes", session) || len(tiles) == 0 {
+		return tiles
+	}
+
+	ids := make([]string, len(tiles))
+	for i, tile := range tiles {
+		ids[i] = tile.Track.BareID()
+	}
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	saved, err := spotifyClient.CheckSavedTracks(r.Context(), accessToken, ids)
+	if err != nil {
+		slog.Warn("failed to check liked tracks, using the cache", slog.Any("error", err))
+		saved = make([]bool, len(ids))
+		for i, id := range ids {
+			saved[i] = library.Liked(session.UserID, id)
+		}
+	}
+	for i := range tiles {
+		tiles[i].Likable = true
+		tiles[i].Liked = saved[i]
+	}
+	return tiles
+}
+
+// likeHandler renders the like button of a track for the detail panel. Accounts that
+// can't change their liked songs get nothing.
+func likeHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+	if !validSpotifyID(trackID) {
+		http.NotFound(w, r)
+		return
+	}
+	if cfg.DemoMode || !featureAvailable("likes", session) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	renderTemplate(w, likeView{TrackID: trackID, Liked: library.Liked(session.UserID, trackID)}, "web/templates/like.html")
+}
+
+// saveLikeHandler adds a track to the user's liked songs. The cache changes first so the
+// grid doesn't wait for Spotify; if Spotify refuses, the change is rolled back and the
+// response is an error toast.
+func saveLikeHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+	if !validSpotifyID(trackID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Usually cached, the tile it was liked from just showed it
+	track, err := spotifyClient.GetTrack(r.Context(), accessToken, trackID)
+	if err != nil {
+		slog.Error("failed to fetch track", "track", trackID, slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Couldn't like the track, Spotify didn't answer.")
+		return
+	}
+
+	undo, err := library.AddTrack(session.UserID, *track, time.Now())
+	if err != nil {
+		slog.Warn("failed to persist library", "user", session.UserID, slog.Any("error", err))
+	}
+	if err := spotifyClient.SaveTracks(r.Context(), accessToken, []string{trackID}); err != nil {
+		slog.Error("failed to like track", "track", trackID, slog.Any("error", err))
+		undo()
+		renderToast(w, http.StatusBadGateway, "Couldn't like "+track.Name+", Spotify refused. Try again in a moment.")
+		return
+	}
+	scheduleReconcile(session.UserID, library.SectionTracks)
+	like := trackEvent(eventlog.Like, session.UserID, *track)
+	like.Source = eventlog.SourceApp
+	logEvents(like)
+
+	renderTemplate(w, likeView{TrackID: trackID, Liked: true, Tile: r.URL.Query().Get("view") == "tile"}, "web/templates/like.html")
+}
+
+// deleteLikeHandler removes a track from the user's liked songs, optimistically like
+// saveLikeHandler
+func deleteLikeHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+	if !validSpotifyID(trackID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	unlike := eventlog.Event{Type: eventlog.Unlike, User: session.UserID, Track: "spotify:track:" + trackID}
+	if eventlog.Enabled() {
+		// Name the track while the cache still has it
+		for _, t := range library.CachedTracks(session.UserID) {
+			if spotifyClient.IDFromURI(t.ID) == trackID {
+				unlike = trackEvent(eventlog.Unlike, session.UserID, t)
+				break
+			}
+		}
+	}
+	unlike.Source = eventlog.SourceApp
+
+	undo, err := library.RemoveTrack(session.UserID, trackID)
+	if err != nil {
+		slog.Warn("failed to persist library", "user", session.UserID, slog.Any("error", err))
+	}
+	if err := spotifyClient.RemoveSavedTracks(r.Context(), accessToken, []string{trackID}); err != nil {
+		slog.Error("failed to unlike track", "track", trackID, slog.Any("error", err))
+		undo()
+		renderToast(w, http.StatusBadGateway, "Couldn't unlike the track, Spotify refused. It's still in your liked songs.")
+		return
+	}
+	scheduleReconcile(session.UserID, library.SectionTracks)
+	logEvents(unlike)
+
+	renderTemplate(w, likeView{TrackID: trackID, Liked: false, Tile: r.URL.Query().Get("view") == "tile"}, "web/templates/like.html")
+}
+
+// renderToast responds with an error notice for the toast area of the page. htmx doesn't
+// swap error responses, app.js picks the toast out of them instead.
+func renderToast(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	renderTemplate(w, message, "web/templates/toast.html")
+}
+
+// scheduleReconcile queues a sync of a section of the user's library reconcileDelay from
+// now, pushing back one that is already waiting. The cache was updated by hand, the sync
+// confirms it against Spotify (and for liked tracks records the change in the timeline).
+func scheduleReconcile(userID string, s library.Section) {
+	jobs.Debounce(jobSync, jobSync+"/"+string(s)+"/"+userID, map[string]string{"user": userID, "section": string(s)}, reconcileDelay)
+}
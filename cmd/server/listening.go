@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/history"
+	"github.com/jendahorak/bangerid/internal/prefs"
+)
+
+const (
+	// maxStreamsBytes bounds uploaded streaming histories, ten years of listening are
+	// ~30 MB zipped
+	maxStreamsBytes = 100 << 20
+	// maxStreamsFileBytes bounds each file unpacked from a zip, Spotify splits the history
+	// into files of ~12 MB
+	maxStreamsFileBytes = 64 << 20
+	// listeningTop is how many tracks and artists the stats show for each year
+	listeningTop = 5
+)
+
+// streamsFile reports whether a file of a zipped export is part of the extended
+// streaming history, whose older exports call them endsong_0.json and newer ones
+// Streaming_History_Audio_2019-2020_3.json. Video plays are in files of their own.
+func streamsFile(name string) bool {
+	base := path.Base(name)
+	return strings.HasSuffix(base, ".json") &&
+		(strings.HasPrefix(base, "endsong") || strings.HasPrefix(base, "Streaming_History_Audio"))
+}
+
+// readStreams parses an uploaded file of the streaming history, or all of them from the
+// zip Spotify sends
+func readStreams(header *multipart.FileHeader) ([]history.Stream, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if !strings.HasSuffix(strings.ToLower(header.Filename), ".zip") {
+		return history.ParseStreams(file)
+	}
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		return nil, history.ErrNotStreamingHistory
+	}
+	var streams []history.Stream
+	for _, f := range archive.File {
+		if !streamsFile(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		s, err := history.ParseStreams(io.LimitReader(rc, maxStreamsFileBytes))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, s...)
+	}
+	return streams, nil
+}
+
+// importStreamsHandler imports the extended streaming history Spotify sends on request
+// (file, repeatable: the zip or the JSON files in it), replacing the one imported before.
+// It backfills when the user last played their tracks and tells how often they played
+// what, year by year, on the stats page.
+func importStreamsHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxStreamsBytes)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			renderSettings(w, r, settingsMessage{StreamsError: "the upload is too large"})
+			return
+		}
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderSettings(w, r, settingsMessage{StreamsError: "pick the zip or the JSON files of your streaming history"})
+		return
+	}
+	var streams []history.Stream
+	for _, header := range files {
+		s, err := readStreams(header)
+		if errors.Is(err, history.ErrNotStreamingHistory) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			renderSettings(w, r, settingsMessage{StreamsError: header.Filename + " isn't an extended streaming history"})
+			return
+		}
+		if err != nil {
+			slog.Warn("failed to read streaming history", "file", header.Filename, slog.Any("error", err))
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			renderSettings(w, r, settingsMessage{StreamsError: header.Filename + " couldn't be read"})
+			return
+		}
+		streams = append(streams, s...)
+	}
+	if len(streams) == 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderSettings(w, r, settingsMessage{StreamsError: "there are no plays of tracks in it"})
+		return
+	}
+
+	listening, err := history.ImportStreams(session.UserID, streams, prefs.Get(session.UserID).Location())
+	if err != nil {
+		// Kept in memory all the same, until a restart
+		slog.Error("failed to save streaming history", slog.Any("error", err))
+	}
+	slog.Info("streaming history imported", "user", session.UserID, "plays", listening.Plays(), "years", len(listening.Years))
+	http.Redirect(w, r, "/stats#listening", http.StatusSeeOther)
+}
+
+// listeningYear is a year of the imported streaming history as the stats show it
+type listeningYear struct {
+	Year       int
+	Plays      int
+	Hours      int // listened, rounded
+	TopTracks  []history.TrackPlays
+	TopArtists []history.ArtistPlays
+}
+
+// listeningHandler renders what the imported streaming history says about each year, the
+// latest first: how much the user listened and what they played most
+func listeningHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	listening, ok := history.ImportedListening(session.UserID)
+
+	var years []listeningYear
+	for i := len(listening.Years) - 1; i >= 0; i-- {
+		y := listening.Years[i]
+		years = append(years, listeningYear{
+			Year:       y.Year,
+			Plays:      y.Plays,
+			Hours:      int(y.Listened.Hours() + 0.5),
+			TopTracks:  y.Tracks[:min(listeningTop, len(y.Tracks))],
+			TopArtists: y.Artists[:min(listeningTop, len(y.Artists))],
+		})
+	}
+
+	data := struct {
+		Imported bool
+		From     string
+		Until    string
+		Plays    int
+		Years    []listeningYear
+	}{
+		Imported: ok,
+		From:     listening.From.Format("January 2006"),
+		Until:    listening.Until.Format("January 2006"),
+		Plays:    listening.Plays(),
+		Years:    years,
+	}
+	renderTemplate(w, data, "web/templates/listening.html")
+}
@@ -1,22 +1,60 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	_ "time/tzdata" // users' time zones load on hosts without a zoneinfo database too
 
+	"github.com/jendahorak/bangerid/internal/annotations"
+	"github.com/jendahorak/bangerid/internal/apitokens"
+	"github.com/jendahorak/bangerid/internal/applemusic"
+	"github.com/jendahorak/bangerid/internal/bots"
+	"github.com/jendahorak/bangerid/internal/collation"
+	"github.com/jendahorak/bangerid/internal/config"
+	"github.com/jendahorak/bangerid/internal/errorreport"
 	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/harmony"
+	"github.com/jendahorak/bangerid/internal/history"
+	"github.com/jendahorak/bangerid/internal/imageproxy"
+	"github.com/jendahorak/bangerid/internal/jobs"
+	"github.com/jendahorak/bangerid/internal/kiosks"
+	"github.com/jendahorak/bangerid/internal/library"
+	"github.com/jendahorak/bangerid/internal/metrics"
+	"github.com/jendahorak/bangerid/internal/mqtt"
+	"github.com/jendahorak/bangerid/internal/playback"
+	"github.com/jendahorak/bangerid/internal/prefs"
+	"github.com/jendahorak/bangerid/internal/prompt"
+	"github.com/jendahorak/bangerid/internal/share"
 	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+	"github.com/jendahorak/bangerid/internal/timeline"
+	"github.com/jendahorak/bangerid/internal/waveform"
 	"github.com/joho/godotenv"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/spotify"
 )
 
 var (
-	oauthConfig *oauth2.Config
-	tracksCache []spotifyClient.Track // Simple global cache for single user
+	cfg           *config.Config
+	oauthApps     *handlers.OAuthApps
+	sessionPolicy handlers.SessionPolicy
+	imageSigner   *imageproxy.Signer
+	interpreter   prompt.Interpreter                             // nil unless a language model is configured
+	appleMusic    *applemusic.Client                             // nil unless a developer token is configured
+	broker        *mqtt.Client                                   // nil unless an MQTT broker is configured
+	player        playback.Player    = playback.SpotifyConnect{} // carries out play actions
 )
 
 // loggingMiddleware wraps an HTTP handler and logs each request
@@ -40,6 +78,58 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// timeoutMiddleware puts a deadline on every request as configured for its route.
+// Spotify calls are made with the request context, so a slow upstream call is cancelled
+// instead of pinning the connection indefinitely.
+func timeoutMiddleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Event streams stay open until the client goes away
+		if r.Header.Get("Accept") == "text/event-stream" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		timeout := cfg.RequestTimeout
+		if _, pattern := mux.Handler(r); pattern != "" {
+			// Patterns may start with a method, e.g. "GET /grid", timeouts are keyed by path
+			if _, path, ok := strings.Cut(pattern, " "); ok {
+				pattern = path
+			}
+			if t, ok := cfg.RouteTimeouts[pattern]; ok {
+				timeout = t
+			}
+		}
+
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// recoverMiddleware turns a panicking handler into a 500 instead of a dropped connection,
+// and reports the panic
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				// Deliberate, e.g. a proxied response the client went away from
+				panic(rec)
+			}
+			slog.Error("handler panicked", "method", r.Method, "path", r.URL.Path, "panic", rec, "stack", string(debug.Stack()))
+			errorreport.Panic(rec, errorreport.Tags{"method": r.Method, "route": r.Pattern})
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
 // responseWriter wraps http.ResponseWriter to capture the status code
 type responseWriter struct {
 	http.ResponseWriter
@@ -51,69 +141,435 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the connection, e.g. to flush event streams
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func main() {
 	// Load environment variables from .env file
 	if err := godotenv.Load(); err != nil {
 		slog.Warn("Warning: .env file not found, using system environment variables")
 	}
 
-	// Initialize OAuth config after env vars are loaded
-	oauthConfig = &oauth2.Config{
-		ClientID:     os.Getenv("CLIENT_ID"),
-		ClientSecret: os.Getenv("CLIENT_SECRET"),
-		RedirectURL:  os.Getenv("REDIRECT_URL"),
-		Scopes:       []string{"user-read-private", "user-read-email", "playlist-read-private", "user-library-read", "streaming"},
-		Endpoint:     spotify.Endpoint,
+	var err error
+	if cfg, err = config.Load(); err != nil {
+		slog.Error("invalid configuration", slog.Any("error", err))
+		os.Exit(1)
+	}
+	sessionPolicy = handlers.SessionPolicy{
+		TTL:         cfg.SessionTTL,
+		RememberTTL: cfg.RememberTTL,
 	}
 
-	// Validate that required env vars are set
-	if oauthConfig.ClientID == "" || oauthConfig.ClientSecret == "" {
-		slog.Error("missing required env vars", slog.String("vars", "CLIENT_ID, CLIENT_SECRET"))
-		os.Exit(1)
+	for resource, ttl := range cfg.CacheTTLs {
+		spotifyClient.SetCacheTTL(spotifyClient.Resource(resource), ttl)
+	}
+
+	// Optional library sections
+	if cfg.LibraryAudiobooks {
+		library.EnableSection(library.SectionAudiobooks)
+	}
+	if cfg.LibraryShows {
+		library.EnableSection(library.SectionShows)
+	}
+	if cfg.LibraryEpisodes {
+		library.EnableSection(library.SectionEpisodes)
+	}
+
+	// Images are served through our signed proxy, see the imageURL template helper
+	secret := []byte(cfg.ImageProxySecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	imageSigner = imageproxy.NewSigner(secret, cfg.ImageURLTTL, "/img")
+	imageproxy.SetConcurrency(cfg.ImageWorkers)
+	spotifyClient.SetBatchParallelism(cfg.EnrichParallelism)
+
+	if cfg.ErrorReportDSN != "" {
+		if err := errorreport.Configure(cfg.ErrorReportDSN, cfg.ErrorReportSampleRate, cfg.ErrorReportEnvironment); err != nil {
+			slog.Error("failed to configure error reporting", slog.Any("error", err))
+			os.Exit(1)
+		}
+		slog.Info("error reporting enabled", "sample_rate", cfg.ErrorReportSampleRate)
+	}
+
+	if cfg.LLMModel != "" {
+		interpreter = prompt.NewOpenAI(cfg.LLMBaseURL, cfg.LLMAPIKey, cfg.LLMModel)
+		slog.Info("free-text requests enabled", "model", cfg.LLMModel)
+	}
+
+	if cfg.AppleMusicDeveloperToken != "" {
+		appleMusic = applemusic.NewClient(cfg.AppleMusicDeveloperToken, cfg.AppleMusicStorefront)
+		slog.Info("apple music export enabled", "storefront", cfg.AppleMusicStorefront)
+	}
+
+	// Initialize OAuth configs after env vars are loaded, one per configured Spotify app
+	var defaultConfig *oauth2.Config
+	if cfg.DefaultApp != nil {
+		defaultConfig = newOAuthConfig(*cfg.DefaultApp)
+	}
+	byHost := make(map[string]*oauth2.Config)
+	for _, app := range cfg.Apps {
+		byHost[app.Host] = newOAuthConfig(app)
+		slog.Info("spotify app configured", slog.String("host", app.Host))
+	}
+	oauthApps = handlers.NewOAuthApps(defaultConfig, byHost)
+
+	// The demo serves its sample library and keeps nothing, a data directory is ignored
+	if cfg.DemoMode {
+		if err := library.LoadDemo(cfg.DemoLibrary, demoUserID); err != nil {
+			slog.Error("failed to load demo library", slog.Any("error", err))
+			os.Exit(1)
+		}
+		slog.Info("running in demo mode", "library", cfg.DemoLibrary)
+	}
+
+	// Restore sessions and library caches from a previous run
+	if cfg.DataDir != "" && !cfg.DemoMode {
+		if err := loadData(cfg.DataDir); err != nil {
+			slog.Error("failed to load data directory", slog.Any("error", err))
+			os.Exit(1)
+		}
+	}
+
+	// "bangerid sync --user ID" refreshes a library without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "sync" {
+		os.Exit(runSyncCommand(os.Args[2:]))
 	}
 
 	// Serve static files (CSS, JS) from /static/ directory
 	fs := http.FileServer(http.Dir("web/static"))
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
 
+	// Image proxy, only serves URLs signed by imageSigner
+	http.Handle("GET /img", imageSigner.Handler())
+
 	// Home page - serves the main HTML template
 	http.HandleFunc("/", homeHandler)
 
 	// OAuth routes
-	http.HandleFunc("/login", handlers.LoginHandler(oauthConfig))
-	http.HandleFunc("/spotify-auth", handlers.CallbackHandler(oauthConfig))
+	http.HandleFunc("/login", handlers.LoginHandler(oauthApps))
+	http.HandleFunc("/spotify-auth", handlers.CallbackHandler(oauthApps, sessionPolicy))
+	http.HandleFunc("/logout", handlers.LogoutHandler())
+
+	requireAuth := handlers.RequireAuth(oauthApps, sessionPolicy)
+	if cfg.DemoMode {
+		requireAuth = handlers.DemoAuth(demoUserID, "Demo")
+	}
 
 	// Grid endpoint - renders the track grid
-	http.HandleFunc("/grid", handlers.RequireAuth(oauthConfig)(gridHandler))
+	http.HandleFunc("/grid", requireAuth(gridHandler))
+
+	// Library sections with their own grids
+	http.HandleFunc("GET /albums", requireAuth(albumsHandler))
+	http.HandleFunc("GET /artists", requireAuth(artistsHandler))
+	http.HandleFunc("GET /library/artists/{id}", requireAuth(artistHandler))
+	http.HandleFunc("GET /library/artists/{id}/related", requireAuth(relatedArtistsHandler))
+	http.HandleFunc("GET /playlists", requireAuth(requireFeature("playlists", playlistsHandler)))
+	http.HandleFunc("GET /playlists/{id}/duplicates", requireAuth(requireFeature("playlists", playlistDupesHandler)))
+	http.HandleFunc("POST /playlists/{id}/duplicates", requireAuth(requireFeature("export", removePlaylistDupesHandler)))
+	http.HandleFunc("GET /audiobooks", requireAuth(requireFeature("audiobooks", audiobooksHandler)))
+	http.HandleFunc("GET /shows", requireAuth(requireFeature("shows", showsHandler)))
+	http.HandleFunc("GET /shows/{id}/episodes", requireAuth(requireFeature("shows", showEpisodesHandler)))
+	http.HandleFunc("GET /episodes", requireAuth(requireFeature("episodes", episodesHandler)))
+	http.HandleFunc("GET /recent", requireAuth(recentHandler))
+	http.HandleFunc("GET /played", requireAuth(requireFeature("recently-played", playedHandler)))
+	http.HandleFunc("GET /forgotten", requireAuth(forgottenHandler))
+	http.HandleFunc("GET /timeline", requireAuth(timelineHandler))
+	http.HandleFunc("GET /top", requireAuth(requireFeature("top", topHandler)))
+	http.HandleFunc("GET /recommendations", requireAuth(recommendationsHandler))
+	http.HandleFunc("GET /search", requireAuth(searchHandler))
+	http.HandleFunc("GET /new", requireAuth(newReleasesHandler))
+
+	// Command palette (ctrl-K) and free-text requests, resolved against the library
+	http.HandleFunc("GET /command", requireAuth(commandHandler))
+	http.HandleFunc("GET /ask", requireAuth(requireFeature("ask", askHandler)))
+
+	// Stats page
+	http.HandleFunc("GET /stats", requireAuth(statsHandler))
+	http.HandleFunc("GET /stats/growth", requireAuth(growthHandler))
+	http.HandleFunc("GET /stats/listening", requireAuth(listeningHandler))
+
+	// Track detail panel
+	http.HandleFunc("GET /tracks/{id}", requireAuth(trackDetailHandler))
+	http.HandleFunc("GET /tracks/{id}/waveform", requireAuth(waveformHandler))
+	http.HandleFunc("GET /tracks/{id}/structure", requireAuth(structureHandler))
+	http.HandleFunc("GET /tracks/{id}/link", requireAuth(trackLinkHandler))
+	http.HandleFunc("GET /tracks/{id}/appears-in", requireAuth(requireFeature("playlists", appearsInHandler)))
+
+	// Track notes
+	http.HandleFunc("GET /tracks/{id}/note", requireAuth(noteHandler))
+	http.HandleFunc("PUT /tracks/{id}/note", requireAuth(saveNoteHandler))
+	http.HandleFunc("DELETE /tracks/{id}/note", requireAuth(deleteNoteHandler))
+
+	// Liking and unliking tracks, saving albums
+	http.HandleFunc("GET /tracks/{id}/like", requireAuth(likeHandler))
+	http.HandleFunc("PUT /tracks/{id}/like", requireAuth(requireFeature("likes", saveLikeHandler)))
+	http.HandleFunc("DELETE /tracks/{id}/like", requireAuth(requireFeature("likes", deleteLikeHandler)))
+	http.HandleFunc("PUT /albums/{id}/save", requireAuth(requireFeature("likes", saveAlbumHandler)))
+
+	// Track ratings
+	http.HandleFunc("GET /tracks/{id}/rating", requireAuth(ratingHandler))
+	http.HandleFunc("PUT /tracks/{id}/rating", requireAuth(saveRatingHandler))
+	http.HandleFunc("DELETE /tracks/{id}/rating", requireAuth(deleteRatingHandler))
+
+	// DJ set builder
+	http.HandleFunc("POST /sets", requireAuth(setBuilderHandler))
+
+	// Export tracks as a Spotify playlist
+	http.HandleFunc("POST /playlists", requireAuth(requireFeature("export", exportHandler)))
+	http.HandleFunc("GET /export/apple-music.csv", requireAuth(appleMusicCSVHandler))
+	http.HandleFunc("POST /export/apple-music", requireAuth(requireFeature("apple-music", appleMusicExportHandler)))
+
+	// Access tokens for the Web Playback SDK
+	http.HandleFunc("GET /player/token", requireAuth(handlers.PlayerTokenHandler(oauthApps)))
+	http.HandleFunc("POST /player/token", requireAuth(handlers.PlayerTokenHandler(oauthApps)))
+	http.HandleFunc("GET /player/state", requireAuth(requireFeature("player-state", playerStateHandler)))
+	http.HandleFunc("GET /now", requireAuth(nowHandler))
+
+	// Heartbeats from open player pages
+	http.HandleFunc("GET /capabilities", requireAuth(capabilitiesHandler))
+	http.HandleFunc("POST /heartbeat", requireAuth(handlers.HeartbeatHandler()))
 
 	// Playback endpoint
-	http.HandleFunc("/play", handlers.RequireAuth(oauthConfig)(playHandler))
+	http.HandleFunc("/play", requireAuth(requireFeature("playback-control", playHandler)))
+	http.HandleFunc("GET /queue", requireAuth(requireFeature("player-state", queueViewHandler)))
+	http.HandleFunc("POST /queue", requireAuth(requireFeature("playback-control", queueHandler)))
+	http.HandleFunc("POST /pause", requireAuth(requireFeature("playback-control", pauseHandler)))
+	http.HandleFunc("POST /next", requireAuth(requireFeature("playback-control", skipHandler(true))))
+	http.HandleFunc("POST /previous", requireAuth(requireFeature("playback-control", skipHandler(false))))
+	http.HandleFunc("POST /seek", requireAuth(requireFeature("playback-control", seekHandler)))
+	http.HandleFunc("POST /volume", requireAuth(requireFeature("playback-control", volumeHandler)))
+	http.HandleFunc("POST /shuffle", requireAuth(requireFeature("playback-control", shuffleHandler)))
+	http.HandleFunc("POST /repeat", requireAuth(requireFeature("playback-control", repeatHandler)))
 
-	http.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
-		http.SetCookie(w, &http.Cookie{
-			Name:   "spotify_access_token",
-			Value:  "",
-			MaxAge: -1,
-		})
-		http.SetCookie(w, &http.Cookie{
-			Name:   "spotify_refresh_token",
-			Value:  "",
-			MaxAge: -1,
+	// Browser event ingestion and metrics
+	http.HandleFunc("POST /events", requireAuth(eventsHandler))
+	http.Handle("GET /metrics", metrics.Handler())
+
+	// The status banner of every page, kept current while it's open
+	http.HandleFunc("GET /status/events", statusEventsHandler)
+
+	// Admin page
+	requireAdmin := handlers.RequireAdmin(cfg.AdminUserIDs)
+	http.HandleFunc("GET /admin", requireAuth(requireAdmin(adminHandler)))
+	http.HandleFunc("GET /admin/users", requireAuth(requireAdmin(accountsHandler)))
+	http.HandleFunc("POST /admin/users/{id}/suspend", requireAuth(requireAdmin(suspendHandler)))
+	http.HandleFunc("DELETE /admin/users/{id}/suspend", requireAuth(requireAdmin(unsuspendHandler)))
+	http.HandleFunc("DELETE /admin/users/{id}", requireAuth(requireAdmin(deleteUserHandler)))
+	http.HandleFunc("POST /admin/jobs/{id}/retry", requireAuth(requireAdmin(retryJobHandler)))
+
+	// Settings page and session management
+	http.HandleFunc("GET /settings", requireAuth(settingsHandler))
+	http.HandleFunc("POST /settings/preferences", requireAuth(savePreferencesHandler))
+	http.HandleFunc("POST /settings/time-zone/detected", requireAuth(detectedTimeZoneHandler))
+	http.HandleFunc("GET /sessions", requireAuth(sessionsHandler))
+	http.HandleFunc("DELETE /sessions/{id}", requireAuth(revokeSessionHandler))
+	http.HandleFunc("POST /settings/logout-everywhere", requireAuth(logoutEverywhereHandler))
+	http.HandleFunc("POST /settings/share", requireAuth(createShareHandler))
+	http.HandleFunc("POST /settings/share/delete", requireAuth(deleteShareHandler))
+	http.HandleFunc("POST /settings/share/slug", requireAuth(shareSlugHandler))
+	http.HandleFunc("POST /settings/import", requireAuth(requireFeature("likes", importFavoritesHandler)))
+	http.HandleFunc("POST /settings/import/like", requireAuth(requireFeature("likes", importLikeHandler)))
+	http.HandleFunc("POST /settings/streaming-history", requireAuth(importStreamsHandler))
+	http.HandleFunc("POST /settings/api-token", requireAuth(createAPITokenHandler))
+	http.HandleFunc("POST /settings/api-token/delete", requireAuth(deleteAPITokenHandler))
+	http.HandleFunc("POST /settings/bots/code", requireAuth(createBotCodeHandler))
+	http.HandleFunc("POST /settings/bots/disconnect", requireAuth(disconnectBotHandler))
+
+	// Home Assistant and other home automation, authenticated by API token
+	http.HandleFunc("GET /api/ha/now", requireAPIToken(requireFeature("player-state", haNowHandler)))
+	http.HandleFunc("POST /api/ha/play", requireAPIToken(requireFeature("playback-control", haControlHandler("play"))))
+	http.HandleFunc("POST /api/ha/pause", requireAPIToken(requireFeature("playback-control", haControlHandler("pause"))))
+	http.HandleFunc("POST /api/ha/toggle", requireAPIToken(requireFeature("player-state", requireFeature("playback-control", haControlHandler("toggle")))))
+	http.HandleFunc("POST /api/ha/next", requireAPIToken(requireFeature("playback-control", haControlHandler("next"))))
+	http.HandleFunc("POST /api/ha/play/mood/{mood}", requireAPIToken(requireFeature("playback-control", haPlayMoodHandler)))
+	http.HandleFunc("POST /api/ha/play/genre/{genre}", requireAPIToken(requireFeature("playback-control", haPlayGenreHandler)))
+
+	// Public share pages and their link previews
+	http.HandleFunc("GET /share/{token}", shareHandler)
+	http.HandleFunc("GET /share/{token}/collage.jpg", shareCollageHandler)
+	http.HandleFunc("GET /share/{token}/likes.json", widgetHandler)
+	http.HandleFunc("GET /u/{slug}", vanityHandler)
+	http.HandleFunc("GET /oembed", oembedHandler)
+
+	// Wall displays, paired with a code instead of signing in
+	http.HandleFunc("GET /kiosk", kioskHandler)
+	http.HandleFunc("GET /kiosk/pairing", kioskPairingHandler)
+	http.HandleFunc("GET /kiosk/events", kioskEventsHandler)
+	http.HandleFunc("GET /kiosk/pair", requireAuth(kioskPairHandler))
+	http.HandleFunc("POST /kiosk/pair", requireAuth(approveKioskHandler))
+	http.HandleFunc("POST /settings/kiosks/{id}/delete", requireAuth(unpairKioskHandler))
+
+	// Workers turning track previews into waveform strips
+	waveform.Start(cfg.WaveformWorkers)
+
+	if cfg.DataDir != "" && !cfg.DemoMode {
+		go handlers.PersistSessions(context.Background(), 10*time.Second)
+	}
+
+	// Plays, likes and syncs are logged from the first sync on
+	if !cfg.DemoMode {
+		if err := startEventLog(context.Background()); err != nil {
+			slog.Error("failed to start event log", slog.Any("error", err))
+			os.Exit(1)
+		}
+	}
+
+	// Background work: syncs keeping the library caches of signed-in users warm and
+	// the enrichment that follows them. The demo has nothing to sync.
+	if !cfg.DemoMode {
+		startJobs(context.Background())
+	}
+	if cfg.JanitorInterval > 0 {
+		go runJanitor(context.Background(), cfg.JanitorInterval)
+	}
+	if cfg.MQTTBroker != "" && !cfg.DemoMode {
+		var err error
+		broker, err = mqtt.New(mqtt.Config{
+			Broker:        cfg.MQTTBroker,
+			ClientID:      "bangerid-" + strconv.Itoa(os.Getpid()),
+			Username:      cfg.MQTTUsername,
+			Password:      cfg.MQTTPassword,
+			WillTopic:     cfg.MQTTTopicPrefix + "/status",
+			WillPayload:   []byte("offline"),
+			OnlinePayload: []byte("online"),
 		})
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-	})
+		if err != nil {
+			slog.Error("failed to configure MQTT", slog.Any("error", err))
+			os.Exit(1)
+		}
+		go publishNowPlaying(context.Background(), cfg.MQTTInterval)
+		slog.Info("publishing to MQTT", "broker", cfg.MQTTBroker, "topic_prefix", cfg.MQTTTopicPrefix)
+	}
+
+	// Chat bots, their webhooks only have routes when they are configured
+	if !cfg.DemoMode {
+		if err := startBots(context.Background()); err != nil {
+			slog.Error("failed to start chat bots", slog.Any("error", err))
+			os.Exit(1)
+		}
+		if telegramBot != nil {
+			http.HandleFunc("POST /bots/telegram", telegramWebhookHandler)
+		}
+		if discordBot != nil {
+			http.HandleFunc("POST /bots/discord", discordInteractionsHandler)
+		}
+	}
+
 	// Start the server with logging middleware
-	port := ":3000"
+	port := cfg.Port
 	slog.Info("server starting", slog.String("url", "http://localhost"+port))
 	slog.Info("authenticate", slog.String("url", "http://localhost"+port+"/login"))
 
-	// Wrap all routes with logging middleware
-	if err := http.ListenAndServe(port, loggingMiddleware(http.DefaultServeMux)); err != nil {
+	// Wrap all routes with logging and timeout middleware
+	handler := timeoutMiddleware(http.DefaultServeMux)
+	if cfg.DemoMode {
+		handler = demoMiddleware(http.DefaultServeMux, handler)
+	}
+	if err := http.ListenAndServe(port, loggingMiddleware(recoverMiddleware(handler))); err != nil {
 		slog.Error("server failed", slog.Any("error", err))
 		os.Exit(1)
 	}
 }
 
+// loadData restores persisted sessions, libraries and annotations from the data directory
+func loadData(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	if err := handlers.LoadSessions(dir); err != nil {
+		return err
+	}
+	if err := library.Load(dir); err != nil {
+		return err
+	}
+	if err := annotations.Load(dir); err != nil {
+		return err
+	}
+	if err := prefs.Load(dir); err != nil {
+		return err
+	}
+	if err := timeline.Load(dir); err != nil {
+		return err
+	}
+	if err := jobs.Load(dir); err != nil {
+		return err
+	}
+	if err := share.Load(dir); err != nil {
+		return err
+	}
+	if err := apitokens.Load(dir); err != nil {
+		return err
+	}
+	if err := bots.Load(dir); err != nil {
+		return err
+	}
+	if err := kiosks.Load(dir); err != nil {
+		return err
+	}
+	return history.Load(dir)
+}
+
+// newOAuthConfig builds the OAuth config for one Spotify app
+func newOAuthConfig(app config.SpotifyApp) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     app.ClientID,
+		ClientSecret: app.ClientSecret,
+		RedirectURL:  app.RedirectURL,
+		Scopes:       oauthScopes(),
+		Endpoint:     spotify.Endpoint,
+	}
+}
+
+// oauthScopes lists the scopes to ask users for, which depend on the enabled library sections
+func oauthScopes() []string {
+	scopes := []string{"user-read-private", "user-read-email", "playlist-read-private", "user-library-read", "user-library-modify", "user-follow-read", "streaming", "user-read-playback-state", "user-modify-playback-state", "user-read-currently-playing", "playlist-modify-private", "playlist-modify-public", "ugc-image-upload", "user-read-recently-played", "user-top-read"}
+	if cfg.LibraryEpisodes {
+		scopes = append(scopes, "user-read-playback-position")
+	}
+	return scopes
+}
+
+// templateFuncs are the helpers available in every template
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// imageURL turns a Spotify image URL into a signed URL of our image proxy
+		"imageURL": imageSigner.URL,
+		"duration": formatDuration,
+		// appStatus is what the status banner shows, see currentStatus
+		"appStatus": currentStatus,
+	}
+}
+
+// formatDuration formats a track length the way players do, e.g. "3:07" or "1:02:45"
+func formatDuration(d time.Duration) string {
+	s := int(d.Round(time.Second) / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// renderTemplate parses the given template files and executes the first one with data.
+// Additional files are partials the first one includes, e.g. the shared header.
+func renderTemplate(w http.ResponseWriter, data any, files ...string) {
+	tmpl, err := template.New(filepath.Base(files[0])).Funcs(templateFuncs()).ParseFiles(files...)
+	if err != nil {
+		slog.Error("template parse error", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		slog.Error("template execute error", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
 // homeHandler serves the main index.html template
 func homeHandler(w http.ResponseWriter, r *http.Request) {
 	// Only serve index.html on the root path
@@ -122,94 +578,545 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Retrieve the token cookie to pass to the frontend
-	cookie, err := r.Cookie("spotify_access_token")
+	// Pass the access token to the frontend for the Web Playback SDK
+	session, loggedIn := handlers.SessionFromRequest(r)
 	var token string
-	loggedIn := err == nil
 	if loggedIn {
-		token = cookie.Value
+		token = session.Token.AccessToken
+	}
+
+	data := struct {
+		LoggedIn     bool
+		Demo         bool
+		Token        string
+		Capabilities capabilities
+		Tiles        tilePreset
+		// AppleMusicToken configures MusicKit JS for the Apple Music export, empty if off
+		AppleMusicToken string
+		// DetectTimeZone has the page report the browser's time zone, until the user
+		// confirms one in the settings
+		DetectTimeZone bool
+	}{
+		// Demo visitors are always "signed in", without a token the player stays off
+		LoggedIn:       loggedIn || cfg.DemoMode,
+		Demo:           cfg.DemoMode,
+		Token:          token,
+		Tiles:          userTilePreset(session.UserID),
+		DetectTimeZone: loggedIn && !prefs.Get(session.UserID).TimeZoneConfirmed,
+	}
+	if data.LoggedIn {
+		data.Capabilities = capabilitiesOf(r.Context(), session)
+	}
+	if data.Capabilities.Features.AppleMusic {
+		data.AppleMusicToken = appleMusic.DeveloperToken()
+	}
+
+	renderTemplate(w, data, "web/templates/index.html", "web/templates/header.html")
+}
+
+// gridTile is one track tile of the grid with the extra data shown on it
+type gridTile struct {
+	Track  spotifyClient.Track
+	Key    string // Camelot notation, empty if unknown
+	Note   string // the user's note on the track, if any
+	Rating int    // the user's stars, 0 if unrated
+	// Tint is how strongly the tile is colored when the grid is colored by an audio feature,
+	// from 0 to 1. Only set with Tinted, tracks without features stay uncolored.
+	Tint   float64
+	Tinted bool
+	// Likable offers to like or unlike the track from the tile, for tiles outside the liked
+	// songs like search results and recommendations. Liked is whether it is liked now.
+	Likable bool
+	Liked   bool
+	// Index, Image and Details are set by the user's tile preset, see tilePreset.apply: the
+	// tile's position in the whole grid, the cover to show and whether to show badges
+	Index   int
+	Image   string
+	Details bool
+}
+
+// gridHandler renders the track grid as HTML.
+// ?mix=8A keeps only tracks that can be mixed harmonically with that Camelot key.
+// ?q= keeps only tracks whose name, any of the artists or note contains the text.
+// ?source=playlist:<id> shows the tracks of a playlist instead of the liked songs,
+// ?source=album:<id> the tracks of an album, ?source=as_of:2023-06 the liked songs as they
+// were at the end of that month. Playlist and album tiles offer to like or unlike theirs.
+// ?artist= keeps only tracks crediting the artist with that Spotify ID, featured or not.
+// ?min_rating=4 keeps only tracks rated at least that many stars.
+// ?year=1998 keeps only tracks released that year, ?year=1990-1999 within those years.
+// ?sort=rating puts the best rated first, ?sort=name and ?sort=artist sort alphabetically
+// by the rules of the user's language. ?sort=tempo, energy, danceability or key sort by
+// audio features, tracks without them last.
+// ?color=tempo, energy, danceability or valence colors the tiles by that audio feature.
+// The grid renders as many tiles as the user's tile preset pages, scrolling to the end
+// loads the next page with ?offset=.
+func gridHandler(w http.ResponseWriter, r *http.Request) {
+	offset := 0
+	if v := r.FormValue("offset"); v != "" {
+		var err error
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, errInvalidFilter.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
-	tmpl, err := template.ParseFiles("web/templates/index.html")
+	tiles, err := gridTiles(r)
+	if errors.Is(err, harmony.ErrInvalidKey) || errors.Is(err, errInvalidFilter) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		slog.Error("template parse error", slog.Any("error", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load tracks", http.StatusInternalServerError)
 		return
 	}
 
+	preset := userTilePreset(handlers.CurrentSession(r).UserID)
+	offset = min(offset, len(tiles))
+	end := min(offset+preset.PageSize, len(tiles))
 	data := struct {
-		LoggedIn bool
-		Token    string
+		Tiles []gridTile
+		More  string // URL of the next page, empty on the last one
 	}{
-		LoggedIn: loggedIn,
-		Token:    token,
+		Tiles: tiles[offset:end],
+	}
+	// Playlists and albums aren't the liked songs, hearts tell which are
+	if source := r.FormValue("source"); strings.HasPrefix(source, "playlist:") || strings.HasPrefix(source, "album:") {
+		data.Tiles = likableTiles(r, data.Tiles)
+	}
+	if end < len(tiles) {
+		query := r.URL.Query()
+		query.Set("offset", strconv.Itoa(end))
+		data.More = "/grid?" + query.Encode()
 	}
 
-	if err := tmpl.Execute(w, data); err != nil {
-		slog.Error("template execute error", slog.Any("error", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	renderTemplate(w, data, "web/templates/grid-page.html", "web/templates/grid.html")
+}
+
+// errInvalidFilter is returned by gridTiles for malformed filter parameters
+var errInvalidFilter = errors.New("invalid grid filter")
+
+// gridTiles builds the liked songs grid for the request, applying the filters and sort order
+// described on gridHandler. Exports use it too, so they match what the grid shows.
+func gridTiles(r *http.Request) ([]gridTile, error) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	var mixWith harmony.Camelot
+	if mix := r.FormValue("mix"); mix != "" {
+		var err error
+		if mixWith, err = harmony.Parse(mix); err != nil {
+			return nil, err
+		}
+	}
+
+	var minRating int
+	if v := r.FormValue("min_rating"); v != "" {
+		var err error
+		if minRating, err = strconv.Atoi(v); err != nil || minRating < 0 || minRating > annotations.MaxRating {
+			return nil, errInvalidFilter
+		}
+	}
+
+	var fromYear, toYear int
+	if v := r.FormValue("year"); v != "" {
+		var err error
+		if fromYear, toYear, err = parseYears(v); err != nil {
+			return nil, err
+		}
+	}
+
+	// Matches any credited artist, not just the main one
+	artistID := r.FormValue("artist")
+
+	colorBy := r.FormValue("color")
+	if _, ok := tileColors[colorBy]; !ok && colorBy != "" {
+		return nil, errInvalidFilter
 	}
+
+	tracks, err := gridSource(r, session.UserID, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	spotifyClient.MergeAudioFeatures(tracks, library.Features(session.UserID))
+	notes := annotations.Notes(session.UserID)
+	ratings := annotations.Ratings(session.UserID)
+	query := strings.ToLower(strings.TrimSpace(r.FormValue("q")))
+
+	tiles := make([]gridTile, 0, len(tracks))
+	for _, track := range tracks {
+		id := spotifyClient.IDFromURI(track.ID)
+		key := harmony.Camelot{}
+		if f := track.Features; f != nil {
+			key = harmony.FromKey(f.Key, f.Mode)
+		}
+
+		if mixWith.Known() && !harmony.Compatible(mixWith, key) {
+			continue
+		}
+		note := notes[id].Text
+		if artistID != "" && !track.HasArtist(artistID) {
+			continue
+		}
+		if query != "" && !matchesQuery(query, track.Name, track.ArtistNames(), note) {
+			continue
+		}
+		if ratings[id] < minRating {
+			continue
+		}
+		if fromYear != 0 && (track.Year < fromYear || track.Year > toYear) {
+			continue
+		}
+		tile := gridTile{Track: track, Key: key.String(), Note: note, Rating: ratings[id]}
+		if colorBy != "" && track.Features != nil {
+			tile.Tint = min(max(tileColors[colorBy](*track.Features), 0), 1)
+			tile.Tinted = true
+		}
+		tiles = append(tiles, tile)
+	}
+
+	switch r.FormValue("sort") {
+	case "rating":
+		// Stable, so equally rated tracks keep their library order
+		slices.SortStableFunc(tiles, func(a, b gridTile) int {
+			return b.Rating - a.Rating
+		})
+	case "name", "artist":
+		byArtist := r.FormValue("sort") == "artist"
+		collator := collation.New(prefs.Get(session.UserID).SortLanguage, r.Header.Get("Accept-Language"))
+		slices.SortStableFunc(tiles, func(a, b gridTile) int {
+			if byArtist {
+				if c := collator.CompareString(a.Track.Artist, b.Track.Artist); c != 0 {
+					return c
+				}
+			}
+			return collator.CompareString(a.Track.Name, b.Track.Name)
+		})
+	case "tempo", "energy", "danceability", "key":
+		sortByFeature(tiles, r.FormValue("sort"))
+	}
+	userTilePreset(session.UserID).apply(tiles, 0)
+	return tiles, nil
 }
 
-// gridHandler renders the track grid as HTML
-func gridHandler(w http.ResponseWriter, r *http.Request) {
-	// If cache is empty, fetch tracks
-	if len(tracksCache) == 0 {
-		accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+// gridSorts are the ?sort= values of the grid besides library order
+var gridSorts = []string{"rating", "name", "artist", "tempo", "energy", "danceability", "key"}
 
-		slog.Info("cache empty, fetching tracks from Spotify")
-		tracks, err := spotifyClient.FetchLikedTracks(accessToken)
+// parseYears parses a release year like 1998 or a range like 1990-1999
+func parseYears(v string) (from, to int, err error) {
+	first, last, isRange := strings.Cut(v, "-")
+	if from, err = strconv.Atoi(first); err != nil || from < 1 {
+		return 0, 0, errInvalidFilter
+	}
+	to = from
+	if isRange {
+		if to, err = strconv.Atoi(last); err != nil || to < from {
+			return 0, 0, errInvalidFilter
+		}
+	}
+	return from, to, nil
+}
+
+// tileColors maps the ?color= values of the grid to how strongly a track shows that feature,
+// from 0 to 1. Tempo is spread over the range most music falls in.
+var tileColors = map[string]func(f spotifyClient.AudioFeatures) float64{
+	"tempo":        func(f spotifyClient.AudioFeatures) float64 { return (f.Tempo - 60) / 120 },
+	"energy":       func(f spotifyClient.AudioFeatures) float64 { return f.Energy },
+	"danceability": func(f spotifyClient.AudioFeatures) float64 { return f.Danceability },
+	"valence":      func(f spotifyClient.AudioFeatures) float64 { return f.Valence },
+}
+
+// sortByFeature sorts tiles by an audio feature: slowest tempo first, most energetic or
+// danceable first, or around the Camelot wheel (1A, 1B, 2A, ...) so neighbours mix.
+// Tracks without features keep their order at the end.
+func sortByFeature(tiles []gridTile, feature string) {
+	slices.SortStableFunc(tiles, func(a, b gridTile) int {
+		fa, fb := a.Track.Features, b.Track.Features
+		if fa == nil || fb == nil {
+			// Known before unknown
+			return cmp.Compare(boolRank(fa == nil), boolRank(fb == nil))
+		}
+		switch feature {
+		case "tempo":
+			return cmp.Compare(fa.Tempo, fb.Tempo)
+		case "energy":
+			return cmp.Compare(fb.Energy, fa.Energy)
+		case "danceability":
+			return cmp.Compare(fb.Danceability, fa.Danceability)
+		default:
+			ka, kb := harmony.FromKey(fa.Key, fa.Mode), harmony.FromKey(fb.Key, fb.Mode)
+			if !ka.Known() || !kb.Known() {
+				return cmp.Compare(boolRank(!ka.Known()), boolRank(!kb.Known()))
+			}
+			if c := cmp.Compare(ka.Number, kb.Number); c != 0 {
+				return c
+			}
+			return cmp.Compare(ka.Letter, kb.Letter)
+		}
+	})
+}
+
+// boolRank orders false before true
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// gridSource returns the tracks the grid is built from: the liked songs, with
+// ?source=playlist:<id> or album:<id> the tracks of a playlist or album, or with
+// ?source=as_of:2023-06 (or a day like 2023-06-15) the liked songs as they were back then
+func gridSource(r *http.Request, userID, accessToken string) ([]spotifyClient.Track, error) {
+	source := r.FormValue("source")
+	if source == "" || source == "liked" {
+		return library.Tracks(r.Context(), userID, accessToken)
+	}
+
+	if date, ok := strings.CutPrefix(source, "as_of:"); ok {
+		at, err := endOfPeriod(date, prefs.Get(userID).Location())
 		if err != nil {
-			slog.Error("failed to fetch tracks", slog.Any("error", err))
-			http.Error(w, "Failed to load tracks", http.StatusInternalServerError)
-			return
+			return nil, errInvalidFilter
 		}
+		return timeline.AsOf(userID, at), nil
+	}
 
-		tracksCache = tracks
-		slog.Info("cached tracks", slog.Int("count", len(tracksCache)))
+	if albumID, ok := strings.CutPrefix(source, "album:"); ok {
+		if !validSpotifyID(albumID) {
+			return nil, errInvalidFilter
+		}
+		if cfg.DemoMode {
+			// No Spotify to ask, show the album's tracks that are in the sample library
+			return albumTracksInLibrary(r, userID, accessToken, albumID)
+		}
+		return spotifyClient.FetchAlbumTracks(r.Context(), accessToken, albumID)
 	}
 
-	// Render the grid template
-	tmpl, err := template.ParseFiles("web/templates/grid.html")
+	playlistID, ok := strings.CutPrefix(source, "playlist:")
+	if !ok || !validSpotifyID(playlistID) {
+		return nil, errInvalidFilter
+	}
+	return spotifyClient.FetchPlaylistTracks(r.Context(), accessToken, playlistID)
+}
+
+// albumTracksInLibrary returns the user's liked tracks from one album
+func albumTracksInLibrary(r *http.Request, userID, accessToken, albumID string) ([]spotifyClient.Track, error) {
+	tracks, err := library.Tracks(r.Context(), userID, accessToken)
 	if err != nil {
-		slog.Error("template parse error", slog.Any("error", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	return slices.DeleteFunc(tracks, func(t spotifyClient.Track) bool {
+		return t.AlbumID != albumID
+	}), nil
+}
 
-	if err := tmpl.Execute(w, tracksCache); err != nil {
-		slog.Error("template execute error", slog.Any("error", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+// matchesQuery reports whether any of the fields contains the lowercased query
+func matchesQuery(query string, fields ...string) bool {
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
 	}
+	return false
 }
 
-// playHandler triggers playback on the client's device
+// playHandler triggers playback on the client's device.
+// It plays one or more tracks or episodes (track_uri, repeatable) or a whole
+// album/artist/audiobook (context_uri). With neither it resumes what was paused, the
+// other half of pauseHandler.
 func playHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
-	trackURI := r.URL.Query().Get("track_uri")
-	deviceID := r.PostFormValue("device_id")
+	session := handlers.CurrentSession(r)
+	trackURIs := r.URL.Query()["track_uri"]
+	contextURI := r.URL.Query().Get("context_uri")
+	target := playbackTarget(r)
 
-	if trackURI == "" || deviceID == "" {
-		slog.Warn("missing track_uri or device_id", "track_uri", trackURI, "device_id", deviceID)
-		http.Error(w, "Missing track_uri or device_id", http.StatusBadRequest)
+	if len(trackURIs) == 0 && contextURI == "" {
+		slog.Info("resuming playback", "device", target.DeviceID)
+		if err := player.Resume(r.Context(), target); err != nil {
+			slog.Error("failed to resume playback", slog.Any("error", err))
+			renderToast(w, http.StatusBadGateway, "Spotify didn't resume, is it still open somewhere?")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	slog.Info("starting playback", "track", trackURI, "device", deviceID)
-
-	if err := spotifyClient.PlayTrack(accessToken, deviceID, trackURI); err != nil {
+	if contextURI != "" {
+		slog.Info("starting playback", "context", contextURI, "device", target.DeviceID)
+	} else {
+		slog.Info("starting playback", "tracks", len(trackURIs), "first", trackURIs[0], "device", target.DeviceID)
+	}
+	err := player.Play(r.Context(), target, playback.Items{URIs: trackURIs, ContextURI: contextURI})
+	if errors.Is(err, playback.ErrNoDevice) {
+		renderToast(w, http.StatusConflict, "No device to play on, open Spotify somewhere or wait for the player to connect.")
+		return
+	}
+	if err != nil {
 		slog.Error("playback failed", slog.Any("error", err))
 		http.Error(w, "Failed to start playback", http.StatusInternalServerError)
 		return
 	}
 
+	// Queued tracks count as played, so they don't resurface as forgotten favorites
+	if len(trackURIs) > 0 {
+		plays := make(map[string]time.Time, len(trackURIs))
+		for _, uri := range trackURIs {
+			if strings.HasPrefix(uri, "spotify:track:") {
+				plays[spotifyClient.IDFromURI(uri)] = time.Now()
+			}
+		}
+		if err := history.Record(session.UserID, plays); err != nil {
+			slog.Warn("failed to record plays", slog.Any("error", err))
+		}
+	}
+
 	// Return 204 No Content so HTMX does nothing (no swap)
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// queueHandler adds a track or episode (track_uri) to the queue of the client's device
+// (device_id), or of whichever device is active, so it plays after the current song
+// instead of interrupting it
+func queueHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.URL.Query().Get("track_uri")
+	if !strings.HasPrefix(uri, "spotify:track:") && !strings.HasPrefix(uri, "spotify:episode:") {
+		http.Error(w, "Invalid track_uri", http.StatusBadRequest)
+		return
+	}
+	target := playbackTarget(r)
+
+	slog.Info("adding to queue", "uri", uri, "device", target.DeviceID)
+	if err := player.Queue(r.Context(), target, uri); err != nil {
+		slog.Error("failed to add to queue", slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Spotify didn't queue the song, is anything playing?")
+		return
+	}
+	recordQueued(handlers.CurrentSession(r), uri)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pauseHandler pauses playback on the client's device (device_id), or on whichever
+// device is active when the in-browser player isn't the one playing
+func pauseHandler(w http.ResponseWriter, r *http.Request) {
+	target := playbackTarget(r)
+
+	slog.Info("pausing playback", "device", target.DeviceID)
+	if err := player.Pause(r.Context(), target); err != nil {
+		slog.Error("failed to pause playback", slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Spotify didn't pause, is it still open somewhere?")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// skipHandler skips to the next or previous track on the client's device (device_id), or
+// on whichever device is active, so the transport controls work without the Spotify app
+func skipHandler(forward bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := playbackTarget(r)
+		skip := player.Previous
+		if forward {
+			skip = player.Next
+		}
+
+		if err := skip(r.Context(), target); err != nil {
+			slog.Error("failed to skip", "forward", forward, slog.Any("error", err))
+			renderToast(w, http.StatusBadGateway, "Spotify didn't skip, is it still open somewhere?")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// seekHandler jumps to position_ms into the track playing on the client's device
+// (device_id), or on whichever device is active, for the progress bar of the player bar
+func seekHandler(w http.ResponseWriter, r *http.Request) {
+	positionMs, err := strconv.Atoi(r.PostFormValue("position_ms"))
+	if err != nil || positionMs < 0 {
+		http.Error(w, "Invalid position_ms", http.StatusBadRequest)
+		return
+	}
+
+	position := time.Duration(positionMs) * time.Millisecond
+	if err := player.Seek(r.Context(), playbackTarget(r), position); err != nil {
+		slog.Error("failed to seek", "position", position, slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Spotify didn't seek, is it still open somewhere?")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// volumeHandler sets the volume of the client's device (device_id), or of whichever
+// device is active, to volume_percent, so a kiosk or party screen can turn it up or down
+// without reaching for another device
+func volumeHandler(w http.ResponseWriter, r *http.Request) {
+	percent, err := strconv.Atoi(r.PostFormValue("volume_percent"))
+	if err != nil || percent < 0 || percent > 100 {
+		http.Error(w, "Invalid volume_percent", http.StatusBadRequest)
+		return
+	}
+
+	if err := player.SetVolume(r.Context(), playbackTarget(r), percent); err != nil {
+		slog.Error("failed to set volume", "percent", percent, slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Spotify didn't change the volume, is it still open somewhere?")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// shuffleHandler turns shuffle on or off (state, true or false) on the client's device
+// (device_id), or on whichever device is active, e.g. to shuffle the liked songs the grid
+// started
+func shuffleHandler(w http.ResponseWriter, r *http.Request) {
+	shuffle, err := strconv.ParseBool(r.PostFormValue("state"))
+	if err != nil {
+		http.Error(w, "Invalid state", http.StatusBadRequest)
+		return
+	}
+
+	if err := player.SetShuffle(r.Context(), playbackTarget(r), shuffle); err != nil {
+		slog.Error("failed to set shuffle", "shuffle", shuffle, slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Spotify didn't change shuffle, is it still open somewhere?")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// repeatHandler sets the repeat mode (state: off, track or context) of the client's device
+// (device_id), or of whichever device is active, so the grid's liked songs can loop
+func repeatHandler(w http.ResponseWriter, r *http.Request) {
+	mode := playback.Repeat(r.PostFormValue("state"))
+	if mode != playback.RepeatOff && mode != playback.RepeatTrack && mode != playback.RepeatContext {
+		http.Error(w, "Invalid state", http.StatusBadRequest)
+		return
+	}
+
+	if err := player.SetRepeat(r.Context(), playbackTarget(r), mode); err != nil {
+		slog.Error("failed to set repeat mode", "mode", mode, slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Spotify didn't change repeat, is it still open somewhere?")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// playbackTarget is the user and device a play action of the request is for. The page
+// posts the device of its in-browser player as device_id, empty while it isn't ready.
+func playbackTarget(r *http.Request) playback.Target {
+	return playback.Target{
+		AccessToken: r.Context().Value(handlers.AccessTokenKey).(string),
+		DeviceID:    r.PostFormValue("device_id"),
+		FindDevice:  handlers.CurrentSession(r).HasScope("user-read-playback-state"),
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/jendahorak/bangerid/internal/annotations"
+	"github.com/jendahorak/bangerid/internal/handlers"
+)
+
+// noteView is what the note template shows
+type noteView struct {
+	TrackID   string
+	Note      annotations.Note
+	HasNote   bool
+	MaxLength int
+}
+
+// validSpotifyID reports whether id looks like a bare Spotify ID, e.g. of a track or playlist.
+// Notes may be attached to any track, not just liked ones, so this is all the checking we can
+// do without a lookup.
+func validSpotifyID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// noteHandler renders the note editor of a track for the detail panel
+func noteHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+	if !validSpotifyID(trackID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	note, ok := annotations.FindNote(session.UserID, trackID)
+	renderNote(w, trackID, note, ok)
+}
+
+// saveNoteHandler creates or replaces the note on a track (form field note).
+// Saving an empty note deletes it.
+func saveNoteHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+	if !validSpotifyID(trackID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	note, err := annotations.SetNote(session.UserID, trackID, r.FormValue("note"))
+	if errors.Is(err, annotations.ErrNoteTooLong) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to save note", "track", trackID, slog.Any("error", err))
+		http.Error(w, "Failed to save note", http.StatusInternalServerError)
+		return
+	}
+
+	renderNote(w, trackID, note, note.Text != "")
+}
+
+// deleteNoteHandler removes the note on a track
+func deleteNoteHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+	if !validSpotifyID(trackID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := annotations.DeleteNote(session.UserID, trackID); err != nil {
+		slog.Error("failed to delete note", "track", trackID, slog.Any("error", err))
+		http.Error(w, "Failed to delete note", http.StatusInternalServerError)
+		return
+	}
+
+	renderNote(w, trackID, annotations.Note{}, false)
+}
+
+// renderNote renders the note editor fragment
+func renderNote(w http.ResponseWriter, trackID string, note annotations.Note, hasNote bool) {
+	renderTemplate(w, noteView{
+		TrackID:   trackID,
+		Note:      note,
+		HasNote:   hasNote,
+		MaxLength: annotations.MaxNoteLength,
+	}, "web/templates/note.html")
+}
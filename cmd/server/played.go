@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// playedHandler renders the tracks the user played most recently on any device, newest
+// first. (/recent is taken by the strip of recently liked tracks.)
+func playedHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	var plays []spotifyClient.Play
+	if !cfg.DemoMode {
+		var err error
+		plays, err = spotifyClient.FetchRecentlyPlayed(r.Context(), accessToken)
+		if err != nil {
+			slog.Error("failed to fetch recently played tracks", slog.Any("error", err))
+			http.Error(w, "Failed to load recently played tracks", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// While we have them, remember the plays for the blast from the past
+	recordPlays(session.UserID, plays)
+
+	var tracks []spotifyClient.Track
+	for _, p := range plays {
+		if p.Track.ID != "" {
+			tracks = append(tracks, p.Track)
+		}
+	}
+
+	data := struct {
+		Tiles []gridTile
+	}{
+		Tiles: trackTiles(session.UserID, tracks),
+	}
+	renderTemplate(w, data, "web/templates/played.html", "web/templates/grid.html")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/nowplaying"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// playerStateTTL is how long a fetched playback state is shared between the user's tabs.
+// Short, the bar is refreshed right after the player changes.
+const playerStateTTL = time.Second
+
+// nowPlayingTTL is how long the currently playing track is shared between the user's
+// tabs, about as often as they poll /now
+const nowPlayingTTL = 5 * time.Second
+
+// stopPolling is the status that makes htmx stop polling an element
+const stopPolling = 286
+
+var (
+	// playerStates coalesces the playback state lookups of all of a user's tabs
+	playerStates = nowplaying.New(playerStateTTL, fetchPlayerState)
+	// nowPlaying coalesces the polls of the currently playing track, see nowHandler
+	nowPlaying = nowplaying.New(nowPlayingTTL, fetchNowPlaying)
+)
+
+// fetchPlayerState looks up what the user's Spotify is playing, nil if nothing
+func fetchPlayerState(ctx context.Context, userID string) (*spotifyClient.PlaybackState, error) {
+	accessToken, _, err := handlers.UserAccessToken(ctx, oauthApps, userID)
+	if err != nil {
+		return nil, err
+	}
+	return spotifyClient.GetPlaybackState(spotifyClient.WithUser(ctx, userID), accessToken)
+}
+
+// fetchNowPlaying looks up the track the user's Spotify is playing, nil if nothing
+func fetchNowPlaying(ctx context.Context, userID string) (*spotifyClient.CurrentlyPlaying, error) {
+	accessToken, _, err := handlers.UserAccessToken(ctx, oauthApps, userID)
+	if err != nil {
+		return nil, err
+	}
+	return spotifyClient.GetCurrentlyPlaying(spotifyClient.WithUser(ctx, userID), accessToken)
+}
+
+// nowHandler renders what is playing in a line for the header, which polls it. Users
+// who can't be asked, without the scope or in the demo, get nothing and the header
+// stops polling.
+func nowHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	if cfg.DemoMode || !featureAvailable("now-playing", session) {
+		w.WriteHeader(stopPolling)
+		return
+	}
+
+	current, err := nowPlaying.Get(r.Context(), session.UserID)
+	if err != nil {
+		// The next poll will likely do better, keep the line as it is
+		slog.Warn("failed to fetch currently playing track", slog.Any("error", err))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplate(w, current, "web/templates/now.html")
+}
+
+// playerStateHandler renders the now-playing bar: the track, how far into it, shuffle and
+// repeat, and the device playing, wherever the user plays. Renders nothing when nothing
+// plays, and in the demo, which has no Spotify account.
+func playerStateHandler(w http.ResponseWriter, r *http.Request) {
+	var state *spotifyClient.PlaybackState
+	if !cfg.DemoMode {
+		var err error
+		if state, err = playerStates.Get(r.Context(), handlers.CurrentSession(r).UserID); err != nil {
+			slog.Error("failed to fetch playback state", slog.Any("error", err))
+			http.Error(w, "Failed to load the player", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplate(w, state, "web/templates/player.html")
+}
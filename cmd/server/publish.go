@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/apitokens"
+	"github.com/jendahorak/bangerid/internal/eventlog"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// What is published to the MQTT broker, under the configured topic prefix, for the users
+// with an API token: they opted in to home automation.
+//
+//	<prefix>/status                     "online" or "offline", retained
+//	<prefix>/<user ID>/now_playing      an haState, retained, whenever it changes
+//	<prefix>/<user ID>/library_sync     a syncEvent after every sync of a library section
+
+// maxSyncEventTracks is how many newly liked songs a sync event lists
+const maxSyncEventTracks = 10
+
+// syncEvent announces a finished sync of a library section
+type syncEvent struct {
+	Section  string        `json:"section"`
+	SyncedAt time.Time     `json:"synced_at"`
+	Liked    int           `json:"liked,omitempty"` // liked songs after the sync, tracks only
+	Added    []syncedTrack `json:"added,omitempty"` // newly liked songs, the most recent first
+	Removed  int           `json:"removed,omitempty"`
+}
+
+// syncedTrack is a song a sync event lists
+type syncedTrack struct {
+	Track  string `json:"track"`
+	Artist string `json:"artist"`
+	URI    string `json:"uri"`
+}
+
+// publishTopic is the topic of a user's messages
+func publishTopic(userID, name string) string {
+	return cfg.MQTTTopicPrefix + "/" + userID + "/" + name
+}
+
+// publishNowPlaying publishes what the users with an API token play every interval. A
+// message is only sent when the state changed, progress aside, so Home Assistant's MQTT
+// sensors update without polling and the broker isn't flooded.
+func publishNowPlaying(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	published := make(map[string]string) // the last state sent per user, without progress
+	for {
+		for userID := range published {
+			if _, ok := apitokens.Get(userID); !ok {
+				delete(published, userID)
+			}
+		}
+		for _, userID := range apitokens.Users() {
+			state, err := playerStates.Get(ctx, userID)
+			if err != nil {
+				slog.Warn("failed to fetch playback state for MQTT", "user", userID, slog.Any("error", err))
+				continue
+			}
+			s := newHAState(state)
+			withoutProgress := s
+			withoutProgress.ProgressMs = 0
+			key, _ := json.Marshal(withoutProgress)
+			if published[userID] == string(key) {
+				continue
+			}
+
+			payload, _ := json.Marshal(s)
+			if err := broker.Publish(ctx, publishTopic(userID, "now_playing"), payload, true); err != nil {
+				slog.Warn("failed to publish now playing", "user", userID, slog.Any("error", err))
+				continue
+			}
+			published[userID] = string(key)
+		}
+
+		select {
+		case <-ctx.Done():
+			broker.Close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncObserver returns what publishSync and logSync need from before a sync: the liked
+// songs, for telling which are new. Nil when nothing will be published or logged.
+func syncObserver(userID string, s library.Section) []spotifyClient.Track {
+	if s != library.SectionTracks {
+		return nil
+	}
+	if !eventlog.Enabled() && !publishesTo(userID) {
+		return nil
+	}
+	return library.CachedTracks(userID)
+}
+
+// publishesTo reports whether the user's messages go to the MQTT broker
+func publishesTo(userID string) bool {
+	if broker == nil {
+		return false
+	}
+	_, ok := apitokens.Get(userID)
+	return ok
+}
+
+// likedChanges compares the liked songs from before and after a sync: the newly liked
+// ones, the most recent first, and the unliked ones. A first sync has nothing to compare
+// with, everything would be new, so it has no changes.
+func likedChanges(before, after []spotifyClient.Track) (added, removed []spotifyClient.Track) {
+	if len(before) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]spotifyClient.Track, len(before))
+	for _, t := range before {
+		known[t.ID] = t
+	}
+	for _, t := range after {
+		if _, ok := known[t.ID]; !ok {
+			added = append(added, t)
+		}
+		delete(known, t.ID)
+	}
+	for _, t := range before {
+		if _, ok := known[t.ID]; ok {
+			removed = append(removed, t)
+		}
+	}
+	return added, removed
+}
+
+// publishSync announces a finished sync of a section of the user's library, with the
+// songs liked and unliked since the last one when it is the liked songs. before are the
+// liked songs from before the sync, see syncObserver. Sync events aren't retained, they
+// are for automations reacting to them, not for state.
+func publishSync(ctx context.Context, userID string, s library.Section, before []spotifyClient.Track) {
+	if !publishesTo(userID) {
+		return
+	}
+
+	event := syncEvent{Section: string(s), SyncedAt: time.Now()}
+	if s == library.SectionTracks {
+		after := library.CachedTracks(userID)
+		event.Liked = len(after)
+
+		added, removed := likedChanges(before, after)
+		for _, t := range added[:min(len(added), maxSyncEventTracks)] {
+			event.Added = append(event.Added, syncedTrack{Track: t.Name, Artist: t.ArtistNames(), URI: t.ID})
+		}
+		event.Removed = len(removed)
+	}
+
+	payload, _ := json.Marshal(event)
+	if err := broker.Publish(ctx, publishTopic(userID, "library_sync"), payload, false); err != nil {
+		slog.Warn("failed to publish library sync", "user", userID, slog.Any("error", err))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+const (
+	// queuedTTL is how long it is remembered who queued a song, queues rarely last longer
+	queuedTTL = 6 * time.Hour
+	// maxQueued is how many queued songs are remembered per user
+	maxQueued = 100
+)
+
+// queuedSong is a song queued from the app, so the queue can say by whom
+type queuedSong struct {
+	URI       string
+	By        string // display name of who queued it
+	SessionID string // the session that queued it, to tell the own ones apart
+	At        time.Time
+}
+
+var (
+	queuedMu sync.Mutex
+	queued   = make(map[string][]queuedSong) // keyed by Spotify user ID, oldest first
+)
+
+// recordQueued remembers that a session queued a song
+func recordQueued(session handlers.Session, uri string) {
+	queuedMu.Lock()
+	defer queuedMu.Unlock()
+
+	songs := append(pruneQueued(queued[session.UserID]), queuedSong{
+		URI:       uri,
+		By:        session.DisplayName,
+		SessionID: session.ID,
+		At:        time.Now(),
+	})
+	if len(songs) > maxQueued {
+		songs = songs[len(songs)-maxQueued:]
+	}
+	queued[session.UserID] = songs
+}
+
+// pruneQueued drops the songs queued longer than queuedTTL ago. queuedMu must be held.
+func pruneQueued(songs []queuedSong) []queuedSong {
+	for len(songs) > 0 && time.Since(songs[0].At) > queuedTTL {
+		songs = songs[1:]
+	}
+	return songs
+}
+
+// queueEntry is a song of the queue fragment
+type queueEntry struct {
+	Track spotifyClient.Track
+	By    string // who queued it from the app, empty if it wasn't
+	Mine  bool   // queued from this session
+}
+
+// queueViewHandler renders what plays next on the user's active device, marking the songs
+// queued from the app with who queued them
+func queueViewHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	queue, err := spotifyClient.GetQueue(r.Context(), accessToken)
+	if err != nil {
+		slog.Error("failed to fetch queue", slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Spotify didn't show the queue, try again in a bit.")
+		return
+	}
+
+	queuedMu.Lock()
+	songs := pruneQueued(queued[session.UserID])
+	queued[session.UserID] = songs
+	// Each recorded song marks one entry, the same song may be queued twice
+	unclaimed := make(map[string][]queuedSong)
+	for i := len(songs) - 1; i >= 0; i-- {
+		unclaimed[songs[i].URI] = append(unclaimed[songs[i].URI], songs[i])
+	}
+	queuedMu.Unlock()
+
+	data := struct {
+		Current *spotifyClient.Track
+		Next    []queueEntry
+	}{Current: queue.Current}
+	for _, t := range queue.Next {
+		entry := queueEntry{Track: t}
+		if claims := unclaimed[t.ID]; len(claims) > 0 {
+			song := claims[len(claims)-1]
+			unclaimed[t.ID] = claims[:len(claims)-1]
+			entry.By, entry.Mine = song.By, song.SessionID == session.ID
+		}
+		data.Next = append(data.Next, entry)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplate(w, data, "web/templates/queue.html")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/jendahorak/bangerid/internal/annotations"
+	"github.com/jendahorak/bangerid/internal/handlers"
+)
+
+// ratingView is what the rating template shows
+type ratingView struct {
+	TrackID string
+	Rating  int   // 0 if unrated
+	Stars   []int // 1..MaxRating, for ranging over in the template
+}
+
+// ratingHandler renders the star rating widget of a track for the detail panel
+func ratingHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+	if !validSpotifyID(trackID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	renderRating(w, trackID, annotations.FindRating(session.UserID, trackID))
+}
+
+// saveRatingHandler rates a track (form field rating, 1 to 5 stars)
+func saveRatingHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+	if !validSpotifyID(trackID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	stars, err := strconv.Atoi(r.FormValue("rating"))
+	if err != nil {
+		http.Error(w, annotations.ErrInvalidRating.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = annotations.SetRating(session.UserID, trackID, stars)
+	if errors.Is(err, annotations.ErrInvalidRating) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to save rating", "track", trackID, slog.Any("error", err))
+		http.Error(w, "Failed to save rating", http.StatusInternalServerError)
+		return
+	}
+
+	renderRating(w, trackID, stars)
+}
+
+// deleteRatingHandler clears the rating of a track
+func deleteRatingHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+	if !validSpotifyID(trackID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := annotations.DeleteRating(session.UserID, trackID); err != nil {
+		slog.Error("failed to delete rating", "track", trackID, slog.Any("error", err))
+		http.Error(w, "Failed to delete rating", http.StatusInternalServerError)
+		return
+	}
+
+	renderRating(w, trackID, 0)
+}
+
+// renderRating renders the star rating fragment
+func renderRating(w http.ResponseWriter, trackID string, rating int) {
+	stars := make([]int, annotations.MaxRating)
+	for i := range stars {
+		stars[i] = i + 1
+	}
+	renderTemplate(w, ratingView{TrackID: trackID, Rating: rating, Stars: stars}, "web/templates/rating.html")
+}
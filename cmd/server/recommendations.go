@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// recommendationsHandler renders a grid of tracks Spotify recommends, e.g. from the
+// "more like this" button of a tile.
+// ?seed_tracks=, ?seed_artists= and ?seed_genres= take comma separated bare IDs and genre
+// names, at most 5 seeds in total. Tunables like ?target_energy=0.8 or ?min_tempo=120 are
+// passed on to Spotify.
+func recommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	seeds := spotifyClient.RecommendationSeeds{
+		Tracks:  splitList(r.FormValue("seed_tracks")),
+		Artists: splitList(r.FormValue("seed_artists")),
+		Genres:  splitList(r.FormValue("seed_genres")),
+	}
+	for _, id := range append(seeds.Tracks, seeds.Artists...) {
+		if !validSpotifyID(id) {
+			http.Error(w, "Invalid seed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tunables := make(map[string]float64)
+	for name := range r.URL.Query() {
+		if !spotifyClient.ValidTunable(name) {
+			continue
+		}
+		value, err := strconv.ParseFloat(r.FormValue(name), 64)
+		if err != nil {
+			http.Error(w, "Invalid "+name, http.StatusBadRequest)
+			return
+		}
+		tunables[name] = value
+	}
+
+	data := struct {
+		Available bool
+		Tiles     []gridTile
+	}{
+		// The demo has no Spotify account to ask
+		Available: !cfg.DemoMode,
+	}
+	if data.Available {
+		tracks, err := spotifyClient.GetRecommendations(r.Context(), accessToken, seeds, tunables)
+		if errors.Is(err, spotifyClient.ErrNoSeeds) || errors.Is(err, spotifyClient.ErrTooManySeeds) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			slog.Error("failed to fetch recommendations", slog.Any("error", err))
+			http.Error(w, "Failed to load recommendations", http.StatusInternalServerError)
+			return
+		}
+		data.Tiles = likableTiles(r, trackTiles(session.UserID, tracks))
+	}
+
+	renderTemplate(w, data, "web/templates/recommendations.html", "web/templates/grid.html")
+}
+
+// splitList splits a comma separated query value, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
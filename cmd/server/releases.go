@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// newReleasesShown is how many of Spotify's new releases the view shows
+const newReleasesShown = 50
+
+// newRelease is one album on the new releases view
+type newRelease struct {
+	Album spotifyClient.Album
+	Saved bool // already in the user's library
+}
+
+// newReleasesHandler renders the albums Spotify features as new, marking those already in
+// the user's library and offering to save the others, those of the user's country. The
+// demo has no Spotify to ask.
+func newReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Available bool
+		CanSave   bool
+		Releases  []newRelease
+	}{
+		Available: !cfg.DemoMode,
+	}
+	if !data.Available {
+		renderTemplate(w, data, "web/templates/new.html")
+		return
+	}
+
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+	data.CanSave = featureAvailable("likes", session)
+
+	// Sessions from before we recorded the country look it up on Spotify
+	country := session.Country
+	if country == "" {
+		if user, err := spotifyClient.GetCurrentUser(r.Context(), accessToken); err != nil {
+			slog.Warn("failed to look up Spotify country", "user", session.UserID, slog.Any("error", err))
+		} else {
+			country = user.Country
+		}
+	}
+	albums, err := spotifyClient.GetNewReleases(r.Context(), accessToken, country, newReleasesShown)
+	if err != nil {
+		slog.Error("failed to fetch new releases", slog.Any("error", err))
+		http.Error(w, "Failed to load new releases", http.StatusInternalServerError)
+		return
+	}
+	saved, err := library.Albums(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch albums", slog.Any("error", err))
+		http.Error(w, "Failed to load new releases", http.StatusInternalServerError)
+		return
+	}
+	savedIDs := make(map[string]bool, len(saved))
+	for _, album := range saved {
+		savedIDs[album.ID] = true
+	}
+	for _, album := range albums {
+		data.Releases = append(data.Releases, newRelease{Album: album, Saved: savedIDs[album.ID]})
+	}
+
+	renderTemplate(w, data, "web/templates/new.html")
+}
+
+// saveAlbumHandler adds an album to the user's library. Like saving a like, the cache
+// changes first and is rolled back with an error toast if Spotify refuses.
+func saveAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+	albumID := r.PathValue("id")
+	if !validSpotifyID(albumID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	album, err := spotifyClient.GetAlbum(r.Context(), accessToken, albumID)
+	if err != nil {
+		slog.Error("failed to fetch album", "album", albumID, slog.Any("error", err))
+		renderToast(w, http.StatusBadGateway, "Couldn't save the album, Spotify didn't answer.")
+		return
+	}
+
+	undo, err := library.AddAlbum(session.UserID, *album)
+	if err != nil {
+		slog.Warn("failed to persist library", "user", session.UserID, slog.Any("error", err))
+	}
+	if err := spotifyClient.SaveAlbums(r.Context(), accessToken, []string{albumID}); err != nil {
+		slog.Error("failed to save album", "album", albumID, slog.Any("error", err))
+		undo()
+		renderToast(w, http.StatusBadGateway, "Couldn't save "+album.Name+", Spotify refused. Try again in a moment.")
+		return
+	}
+	scheduleReconcile(session.UserID, library.SectionAlbums)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// defaultSearchLimit is how many results of each type a search shows unless ?limit= says otherwise
+const defaultSearchLimit = 20
+
+// searchHandler renders what Spotify's catalog has for a query, not just the user's
+// library. Track tiles play like the liked songs and can be liked or unliked in place.
+// ?q= is the query, ?type= comma separated types out of track, album and artist (all by
+// default) and ?limit= the number of results per type, at most 50.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	query := r.FormValue("q")
+	types := splitList(r.FormValue("type"))
+	if len(types) == 0 {
+		types = []string{spotifyClient.SearchTrack, spotifyClient.SearchAlbum, spotifyClient.SearchArtist}
+	}
+	limit := defaultSearchLimit
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	data := struct {
+		Available bool
+		Query     string
+		Tiles     []gridTile
+		Albums    []spotifyClient.Album
+		Artists   []spotifyClient.Artist
+	}{
+		// The demo has no Spotify account to search with
+		Available: !cfg.DemoMode,
+		Query:     query,
+	}
+	if data.Available {
+		results, err := spotifyClient.Search(r.Context(), accessToken, query, types, limit)
+		if errors.Is(err, spotifyClient.ErrInvalidSearch) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			slog.Error("failed to search Spotify", slog.Any("error", err))
+			http.Error(w, "Failed to search", http.StatusInternalServerError)
+			return
+		}
+
+		data.Tiles = likableTiles(r, trackTiles(session.UserID, results.Tracks))
+		data.Albums = results.Albums
+		data.Artists = results.Artists
+	}
+
+	renderTemplate(w, data, "web/templates/search.html", "web/templates/grid.html", "web/templates/albums.html", "web/templates/artists.html")
+}
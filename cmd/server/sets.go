@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/jendahorak/bangerid/internal/djset"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/harmony"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// setEntry is one track of a built DJ set as shown in the set template
+type setEntry struct {
+	ID    string // bare Spotify ID
+	Track spotifyClient.Track
+	Tempo float64
+	Key   string
+}
+
+// setBuilderHandler orders the selected tracks (track_id, repeatable) into a DJ set
+// with the smallest tempo and key jumps and renders it with a play-all action
+func setBuilderHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	ids := r.PostForm["track_id"]
+	if len(ids) == 0 {
+		http.Error(w, "Select some tracks first", http.StatusBadRequest)
+		return
+	}
+	if len(ids) > djset.MaxTracks {
+		ids = ids[:djset.MaxTracks]
+	}
+
+	tracks, err := library.Tracks(r.Context(), session.UserID, accessToken)
+	if err != nil {
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load tracks", http.StatusInternalServerError)
+		return
+	}
+	byID := make(map[string]spotifyClient.Track, len(tracks))
+	for _, t := range tracks {
+		byID[spotifyClient.IDFromURI(t.ID)] = t
+	}
+
+	// Liked tracks get their features during sync, so no Spotify call is needed here
+	features := library.Features(session.UserID)
+
+	var selection []djset.Track
+	for _, id := range ids {
+		if _, ok := byID[id]; !ok {
+			continue // not in the library, ignore
+		}
+		f := features[id]
+		selection = append(selection, djset.Track{
+			ID:    id,
+			Tempo: f.Tempo,
+			Key:   harmony.FromKey(f.Key, f.Mode),
+		})
+	}
+
+	var set []setEntry
+	for _, t := range djset.Order(selection) {
+		set = append(set, setEntry{
+			ID:    t.ID,
+			Track: byID[t.ID],
+			Tempo: t.Tempo,
+			Key:   t.Key.String(),
+		})
+	}
+
+	renderTemplate(w, set, "web/templates/set.html")
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/apitokens"
+	"github.com/jendahorak/bangerid/internal/bots"
+	"github.com/jendahorak/bangerid/internal/collation"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/history"
+	"github.com/jendahorak/bangerid/internal/kiosks"
+	"github.com/jendahorak/bangerid/internal/prefs"
+	"github.com/jendahorak/bangerid/internal/share"
+)
+
+// settingsHandler renders the settings page
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	renderSettings(w, r, settingsMessage{})
+}
+
+// settingsMessage is feedback on a settings form that couldn't be saved
+type settingsMessage struct {
+	SlugError    string
+	Slug         string // what was typed, to correct it
+	ImportError  string
+	StreamsError string // the streaming history couldn't be imported
+	APIToken     string // just created, shown this once
+	BotCode      string // just created, for connecting a chat bot
+}
+
+// botSettings is what the settings page shows about the chat bots
+type botSettings struct {
+	Telegram         bool   // the Telegram bot is configured
+	TelegramUsername string // to link to it, may be empty
+	Discord          bool   // the Discord bot is configured
+	Link             bots.Link
+}
+
+// renderSettings renders the settings page, with feedback on the form just posted
+func renderSettings(w http.ResponseWriter, r *http.Request, message settingsMessage) {
+	session := handlers.CurrentSession(r)
+
+	data := struct {
+		LoggedIn    bool
+		Session     handlers.Session
+		Prefs       prefs.Prefs
+		Languages   []collation.Language
+		TilePresets []tilePreset
+		TilePreset  string           // name of the one in use
+		ShareURL    string           // empty until the user creates a share link
+		VanityURL   string           // empty until the user claims an address
+		SlugBase    string           // what addresses are prefixed with
+		CanImport   bool             // favorites from other services can be liked
+		APIToken    *apitokens.Token // nil until the user creates one
+		APIBase     string           // what the Home Assistant endpoints are under
+		MQTTTopic   string           // what MQTT topics are under, empty without a broker
+		Bots        botSettings
+		Kiosks      []kiosks.Kiosk     // displays the user paired
+		Listening   *history.Listening // nil until the user imports their streaming history
+		KioskURL    string             // where displays show their pairing code
+		Message     settingsMessage
+	}{
+		LoggedIn:    true,
+		Session:     session,
+		Prefs:       prefs.Get(session.UserID),
+		Languages:   collation.Languages(),
+		TilePresets: tilePresets,
+		TilePreset:  userTilePreset(session.UserID).Name,
+		SlugBase:    baseURL(r) + "/u/",
+		CanImport:   !cfg.DemoMode && featureAvailable("likes", session),
+		APIBase:     baseURL(r) + "/api/ha",
+		Kiosks:      kiosks.List(session.UserID),
+		KioskURL:    baseURL(r) + "/kiosk",
+		Bots: botSettings{
+			Telegram:         telegramBot != nil,
+			TelegramUsername: cfg.TelegramBotUsername,
+			Discord:          discordBot != nil,
+		},
+		Message: message,
+	}
+	if link, ok := bots.Get(session.UserID); ok {
+		data.Bots.Link = link
+	}
+	if token, ok := apitokens.Get(session.UserID); ok {
+		data.APIToken = &token
+		if cfg.MQTTBroker != "" {
+			data.MQTTTopic = cfg.MQTTTopicPrefix + "/" + session.UserID
+		}
+	}
+	if l, ok := history.ImportedListening(session.UserID); ok {
+		data.Listening = &l
+	}
+	if link, ok := share.Get(session.UserID); ok {
+		data.ShareURL = baseURL(r) + "/share/" + link.Token
+		if link.Slug != "" {
+			data.VanityURL = data.SlugBase + link.Slug
+		}
+		if message.SlugError == "" {
+			data.Message.Slug = link.Slug
+		}
+	}
+
+	renderTemplate(w, data, "web/templates/settings.html", "web/templates/header.html")
+}
+
+// savePreferencesHandler stores the preference forms of the settings page. Each form posts
+// some of the preferences, the others are left alone.
+func savePreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	p := prefs.Get(session.UserID)
+	if _, ok := r.PostForm["sort_language"]; ok {
+		p.SortLanguage = r.PostFormValue("sort_language")
+		if !collation.Valid(p.SortLanguage) {
+			http.Error(w, "Unknown language", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, ok := r.PostForm["tile_size"]; ok {
+		p.TileSize = r.PostFormValue("tile_size")
+		if _, ok := tilePresetNamed(p.TileSize); !ok {
+			http.Error(w, "Unknown tile size", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, ok := r.PostForm["time_zone"]; ok {
+		p.TimeZone = r.PostFormValue("time_zone")
+		if !validTimeZone(p.TimeZone) {
+			http.Error(w, "Unknown time zone", http.StatusBadRequest)
+			return
+		}
+		p.TimeZoneConfirmed = true
+	}
+
+	if err := prefs.Set(session.UserID, p); err != nil {
+		slog.Error("failed to save preferences", slog.Any("error", err))
+		http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// detectedTimeZoneHandler stores the time zone the browser reports, which pages post
+// while the user hasn't confirmed one in the settings. Travelling moves it along.
+func detectedTimeZoneHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	name := r.PostFormValue("time_zone")
+	if !validTimeZone(name) {
+		http.Error(w, "Unknown time zone", http.StatusBadRequest)
+		return
+	}
+
+	p := prefs.Get(session.UserID)
+	if !p.TimeZoneConfirmed && p.TimeZone != name {
+		p.TimeZone = name
+		if err := prefs.Set(session.UserID, p); err != nil {
+			slog.Error("failed to save preferences", slog.Any("error", err))
+			http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validTimeZone reports whether name is an IANA time zone like Europe/Prague, or UTC.
+// "Local" would be the server's, which is what the user's time zone is there to replace.
+func validTimeZone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// sessionsHandler renders the list of the user's active sessions across devices
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current := handlers.CurrentSession(r)
+
+	data := struct {
+		CurrentID string
+		Sessions  []handlers.SessionInfo
+	}{
+		CurrentID: current.PublicID(),
+		Sessions:  handlers.UserSessions(current.UserID),
+	}
+
+	renderTemplate(w, data, "web/templates/sessions.html")
+}
+
+// revokeSessionHandler signs out one of the user's sessions and re-renders the list
+func revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	current := handlers.CurrentSession(r)
+	id := r.PathValue("id")
+
+	if !handlers.RevokeSession(current.UserID, id) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	slog.Info("session revoked", "user", current.UserID)
+
+	// Revoking the session we're using is the same as logging out
+	if id == current.PublicID() {
+		w.Header().Set("HX-Redirect", "/")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	sessionsHandler(w, r)
+}
+
+// logoutEverywhereHandler invalidates all of the user's sessions, including this one
+func logoutEverywhereHandler(w http.ResponseWriter, r *http.Request) {
+	current := handlers.CurrentSession(r)
+	handlers.LogoutEverywhere(current.UserID)
+	slog.Info("logged out everywhere", "user", current.UserID)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/cover"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/library"
+	"github.com/jendahorak/bangerid/internal/share"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+const (
+	shareTiles      = 50        // most recently liked tracks shown on a share page
+	shareTopArtists = 3         // artists named in the page description
+	collageTTL      = time.Hour // how long a share collage is reused before it is drawn again
+)
+
+// shareStats sums up a shared library for the page and its link previews
+type shareStats struct {
+	Liked      int
+	Artists    int
+	TopArtists []string // most liked first
+}
+
+// String describes the library in one line, for link previews
+func (s shareStats) String() string {
+	desc := fmt.Sprintf("%d liked songs by %d artists", s.Liked, s.Artists)
+	if len(s.TopArtists) > 0 {
+		desc += ", most of all " + strings.Join(s.TopArtists, ", ")
+	}
+	return desc
+}
+
+// statsOf counts the liked tracks and their main artists
+func statsOf(tracks []spotifyClient.Track) shareStats {
+	perArtist := make(map[string]int)
+	for _, t := range tracks {
+		perArtist[t.Artist]++
+	}
+	artists := make([]string, 0, len(perArtist))
+	for name := range perArtist {
+		artists = append(artists, name)
+	}
+	slices.SortFunc(artists, func(a, b string) int {
+		return cmp.Or(perArtist[b]-perArtist[a], strings.Compare(a, b))
+	})
+
+	return shareStats{
+		Liked:      len(tracks),
+		Artists:    len(artists),
+		TopArtists: artists[:min(shareTopArtists, len(artists))],
+	}
+}
+
+// sharedLibrary resolves a share token to its link and the liked tracks behind it.
+// Links of suspended users stop working.
+func sharedLibrary(token string) (share.Link, []spotifyClient.Track, bool) {
+	userID, ok := share.Lookup(token)
+	if !ok || handlers.Suspended(userID) {
+		return share.Link{}, nil, false
+	}
+	link, _ := share.Get(userID)
+	return link, library.CachedTracks(userID), true
+}
+
+// baseURL is the public origin of the server for absolute links in previews. It comes
+// from the redirect URL of the Spotify app serving the host, which is configured rather
+// than sent by the client.
+func baseURL(r *http.Request) string {
+	if app, ok := oauthApps.ForRequest(r); ok {
+		if u, err := url.Parse(app.RedirectURL); err == nil && u.Host != "" {
+			return u.Scheme + "://" + u.Host
+		}
+	}
+	return "http://" + r.Host
+}
+
+// shareHandler renders the public page of a share link: the most recently liked tracks
+// with OpenGraph and Twitter meta tags, so pasted links unfurl with the collage.
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	renderShare(w, r, token, baseURL(r)+"/share/"+token)
+}
+
+// vanityHandler renders the share page at the address its owner claimed, /u/<slug>
+func vanityHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := shareToken(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	renderShare(w, r, token, baseURL(r)+r.URL.Path)
+}
+
+// shareToken resolves the path of a share page, /share/<token> or /u/<slug>, to its token
+func shareToken(path string) (string, bool) {
+	if token, ok := strings.CutPrefix(path, "/share/"); ok {
+		return token, token != "" && !strings.Contains(token, "/")
+	}
+	slug, ok := strings.CutPrefix(path, "/u/")
+	if !ok || slug == "" || strings.Contains(slug, "/") {
+		return "", false
+	}
+	userID, ok := share.LookupSlug(slug)
+	if !ok {
+		return "", false
+	}
+	link, ok := share.Get(userID)
+	return link.Token, ok
+}
+
+// renderShare renders the share page of a token at pageURL, the address it was visited at
+func renderShare(w http.ResponseWriter, r *http.Request, token, pageURL string) {
+	link, tracks, ok := sharedLibrary(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		Title     string
+		Stats     shareStats
+		Tracks    []spotifyClient.Track
+		PageURL   string
+		ImageURL  string
+		ImageSize int
+		OEmbedURL string
+	}{
+		Title:     shareTitle(link.Name),
+		Stats:     statsOf(tracks),
+		Tracks:    tracks[:min(shareTiles, len(tracks))],
+		PageURL:   pageURL,
+		ImageURL:  baseURL(r) + "/share/" + token + "/collage.jpg",
+		ImageSize: cover.Size,
+		OEmbedURL: baseURL(r) + "/oembed?format=json&url=" + url.QueryEscape(pageURL),
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	renderTemplate(w, data, "web/templates/share.html")
+}
+
+// collages caches drawn share collages by token, unfurling bots fetch them a lot
+var (
+	collagesMu sync.Mutex
+	collages   = make(map[string]cachedCollage)
+)
+
+type cachedCollage struct {
+	jpeg    []byte
+	drawnAt time.Time
+}
+
+// shareCollageHandler serves the collage of the album art of the latest likes behind a
+// share link, the image of its link previews
+func shareCollageHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	_, tracks, ok := sharedLibrary(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	collagesMu.Lock()
+	cached, ok := collages[token]
+	collagesMu.Unlock()
+
+	if !ok || time.Since(cached.drawnAt) > collageTTL {
+		var urls []string
+		for _, t := range tracks {
+			image := cmp.Or(t.CoverImage, t.AlbumImage)
+			if image != "" && !slices.Contains(urls, image) {
+				urls = append(urls, image)
+			}
+			if len(urls) == 4 {
+				break
+			}
+		}
+		if len(urls) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		jpeg, err := cover.Collage(r.Context(), urls, spotifyClient.MaxCoverBytes)
+		if err != nil {
+			slog.Error("failed to draw share collage", slog.Any("error", err))
+			http.Error(w, "Failed to draw collage", http.StatusBadGateway)
+			return
+		}
+		cached = cachedCollage{jpeg: jpeg, drawnAt: time.Now()}
+		collagesMu.Lock()
+		collages[token] = cached
+		collagesMu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(cached.jpeg)
+}
+
+// oembedResponse is an oEmbed "link" response with a thumbnail, see https://oembed.com
+type oembedResponse struct {
+	Version         string `json:"version"`
+	Type            string `json:"type"`
+	Title           string `json:"title"`
+	AuthorName      string `json:"author_name,omitempty"`
+	ProviderName    string `json:"provider_name"`
+	ProviderURL     string `json:"provider_url"`
+	ThumbnailURL    string `json:"thumbnail_url"`
+	ThumbnailWidth  int    `json:"thumbnail_width"`
+	ThumbnailHeight int    `json:"thumbnail_height"`
+	CacheAge        int    `json:"cache_age"`
+}
+
+// oembedHandler describes a share link for apps that unfurl links through oEmbed.
+// ?url= is the share page, by token or slug, ?format= may only be json.
+func oembedHandler(w http.ResponseWriter, r *http.Request) {
+	if format := r.FormValue("format"); format != "" && format != "json" {
+		http.Error(w, "Only JSON is supported", http.StatusNotImplemented)
+		return
+	}
+
+	u, err := url.Parse(r.FormValue("url"))
+	if err != nil {
+		http.Error(w, "Invalid url", http.StatusBadRequest)
+		return
+	}
+	token, ok := shareToken(u.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	link, tracks, ok := sharedLibrary(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	base := baseURL(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(oembedResponse{
+		Version:         "1.0",
+		Type:            "link",
+		Title:           shareTitle(link.Name) + ": " + statsOf(tracks).String(),
+		AuthorName:      link.Name,
+		ProviderName:    "Bangerid",
+		ProviderURL:     base,
+		ThumbnailURL:    base + "/share/" + token + "/collage.jpg",
+		ThumbnailWidth:  cover.Size,
+		ThumbnailHeight: cover.Size,
+		CacheAge:        int(collageTTL.Seconds()),
+	})
+}
+
+// shareTitle names a shared library after the user who shared it
+func shareTitle(name string) string {
+	if name == "" {
+		return "Liked songs"
+	}
+	return name + "'s liked songs"
+}
+
+// createShareHandler creates the user's share link, if they have none yet
+func createShareHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	if _, err := share.Create(session.UserID, session.DisplayName); err != nil {
+		slog.Error("failed to create share link", slog.Any("error", err))
+		http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// shareSlugHandler claims the address in the slug form field for the user's share link,
+// or releases it when empty. Addresses that can't be claimed are explained on the
+// settings page.
+func shareSlugHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	slug := r.PostFormValue("slug")
+
+	err := share.SetSlug(session.UserID, slug)
+	switch {
+	case errors.Is(err, share.ErrNoLink):
+		http.Error(w, "Create a share link first", http.StatusConflict)
+	case errors.Is(err, share.ErrInvalidSlug), errors.Is(err, share.ErrReservedSlug), errors.Is(err, share.ErrSlugTaken):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderSettings(w, r, settingsMessage{SlugError: err.Error(), Slug: slug})
+	case err != nil:
+		slog.Error("failed to save share address", slog.Any("error", err))
+		http.Error(w, "Failed to save the address", http.StatusInternalServerError)
+	default:
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+	}
+}
+
+// deleteShareHandler deletes the user's share link, so it stops working
+func deleteShareHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	link, ok := share.Get(session.UserID)
+	if err := share.Delete(session.UserID); err != nil {
+		slog.Error("failed to delete share link", slog.Any("error", err))
+		http.Error(w, "Failed to delete share link", http.StatusInternalServerError)
+		return
+	}
+	if ok {
+		collagesMu.Lock()
+		delete(collages, link.Token)
+		collagesMu.Unlock()
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
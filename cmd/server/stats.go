@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/prefs"
+	"github.com/jendahorak/bangerid/internal/timeline"
+)
+
+// growthYear annotates one year of the growth chart
+type growthYear struct {
+	Year    int
+	Added   int
+	Removed int
+	Liked   int     // library size at the end of the year (or now, for this year)
+	X       float64 // where the year starts on the chart
+	Percent float64 // the same as a percentage of the chart width, for labels outside the SVG
+}
+
+// statsHandler renders the stats page, whose charts load as fragments
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		LoggedIn bool
+	}{
+		LoggedIn: true,
+	}
+	renderTemplate(w, data, "web/templates/stats.html", "web/templates/header.html")
+}
+
+// growthHandler renders the cumulative size of the user's liked songs over time as an
+// SVG area chart, annotated with what was added and removed in each year
+func growthHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	months := timeline.Growth(session.UserID, time.Now(), prefs.Get(session.UserID).Location())
+
+	most := 1
+	for _, m := range months {
+		most = max(most, m.Liked)
+	}
+
+	// One point per month end; a single month still gets a flat line across the chart
+	step := float64(chartWidth)
+	if len(months) > 1 {
+		step = float64(chartWidth) / float64(len(months)-1)
+	}
+	y := func(liked int) float64 {
+		return chartHeight - float64(liked)/float64(most)*chartHeight
+	}
+
+	var path strings.Builder
+	var years []growthYear
+	for i, m := range months {
+		x := float64(i) * step
+		if i == 0 {
+			fmt.Fprintf(&path, "M0,%d", chartHeight)
+		}
+		fmt.Fprintf(&path, " L%.2f,%.2f", x, y(m.Liked))
+		if len(months) == 1 {
+			fmt.Fprintf(&path, " L%d,%.2f", chartWidth, y(m.Liked))
+		}
+
+		if len(years) == 0 || years[len(years)-1].Year != m.Start.Year() {
+			years = append(years, growthYear{Year: m.Start.Year(), X: x, Percent: x / chartWidth * 100})
+		}
+		year := &years[len(years)-1]
+		year.Added += m.Added
+		year.Removed += m.Removed
+		year.Liked = m.Liked
+	}
+	if path.Len() > 0 {
+		fmt.Fprintf(&path, " L%d,%d Z", chartWidth, chartHeight)
+	}
+
+	data := struct {
+		Path   string
+		Years  []growthYear
+		Liked  int
+		Width  int
+		Height int
+	}{
+		Path:   path.String(),
+		Years:  years,
+		Width:  chartWidth,
+		Height: chartHeight,
+	}
+	if len(months) > 0 {
+		data.Liked = months[len(months)-1].Liked
+	}
+
+	renderTemplate(w, data, "web/templates/growth.html")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// States of the app the status banner tells about, besides all being well
+const (
+	statusOffline     = "offline"      // Spotify can't be reached, only the cache is left
+	statusRateLimited = "rate_limited" // Spotify asked us to back off
+	statusDegraded    = "degraded"     // Spotify answers with errors
+)
+
+const (
+	// offlineAfter is how long Spotify has to fail without a call going through for the
+	// app to count as offline
+	offlineAfter = 2 * time.Minute
+	// statusCheckInterval is how often event streams look for a changed status
+	statusCheckInterval = 5 * time.Second
+	// statusKeepAlive is how often an event stream without news sends a comment, so
+	// proxies don't close it as idle
+	statusKeepAlive = 30 * time.Second
+)
+
+// appStatus is what the status banner on every page shows, the same for every user: the
+// trouble is Spotify's, not theirs
+type appStatus struct {
+	State   string
+	Message string
+}
+
+// currentStatus returns what the status banner shows, nil while all is well. The most
+// severe trouble wins.
+func currentStatus() *appStatus {
+	// The demo never calls Spotify
+	if cfg == nil || cfg.DemoMode {
+		return nil
+	}
+
+	health := spotifyClient.CurrentHealth()
+	now := time.Now()
+	switch {
+	case !health.FailingSince.IsZero() && now.Sub(health.FailingSince) >= offlineAfter:
+		return &appStatus{
+			State:   statusOffline,
+			Message: "Spotify can't be reached. Your cached library still shows, playing and liking wait until it's back.",
+		}
+	case health.RateLimitedUntil.After(now):
+		wait := "a minute"
+		if minutes := int(math.Ceil(health.RateLimitedUntil.Sub(now).Minutes())); minutes > 1 {
+			wait = fmt.Sprintf("%d minutes", minutes)
+		}
+		return &appStatus{
+			State:   statusRateLimited,
+			Message: "Spotify asked us to slow down. Some things won't load for about " + wait + ".",
+		}
+	case !health.FailingSince.IsZero():
+		return &appStatus{
+			State:   statusDegraded,
+			Message: "Spotify is having trouble. Some things may fail to load, try again in a bit.",
+		}
+	}
+	return nil
+}
+
+// statusEventsHandler streams the status banner as server-sent events: a "status" event
+// with the banner's HTML on connecting and whenever the status changes, so open pages
+// show trouble as it starts and drop the banner once it's over
+func statusEventsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.New("header.html").Funcs(templateFuncs()).ParseFiles("web/templates/header.html")
+	if err != nil {
+		slog.Error("template parse error", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	rc := http.NewResponseController(w)
+
+	ticker := time.NewTicker(statusCheckInterval)
+	defer ticker.Stop()
+
+	var sent *string // the banner sent last, nil before the first
+	lastWrite := time.Now()
+	for {
+		var banner bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&banner, "status-banner", currentStatus()); err != nil {
+			slog.Error("template execute error", slog.Any("error", err))
+			return
+		}
+
+		var event string
+		switch {
+		case sent == nil || *sent != banner.String():
+			event = sseEvent("status", banner.String())
+			s := banner.String()
+			sent = &s
+		case time.Since(lastWrite) >= statusKeepAlive:
+			event = sseKeepAlive
+		}
+		if event != "" {
+			if _, err := w.Write([]byte(event)); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			lastWrite = time.Now()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sseKeepAlive is a server-sent event comment, which keeps an idle stream open
+const sseKeepAlive = ": keep-alive\n\n"
+
+// sseEvent formats a server-sent event, with each line of data in a data field of its own
+func sseEvent(name, data string) string {
+	var event strings.Builder
+	event.WriteString("event: " + name + "\n")
+	for line := range strings.SplitSeq(data, "\n") {
+		event.WriteString("data: " + line + "\n")
+	}
+	event.WriteString("\n")
+	return event.String()
+}
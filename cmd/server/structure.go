@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/harmony"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// The structure strip is drawn into a viewBox of this size and stretched to the panel
+const (
+	structureWidth   = 600
+	structureHeight  = 80
+	barTickHeight    = 6
+	loudnessPoints   = 150 // segments are bucketed into this many points of the loudness line
+	quietestLoudness = -40 // dB drawn at the bottom of the strip, anything quieter too
+)
+
+// structureSection is one section of the track as drawn on the strip
+type structureSection struct {
+	X, Width float64
+	Alt      bool   // every other section is shaded differently so boundaries show
+	Label    string // e.g. "0:32 - 1:04 · 8A · 124 BPM"
+}
+
+// structureHandler renders the sections, loudness and bars of a track as an SVG strip
+// for the detail panel. Any track can be drawn, but without Spotify (the demo) or when
+// Spotify has no analysis it renders nothing and the panel just skips the strip.
+func structureHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	trackID := r.PathValue("id")
+	if cfg.DemoMode || !validSpotifyID(trackID) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	analysis, err := spotifyClient.GetAudioAnalysis(r.Context(), accessToken, trackID)
+	if err != nil {
+		slog.Warn("failed to fetch audio analysis", "track", trackID, slog.Any("error", err))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	duration := analysis.Track.Duration
+	if duration <= 0 || len(analysis.Sections) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	scale := structureWidth / duration
+
+	sections := make([]structureSection, len(analysis.Sections))
+	for i, s := range analysis.Sections {
+		label := formatDuration(seconds(s.Start)) + " - " + formatDuration(seconds(s.Start+s.Duration))
+		if key := harmony.FromKey(s.Key, s.Mode); key.Known() {
+			label += " · " + key.String()
+		}
+		if s.Tempo > 0 {
+			label += fmt.Sprintf(" · %.0f BPM", s.Tempo)
+		}
+		sections[i] = structureSection{X: s.Start * scale, Width: s.Duration * scale, Alt: i%2 == 1, Label: label}
+	}
+
+	bars := make([]float64, len(analysis.Bars))
+	for i, b := range analysis.Bars {
+		bars[i] = b.Start * scale
+	}
+
+	data := struct {
+		Width, Height int
+		BarTop        int // where the bar ticks along the bottom start
+		Sections      []structureSection
+		Loudness      string // polyline points
+		Bars          []float64
+		Tempo         float64
+	}{
+		Width:    structureWidth,
+		Height:   structureHeight,
+		BarTop:   structureHeight - barTickHeight,
+		Sections: sections,
+		Loudness: loudnessLine(analysis.Segments, duration),
+		Bars:     bars,
+		Tempo:    analysis.Track.Tempo,
+	}
+	renderTemplate(w, data, "web/templates/structure.html")
+}
+
+// loudnessLine turns the peak loudness of the segments into polyline points across the
+// strip, taking the loudest segment starting in each bucket. Bars run along the bottom,
+// so the line stays above them.
+func loudnessLine(segments []spotifyClient.AnalysisSegment, duration float64) string {
+	if len(segments) == 0 {
+		return ""
+	}
+
+	peaks := make([]float64, loudnessPoints)
+	for i := range peaks {
+		peaks[i] = quietestLoudness
+	}
+	for _, s := range segments {
+		i := min(int(s.Start/duration*loudnessPoints), loudnessPoints-1)
+		if i >= 0 {
+			peaks[i] = max(peaks[i], s.LoudnessMax)
+		}
+	}
+
+	var sb strings.Builder
+	top, bottom := 4.0, float64(structureHeight-barTickHeight-4)
+	for i, db := range peaks {
+		level := min(max(1-db/quietestLoudness, 0), 1)
+		x := (float64(i) + 0.5) * structureWidth / loudnessPoints
+		fmt.Fprintf(&sb, "%.1f,%.1f ", x, bottom-level*(bottom-top))
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// seconds converts the fractional seconds of an analysis into a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
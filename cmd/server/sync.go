@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// runSyncCommand implements "bangerid sync --user ID": it syncs one user's library with
+// the refresh token of their stored session and writes it to the data directory, so a
+// cron job can keep the web instance warm. It returns the process exit code.
+func runSyncCommand(args []string) int {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	userID := flags.String("user", "", "Spotify user ID whose library to sync (required)")
+	section := flags.String("section", "", "only sync this section, e.g. tracks, albums or artists")
+	timeout := flags.Duration("timeout", 10*time.Minute, "give up after this long")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *userID == "" {
+		fmt.Fprintln(os.Stderr, "sync: --user is required")
+		flags.Usage()
+		return 2
+	}
+	if cfg.DataDir == "" {
+		fmt.Fprintln(os.Stderr, "sync: DATA_DIR must be set, the stored sessions live there")
+		return 2
+	}
+
+	sections := library.EnabledSections()
+	if *section != "" {
+		sections = []library.Section{library.Section(*section)}
+		if !library.KnownSection(sections[0]) {
+			fmt.Fprintf(os.Stderr, "sync: unknown section %q\n", *section)
+			return 2
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	ctx = spotifyClient.WithUser(ctx, *userID)
+
+	accessToken, rotated, err := handlers.UserAccessToken(ctx, oauthApps, *userID)
+	if err != nil {
+		slog.Error("sync failed", "user", *userID, slog.Any("error", err))
+		return 1
+	}
+	// Only write the session store back when Spotify handed out a new refresh token,
+	// the running server keeps its own copy and saves it regularly anyway
+	if rotated {
+		if err := handlers.SaveSessions(); err != nil {
+			slog.Error("failed to save sessions", slog.Any("error", err))
+		}
+	}
+
+	failed := false
+	for _, s := range sections {
+		if err := library.Sync(ctx, *userID, accessToken, s); err != nil {
+			slog.Error("sync failed", "section", s, "user", *userID, slog.Any("error", err))
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
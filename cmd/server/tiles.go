@@ -0,0 +1,66 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/jendahorak/bangerid/internal/prefs"
+)
+
+// tilePreset is a density of the grid the user picks in the settings: how large tiles
+// are, which cover image they show, how many the grid renders at once and how much
+// they show besides the cover
+type tilePreset struct {
+	Name     string
+	Title    string // what the settings call it
+	Size     int    // tile edge in CSS pixels
+	PageSize int    // tiles the grid renders before loading more as you scroll
+	Details  bool   // duration, key, rating and note badges
+	Large    bool   // the ~300px cover instead of the 64px thumbnail, for large tiles
+}
+
+// tilePresets are the densities to pick from, roomiest first
+var tilePresets = []tilePreset{
+	{Name: "cozy", Title: "Cozy, large covers", Size: 128, PageSize: 200, Details: true, Large: true},
+	{Name: "compact", Title: "Compact", Size: 64, PageSize: 500, Details: true},
+	{Name: "micro", Title: "Micro, covers only", Size: 32, PageSize: 1500},
+}
+
+// defaultTilePreset is the density for users who never picked one
+const defaultTilePreset = "compact"
+
+// tilePresetNamed finds a preset by name, the default one for an empty name
+func tilePresetNamed(name string) (tilePreset, bool) {
+	if name == "" {
+		name = defaultTilePreset
+	}
+	i := slices.IndexFunc(tilePresets, func(p tilePreset) bool { return p.Name == name })
+	if i < 0 {
+		return tilePreset{}, false
+	}
+	return tilePresets[i], true
+}
+
+// userTilePreset is the density the user picked, the default one if none or one that no
+// longer exists
+func userTilePreset(userID string) tilePreset {
+	if p, ok := tilePresetNamed(prefs.Get(userID).TileSize); ok {
+		return p
+	}
+	p, _ := tilePresetNamed(defaultTilePreset)
+	return p
+}
+
+// apply fills in what the preset decides about tiles, numbering them from offset
+func (p tilePreset) apply(tiles []gridTile, offset int) {
+	for i := range tiles {
+		t := &tiles[i]
+		t.Index = offset + i
+		t.Details = p.Details
+		t.Image = t.Track.AlbumImage
+		if p.Large {
+			// Libraries synced before medium covers were kept fall back to the largest one
+			t.Image = cmp.Or(t.Track.MediumImage, t.Track.CoverImage, t.Track.AlbumImage)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/prefs"
+	"github.com/jendahorak/bangerid/internal/timeline"
+)
+
+// Size of the growth chart's SVG coordinate system; the page scales it to fit
+const (
+	chartWidth  = 600
+	chartHeight = 120
+)
+
+// timelineBar is one month of the growth chart
+type timelineBar struct {
+	Month   string // as_of value, e.g. 2023-06
+	Label   string // e.g. Jun 2023
+	Liked   int
+	Added   int
+	Removed int
+	X, Y    float64
+	W, H    float64
+}
+
+// endOfPeriod returns the last instant of a month like 2023-06 or a day like 2023-06-15,
+// in loc
+func endOfPeriod(date string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", date, loc); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	t, err := time.ParseInLocation("2006-01", date, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", date)
+	}
+	return t.AddDate(0, 1, 0).Add(-time.Nanosecond), nil
+}
+
+// timelineHandler renders the growth of the user's liked songs as a bar chart per month.
+// Clicking a month shows the grid as it was at the end of it.
+func timelineHandler(w http.ResponseWriter, r *http.Request) {
+	session := handlers.CurrentSession(r)
+	loc := prefs.Get(session.UserID).Location()
+	months := timeline.Growth(session.UserID, time.Now(), loc)
+
+	most := 1
+	for _, m := range months {
+		most = max(most, m.Liked)
+	}
+
+	bars := make([]timelineBar, len(months))
+	for i, m := range months {
+		width := float64(chartWidth) / float64(len(months))
+		height := float64(m.Liked) / float64(most) * chartHeight
+		bars[i] = timelineBar{
+			Month:   m.Start.Format("2006-01"),
+			Label:   m.Start.Format("Jan 2006"),
+			Liked:   m.Liked,
+			Added:   m.Added,
+			Removed: m.Removed,
+			X:       float64(i) * width,
+			Y:       chartHeight - height,
+			W:       width,
+			H:       height,
+		}
+	}
+
+	data := struct {
+		Bars   []timelineBar
+		Width  int
+		Height int
+		Today  string
+	}{
+		Bars:   bars,
+		Width:  chartWidth,
+		Height: chartHeight,
+		Today:  time.Now().In(loc).Format("2006-01-02"),
+	}
+
+	renderTemplate(w, data, "web/templates/timeline.html")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/jendahorak/bangerid/internal/annotations"
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/harmony"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// topRange is one of the time range choices of the top view
+type topRange struct {
+	Value spotifyClient.TimeRange
+	Label string
+}
+
+var topRanges = []topRange{
+	{spotifyClient.ShortTerm, "Last 4 weeks"},
+	{spotifyClient.MediumTerm, "Last 6 months"},
+	{spotifyClient.LongTerm, "Last year"},
+}
+
+// topHandler renders the user's most played tracks (?type=tracks, the default) or artists
+// (?type=artists) as a grid, ranked by Spotify over ?time_range=short_term, medium_term
+// (the default) or long_term.
+func topHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+
+	timeRange := spotifyClient.TimeRange(r.FormValue("time_range"))
+	if timeRange == "" {
+		timeRange = spotifyClient.MediumTerm
+	}
+	itemType := r.FormValue("type")
+	if itemType == "" {
+		itemType = "tracks"
+	}
+	if !timeRange.Valid() || (itemType != "tracks" && itemType != "artists") {
+		http.Error(w, "Unknown time range or type", http.StatusBadRequest)
+		return
+	}
+
+	data := struct {
+		Type      string
+		TimeRange spotifyClient.TimeRange
+		Ranges    []topRange
+		Tiles     []gridTile
+		Artists   []spotifyClient.Artist
+	}{
+		Type:      itemType,
+		TimeRange: timeRange,
+		Ranges:    topRanges,
+	}
+
+	var err error
+	if itemType == "artists" {
+		data.Artists, err = spotifyClient.FetchTopArtists(r.Context(), accessToken, timeRange)
+	} else {
+		var tracks []spotifyClient.Track
+		tracks, err = spotifyClient.FetchTopTracks(r.Context(), accessToken, timeRange)
+		data.Tiles = trackTiles(session.UserID, tracks)
+	}
+	if err != nil {
+		slog.Error("failed to fetch top items", "type", itemType, slog.Any("error", err))
+		http.Error(w, "Failed to load your top "+itemType, http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, data, "web/templates/top.html", "web/templates/grid.html", "web/templates/artists.html")
+}
+
+// trackTiles makes grid tiles of tracks with the key, note and rating the user has for each
+func trackTiles(userID string, tracks []spotifyClient.Track) []gridTile {
+	features := library.Features(userID)
+	notes := annotations.Notes(userID)
+	ratings := annotations.Ratings(userID)
+
+	tiles := make([]gridTile, len(tracks))
+	for i, track := range tracks {
+		id := spotifyClient.IDFromURI(track.ID)
+		tiles[i] = gridTile{Track: track, Note: notes[id].Text, Rating: ratings[id]}
+		if f, ok := features[id]; ok {
+			tiles[i].Key = harmony.FromKey(f.Key, f.Mode).String()
+		}
+	}
+	userTilePreset(userID).apply(tiles, 0)
+	return tiles
+}
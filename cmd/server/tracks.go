@@ -0,0 +1,211 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/jendahorak/bangerid/internal/handlers"
+	"github.com/jendahorak/bangerid/internal/harmony"
+	"github.com/jendahorak/bangerid/internal/history"
+	"github.com/jendahorak/bangerid/internal/library"
+	spotifyClient "github.com/jendahorak/bangerid/internal/spotify"
+	"github.com/jendahorak/bangerid/internal/waveform"
+	"golang.org/x/sync/errgroup"
+)
+
+// trackDetailHandler renders the detail panel for one of the user's liked tracks
+func trackDetailHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+
+	track, found, err := library.FindTrack(r.Context(), session.UserID, accessToken, trackID)
+	if err != nil {
+		slog.Error("failed to fetch tracks", slog.Any("error", err))
+		http.Error(w, "Failed to load track", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Start analyzing the preview now so the strip is likely ready when the panel asks for it
+	waveform.Request(trackID, track.PreviewURL)
+
+	data := struct {
+		TrackID string
+		Track   spotifyClient.Track
+		Key     string
+		Tempo   float64
+		Genres  []string
+		// Playlists offers to list the user's playlists with the track
+		Playlists bool
+		Plays     int // in the imported streaming history
+	}{
+		TrackID:   trackID,
+		Track:     track,
+		Genres:    library.Genres(session.UserID, track),
+		Playlists: !cfg.DemoMode && featureAvailable("playlists", session),
+		Plays:     history.PlayCount(session.UserID, trackID),
+	}
+	if f, ok := library.Features(session.UserID)[trackID]; ok {
+		data.Key = harmony.FromKey(f.Key, f.Mode).String()
+		data.Tempo = f.Tempo
+	}
+
+	renderTemplate(w, data, "web/templates/track.html")
+}
+
+// waveformHandler renders the waveform strip of a track's preview as inline SVG.
+// While the preview is still being analyzed it returns a placeholder that polls again.
+func waveformHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+
+	track, found, err := library.FindTrack(r.Context(), session.UserID, accessToken, trackID)
+	if err != nil || !found || track.PreviewURL == "" {
+		// No preview, no waveform; render nothing so the panel just skips the strip
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	data := struct {
+		TrackID string
+		Ready   bool
+		SVG     template.HTML
+	}{
+		TrackID: trackID,
+	}
+
+	if peaks, ok := waveform.Get(trackID); ok {
+		data.Ready = true
+		// SVG is generated by us from numbers only, safe to embed
+		data.SVG = template.HTML(waveform.SVG(peaks, 600, 60))
+	} else {
+		waveform.Request(trackID, track.PreviewURL)
+	}
+
+	renderTemplate(w, data, "web/templates/waveform.html")
+}
+
+// trackLinkHandler renders the share links of a track, its open.spotify.com URL and spotify:
+// URI, ready to be copied. Any track can be shared, liked or not, so it needs no lookup.
+func trackLinkHandler(w http.ResponseWriter, r *http.Request) {
+	trackID := r.PathValue("id")
+	if !validSpotifyID(trackID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	uri := spotifyClient.TrackURI(trackID)
+	data := struct {
+		URI string
+		URL string
+	}{
+		URI: uri,
+		URL: spotifyClient.OpenURL(uri),
+	}
+
+	renderTemplate(w, data, "web/templates/link.html")
+}
+
+const (
+	// maxPlaylistFetches is how many playlists the appears-in list fetches the tracks of,
+	// the ones not cached yet, per request: users with hundreds of playlists would wait
+	// for hundreds of requests. The rest are checked the next time.
+	maxPlaylistFetches = 20
+	// playlistFetchParallelism is how many of them are fetched at the same time
+	playlistFetchParallelism = 4
+)
+
+// playlistAppearance is one of the user's playlists a track is in
+type playlistAppearance struct {
+	Playlist spotifyClient.Playlist
+	// Release is set when the playlist has the same recording from another album, not the
+	// track itself
+	Release bool
+}
+
+// appearsInHandler lists the user's playlists that contain a track, or the same recording
+// on another release, from the cached playlist contents. Playlists not cached yet are
+// fetched up to maxPlaylistFetches at a time, the list tells how many it didn't check.
+func appearsInHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Context().Value(handlers.AccessTokenKey).(string)
+	session := handlers.CurrentSession(r)
+	trackID := r.PathValue("id")
+	if !validSpotifyID(trackID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		TrackID   string
+		Playlists []playlistAppearance
+		Unchecked int
+	}{
+		TrackID: trackID,
+	}
+
+	// The sample library has no playlists and there is no Spotify account to ask
+	if !cfg.DemoMode {
+		playlists, err := spotifyClient.FetchUserPlaylists(r.Context(), accessToken)
+		if err != nil {
+			slog.Error("failed to fetch playlists", slog.Any("error", err))
+			http.Error(w, "Failed to load playlists", http.StatusInternalServerError)
+			return
+		}
+
+		uri := spotifyClient.TrackURI(trackID)
+		key := uri
+		if track, found, err := library.FindTrack(r.Context(), session.UserID, accessToken, trackID); err == nil && found {
+			key = recordingKey(track)
+		}
+
+		appearances := make([]*playlistAppearance, len(playlists))
+		var checked atomic.Int32
+		g, ctx := errgroup.WithContext(r.Context())
+		g.SetLimit(playlistFetchParallelism)
+		fetches := 0
+		for i, p := range playlists {
+			if !spotifyClient.PlaylistCached(p) {
+				if fetches == maxPlaylistFetches {
+					continue
+				}
+				fetches++
+			}
+			g.Go(func() error {
+				tracks, err := spotifyClient.PlaylistTracksAt(ctx, accessToken, p)
+				if err != nil {
+					// Followed playlists can be gone, that shouldn't hide the others
+					slog.Warn("failed to fetch playlist tracks", "playlist", p.ID, slog.Any("error", err))
+					return nil
+				}
+				checked.Add(1)
+				for _, t := range tracks {
+					if t.ID == uri {
+						appearances[i] = &playlistAppearance{Playlist: p}
+						return nil
+					}
+					if recordingKey(t) == key {
+						appearances[i] = &playlistAppearance{Playlist: p, Release: true}
+					}
+				}
+				return nil
+			})
+		}
+		g.Wait()
+
+		for _, a := range appearances {
+			if a != nil {
+				data.Playlists = append(data.Playlists, *a)
+			}
+		}
+		data.Unchecked = len(playlists) - int(checked.Load())
+	}
+
+	renderTemplate(w, data, "web/templates/appears-in.html")
+}
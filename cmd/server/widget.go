@@ -0,0 +1,132 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	widgetTracks    = 10               // likes returned without ?limit=
+	widgetRateLimit = 30               // requests per client and widgetWindow
+	widgetWindow    = time.Minute      // how long the rate limit counts requests of a client
+	widgetMaxAge    = 10 * time.Minute // how long browsers and CDNs reuse a response
+)
+
+// widgetLikes is what the widget endpoint returns: the latest likes behind a share link,
+// to show on another site
+type widgetLikes struct {
+	Name   string        `json:"name,omitempty"` // of the user who shared, empty if they didn't say
+	Liked  int           `json:"liked"`          // liked songs in all
+	URL    string        `json:"url"`            // the share page
+	Tracks []widgetTrack `json:"tracks"`         // the most recently liked first
+}
+
+// widgetTrack is one of the latest likes of a widget
+type widgetTrack struct {
+	Name    string    `json:"name"`
+	Artist  string    `json:"artist"`
+	Album   string    `json:"album,omitempty"`
+	URL     string    `json:"url"`             // open.spotify.com
+	Image   string    `json:"image,omitempty"` // album art, the smallest Spotify has
+	LikedAt time.Time `json:"liked_at,omitzero"`
+}
+
+// widgetHits counts the requests of each client in the current window of the rate limit
+var (
+	widgetMu          sync.Mutex
+	widgetWindowStart time.Time
+	widgetHits        = make(map[string]int)
+)
+
+// allowWidget counts a request of a client against the rate limit. When it's over the
+// limit it returns how long until it may ask again.
+func allowWidget(client string) (time.Duration, bool) {
+	widgetMu.Lock()
+	defer widgetMu.Unlock()
+
+	now := time.Now()
+	if now.Sub(widgetWindowStart) >= widgetWindow {
+		clear(widgetHits)
+		widgetWindowStart = now
+	}
+	widgetHits[client]++
+	if widgetHits[client] > widgetRateLimit {
+		return widgetWindowStart.Add(widgetWindow).Sub(now), false
+	}
+	return 0, true
+}
+
+// widgetHandler returns the latest likes behind a share link as JSON, ?limit= of them up
+// to as many as the share page shows, for "recently liked" widgets on personal sites.
+// Anyone with the link may ask, from any origin. Responses are cached for long and
+// revalidated by ETag, and each client is rate limited, by address: visitors of a site
+// fetch it from their own browsers.
+func widgetHandler(w http.ResponseWriter, r *http.Request) {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	if wait, ok := allowWidget(client); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	token := r.PathValue("token")
+	link, tracks, ok := sharedLibrary(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	limit := widgetTracks
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, shareTiles)
+	}
+
+	likes := widgetLikes{
+		Name:   link.Name,
+		Liked:  len(tracks),
+		URL:    baseURL(r) + "/share/" + token,
+		Tracks: []widgetTrack{},
+	}
+	for _, t := range tracks[:min(limit, len(tracks))] {
+		likes.Tracks = append(likes.Tracks, widgetTrack{
+			Name:    t.Name,
+			Artist:  t.ArtistNames(),
+			Album:   t.Album,
+			URL:     t.URL(),
+			Image:   cmp.Or(t.AlbumImage, t.CoverImage),
+			LikedAt: t.AddedAt,
+		})
+	}
+	body, err := json.Marshal(likes)
+	if err != nil {
+		http.Error(w, "Failed to encode likes", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(widgetMaxAge.Seconds()))+", stale-while-revalidate=86400")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -5,6 +5,9 @@ go 1.24.0
 toolchain go1.24.10
 
 require (
-	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/oauth2 v0.33.0 // indirect
+	github.com/hajimehoshi/go-mp3 v0.3.4
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/oauth2 v0.33.0
+	golang.org/x/sync v0.16.0
+	golang.org/x/text v0.27.0
 )
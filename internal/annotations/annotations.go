@@ -0,0 +1,234 @@
+// Package annotations stores what users attach to tracks on top of what Spotify knows
+// about them, like free-text notes and star ratings. Annotations are kept per user and, when a data
+// directory is configured, persisted there.
+package annotations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+)
+
+// MaxNoteLength is the longest note in characters
+const MaxNoteLength = 2000
+
+// ErrNoteTooLong is returned when a note exceeds MaxNoteLength
+var ErrNoteTooLong = fmt.Errorf("note is longer than %d characters", MaxNoteLength)
+
+// MaxRating is the best star rating; ratings go from 1 to MaxRating
+const MaxRating = 5
+
+// ErrInvalidRating is returned for ratings outside 1..MaxRating
+var ErrInvalidRating = fmt.Errorf("rating must be between 1 and %d", MaxRating)
+
+// Note is a free-text note on a track, e.g. "heard this at Jana's wedding"
+type Note struct {
+	Text      string    `json:"text"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// userAnnotations is everything one user attached to tracks, keyed by bare track ID
+type userAnnotations struct {
+	Notes   map[string]Note `json:"notes"`
+	Ratings map[string]int  `json:"ratings"` // stars, 1..MaxRating
+}
+
+var (
+	mu    sync.Mutex
+	users = make(map[string]*userAnnotations) // keyed by Spotify user ID
+
+	// storeDir is where annotations are persisted, one JSON file per user.
+	// Empty while running in memory only.
+	storeDir string
+)
+
+// annotationsFor returns the user's annotations, creating empty ones if needed. mu must be held.
+func annotationsFor(userID string) *userAnnotations {
+	a, ok := users[userID]
+	if !ok {
+		a = &userAnnotations{
+			Notes:   make(map[string]Note),
+			Ratings: make(map[string]int),
+		}
+		users[userID] = a
+	}
+	return a
+}
+
+// Load restores all persisted annotations from the data directory and saves every
+// change there from now on.
+func Load(dir string) error {
+	dir = filepath.Join(dir, "annotations")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create annotations directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list annotations: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	storeDir = dir
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		userID, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read annotations: %w", err)
+		}
+		var a userAnnotations
+		if err := json.Unmarshal(data, &a); err != nil {
+			// Unlike the library cache these can't be fetched again, so refuse to start
+			// rather than overwrite the file on the next change
+			return fmt.Errorf("failed to parse annotations of %s: %w", userID, err)
+		}
+		if a.Notes == nil {
+			a.Notes = make(map[string]Note)
+		}
+		if a.Ratings == nil {
+			a.Ratings = make(map[string]int)
+		}
+		users[userID] = &a
+	}
+	slog.Info("annotations loaded", "dir", dir, "users", len(users))
+	return nil
+}
+
+// save writes the user's annotations to the data directory, if there is one. mu must be held.
+func save(userID string) error {
+	if storeDir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(annotationsFor(userID))
+	if err != nil {
+		return fmt.Errorf("failed to encode annotations: %w", err)
+	}
+
+	path := filepath.Join(storeDir, url.PathEscape(userID)+".json")
+	if err := fileutil.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write annotations: %w", err)
+	}
+	return nil
+}
+
+// FindNote returns the user's note on a track.
+func FindNote(userID, trackID string) (Note, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	note, ok := annotationsFor(userID).Notes[trackID]
+	return note, ok
+}
+
+// Notes returns all of the user's notes keyed by bare track ID.
+func Notes(userID string) map[string]Note {
+	mu.Lock()
+	defer mu.Unlock()
+	return maps.Clone(annotationsFor(userID).Notes)
+}
+
+// SetNote creates or replaces the user's note on a track. An empty text deletes the note.
+func SetNote(userID, trackID, text string) (Note, error) {
+	text = strings.TrimSpace(text)
+	if len([]rune(text)) > MaxNoteLength {
+		return Note{}, ErrNoteTooLong
+	}
+	if text == "" {
+		return Note{}, DeleteNote(userID, trackID)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	note := Note{Text: text, UpdatedAt: time.Now()}
+	annotationsFor(userID).Notes[trackID] = note
+	return note, save(userID)
+}
+
+// DeleteNote removes the user's note on a track. Deleting a missing note is not an error.
+func DeleteNote(userID, trackID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	notes := annotationsFor(userID).Notes
+	if _, ok := notes[trackID]; !ok {
+		return nil
+	}
+	delete(notes, trackID)
+	return save(userID)
+}
+
+// Ratings returns all of the user's star ratings keyed by bare track ID.
+func Ratings(userID string) map[string]int {
+	mu.Lock()
+	defer mu.Unlock()
+	return maps.Clone(annotationsFor(userID).Ratings)
+}
+
+// FindRating returns the user's star rating of a track, 0 if unrated.
+func FindRating(userID, trackID string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return annotationsFor(userID).Ratings[trackID]
+}
+
+// SetRating rates a track with 1 to MaxRating stars.
+func SetRating(userID, trackID string, stars int) error {
+	if stars < 1 || stars > MaxRating {
+		return ErrInvalidRating
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	annotationsFor(userID).Ratings[trackID] = stars
+	return save(userID)
+}
+
+// DeleteRating removes the user's rating of a track. Deleting a missing rating is not an error.
+func DeleteRating(userID, trackID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	ratings := annotationsFor(userID).Ratings
+	if _, ok := ratings[trackID]; !ok {
+		return nil
+	}
+	delete(ratings, trackID)
+	return save(userID)
+}
+
+// Delete forgets all annotations of the user, on disk too.
+func Delete(userID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(users, userID)
+	if storeDir == "" {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(storeDir, url.PathEscape(userID)+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete annotations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Package apitokens keeps the API tokens users create for home automation, like Home
+// Assistant, that can't sign in with Spotify in a browser. A user has at most one token;
+// creating a new one replaces it and revoking it locks the automation out. Only a hash
+// of each token is kept, the token itself is shown once when it is created. When a data
+// directory is configured tokens are persisted there, in a single file.
+package apitokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+)
+
+// prefix marks our tokens so they are recognizable in configuration files and logs
+const prefix = "bgr_"
+
+// Token is what is known about a user's API token
+type Token struct {
+	Hash      string    `json:"hash"` // SHA-256 of the token, hex encoded
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var (
+	mu     sync.Mutex
+	tokens = make(map[string]Token) // keyed by Spotify user ID
+
+	// storePath is the file tokens are persisted to, empty while running in memory only
+	storePath string
+)
+
+// Load restores the tokens from the data directory and saves every change there from now on.
+func Load(dir string) error {
+	path := filepath.Join(dir, "api-tokens.json")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read API tokens: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &tokens); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	storePath = path
+	return nil
+}
+
+// Get returns what is known about the user's token. It reports false if they have none.
+func Get(userID string) (Token, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	t, ok := tokens[userID]
+	return t, ok
+}
+
+// Create makes a new token for the user, replacing the one they had, and returns it. It
+// can't be looked up again later.
+func Create(userID string) (string, error) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	token := prefix + base64.RawURLEncoding.EncodeToString(b)
+
+	mu.Lock()
+	defer mu.Unlock()
+	tokens[userID] = Token{Hash: hash(token), CreatedAt: time.Now()}
+	return token, save()
+}
+
+// Lookup returns the user a token belongs to. It reports false for unknown tokens.
+func Lookup(token string) (string, bool) {
+	h := []byte(hash(token))
+
+	mu.Lock()
+	defer mu.Unlock()
+	for userID, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), h) == 1 {
+			return userID, true
+		}
+	}
+	return "", false
+}
+
+// Users lists the users who have a token, sorted
+func Users() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	users := make([]string, 0, len(tokens))
+	for userID := range tokens {
+		users = append(users, userID)
+	}
+	slices.Sort(users)
+	return users
+}
+
+// Delete revokes the user's token, if they have one.
+func Delete(userID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(tokens, userID)
+	return save()
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// save writes all tokens to the data directory, if there is one. mu must be held.
+func save() error {
+	if storePath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return fmt.Errorf("failed to encode API tokens: %w", err)
+	}
+
+	if err := fileutil.WriteFileAtomic(storePath, data); err != nil {
+		return fmt.Errorf("failed to write API tokens: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Package applemusic takes a library over to Apple Music, for users moving there or
+// keeping both. Songs are matched by ISRC, the code that identifies a recording on every
+// service, with title and artist as a fallback for the migration tools that read CSV.
+//
+// WriteCSV needs no account and produces the track list that Apple Music importers
+// (TuneMyMusic, SongShift, Soundiiz, ...) take. With an Apple developer token configured,
+// Client looks songs up in the Apple Music catalog and saves them as a playlist in the
+// user's library directly.
+package applemusic
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiBaseURL = "https://api.music.apple.com/v1"
+	// isrcBatchSize is how many ISRCs the catalog looks up per request
+	isrcBatchSize = 25
+)
+
+// ErrUnauthorized is returned when Apple Music refuses the developer or user token
+var ErrUnauthorized = errors.New("apple music refused the token")
+
+// Song is a track to take over
+type Song struct {
+	Title    string
+	Artist   string
+	Album    string
+	ISRC     string // empty if unknown, the song is then matched by title and artist
+	Duration time.Duration
+}
+
+// WriteCSV writes songs as a CSV track list with a header row
+func WriteCSV(w io.Writer, songs []Song) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Title", "Artist", "Album", "ISRC", "Duration"})
+	for _, s := range songs {
+		cw.Write([]string{
+			s.Title,
+			s.Artist,
+			s.Album,
+			s.ISRC,
+			strconv.FormatInt(int64(s.Duration.Seconds()), 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Client talks to the Apple Music API with a developer token, a JWT signed with a
+// MusicKit key from the Apple developer account
+type Client struct {
+	developerToken string
+	storefront     string // the catalog country, e.g. "us"
+	client         *http.Client
+}
+
+// NewClient returns a client that looks songs up in the catalog of storefront.
+func NewClient(developerToken, storefront string) *Client {
+	return &Client{
+		developerToken: developerToken,
+		storefront:     storefront,
+		client:         &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// DeveloperToken returns the token pages configure MusicKit JS with, which is meant to
+// be public. Users sign in there and hand the Music-User-Token CreatePlaylist needs.
+func (c *Client) DeveloperToken() string {
+	return c.developerToken
+}
+
+// CatalogIDs finds the catalog songs of ISRCs and returns their IDs by ISRC. ISRCs the
+// catalog doesn't have are left out.
+func (c *Client) CatalogIDs(ctx context.Context, isrcs []string) (map[string]string, error) {
+	ids := make(map[string]string, len(isrcs))
+	for start := 0; start < len(isrcs); start += isrcBatchSize {
+		batch := isrcs[start:min(start+isrcBatchSize, len(isrcs))]
+
+		var response struct {
+			Data []struct {
+				ID         string `json:"id"`
+				Attributes struct {
+					ISRC string `json:"isrc"`
+				} `json:"attributes"`
+			} `json:"data"`
+		}
+		endpoint := apiBaseURL + "/catalog/" + url.PathEscape(c.storefront) +
+			"/songs?filter[isrc]=" + url.QueryEscape(strings.Join(batch, ","))
+		if err := c.do(ctx, http.MethodGet, endpoint, "", nil, &response); err != nil {
+			return nil, fmt.Errorf("failed to look up songs: %w", err)
+		}
+		for _, song := range response.Data {
+			// The same recording may be in the catalog several times, e.g. on an album and
+			// a compilation, the first one will do
+			isrc := strings.ToUpper(song.Attributes.ISRC)
+			if _, ok := ids[isrc]; !ok {
+				ids[isrc] = song.ID
+			}
+		}
+	}
+	return ids, nil
+}
+
+// CreatePlaylist saves catalog songs, in order, as a new playlist in the library of the
+// user the Music-User-Token belongs to, and returns its ID.
+func (c *Client) CreatePlaylist(ctx context.Context, userToken, name, description string, songIDs []string) (string, error) {
+	type track struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	}
+	var body struct {
+		Attributes struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		} `json:"attributes"`
+		Relationships struct {
+			Tracks struct {
+				Data []track `json:"data"`
+			} `json:"tracks"`
+		} `json:"relationships"`
+	}
+	body.Attributes.Name = name
+	body.Attributes.Description = description
+	for _, id := range songIDs {
+		body.Relationships.Tracks.Data = append(body.Relationships.Tracks.Data, track{ID: id, Type: "songs"})
+	}
+
+	var response struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodPost, apiBaseURL+"/me/library/playlists", userToken, body, &response); err != nil {
+		return "", fmt.Errorf("failed to create playlist: %w", err)
+	}
+	if len(response.Data) == 0 {
+		return "", errors.New("failed to create playlist: empty response")
+	}
+	return response.Data[0].ID, nil
+}
+
+// do sends a request and decodes the JSON response into v. userToken is only sent when
+// not empty, for requests on the user's library.
+func (c *Client) do(ctx context.Context, method, endpoint, userToken string, body, v any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.developerToken)
+	if userToken != "" {
+		req.Header.Set("Music-User-Token", userToken)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to perform request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return ErrUnauthorized
+	}
+	if resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("apple music API returned %d: %s", resp.StatusCode, snippet)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
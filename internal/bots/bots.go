@@ -0,0 +1,253 @@
+// Package bots connects users to chat bots on Telegram and Discord. The bots send a
+// weekly digest of the songs liked that week and answer a few commands, like what is
+// playing or playing a mood, through the webhooks the services call.
+//
+// A user connects a chat by creating a link code in the settings and sending it to the
+// bot, which proves the chat is theirs. Connections are kept per user; when a data
+// directory is configured they are persisted there, in a single file.
+package bots
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+	"github.com/jendahorak/bangerid/internal/shortcode"
+)
+
+// codeTTL is how long a link code can be redeemed
+const codeTTL = 15 * time.Minute
+
+// Service is a chat service with a bot
+type Service string
+
+const (
+	Telegram Service = "telegram"
+	Discord  Service = "discord"
+)
+
+// Link is how a user is connected to the bots
+type Link struct {
+	TelegramChatID string `json:"telegram_chat_id,omitempty"` // the private chat with the bot
+	DiscordUserID  string `json:"discord_user_id,omitempty"`  // the bot messages them directly
+	// LastDigest is when the last weekly digest was sent, or the chat was connected
+	LastDigest time.Time `json:"last_digest"`
+}
+
+// connected reports whether any chat is connected
+func (l Link) connected() bool {
+	return l.TelegramChatID != "" || l.DiscordUserID != ""
+}
+
+// chat returns the chat connected on a service, empty if none
+func (l Link) chat(service Service) string {
+	if service == Telegram {
+		return l.TelegramChatID
+	}
+	return l.DiscordUserID
+}
+
+// setChat connects a chat on a service, or disconnects it when chat is empty
+func (l *Link) setChat(service Service, chat string) {
+	if service == Telegram {
+		l.TelegramChatID = chat
+	} else {
+		l.DiscordUserID = chat
+	}
+}
+
+// code is a link code waiting to be redeemed
+type code struct {
+	userID    string
+	expiresAt time.Time
+}
+
+var (
+	mu    sync.Mutex
+	links = make(map[string]Link) // keyed by Spotify user ID
+	codes = make(map[string]code) // keyed by the code, in memory only
+
+	// storePath is the file links are persisted to, empty while running in memory only
+	storePath string
+)
+
+// Load restores the links from the data directory and saves every change there from now on.
+func Load(dir string) error {
+	path := filepath.Join(dir, "bots.json")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read bot links: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &links); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	storePath = path
+	return nil
+}
+
+// Get returns how the user is connected. It reports false if no chat is.
+func Get(userID string) (Link, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	l, ok := links[userID]
+	return l, ok
+}
+
+// Users lists the users with a connected chat, sorted
+func Users() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	users := make([]string, 0, len(links))
+	for userID := range links {
+		users = append(users, userID)
+	}
+	slices.Sort(users)
+	return users
+}
+
+// NewCode returns a code the user can send to a bot to connect the chat, replacing the
+// one they got before
+func NewCode(userID string) string {
+	linkCode := shortcode.New(8)
+
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	for c, pending := range codes {
+		if pending.userID == userID || now.After(pending.expiresAt) {
+			delete(codes, c)
+		}
+	}
+	codes[linkCode] = code{userID: userID, expiresAt: now.Add(codeTTL)}
+	return linkCode
+}
+
+// Connect redeems a link code sent from a chat: a Telegram chat ID or a Discord user ID.
+// It returns the user the chat now belongs to, false if the code is unknown or expired.
+// A chat belongs to one user, connecting it again moves it.
+func Connect(service Service, chat, linkCode string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	linkCode = strings.ToUpper(strings.TrimSpace(linkCode))
+	pending, ok := codes[linkCode]
+	if !ok || time.Now().After(pending.expiresAt) {
+		return "", false
+	}
+	delete(codes, linkCode)
+
+	for userID, l := range links {
+		if l.chat(service) == chat {
+			l.setChat(service, "")
+			setLocked(userID, l)
+		}
+	}
+
+	l, ok := links[pending.userID]
+	if !ok {
+		// The first digest comes a week after connecting, not right away
+		l.LastDigest = time.Now()
+	}
+	l.setChat(service, chat)
+	setLocked(pending.userID, l)
+	if err := save(); err != nil {
+		// Connected all the same, until a restart
+		slog.Error("failed to save bot link", slog.Any("error", err))
+	}
+	return pending.userID, true
+}
+
+// User returns the user a chat belongs to: a Telegram chat ID or a Discord user ID. It
+// reports false for chats nobody connected.
+func User(service Service, chat string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	for userID, l := range links {
+		if chat != "" && l.chat(service) == chat {
+			return userID, true
+		}
+	}
+	return "", false
+}
+
+// Disconnect removes the user's chat on a service, if one is connected.
+func Disconnect(userID string, service Service) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	l, ok := links[userID]
+	if !ok {
+		return nil
+	}
+	l.setChat(service, "")
+	setLocked(userID, l)
+	return save()
+}
+
+// DigestSent records that the user's weekly digest went out
+func DigestSent(userID string, at time.Time) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	l, ok := links[userID]
+	if !ok {
+		return nil
+	}
+	l.LastDigest = at
+	links[userID] = l
+	return save()
+}
+
+// Delete removes the user's links and pending codes.
+func Delete(userID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for c, pending := range codes {
+		if pending.userID == userID {
+			delete(codes, c)
+		}
+	}
+	delete(links, userID)
+	return save()
+}
+
+// setLocked stores a link, dropping it when no chat is left. mu must be held.
+func setLocked(userID string, l Link) {
+	if l.connected() {
+		links[userID] = l
+	} else {
+		delete(links, userID)
+	}
+}
+
+// save writes all links to the data directory, if there is one. mu must be held.
+func save() error {
+	if storePath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(links)
+	if err != nil {
+		return fmt.Errorf("failed to encode bot links: %w", err)
+	}
+
+	if err := fileutil.WriteFileAtomic(storePath, data); err != nil {
+		return fmt.Errorf("failed to write bot links: %w", err)
+	}
+	return nil
+}
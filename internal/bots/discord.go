@@ -0,0 +1,210 @@
+package bots
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const discordBaseURL = "https://discord.com/api/v10"
+
+// Interaction types Discord posts to the interactions endpoint
+const (
+	discordPing    = 1
+	discordCommand = 2
+)
+
+// DiscordBot answers slash commands posted to its interactions endpoint and messages users
+// directly, as one Discord application
+type DiscordBot struct {
+	appID     string
+	publicKey ed25519.PublicKey // interactions are signed with it
+	token     string            // the bot token, for registering commands and sending messages
+	client    *http.Client
+}
+
+// NewDiscordBot returns a bot for the application ID, public key (hex, as the developer
+// portal shows it) and bot token. It fails on a malformed public key.
+func NewDiscordBot(appID, publicKey, token string) (*DiscordBot, error) {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Discord public key, expected %d hex encoded bytes", ed25519.PublicKeySize)
+	}
+	return &DiscordBot{appID: appID, publicKey: key, token: token, client: &http.Client{Timeout: 15 * time.Second}}, nil
+}
+
+// DiscordCommand is a slash command, with at most one option
+type DiscordCommand struct {
+	Name        string
+	Description string
+	Option      string   // the option's name, empty for none. It is required when there is one.
+	OptionHelp  string   // the option's description
+	Choices     []string // what the option may be, any text if empty
+}
+
+// DiscordInteraction is a slash command a user ran
+type DiscordInteraction struct {
+	// Ping is set for Discord checking the endpoint, answer it with PongResponse
+	Ping    bool
+	UserID  string // who ran the command
+	Command string
+	Option  string // the value of the command's option, empty if it has none
+}
+
+// ReadInteraction reads an interaction posted to the endpoint, checking Discord's
+// signature. Discord removes endpoints that accept unsigned calls.
+func (b *DiscordBot) ReadInteraction(r *http.Request) (*DiscordInteraction, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read interaction: %w", err)
+	}
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, ErrUnverified
+	}
+	message := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	if !ed25519.Verify(b.publicKey, message, signature) {
+		return nil, ErrUnverified
+	}
+
+	var raw struct {
+		Type int `json:"type"`
+		Data struct {
+			Name    string `json:"name"`
+			Options []struct {
+				Value any `json:"value"`
+			} `json:"options"`
+		} `json:"data"`
+		// Member is set in servers, User in direct messages
+		Member *struct {
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"member"`
+		User *struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse interaction: %w", err)
+	}
+
+	i := &DiscordInteraction{Ping: raw.Type == discordPing, Command: raw.Data.Name}
+	if raw.Type != discordPing && raw.Type != discordCommand {
+		return nil, nil
+	}
+	switch {
+	case raw.Member != nil:
+		i.UserID = raw.Member.User.ID
+	case raw.User != nil:
+		i.UserID = raw.User.ID
+	}
+	if len(raw.Data.Options) > 0 {
+		i.Option = fmt.Sprint(raw.Data.Options[0].Value)
+	}
+	return i, nil
+}
+
+// PongResponse is the answer to a ping
+func PongResponse() any {
+	return map[string]int{"type": discordPing}
+}
+
+// MessageResponse is the answer to a command, a message only the user who ran it sees
+func MessageResponse(text string) any {
+	return map[string]any{
+		"type": 4, // CHANNEL_MESSAGE_WITH_SOURCE
+		"data": map[string]any{"content": text, "flags": 64 /* EPHEMERAL */},
+	}
+}
+
+// RegisterCommands replaces the application's global slash commands
+func (b *DiscordBot) RegisterCommands(ctx context.Context, commands []DiscordCommand) error {
+	type choice struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type option struct {
+		Type        int      `json:"type"` // 3 is a string
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Required    bool     `json:"required"`
+		Choices     []choice `json:"choices,omitempty"`
+	}
+	type command struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Options     []option `json:"options,omitempty"`
+	}
+
+	body := make([]command, len(commands))
+	for i, c := range commands {
+		body[i] = command{Name: c.Name, Description: c.Description}
+		if c.Option != "" {
+			o := option{Type: 3, Name: c.Option, Description: c.OptionHelp, Required: true}
+			for _, value := range c.Choices {
+				o.Choices = append(o.Choices, choice{Name: value, Value: value})
+			}
+			body[i].Options = []option{o}
+		}
+	}
+	if err := b.do(ctx, http.MethodPut, "/applications/"+url.PathEscape(b.appID)+"/commands", body, nil); err != nil {
+		return fmt.Errorf("failed to register Discord commands: %w", err)
+	}
+	return nil
+}
+
+// SendDirectMessage messages a user directly. They need to share a server with the bot
+// or have it installed.
+func (b *DiscordBot) SendDirectMessage(ctx context.Context, userID, text string) error {
+	var channel struct {
+		ID string `json:"id"`
+	}
+	if err := b.do(ctx, http.MethodPost, "/users/@me/channels", map[string]string{"recipient_id": userID}, &channel); err != nil {
+		return fmt.Errorf("failed to open Discord direct message: %w", err)
+	}
+	if err := b.do(ctx, http.MethodPost, "/channels/"+url.PathEscape(channel.ID)+"/messages", map[string]string{"content": text}, nil); err != nil {
+		return fmt.Errorf("failed to send Discord message: %w", err)
+	}
+	return nil
+}
+
+// do sends a request to the Discord API as the bot and decodes the response into v, if
+// given
+func (b *DiscordBot) do(ctx context.Context, method, path string, body, v any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, discordBaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bot "+b.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to perform request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, data)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
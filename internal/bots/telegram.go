@@ -0,0 +1,137 @@
+package bots
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const telegramBaseURL = "https://api.telegram.org"
+
+// ErrUnverified is returned for webhook calls that don't prove they come from the service
+var ErrUnverified = errors.New("webhook call isn't signed by the service")
+
+// maxWebhookBytes bounds webhook bodies, updates and interactions are a few KB
+const maxWebhookBytes = 1 << 20
+
+// TelegramBot talks to the Telegram Bot API as one bot
+type TelegramBot struct {
+	token  string // from @BotFather
+	secret string // Telegram sends it with every webhook call, so they can be told apart
+	client *http.Client
+}
+
+// NewTelegramBot returns a bot for the token @BotFather gave. secret is what the webhook is
+// registered with and calls are checked against.
+func NewTelegramBot(token, secret string) *TelegramBot {
+	return &TelegramBot{token: token, secret: secret, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// TelegramMessage is a text message sent to the bot
+type TelegramMessage struct {
+	ChatID string // the chat to answer in
+	// Private is set for the one-on-one chat with the bot, the only ones it connects
+	Private bool
+	Command string // e.g. "start" for "/start ABC" or "/start@bangeridbot ABC", empty for plain text
+	Args    string // the rest of the text, "ABC" for the above
+}
+
+// ReadUpdate reads the message of a webhook call, checking the secret token it was
+// registered with. It returns nil for updates that aren't a text message.
+func (b *TelegramBot) ReadUpdate(r *http.Request) (*TelegramMessage, error) {
+	got := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(b.secret)) != 1 {
+		return nil, ErrUnverified
+	}
+
+	var update struct {
+		Message *struct {
+			Chat struct {
+				ID   int64  `json:"id"`
+				Type string `json:"type"`
+			} `json:"chat"`
+			Text string `json:"text"`
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxWebhookBytes)).Decode(&update); err != nil {
+		return nil, fmt.Errorf("failed to parse update: %w", err)
+	}
+	if update.Message == nil || update.Message.Text == "" {
+		return nil, nil
+	}
+
+	m := &TelegramMessage{
+		ChatID:  strconv.FormatInt(update.Message.Chat.ID, 10),
+		Private: update.Message.Chat.Type == "private",
+		Args:    strings.TrimSpace(update.Message.Text),
+	}
+	if rest, ok := strings.CutPrefix(m.Args, "/"); ok {
+		command, args, _ := strings.Cut(rest, " ")
+		command, _, _ = strings.Cut(command, "@")
+		m.Command, m.Args = strings.ToLower(command), strings.TrimSpace(args)
+	}
+	return m, nil
+}
+
+// SendMessage sends a plain text message to a chat
+func (b *TelegramBot) SendMessage(ctx context.Context, chatID, text string) error {
+	body := map[string]any{"chat_id": chatID, "text": text, "disable_web_page_preview": true}
+	if err := b.call(ctx, "sendMessage", body); err != nil {
+		return fmt.Errorf("failed to send Telegram message: %w", err)
+	}
+	return nil
+}
+
+// SetWebhook tells Telegram to deliver the bot's updates to webhookURL, with the secret
+func (b *TelegramBot) SetWebhook(ctx context.Context, webhookURL string) error {
+	body := map[string]any{"url": webhookURL, "secret_token": b.secret, "allowed_updates": []string{"message"}}
+	if err := b.call(ctx, "setWebhook", body); err != nil {
+		return fmt.Errorf("failed to set Telegram webhook: %w", err)
+	}
+	return nil
+}
+
+// call invokes a Bot API method
+func (b *TelegramBot) call(ctx context.Context, method string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramBaseURL+"/bot"+b.token+"/"+method, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		// The URL has the token in it, don't let it end up in the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to perform request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to parse response: status %d", resp.StatusCode)
+	}
+	if !result.OK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, result.Description)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Package collation compares names the way speakers of a language expect, so that
+// "Čechomor" sorts between "C" and "D" for Czech users and "Ørsted" after "Z" for Danes,
+// instead of somewhere after the end of the alphabet as byte comparison puts them.
+package collation
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// Language is a language names can be sorted by
+type Language struct {
+	Tag  string // BCP 47 tag, e.g. "cs"
+	Name string // name of the language in itself, e.g. "čeština"
+}
+
+// matcher picks the closest language we have collation rules for
+var matcher = language.NewMatcher(append([]language.Tag{language.English}, collate.Supported()...))
+
+// New returns a collator for the preferred language, falling back to the browser's
+// Accept-Language header when there is no preference. Collators are not safe for
+// concurrent use, so create one per request.
+func New(preference, acceptLanguage string) *collate.Collator {
+	tag, _ := language.MatchStrings(matcher, preference, acceptLanguage)
+	return collate.New(tag, collate.IgnoreCase, collate.Loose)
+}
+
+// Valid reports whether tag is empty (automatic) or a language we can sort by.
+func Valid(tag string) bool {
+	if tag == "" {
+		return true
+	}
+	return slices.ContainsFunc(Languages(), func(l Language) bool { return l.Tag == tag })
+}
+
+// Languages lists the languages with their own collation rules, sorted by tag.
+func Languages() []Language {
+	var languages []Language
+	seen := make(map[string]bool)
+	for _, tag := range collate.Supported() {
+		base, _ := tag.Base()
+		if seen[base.String()] {
+			continue // regional variants would just repeat the language
+		}
+		seen[base.String()] = true
+
+		name := display.Self.Name(base)
+		if name == "" {
+			continue
+		}
+		languages = append(languages, Language{Tag: base.String(), Name: name})
+	}
+	slices.SortFunc(languages, func(a, b Language) int { return strings.Compare(a.Tag, b.Tag) })
+	return languages
+}
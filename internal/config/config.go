@@ -0,0 +1,461 @@
+// Package config reads the server settings from environment variables.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpotifyApp is one set of Spotify app credentials. Each hostname the server answers on
+// can be registered as its own app in the Spotify dashboard.
+type SpotifyApp struct {
+	Host         string `json:"host"` // empty for the default app used by any other host
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectURL  string `json:"redirect_url"`
+}
+
+// Config holds everything the server can be tuned with.
+type Config struct {
+	Port string // address to listen on, e.g. ":3000"
+
+	// RequestTimeout bounds how long any request may take, including the Spotify calls made
+	// for it. RouteTimeouts overrides it per route path, e.g. ROUTE_TIMEOUTS="/grid=15s,/play=5s";
+	// zero disables the timeout for that route.
+	RequestTimeout time.Duration
+	RouteTimeouts  map[string]time.Duration
+
+	// DemoMode serves the sample library at DemoLibrary to every visitor, without logins,
+	// playback or anything that writes, so the project can be shown off publicly.
+	DemoMode    bool
+	DemoLibrary string
+
+	// ImageProxySecret signs image proxy URLs (IMAGE_PROXY_SECRET). When unset a random
+	// secret is generated at startup, which only means URLs don't survive restarts.
+	// Signed URLs stay valid for one to two ImageURLTTLs.
+	ImageProxySecret string
+	ImageURLTTL      time.Duration
+
+	// DataDir is where sessions and library caches are persisted so they survive restarts
+	// and the sync command can use them. Empty keeps everything in memory only.
+	DataDir string
+
+	// DefaultApp comes from CLIENT_ID, CLIENT_SECRET and REDIRECT_URL and serves any host
+	// that has no entry in Apps. It is nil when those variables are unset.
+	DefaultApp *SpotifyApp
+	// Apps are per-host credentials read from the JSON file named by SPOTIFY_APPS_FILE.
+	Apps []SpotifyApp
+
+	// Session lifetimes are sliding: every authenticated request pushes the expiry forward.
+	SessionTTL  time.Duration // how long a regular session survives without activity
+	RememberTTL time.Duration // the same for sessions created with "keep me signed in"
+
+	// SyncInterval is how often the library caches of active users are refreshed in the
+	// background. Zero disables background sync.
+	SyncInterval time.Duration
+	// JanitorInterval is how often expired sessions, cache entries and leftover files are
+	// cleaned up. Zero disables the janitor.
+	JanitorInterval time.Duration
+	// LibraryAudiobooks, LibraryShows and LibraryEpisodes add saved audiobooks, podcasts and
+	// podcast episodes as library sections. Episodes need an extra scope, so users sign in
+	// again once enabled.
+	LibraryAudiobooks bool
+	LibraryShows      bool
+	LibraryEpisodes   bool
+	// ActiveWindow is how recent a player page heartbeat must be for a user to count as
+	// active. Background work (sync, now-playing polling) skips everyone else.
+	ActiveWindow time.Duration
+
+	// NewTracksWindow is how far back a liked track counts as new for the "New this week" strip.
+	NewTracksWindow time.Duration
+	// A liked track is a forgotten favorite when it was liked more than ForgottenLikedAgo
+	// and hasn't been played for ForgottenUnplayedFor.
+	ForgottenLikedAgo    time.Duration
+	ForgottenUnplayedFor time.Duration
+
+	// AdminUserIDs are the Spotify user IDs allowed to open the admin page.
+	AdminUserIDs []string
+	// APIDailyBudget is the number of Spotify API calls per user per day the admin page
+	// measures usage against. Spotify doesn't publish quotas, so this is our own yardstick.
+	APIDailyBudget int
+
+	// Background concurrency, to tune small machines down and big ones up: JobWorkers run
+	// queued jobs like syncs, EnrichParallelism is how many batches of an enrichment are
+	// fetched at once, ImageWorkers how many images the image proxy downloads at once and
+	// WaveformWorkers how many previews are analyzed at once.
+	JobWorkers        int
+	EnrichParallelism int
+	ImageWorkers      int
+	WaveformWorkers   int
+
+	// CacheTTLs overrides how long cached Spotify catalog responses stay fresh, keyed by
+	// resource name, e.g. SPOTIFY_CACHE_TTLS="track=168h,artist=12h". Zero disables caching.
+	CacheTTLs map[string]time.Duration
+
+	// Free-text requests like "sad 90s bangers" are understood by a language model behind an
+	// OpenAI compatible chat completions API at LLMBaseURL. They are turned off unless
+	// LLMModel is set; LLMAPIKey may stay empty for local servers that don't need one.
+	LLMBaseURL string
+	LLMAPIKey  string
+	LLMModel   string
+
+	// Libraries can be saved as Apple Music playlists when AppleMusicDeveloperToken, a
+	// MusicKit JWT from the Apple developer account, is set. Songs are looked up in the
+	// catalog of AppleMusicStorefront, a country code.
+	AppleMusicDeveloperToken string
+	AppleMusicStorefront     string
+
+	// Home Assistant and other home automation reach the app with API tokens users create
+	// in the settings. When MQTTBroker is set, e.g. mqtt://homeassistant.local:1883, what
+	// those users play (checked every MQTTInterval) and their library syncs are also
+	// published there under MQTTTopicPrefix.
+	MQTTBroker      string
+	MQTTUsername    string
+	MQTTPassword    string
+	MQTTTopicPrefix string
+	MQTTInterval    time.Duration
+
+	// Chat bots send users who connect them a weekly digest of their new likes and answer
+	// commands. The Telegram bot needs the token from @BotFather and the public URL of
+	// /bots/telegram, which it registers as its webhook; TelegramBotUsername only makes
+	// the settings link to it. The Discord bot needs the application ID, public key and
+	// bot token from the developer portal, with /bots/discord as the interactions
+	// endpoint URL.
+	TelegramBotToken    string
+	TelegramBotUsername string
+	TelegramWebhookURL  string
+	DiscordAppID        string
+	DiscordPublicKey    string
+	DiscordBotToken     string
+
+	// Plays, likes and syncs are appended to a log of JSON lines, a file per day, in
+	// EventLogDir when it is set. With EventLogBucketURL, e.g.
+	// https://s3.eu-central-1.amazonaws.com/bucket/bangerid, the files are also uploaded to
+	// that S3 compatible bucket every EventLogUploadInterval, signed with the access keys
+	// for EventLogBucketRegion.
+	EventLogDir            string
+	EventLogBucketURL      string
+	EventLogBucketRegion   string
+	EventLogAccessKey      string
+	EventLogSecretKey      string
+	EventLogUploadInterval time.Duration
+
+	// Errors and panics are reported to the Sentry compatible service at ErrorReportDSN,
+	// if set. ErrorReportSampleRate (0 to 1) is the share of errors sent, panics always are.
+	ErrorReportDSN         string
+	ErrorReportSampleRate  float64
+	ErrorReportEnvironment string
+}
+
+// Load builds the config from the environment, falling back to defaults for unset values.
+func Load() (*Config, error) {
+	cfg := &Config{
+		Port:    getEnv("PORT", ":3000"),
+		DataDir: os.Getenv("DATA_DIR"),
+	}
+
+	if id := os.Getenv("CLIENT_ID"); id != "" {
+		cfg.DefaultApp = &SpotifyApp{
+			ClientID:     id,
+			ClientSecret: os.Getenv("CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("REDIRECT_URL"),
+		}
+	}
+
+	var err error
+	if cfg.DemoMode, err = getBool("DEMO_MODE"); err != nil {
+		return nil, err
+	}
+	cfg.DemoLibrary = getEnv("DEMO_LIBRARY", "web/demo/library.json")
+
+	if path := os.Getenv("SPOTIFY_APPS_FILE"); path != "" {
+		if cfg.Apps, err = loadApps(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// The demo never talks to Spotify on anyone's behalf, so it needs no app
+	if cfg.DefaultApp == nil && len(cfg.Apps) == 0 && !cfg.DemoMode {
+		return nil, fmt.Errorf("no Spotify app configured: set CLIENT_ID and CLIENT_SECRET or SPOTIFY_APPS_FILE")
+	}
+	if cfg.DefaultApp != nil && cfg.DefaultApp.ClientSecret == "" {
+		return nil, fmt.Errorf("CLIENT_SECRET must be set together with CLIENT_ID")
+	}
+
+	if cfg.RequestTimeout, err = getDuration("REQUEST_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	// Playback should feel instant or fail fast, the grid can take a while on a cold cache
+	cfg.RouteTimeouts = map[string]time.Duration{
+		"/grid": 15 * time.Second,
+		"/play": 5 * time.Second,
+		// Every imported favorite is a search
+		"/settings/import": 2 * time.Minute,
+	}
+	routeTimeouts, err := getDurationMap("ROUTE_TIMEOUTS")
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(cfg.RouteTimeouts, routeTimeouts)
+
+	cfg.ImageProxySecret = os.Getenv("IMAGE_PROXY_SECRET")
+	if cfg.ImageURLTTL, err = getDuration("IMAGE_URL_TTL", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.ImageURLTTL < time.Minute {
+		return nil, fmt.Errorf("IMAGE_URL_TTL must be at least 1m")
+	}
+
+	if cfg.SessionTTL, err = getDuration("SESSION_TTL", 12*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.RememberTTL, err = getDuration("SESSION_REMEMBER_TTL", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	if cfg.SyncInterval, err = getDuration("LIBRARY_SYNC_INTERVAL", 30*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.JanitorInterval, err = getDuration("JANITOR_INTERVAL", time.Hour); err != nil {
+		return nil, err
+	}
+
+	if cfg.LibraryAudiobooks, err = getBool("LIBRARY_AUDIOBOOKS"); err != nil {
+		return nil, err
+	}
+	if cfg.LibraryShows, err = getBool("LIBRARY_SHOWS"); err != nil {
+		return nil, err
+	}
+	if cfg.LibraryEpisodes, err = getBool("LIBRARY_EPISODES"); err != nil {
+		return nil, err
+	}
+
+	if cfg.ActiveWindow, err = getDuration("ACTIVE_WINDOW", 15*time.Minute); err != nil {
+		return nil, err
+	}
+
+	if cfg.NewTracksWindow, err = getDuration("NEW_TRACKS_WINDOW", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	if cfg.ForgottenLikedAgo, err = getDuration("FORGOTTEN_LIKED_AGO", 365*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.ForgottenUnplayedFor, err = getDuration("FORGOTTEN_UNPLAYED_FOR", 90*24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	cfg.AdminUserIDs = getList("ADMIN_USER_IDS")
+	if cfg.APIDailyBudget, err = getInt("API_DAILY_BUDGET", 10000); err != nil {
+		return nil, err
+	}
+
+	if cfg.CacheTTLs, err = getDurationMap("SPOTIFY_CACHE_TTLS"); err != nil {
+		return nil, err
+	}
+
+	cfg.LLMBaseURL = getEnv("LLM_BASE_URL", "https://api.openai.com/v1")
+	cfg.LLMAPIKey = os.Getenv("LLM_API_KEY")
+	cfg.LLMModel = os.Getenv("LLM_MODEL")
+
+	cfg.AppleMusicDeveloperToken = os.Getenv("APPLE_MUSIC_DEVELOPER_TOKEN")
+	cfg.AppleMusicStorefront = getEnv("APPLE_MUSIC_STOREFRONT", "us")
+
+	cfg.MQTTBroker = os.Getenv("MQTT_BROKER")
+	cfg.MQTTUsername = os.Getenv("MQTT_USERNAME")
+	cfg.MQTTPassword = os.Getenv("MQTT_PASSWORD")
+	cfg.MQTTTopicPrefix = strings.Trim(getEnv("MQTT_TOPIC_PREFIX", "bangerid"), "/")
+	if cfg.MQTTInterval, err = getDuration("MQTT_INTERVAL", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.MQTTBroker != "" && cfg.MQTTInterval <= 0 {
+		return nil, fmt.Errorf("MQTT_INTERVAL must be positive")
+	}
+
+	cfg.TelegramBotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
+	cfg.TelegramBotUsername = strings.TrimPrefix(os.Getenv("TELEGRAM_BOT_USERNAME"), "@")
+	cfg.TelegramWebhookURL = os.Getenv("TELEGRAM_WEBHOOK_URL")
+	if cfg.TelegramBotToken != "" && cfg.TelegramWebhookURL == "" {
+		return nil, fmt.Errorf("TELEGRAM_WEBHOOK_URL is required with TELEGRAM_BOT_TOKEN, e.g. https://example.com/bots/telegram")
+	}
+	cfg.DiscordAppID = os.Getenv("DISCORD_APPLICATION_ID")
+	cfg.DiscordPublicKey = os.Getenv("DISCORD_PUBLIC_KEY")
+	cfg.DiscordBotToken = os.Getenv("DISCORD_BOT_TOKEN")
+	if (cfg.DiscordAppID != "" || cfg.DiscordPublicKey != "" || cfg.DiscordBotToken != "") &&
+		(cfg.DiscordAppID == "" || cfg.DiscordPublicKey == "" || cfg.DiscordBotToken == "") {
+		return nil, fmt.Errorf("the Discord bot needs DISCORD_APPLICATION_ID, DISCORD_PUBLIC_KEY and DISCORD_BOT_TOKEN")
+	}
+
+	cfg.EventLogDir = os.Getenv("EVENT_LOG_DIR")
+	cfg.EventLogBucketURL = os.Getenv("EVENT_LOG_BUCKET_URL")
+	cfg.EventLogBucketRegion = getEnv("EVENT_LOG_BUCKET_REGION", "us-east-1")
+	cfg.EventLogAccessKey = os.Getenv("EVENT_LOG_ACCESS_KEY")
+	cfg.EventLogSecretKey = os.Getenv("EVENT_LOG_SECRET_KEY")
+	if cfg.EventLogUploadInterval, err = getDuration("EVENT_LOG_UPLOAD_INTERVAL", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.EventLogBucketURL != "" {
+		switch {
+		case cfg.EventLogDir == "":
+			return nil, fmt.Errorf("EVENT_LOG_BUCKET_URL needs EVENT_LOG_DIR, the log is written there before it is uploaded")
+		case cfg.EventLogAccessKey == "" || cfg.EventLogSecretKey == "":
+			return nil, fmt.Errorf("EVENT_LOG_BUCKET_URL needs EVENT_LOG_ACCESS_KEY and EVENT_LOG_SECRET_KEY")
+		case cfg.EventLogUploadInterval <= 0:
+			return nil, fmt.Errorf("EVENT_LOG_UPLOAD_INTERVAL must be positive")
+		}
+	}
+
+	cfg.ErrorReportDSN = os.Getenv("ERROR_REPORT_DSN")
+	cfg.ErrorReportEnvironment = os.Getenv("ERROR_REPORT_ENVIRONMENT")
+	if cfg.ErrorReportSampleRate, err = getFraction("ERROR_REPORT_SAMPLE_RATE", 1); err != nil {
+		return nil, err
+	}
+
+	if cfg.JobWorkers, err = getWorkers("JOB_WORKERS", 2); err != nil {
+		return nil, err
+	}
+	if cfg.EnrichParallelism, err = getWorkers("ENRICH_PARALLELISM", 2); err != nil {
+		return nil, err
+	}
+	if cfg.ImageWorkers, err = getWorkers("IMAGE_WORKERS", 8); err != nil {
+		return nil, err
+	}
+	if cfg.WaveformWorkers, err = getWorkers("WAVEFORM_WORKERS", 2); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadApps reads per-host Spotify app credentials from a JSON file shaped like
+// [{"host": "bangerid.example.com", "client_id": "...", "client_secret": "...", "redirect_url": "..."}].
+func loadApps(path string) ([]SpotifyApp, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SPOTIFY_APPS_FILE: %w", err)
+	}
+
+	var apps []SpotifyApp
+	if err := json.Unmarshal(data, &apps); err != nil {
+		return nil, fmt.Errorf("failed to parse SPOTIFY_APPS_FILE: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, app := range apps {
+		if app.Host == "" || app.ClientID == "" || app.ClientSecret == "" || app.RedirectURL == "" {
+			return nil, fmt.Errorf("SPOTIFY_APPS_FILE: every app needs host, client_id, client_secret and redirect_url")
+		}
+		if seen[app.Host] {
+			return nil, fmt.Errorf("SPOTIFY_APPS_FILE: host %q is configured twice", app.Host)
+		}
+		seen[app.Host] = true
+	}
+	return apps, nil
+}
+
+// getEnv returns the value of an environment variable or the fallback if it is unset.
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// getList parses a comma separated list from the environment, skipping empty entries.
+func getList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getBool parses a boolean like "1", "true" or "false" from the environment, false if unset.
+func getBool(key string) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: expected true or false", key, v)
+	}
+	return b, nil
+}
+
+// getInt parses a non-negative integer from the environment.
+func getInt(key string, fallback int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a non-negative number", key, v)
+	}
+	return n, nil
+}
+
+// getFraction parses a number from 0 to 1 from the environment.
+func getFraction(key string, fallback float64) (float64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f > 1 {
+		return 0, fmt.Errorf("invalid %s %q: expected a number from 0 to 1", key, v)
+	}
+	return f, nil
+}
+
+// getWorkers parses a concurrency setting from the environment, which must be at least 1.
+func getWorkers(key string, fallback int) (int, error) {
+	n, err := getInt(key, fallback)
+	if err == nil && n < 1 {
+		err = fmt.Errorf("invalid %s %d: expected at least 1", key, n)
+	}
+	return n, err
+}
+
+// getDuration parses a Go duration string (e.g. "90m", "720h") from the environment.
+func getDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a duration like 12h", key, v)
+	}
+	return d, nil
+}
+
+// getDurationMap parses a comma separated list of name=duration pairs from the environment.
+func getDurationMap(key string) (map[string]time.Duration, error) {
+	durations := make(map[string]time.Duration)
+	v := os.Getenv(key)
+	if v == "" {
+		return durations, nil
+	}
+
+	for _, pair := range strings.Split(v, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %s entry %q: expected name=duration", key, pair)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid %s entry %q: expected a duration like 24h", key, pair)
+		}
+		durations[name] = d
+	}
+	return durations, nil
+}
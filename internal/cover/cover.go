@@ -0,0 +1,109 @@
+// Package cover renders playlist cover images as a collage of album art.
+package cover
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" // Spotify serves some images as PNG
+	"io"
+	"net/http"
+	"time"
+)
+
+// Size is the width and height of a cover in pixels. Spotify recommends at least 300.
+const Size = 600
+
+const maxImageBytes = 2 << 20 // album art is ~100 KB, anything bigger isn't
+
+// Downloads get their own client so a slow CDN can't block an export forever
+var imageClient = &http.Client{Timeout: 10 * time.Second}
+
+// Collage downloads up to four album images and tiles them into one square JPEG no
+// larger than maxBytes. With fewer than four usable images the first one fills the
+// whole cover. Duplicate URLs should be removed by the caller.
+func Collage(ctx context.Context, imageURLs []string, maxBytes int) ([]byte, error) {
+	var images []image.Image
+	for _, imageURL := range imageURLs {
+		if len(images) == 4 {
+			break
+		}
+		img, err := download(ctx, imageURL)
+		if err != nil {
+			// A missing tile isn't worth failing the cover for, try the next album
+			continue
+		}
+		images = append(images, img)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no album images could be downloaded")
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, Size, Size))
+	if len(images) < 4 {
+		drawScaled(canvas, canvas.Bounds(), images[0])
+	} else {
+		half := Size / 2
+		for i, img := range images {
+			x, y := (i%2)*half, (i/2)*half
+			drawScaled(canvas, image.Rect(x, y, x+half, y+half), img)
+		}
+	}
+
+	// Lower the quality until the cover fits; album art compresses well so this rarely loops
+	for quality := 90; quality >= 30; quality -= 15 {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, canvas, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, fmt.Errorf("failed to encode cover: %w", err)
+		}
+		if buf.Len() <= maxBytes {
+			return buf.Bytes(), nil
+		}
+	}
+	return nil, fmt.Errorf("cover doesn't fit in %d bytes", maxBytes)
+}
+
+// download fetches and decodes one image
+func download(ctx context.Context, imageURL string) (image.Image, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image download error %d", resp.StatusCode)
+	}
+
+	img, _, err := image.Decode(io.LimitReader(resp.Body, maxImageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// drawScaled draws src into the dst rectangle with nearest neighbour scaling. Album art
+// is square and gets scaled down, where nearest neighbour looks fine.
+func drawScaled(dst *image.RGBA, rect image.Rectangle, src image.Image) {
+	sb := src.Bounds()
+	if sb.Dx() == rect.Dx() && sb.Dy() == rect.Dy() {
+		draw.Draw(dst, rect, src, sb.Min, draw.Src)
+		return
+	}
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		sy := sb.Min.Y + (y-rect.Min.Y)*sb.Dy()/rect.Dy()
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			sx := sb.Min.X + (x-rect.Min.X)*sb.Dx()/rect.Dx()
+			dst.Set(x, y, src.At(sx, sy))
+		}
+	}
+}
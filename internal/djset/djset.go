@@ -0,0 +1,88 @@
+// Package djset orders tracks into a DJ set with smooth tempo and key transitions.
+package djset
+
+import (
+	"math"
+
+	"github.com/jendahorak/bangerid/internal/harmony"
+)
+
+// MaxTracks bounds the selection size; ordering tries every start track so it is O(n³)
+const MaxTracks = 200
+
+// Track is what the set builder needs to know about a track.
+type Track struct {
+	ID    string
+	Tempo float64 // BPM, 0 if unknown
+	Key   harmony.Camelot
+}
+
+// How much one Camelot step weighs compared to one BPM of difference
+const keyStepCost = 4.0
+
+// transitionCost scores moving from a to b; lower is smoother
+func transitionCost(a, b Track) float64 {
+	return tempoDistance(a.Tempo, b.Tempo) + keyStepCost*float64(harmony.Distance(a.Key, b.Key))
+}
+
+// tempoDistance compares tempos, also accepting half/double time mixes (e.g. 85 into 170 BPM)
+func tempoDistance(a, b float64) float64 {
+	if a == 0 || b == 0 {
+		return 20 // unknown tempo, treat as a rough transition
+	}
+	best := math.Abs(a - b)
+	best = math.Min(best, math.Abs(a*2-b))
+	best = math.Min(best, math.Abs(a-b*2))
+	return best
+}
+
+// Order returns the tracks arranged to minimize tempo and key jumps between consecutive tracks.
+// It builds a greedy nearest-neighbor chain from every possible start and keeps the cheapest.
+func Order(tracks []Track) []Track {
+	if len(tracks) > MaxTracks {
+		tracks = tracks[:MaxTracks]
+	}
+	if len(tracks) < 3 {
+		return append([]Track(nil), tracks...)
+	}
+
+	var best []Track
+	bestCost := math.Inf(1)
+	for start := range tracks {
+		order, cost := chainFrom(tracks, start)
+		if cost < bestCost {
+			best, bestCost = order, cost
+		}
+	}
+	return best
+}
+
+// chainFrom builds a set starting at tracks[start], always continuing with the smoothest unused track
+func chainFrom(tracks []Track, start int) ([]Track, float64) {
+	used := make([]bool, len(tracks))
+	order := make([]Track, 0, len(tracks))
+
+	current := start
+	used[current] = true
+	order = append(order, tracks[current])
+	total := 0.0
+
+	for len(order) < len(tracks) {
+		next := -1
+		nextCost := math.Inf(1)
+		for i, t := range tracks {
+			if used[i] {
+				continue
+			}
+			if cost := transitionCost(tracks[current], t); cost < nextCost {
+				next, nextCost = i, cost
+			}
+		}
+
+		used[next] = true
+		order = append(order, tracks[next])
+		total += nextCost
+		current = next
+	}
+	return order, total
+}
@@ -0,0 +1,296 @@
+// Package errorreport sends errors and panics to a Sentry compatible service (Sentry
+// itself, GlitchTip, ...), so problems get noticed without anyone reading the logs.
+// It is optional: until Configure is called with a DSN, reporting does nothing.
+//
+// Reports are sent in the background and dropped rather than queued up when the service
+// is slow, a flood of errors must not slow down requests. Everything that looks like a
+// token or secret is scrubbed before it leaves the server.
+package errorreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Levels of a report, as Sentry names them
+const (
+	LevelFatal   = "fatal" // panics
+	LevelError   = "error"
+	LevelWarning = "warning"
+)
+
+const (
+	queueSize   = 100 // reports waiting to be sent before new ones are dropped
+	sendTimeout = 10 * time.Second
+	maxFrames   = 50
+)
+
+// Tags are indexed key/value pairs attached to a report, e.g. the route or job kind
+type Tags map[string]string
+
+// sink is where reports go once configured
+type sink struct {
+	endpoint    string // the envelope endpoint of the project
+	dsn         string
+	auth        string // X-Sentry-Auth header
+	sampleRate  float64
+	environment string
+	serverName  string
+	queue       chan []byte
+	client      *http.Client
+}
+
+var (
+	mu      sync.Mutex
+	current *sink // nil while reporting is off
+)
+
+// Configure turns reporting on for a DSN like https://<key>@sentry.example.com/<project>.
+// sampleRate (0 to 1) is the share of errors and warnings that is sent, panics are always
+// sent. environment names the deployment, e.g. "production", and may be empty.
+func Configure(dsn string, sampleRate float64, environment string) error {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return fmt.Errorf("invalid error reporting DSN: expected https://<key>@<host>/<project>")
+	}
+	path, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		path, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return fmt.Errorf("invalid error reporting DSN: no project ID")
+	}
+
+	hostname, _ := os.Hostname()
+	s := &sink{
+		endpoint:    u.Scheme + "://" + u.Host + path + "/api/" + project + "/envelope/",
+		dsn:         dsn,
+		auth:        "Sentry sentry_version=7, sentry_client=bangerid/1.0, sentry_key=" + u.User.Username(),
+		sampleRate:  min(max(sampleRate, 0), 1),
+		environment: environment,
+		serverName:  hostname,
+		queue:       make(chan []byte, queueSize),
+		client:      &http.Client{Timeout: sendTimeout},
+	}
+	go s.send()
+
+	mu.Lock()
+	current = s
+	mu.Unlock()
+	return nil
+}
+
+// Enabled reports whether reports are sent anywhere.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return current != nil
+}
+
+// Error reports an error, subject to sampling.
+func Error(err error, tags Tags) {
+	if err == nil {
+		return
+	}
+	report(LevelError, fmt.Sprintf("%T", err), err.Error(), callers(3), tags)
+}
+
+// Message reports something that isn't a Go error, like an error the browser reported,
+// subject to sampling.
+func Message(level, message string, tags Tags) {
+	report(level, "", message, nil, tags)
+}
+
+// Panic reports a recovered panic with the stack it happened on. Call it in the deferred
+// function that recovered.
+func Panic(recovered any, tags Tags) {
+	// The stack starts at the deferred function, the panic was raised a frame or two below
+	report(LevelFatal, "panic", fmt.Sprint(recovered), callers(3), tags)
+}
+
+// event is the part of Sentry's event payload we fill in
+type event struct {
+	EventID     string    `json:"event_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Level       string    `json:"level"`
+	Platform    string    `json:"platform"`
+	Environment string    `json:"environment,omitempty"`
+	ServerName  string    `json:"server_name,omitempty"`
+	Tags        Tags      `json:"tags,omitempty"`
+	Message     *struct {
+		Formatted string `json:"formatted"`
+	} `json:"message,omitempty"`
+	Exception *struct {
+		Values []exception `json:"values"`
+	} `json:"exception,omitempty"`
+}
+
+type exception struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Stacktrace *struct {
+		Frames []frame `json:"frames"`
+	} `json:"stacktrace,omitempty"`
+}
+
+type frame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// report builds an event and queues it for sending. Events with a type are exceptions,
+// the others plain messages.
+func report(level, typ, message string, frames []frame, tags Tags) {
+	mu.Lock()
+	s := current
+	mu.Unlock()
+	if s == nil {
+		return
+	}
+	if level != LevelFatal && rand.Float64() >= s.sampleRate {
+		return
+	}
+
+	e := event{
+		EventID:     fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64()),
+		Timestamp:   time.Now().UTC(),
+		Level:       level,
+		Platform:    "go",
+		Environment: s.environment,
+		ServerName:  s.serverName,
+		Tags:        make(Tags, len(tags)),
+	}
+	for k, v := range tags {
+		e.Tags[k] = Scrub(v)
+	}
+	message = Scrub(message)
+	if typ == "" {
+		e.Message = &struct {
+			Formatted string `json:"formatted"`
+		}{message}
+	} else {
+		ex := exception{Type: typ, Value: message}
+		if len(frames) > 0 {
+			ex.Stacktrace = &struct {
+				Frames []frame `json:"frames"`
+			}{frames}
+		}
+		e.Exception = &struct {
+			Values []exception `json:"values"`
+		}{[]exception{ex}}
+	}
+
+	envelope, err := s.envelope(e)
+	if err != nil {
+		slog.Warn("failed to encode error report", slog.Any("error", err))
+		return
+	}
+	select {
+	case s.queue <- envelope:
+	default:
+		// The service can't keep up, losing a report beats blocking the caller
+	}
+}
+
+// envelope wraps an event in Sentry's envelope format: a header line, an item header
+// line and the event itself
+func (s *sink) envelope(e event) ([]byte, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	header, _ := json.Marshal(map[string]any{"event_id": e.EventID, "dsn": s.dsn, "sent_at": e.Timestamp})
+	item, _ := json.Marshal(map[string]any{"type": "event", "length": len(payload)})
+	b.Write(header)
+	b.WriteByte('\n')
+	b.Write(item)
+	b.WriteByte('\n')
+	b.Write(payload)
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// send posts queued reports one at a time, forever
+func (s *sink) send() {
+	for envelope := range s.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(envelope))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-sentry-envelope")
+			req.Header.Set("X-Sentry-Auth", s.auth)
+			var resp *http.Response
+			if resp, err = s.client.Do(req); err == nil {
+				resp.Body.Close()
+				if resp.StatusCode >= 300 {
+					err = fmt.Errorf("status %d", resp.StatusCode)
+				}
+			}
+		}
+		cancel()
+		if err != nil {
+			// Not through Error, a broken sink would report its own failures forever
+			slog.Warn("failed to send error report", slog.Any("error", err))
+		}
+	}
+}
+
+// callers returns the stack of the caller skip frames up, outermost frame first as Sentry
+// wants them
+func callers(skip int) []frame {
+	pcs := make([]uintptr, maxFrames)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []frame
+	for {
+		f, more := frames.Next()
+		stack = append(stack, frame{
+			Function: f.Function,
+			Filename: f.File,
+			Lineno:   f.Line,
+			InApp:    strings.Contains(f.Function, "bangerid/"),
+		})
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return stack
+}
+
+// secrets match what must not leave the server: bearer tokens, OAuth parameters and
+// fields, and anything long and opaque enough to be a token (Spotify's are ~200 chars)
+var secrets = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(bearer\s+)[^\s"',]+`),
+	regexp.MustCompile(`(?i)((?:access_token|refresh_token|client_secret|code|token|secret|password)["']?\s*[:=]\s*["']?)[^\s"'&,]+`),
+	regexp.MustCompile(`[A-Za-z0-9_\-]{60,}`),
+}
+
+// Scrub replaces tokens and secrets in s with a placeholder.
+func Scrub(s string) string {
+	for _, re := range secrets {
+		if re.NumSubexp() > 0 {
+			s = re.ReplaceAllString(s, "${1}[scrubbed]")
+		} else {
+			s = re.ReplaceAllString(s, "[scrubbed]")
+		}
+	}
+	return s
+}
@@ -0,0 +1,252 @@
+// Package eventlog appends what users do, plays, likes and syncs, to a log of JSON lines
+// with one file per day (UTC), for people who want the raw feed to analyze with their
+// own tools. Files only grow, unless a user's data is deleted.
+package eventlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+)
+
+// Event types
+const (
+	Play   = "play"
+	Like   = "like"
+	Unlike = "unlike"
+	Sync   = "sync"
+)
+
+// Where a like or unlike happened
+const (
+	SourceApp     = "bangerid"
+	SourceSpotify = "spotify" // anywhere else, noticed by a sync
+)
+
+// Event is one line of the log
+type Event struct {
+	Time   time.Time `json:"time"` // when it happened, for plays when Spotify says it ended
+	Type   string    `json:"type"`
+	User   string    `json:"user"`            // Spotify user ID
+	Track  string    `json:"track,omitempty"` // Spotify URI
+	Name   string    `json:"name,omitempty"`
+	Artist string    `json:"artist,omitempty"`
+	Source string    `json:"source,omitempty"` // likes and unlikes
+
+	// Syncs only
+	Section string `json:"section,omitempty"`
+	Total   int    `json:"total,omitempty"` // items in the section after the sync
+	Added   int    `json:"added,omitempty"`
+	Removed int    `json:"removed,omitempty"`
+}
+
+// playsFile keeps the newest play recorded per user, see RecordPlays
+const playsFile = ".plays.json"
+
+var (
+	mu sync.Mutex
+	// logDir is where the day files are written. Empty while the log is off.
+	logDir   string
+	lastPlay = make(map[string]time.Time) // user ID -> newest play recorded
+)
+
+// Open turns the log on, writing to dir from now on
+func Open(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create event log directory: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	data, err := os.ReadFile(filepath.Join(dir, playsFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read event log: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &lastPlay); err != nil {
+			// Only costs logging the last 50 plays of everyone again
+			slog.Warn("ignoring recorded plays", slog.Any("error", err))
+		}
+	}
+	logDir = dir
+	return nil
+}
+
+// Enabled reports whether the log is on, so callers can skip work only it needs
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return logDir != ""
+}
+
+// Record appends events to the file of the day each happened on. It does nothing while
+// the log is off.
+func Record(events ...Event) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return record(events)
+}
+
+// RecordPlays appends the user's plays that are newer than the newest one recorded, so
+// the same list of recent plays Spotify hands out can be passed in again and again
+func RecordPlays(userID string, plays []Event) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if logDir == "" {
+		return nil
+	}
+
+	newest := lastPlay[userID]
+	var events []Event
+	for _, p := range plays {
+		if p.Time.After(lastPlay[userID]) {
+			p.Type, p.User = Play, userID
+			events = append(events, p)
+			if p.Time.After(newest) {
+				newest = p.Time
+			}
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	// Oldest first, the way they happened
+	slices.SortFunc(events, func(a, b Event) int { return a.Time.Compare(b.Time) })
+	if err := record(events); err != nil {
+		return err
+	}
+	lastPlay[userID] = newest
+	return savePlays()
+}
+
+// record is Record. mu must be held.
+func record(events []Event) error {
+	if logDir == "" || len(events) == 0 {
+		return nil
+	}
+
+	// Plays from Spotify's history can be from yesterday, group by day
+	lines := make(map[string][]byte)
+	var days []string
+	for _, e := range events {
+		if e.Time.IsZero() {
+			e.Time = time.Now()
+		}
+		e.Time = e.Time.UTC()
+		line, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		name := fileName(e.Time)
+		if _, ok := lines[name]; !ok {
+			days = append(days, name)
+		}
+		lines[name] = append(append(lines[name], line...), '\n')
+	}
+
+	for _, name := range days {
+		if err := appendFile(filepath.Join(logDir, name), lines[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// savePlays writes the newest play recorded per user. mu must be held.
+func savePlays() error {
+	data, err := json.Marshal(lastPlay)
+	if err != nil {
+		return fmt.Errorf("failed to encode recorded plays: %w", err)
+	}
+	return writeFile(filepath.Join(logDir, playsFile), data)
+}
+
+// Delete removes the user's events from every day file
+func Delete(userID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if logDir == "" {
+		return nil
+	}
+
+	if _, ok := lastPlay[userID]; ok {
+		delete(lastPlay, userID)
+		if err := savePlays(); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(logDir)
+	if err != nil {
+		return fmt.Errorf("failed to list event log: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jsonl") {
+			continue
+		}
+		path := filepath.Join(logDir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read event log: %w", err)
+		}
+
+		var kept bytes.Buffer
+		removed := false
+		for line := range bytes.Lines(data) {
+			var e struct {
+				User string `json:"user"`
+			}
+			if json.Unmarshal(line, &e) == nil && e.User == userID {
+				removed = true
+				continue
+			}
+			kept.Write(line)
+		}
+		if removed {
+			if err := writeFile(path, kept.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFile replaces a file, through a temporary one so a crash never leaves a truncated
+// file behind
+func writeFile(path string, data []byte) error {
+	if err := fileutil.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write event log: %w", err)
+	}
+	return nil
+}
+
+// appendFile adds data to the end of a day file, creating it if needed. mu must be held.
+func appendFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write event log: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write event log: %w", err)
+	}
+	return nil
+}
+
+// fileName is the name of the file of the day t is on, e.g. 2026-10-14.jsonl
+func fileName(t time.Time) string {
+	return t.UTC().Format(time.DateOnly) + ".jsonl"
+}
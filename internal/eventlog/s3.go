@@ -0,0 +1,211 @@
+package eventlog
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+)
+
+// uploadedFile remembers which day files are in the bucket, and how big they were then
+const uploadedFile = ".uploaded.json"
+
+// Uploader copies the day files to an S3 compatible bucket (AWS, MinIO, R2, B2 and the
+// like), so the log lives where analysis tools read from. A file is uploaded again
+// whenever it grew, objects can't be appended to.
+type Uploader struct {
+	base      *url.URL // the bucket, path style, and an optional key prefix
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// NewUploader returns an uploader to the bucket at rawURL, path style, e.g.
+// https://s3.eu-central-1.amazonaws.com/my-bucket/bangerid, signing requests with the
+// keys for the region
+func NewUploader(rawURL, region, accessKey, secretKey string) (*Uploader, error) {
+	base, err := url.Parse(strings.TrimSuffix(rawURL, "/"))
+	if err != nil || (base.Scheme != "https" && base.Scheme != "http") || base.Host == "" || strings.Trim(base.Path, "/") == "" {
+		return nil, fmt.Errorf("invalid bucket URL %q, expected e.g. https://s3.amazonaws.com/bucket/prefix", rawURL)
+	}
+	return &Uploader{
+		base:      base,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: 2 * time.Minute},
+	}, nil
+}
+
+// Run uploads the changed day files every interval until ctx is done
+func (u *Uploader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := u.Upload(ctx); err != nil {
+			slog.Warn("failed to upload event log", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Upload puts the day files that changed since their last upload into the bucket
+func (u *Uploader) Upload(ctx context.Context) error {
+	mu.Lock()
+	dir := logDir
+	mu.Unlock()
+	if dir == "" {
+		return nil
+	}
+
+	uploaded := make(map[string]int64)
+	if data, err := os.ReadFile(filepath.Join(dir, uploadedFile)); err == nil {
+		if err := json.Unmarshal(data, &uploaded); err != nil {
+			// Only costs uploading everything again
+			slog.Warn("ignoring event log upload state", slog.Any("error", err))
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list event log: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".jsonl") && !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+
+	changed := false
+	defer func() {
+		if changed {
+			saveUploaded(dir, uploaded)
+		}
+	}()
+	for _, name := range names {
+		// Read under the lock so a line being appended isn't cut in half
+		mu.Lock()
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to read event log: %w", err)
+		}
+		if uploaded[name] == int64(len(data)) {
+			continue
+		}
+		if err := u.put(ctx, name, data); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+		uploaded[name] = int64(len(data))
+		changed = true
+	}
+	return nil
+}
+
+// saveUploaded writes which day files are in the bucket
+func saveUploaded(dir string, uploaded map[string]int64) {
+	data, _ := json.Marshal(uploaded)
+	path := filepath.Join(dir, uploadedFile)
+	if err := fileutil.WriteFileAtomic(path, data); err != nil {
+		slog.Warn("failed to save event log upload state", slog.Any("error", err))
+	}
+}
+
+// put stores an object under the bucket URL's prefix
+func (u *Uploader) put(ctx context.Context, name string, data []byte) error {
+	target := *u.base
+	target.Path += "/" + name
+	target.RawPath = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.String(), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	sum := sha256.Sum256(data)
+	u.sign(req, hex.EncodeToString(sum[:]), time.Now())
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to perform request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// sign adds AWS Signature Version 4 headers to req, covering every header it has.
+// payloadHash is the hex SHA-256 of the body.
+func (u *Uploader) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := slices.Sorted(maps.Keys(headers))
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + u.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+u.secretKey), day)
+	key = hmacSHA256(key, u.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+u.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,48 @@
+// Package fileutil has what the stores persisting to the data directory share.
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteFileAtomic replaces the file at path with data, readable by the server's user
+// only: stores hold tokens and listening habits. It writes to a temporary file next to
+// it first and renames that into place, so a crash never leaves a truncated file behind.
+// Every write gets its own temporary file, writes of the same file may overlap.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// IsTemp reports whether the file name is one of WriteFileAtomic's temporary files, which
+// a crash between writing and renaming leaves behind
+func IsTemp(name string) bool {
+	rest, ok := strings.CutPrefix(name, ".")
+	if !ok {
+		return false
+	}
+	i := strings.LastIndexByte(rest, '-')
+	if i <= 0 || i == len(rest)-1 {
+		return false
+	}
+	for _, c := range rest[i+1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// OAuthApps selects the Spotify app credentials for a request by its Host header,
+// so one server can answer on several hostnames that are each registered as their
+// own Spotify app (every app has its own allowed redirect URL).
+type OAuthApps struct {
+	byHost   map[string]*oauth2.Config
+	fallback *oauth2.Config // used for hosts without their own app; may be nil
+}
+
+// NewOAuthApps creates the app registry. byHost is keyed by hostname without port.
+func NewOAuthApps(fallback *oauth2.Config, byHost map[string]*oauth2.Config) *OAuthApps {
+	normalized := make(map[string]*oauth2.Config, len(byHost))
+	for host, config := range byHost {
+		normalized[normalizeHost(host)] = config
+	}
+	return &OAuthApps{byHost: normalized, fallback: fallback}
+}
+
+// ForRequest returns the OAuth config for the host the request was sent to.
+// It reports false if the host has no app and there is no default app.
+func (a *OAuthApps) ForRequest(r *http.Request) (*oauth2.Config, bool) {
+	return a.ForHost(r.Host)
+}
+
+// ForHost returns the OAuth config for a host, for work done outside of a request.
+func (a *OAuthApps) ForHost(host string) (*oauth2.Config, bool) {
+	if config, ok := a.byHost[normalizeHost(host)]; ok {
+		return config, true
+	}
+	return a.fallback, a.fallback != nil
+}
+
+// normalizeHost lowercases a host and strips the port, if any.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// unknownHost is the response for requests to a host no Spotify app is configured for.
+func unknownHost(w http.ResponseWriter) {
+	http.Error(w, "This host is not configured", http.StatusMisdirectedRequest)
+}
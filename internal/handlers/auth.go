@@ -3,19 +3,30 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
-	"golang.org/x/oauth2"
+	"github.com/jendahorak/bangerid/internal/spotify"
 )
 
+// pendingLogin is what we remember about a login between /login and the callback.
+type pendingLogin struct {
+	createdAt time.Time
+	returnTo  string // local path to send the user back to after the callback
+	remember  bool   // "keep me signed in" was ticked on the login form
+}
+
 // In-memory storage for state tokens. In production, use Redis or signed JWTs.
 // The state only needs to live for ~60 seconds (the OAuth round-trip time).
 var (
 	stateMu    sync.Mutex
-	stateStore = make(map[string]time.Time)
+	stateStore = make(map[string]pendingLogin)
 )
 
 // generateState creates a cryptographically secure random string for CSRF protection.
@@ -33,17 +44,37 @@ func cleanupExpiredStates() {
 	defer stateMu.Unlock()
 
 	cutoff := time.Now().Add(-2 * time.Minute)
-	for state, createdAt := range stateStore {
-		if createdAt.Before(cutoff) {
+	for state, pending := range stateStore {
+		if pending.createdAt.Before(cutoff) {
 			delete(stateStore, state)
 		}
 	}
 }
 
+// safeReturnTo only accepts local paths so the return_to parameter can't be
+// used as an open redirect to another site. Anything else falls back to "/".
+func safeReturnTo(raw string) string {
+	if raw == "" || !strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "//") || strings.HasPrefix(raw, "/\\") {
+		return "/"
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "/"
+	}
+	return u.RequestURI()
+}
+
 // LoginHandler redirects the user to Spotify's authorization page.
-// This is where the OAuth flow begins.
-func LoginHandler(oauthConfig *oauth2.Config) http.HandlerFunc {
+// This is where the OAuth flow begins. An optional return_to query parameter
+// names the local page to come back to once the callback has stored the tokens.
+func LoginHandler(apps *OAuthApps) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		oauthConfig, ok := apps.ForRequest(r)
+		if !ok {
+			unknownHost(w)
+			return
+		}
+
 		// Generate a random state token to protect against CSRF attacks
 		state, err := generateState()
 		if err != nil {
@@ -51,9 +82,14 @@ func LoginHandler(oauthConfig *oauth2.Config) http.HandlerFunc {
 			return
 		}
 
-		// Store the state with its creation time so we can validate it in the callback
+		// Store the state with its creation time so we can validate it in the callback,
+		// together with where the user wanted to go before being sent to login
 		stateMu.Lock()
-		stateStore[state] = time.Now()
+		stateStore[state] = pendingLogin{
+			createdAt: time.Now(),
+			returnTo:  safeReturnTo(r.URL.Query().Get("return_to")),
+			remember:  r.URL.Query().Get("remember") != "",
+		}
 		stateMu.Unlock()
 
 		// Clean up old states to prevent memory leaks
@@ -68,10 +104,17 @@ func LoginHandler(oauthConfig *oauth2.Config) http.HandlerFunc {
 	}
 }
 
-// CallbackHandler receives the authorization code from Spotify and exchanges it for tokens.
+// CallbackHandler receives the authorization code from Spotify, exchanges it for tokens
+// and starts a session for the user.
 // This is the redirect_uri endpoint that Spotify sends the user back to.
-func CallbackHandler(oauthConfig *oauth2.Config) http.HandlerFunc {
+func CallbackHandler(apps *OAuthApps, policy SessionPolicy) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		oauthConfig, ok := apps.ForRequest(r)
+		if !ok {
+			unknownHost(w)
+			return
+		}
+
 		// Extract the state and code from the query parameters
 		state := r.URL.Query().Get("state")
 		code := r.URL.Query().Get("code")
@@ -85,7 +128,7 @@ func CallbackHandler(oauthConfig *oauth2.Config) http.HandlerFunc {
 
 		// Verify the state token matches what we stored (CSRF protection)
 		stateMu.Lock()
-		createdAt, exists := stateStore[state]
+		pending, exists := stateStore[state]
 		if exists {
 			delete(stateStore, state) // Use the state only once
 		}
@@ -97,7 +140,7 @@ func CallbackHandler(oauthConfig *oauth2.Config) http.HandlerFunc {
 		}
 
 		// Ensure the state isn't too old (should be used within 2 minutes)
-		if time.Since(createdAt) > 2*time.Minute {
+		if time.Since(pending.createdAt) > 2*time.Minute {
 			http.Error(w, "State token expired", http.StatusBadRequest)
 			return
 		}
@@ -110,44 +153,68 @@ func CallbackHandler(oauthConfig *oauth2.Config) http.HandlerFunc {
 			return
 		}
 
-		// Store the access token in a secure HTTP-only cookie
-		// This prevents JavaScript from accessing it (XSS protection)
-		http.SetCookie(w, &http.Cookie{
-			Name:     "spotify_access_token",
-			Value:    token.AccessToken,
-			Path:     "/",
-			HttpOnly: true,                 // Prevent JavaScript access
-			Secure:   false,                // Set to false for local dev (no HTTPS on localhost)
-			SameSite: http.SameSiteLaxMode, // CSRF protection
-			Expires:  token.Expiry,         // Cookie expires when token expires (~1 hour)
-		})
+		// Look up who just logged in so sessions can be listed and revoked per user
+		user, err := spotify.GetCurrentUser(r.Context(), token.AccessToken)
+		if err != nil {
+			log.Printf("Failed to fetch user profile: %v", err)
+			http.Error(w, "Failed to fetch user profile", http.StatusInternalServerError)
+			return
+		}
 
-		// Store the token expiry time so middleware can check if refresh is needed
-		http.SetCookie(w, &http.Cookie{
-			Name:     "spotify_token_expiry",
-			Value:    token.Expiry.Format(time.RFC3339),
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   false,
-			SameSite: http.SameSiteLaxMode,
-			Expires:  token.Expiry,
-		})
+		if Suspended(user.ID) {
+			log.Printf("Refused login of suspended user %s", user.ID)
+			http.Error(w, "This account is suspended", http.StatusForbidden)
+			return
+		}
+
+		// Keep the tokens on the server; the browser only gets a session cookie
+		if err := createSession(w, r, policy, token, user, pending.remember); err != nil {
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			return
+		}
+
+		// Send the user back to the page they originally asked for
+		http.Redirect(w, r, pending.returnTo, http.StatusTemporaryRedirect)
+	}
+}
 
-		// Store the refresh token in a separate cookie
-		// The refresh token is used to get new access tokens when they expire
-		if token.RefreshToken != "" {
-			http.SetCookie(w, &http.Cookie{
-				Name:     "spotify_refresh_token",
-				Value:    token.RefreshToken,
-				Path:     "/",
-				HttpOnly: true,
-				Secure:   false,                 // Set to false for local dev
-				SameSite: http.SameSiteLaxMode,
-				MaxAge:   60 * 60 * 24 * 30, // 30 days
-			})
+// PlayerTokenHandler hands the Web Playback SDK an access token. It must be wrapped in
+// RequireAuth, which already refreshes tokens that are about to expire, so a GET simply
+// returns the session's current token. A POST forces a refresh first: the frontend sends
+// one when the SDK reports an authentication_error, since Spotify may reject a token
+// before the expiry we know about.
+func PlayerTokenHandler(apps *OAuthApps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := CurrentSession(r)
+
+		if r.Method == http.MethodPost {
+			if err := refreshToken(r.Context(), apps, &session); err != nil {
+				log.Printf("Failed to refresh token for player: %v", err)
+				http.Error(w, "Failed to refresh token", http.StatusUnauthorized)
+				return
+			}
+			updateSession(session.ID, func(s *Session) { s.Token, s.Scopes = session.Token, session.Scopes })
 		}
 
-		// Redirect to your application's main page
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(struct {
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int    `json:"expires_in"` // seconds
+		}{
+			AccessToken: session.Token.AccessToken,
+			ExpiresIn:   int(time.Until(session.Token.Expiry).Seconds()),
+		})
+	}
+}
+
+// LogoutHandler ends the current session and sends the user back to the home page.
+func LogoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if session, ok := SessionFromRequest(r); ok {
+			deleteSession(session.ID)
+		}
+		clearSessionCookie(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
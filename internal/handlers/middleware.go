@@ -2,113 +2,183 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
 	"time"
 
+	"github.com/jendahorak/bangerid/internal/spotify"
 	"golang.org/x/oauth2"
 )
 
 type contextKey string
 
-const AccessTokenKey contextKey = "access_token"
+const (
+	AccessTokenKey contextKey = "access_token"
+	SessionKey     contextKey = "session"
+)
+
+// redirectToLogin sends the user to /login, remembering the page they asked for
+// so the OAuth callback can bring them back to it.
+// HTMX requests can't follow a redirect to Spotify's login page from an XHR,
+// so for those we ask HTMX to do a full page navigation instead, returning to
+// the page the fragment was requested from.
+func redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	isHTMX := r.Header.Get("HX-Request") == "true"
+
+	returnTo := r.URL.RequestURI()
+	if isHTMX {
+		returnTo = "/"
+		if current, err := url.Parse(r.Header.Get("HX-Current-URL")); err == nil && current.Path != "" {
+			returnTo = current.RequestURI()
+		}
+	}
+
+	loginURL := "/login?return_to=" + url.QueryEscape(returnTo)
+	if isHTMX {
+		w.Header().Set("HX-Redirect", loginURL)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(w, r, loginURL, http.StatusTemporaryRedirect)
+}
 
-// RequireAuth is a middleware that ensures the user has a valid access token.
-// If the token is expired but a refresh token exists, it automatically refreshes.
-// If no valid token can be obtained, it redirects to /login.
-func RequireAuth(oauthConfig *oauth2.Config) func(http.HandlerFunc) http.HandlerFunc {
+// RequireAuth is a middleware that ensures the user has a valid session.
+// If the session's access token is expired, it is refreshed automatically using the refresh token.
+// Every request slides the session expiry forward according to the policy.
+// If no valid session or token can be obtained, it redirects to /login and comes back afterwards.
+func RequireAuth(apps *OAuthApps, policy SessionPolicy) func(http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			// Try to get the access token from cookies
-			accessCookie, err := r.Cookie("spotify_access_token")
-			if err != nil {
-				// No access token - redirect to login
-				log.Println("No access token found, redirecting to login")
-				http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			session, ok := SessionFromRequest(r)
+			if !ok {
+				// No session (or it expired) - redirect to login
+				log.Println("No valid session found, redirecting to login")
+				clearSessionCookie(w)
+				redirectToLogin(w, r)
 				return
 			}
 
-			// Check if the access token is expired or will expire soon (within 5 minutes)
-			expiryCookie, err := r.Cookie("spotify_token_expiry")
-			needsRefresh := false
-
-			if err != nil {
-				// No expiry info - assume it might need refresh
-				needsRefresh = true
-			} else {
-				expiry, err := time.Parse(time.RFC3339, expiryCookie.Value)
-				if err != nil || time.Until(expiry) < 5*time.Minute {
-					needsRefresh = true
+			// Refresh the access token if it is expired or will expire soon (within 5 minutes)
+			previous := session.Token
+			if err := ensureFreshToken(r.Context(), apps, &session); err != nil {
+				log.Printf("Failed to refresh token: %v", err)
+				if errors.Is(err, errNoRefreshToken) {
+					deleteSession(session.ID)
+					clearSessionCookie(w)
 				}
+				redirectToLogin(w, r)
+				return
 			}
 
-			// If token needs refresh, try to refresh it
-			if needsRefresh {
-				refreshCookie, err := r.Cookie("spotify_refresh_token")
-				if err != nil {
-					// No refresh token - redirect to login
-					log.Println("Token expired and no refresh token, redirecting to login")
-					http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
-					return
+			// Sliding expiration: each request keeps the session alive for another lifetime
+			now := time.Now()
+			session.LastSeen = now
+			session.ExpiresAt = now.Add(policy.lifetime(session.Remember))
+			// Only what this request changed is written back, a heartbeat or a refresh for
+			// the player may have changed the rest meanwhile
+			updateSession(session.ID, func(s *Session) {
+				s.LastSeen, s.ExpiresAt = session.LastSeen, session.ExpiresAt
+				if session.Token != previous {
+					s.Token, s.Scopes = session.Token, session.Scopes
 				}
+			})
+			if session.Remember {
+				setSessionCookie(w, &session)
+			}
 
-				// Use the refresh token to get a new access token
-				token := &oauth2.Token{
-					RefreshToken: refreshCookie.Value,
-				}
+			// Add the valid access token and the session to the request context
+			// Handlers can retrieve them with: token := r.Context().Value(handlers.AccessTokenKey).(string)
+			// or session := handlers.CurrentSession(r)
+			// Spotify API calls made with this context are counted against the user
+			ctx := context.WithValue(r.Context(), AccessTokenKey, session.Token.AccessToken)
+			ctx = context.WithValue(ctx, SessionKey, session)
+			ctx = spotify.WithUser(ctx, session.UserID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+	}
+}
 
-				// TokenSource automatically refreshes the token
-				tokenSource := oauthConfig.TokenSource(r.Context(), token)
-				newToken, err := tokenSource.Token()
-				if err != nil {
-					log.Printf("Failed to refresh token: %v", err)
-					http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
-					return
-				}
+// DemoAuth is used instead of RequireAuth in demo mode: every visitor shares one
+// made-up session without a Spotify token, so nothing can reach a real account.
+func DemoAuth(userID, displayName string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			session := Session{UserID: userID, DisplayName: displayName, Token: &oauth2.Token{}}
 
-				// Update the access token cookie with the new token
-				http.SetCookie(w, &http.Cookie{
-					Name:     "spotify_access_token",
-					Value:    newToken.AccessToken,
-					Path:     "/",
-					HttpOnly: true,
-					Secure:   false, // Set to false for local dev
-					SameSite: http.SameSiteLaxMode,
-					Expires:  newToken.Expiry,
-				})
-
-				// Store the expiry time so we can check it next time
-				http.SetCookie(w, &http.Cookie{
-					Name:     "spotify_token_expiry",
-					Value:    newToken.Expiry.Format(time.RFC3339),
-					Path:     "/",
-					HttpOnly: true,
-					Secure:   false,
-					SameSite: http.SameSiteLaxMode,
-					Expires:  newToken.Expiry,
-				})
-
-				// Update the refresh token if Spotify sent a new one
-				if newToken.RefreshToken != "" {
-					http.SetCookie(w, &http.Cookie{
-						Name:     "spotify_refresh_token",
-						Value:    newToken.RefreshToken,
-						Path:     "/",
-						HttpOnly: true,
-						Secure:   false,
-						SameSite: http.SameSiteLaxMode,
-						MaxAge:   60 * 60 * 24 * 30, // 30 days
-					})
-				}
+			ctx := context.WithValue(r.Context(), AccessTokenKey, "")
+			ctx = context.WithValue(ctx, SessionKey, session)
+			ctx = spotify.WithUser(ctx, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+	}
+}
 
-				log.Println("Token refreshed successfully")
-				accessCookie.Value = newToken.AccessToken
-			}
+var errNoRefreshToken = errors.New("token expired and no refresh token")
 
-			// Add the valid access token to the request context
-			// Handlers can retrieve it with: token := r.Context().Value(handlers.AccessTokenKey).(string)
-			ctx := context.WithValue(r.Context(), AccessTokenKey, accessCookie.Value)
-			next.ServeHTTP(w, r.WithContext(ctx))
+// ensureFreshToken refreshes the session's access token if it expires within 5 minutes.
+// The session is updated in place; the caller is responsible for saving it.
+func ensureFreshToken(ctx context.Context, apps *OAuthApps, session *Session) error {
+	if time.Until(session.Token.Expiry) >= 5*time.Minute {
+		return nil
+	}
+	return refreshToken(ctx, apps, session)
+}
+
+// refreshToken gets a new access token for the session using its refresh token,
+// regardless of when the current one expires.
+// The session is updated in place; the caller is responsible for saving it.
+func refreshToken(ctx context.Context, apps *OAuthApps, session *Session) error {
+	if session.Token.RefreshToken == "" {
+		return errNoRefreshToken
+	}
+
+	oauthConfig, ok := apps.ForHost(session.Host)
+	if !ok {
+		return fmt.Errorf("no Spotify app configured for host %q", session.Host)
+	}
+
+	// Only pass the refresh token so TokenSource is forced to fetch a new access token
+	token := &oauth2.Token{
+		RefreshToken: session.Token.RefreshToken,
+	}
+
+	newToken, err := oauthConfig.TokenSource(ctx, token).Token()
+	if err != nil {
+		return err
+	}
+
+	// Keep the old refresh token unless Spotify sent a new one
+	if newToken.RefreshToken == "" {
+		newToken.RefreshToken = session.Token.RefreshToken
+	}
+	session.Token = newToken
+	if scopes := grantedScopes(newToken); scopes != nil {
+		session.Scopes = scopes
+	}
+	log.Println("Token refreshed successfully")
+	return nil
+}
+
+// CurrentSession returns the session RequireAuth put into the request context.
+func CurrentSession(r *http.Request) Session {
+	session, _ := r.Context().Value(SessionKey).(Session)
+	return session
+}
+
+// RequireAdmin is a middleware for routes behind RequireAuth that only the given
+// Spotify user IDs may use. Everyone else gets a 404 so the admin pages stay hidden.
+func RequireAdmin(adminUserIDs []string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(adminUserIDs, CurrentSession(r).UserID) {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
 		}
 	}
 }
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/spotify"
+	"golang.org/x/oauth2"
+)
+
+const sessionCookieName = "bangerid_session"
+
+// SessionPolicy controls how long sessions stay valid.
+// Both lifetimes are sliding: every authenticated request pushes the expiry forward.
+type SessionPolicy struct {
+	TTL         time.Duration // regular sessions end after being idle this long
+	RememberTTL time.Duration // sessions created with "keep me signed in"
+}
+
+// lifetime returns the sliding lifetime that applies to a session.
+func (p SessionPolicy) lifetime(remember bool) time.Duration {
+	if remember {
+		return p.RememberTTL
+	}
+	return p.TTL
+}
+
+// Session is one signed-in browser. The Spotify tokens live here on the server,
+// the browser only gets the random session ID in a cookie.
+type Session struct {
+	ID          string
+	UserID      string
+	DisplayName string
+	Token       *oauth2.Token
+	Remember    bool
+	Generation  int    // must match the user's current generation, see LogoutEverywhere
+	Host        string // host the user logged in on, selects the Spotify app for token refreshes
+	UserAgent   string
+	CreatedAt   time.Time
+	LastSeen    time.Time
+	ExpiresAt   time.Time
+	// LastHeartbeat is when an open player page last pinged /heartbeat. Unlike LastSeen it
+	// tells us someone actually has the app open, not just that a request came in.
+	LastHeartbeat time.Time
+	// Product is the Spotify subscription at login, see spotify.User. Empty for sessions
+	// from before we recorded it.
+	Product string
+	// Country is the country of the Spotify account at login, see spotify.User. Empty for
+	// sessions from before we recorded it.
+	Country string
+	// Scopes are the OAuth scopes the user granted. Empty for sessions from before we
+	// recorded them, see HasScope.
+	Scopes []string
+}
+
+// HasScope reports whether the user granted the OAuth scope. Sessions that don't know
+// their scopes are assumed to have everything we asked for at the time.
+func (s Session) HasScope(scope string) bool {
+	return len(s.Scopes) == 0 || slices.Contains(s.Scopes, scope)
+}
+
+// grantedScopes returns the scopes Spotify says the token carries, nil if it didn't say
+func grantedScopes(token *oauth2.Token) []string {
+	scope, _ := token.Extra("scope").(string)
+	return strings.Fields(scope)
+}
+
+// In-memory session storage, keyed by session ID.
+// Without a data directory sessions are lost on restart, which just means users have
+// to log in again; with one they are persisted, see LoadSessions.
+// userGenerations holds a counter per user ID that is bumped on "log out everywhere";
+// sessions created under an older generation are no longer accepted.
+var (
+	sessionMu       sync.Mutex
+	sessionStore    = make(map[string]*Session)
+	userGenerations = make(map[string]int)
+)
+
+// generateSessionID creates a cryptographically secure random session ID.
+func generateSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// createSession stores a new session for a freshly authorized user and sets its cookie.
+func createSession(w http.ResponseWriter, r *http.Request, policy SessionPolicy, token *oauth2.Token, user *spotify.User, remember bool) error {
+	id, err := generateSessionID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	session := &Session{
+		ID:          id,
+		UserID:      user.ID,
+		DisplayName: user.DisplayName,
+		Token:       token,
+		Remember:    remember,
+		Generation:  userGenerations[user.ID],
+		Host:        r.Host,
+		UserAgent:   r.UserAgent(),
+		CreatedAt:   now,
+		LastSeen:    now,
+		ExpiresAt:   now.Add(policy.lifetime(remember)),
+		Product:     user.Product,
+		Country:     user.Country,
+		Scopes:      grantedScopes(token),
+	}
+
+	sessionStore[id] = session
+
+	// Clean up expired sessions to prevent memory leaks
+	go PruneSessions()
+
+	setSessionCookie(w, session)
+	return nil
+}
+
+// setSessionCookie writes the session cookie. Remembered sessions get a persistent cookie
+// that outlives the browser; regular ones get a cookie that is dropped when the browser closes.
+func setSessionCookie(w http.ResponseWriter, session *Session) {
+	cookie := &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.ID,
+		Path:     "/",
+		HttpOnly: true,  // Prevent JavaScript access
+		Secure:   false, // Set to false for local dev (no HTTPS on localhost)
+		SameSite: http.SameSiteLaxMode,
+	}
+	if session.Remember {
+		cookie.Expires = session.ExpiresAt
+	}
+	http.SetCookie(w, cookie)
+}
+
+// clearSessionCookie removes the session cookie from the browser.
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   sessionCookieName,
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+}
+
+// SessionFromRequest returns a copy of the session the request's cookie points at,
+// or false if there is none, it has expired or it was invalidated by LogoutEverywhere.
+// It does not refresh tokens or extend the session; use RequireAuth for that.
+func SessionFromRequest(r *http.Request) (Session, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return Session{}, false
+	}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	session, exists := sessionStore[cookie.Value]
+	if !exists || time.Now().After(session.ExpiresAt) || session.Generation != userGenerations[session.UserID] {
+		return Session{}, false
+	}
+	return *session, true
+}
+
+// updateSession changes only some fields of a stored session, under sessionMu, so what
+// other requests changed meanwhile (a refreshed token, an extended expiry) isn't rolled
+// back the way writing back a whole copy would. It does nothing if the session was
+// revoked in the meantime.
+func updateSession(id string, update func(*Session)) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	if session, exists := sessionStore[id]; exists {
+		update(session)
+	}
+}
+
+// deleteSession removes a session from the store.
+func deleteSession(id string) {
+	sessionMu.Lock()
+	delete(sessionStore, id)
+	sessionMu.Unlock()
+}
+
+// SessionInfo is what the user sees of one of their sessions. The session ID is the
+// cookie that signs the browser in, so it is never shown, only a hash of it.
+type SessionInfo struct {
+	PublicID  string // see PublicID
+	UserAgent string
+	Remember  bool
+	LastSeen  time.Time
+	ExpiresAt time.Time
+}
+
+// PublicID returns the ID the session is listed and revoked by. It is derived from the
+// session ID and doesn't lead back to it.
+func (s Session) PublicID() string {
+	sum := sha256.Sum256([]byte(s.ID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// UserSessions lists the active sessions of a user, most recently used first.
+func UserSessions(userID string) []SessionInfo {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	now := time.Now()
+	var sessions []SessionInfo
+	for _, session := range sessionStore {
+		if session.UserID == userID && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, SessionInfo{
+				PublicID:  session.PublicID(),
+				UserAgent: session.UserAgent,
+				Remember:  session.Remember,
+				LastSeen:  session.LastSeen,
+				ExpiresAt: session.ExpiresAt,
+			})
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions
+}
+
+// RevokeSession ends one of the user's sessions, by its public ID. It reports false if
+// the user has no such session.
+func RevokeSession(userID, publicID string) bool {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	for id, session := range sessionStore {
+		if session.UserID == userID && subtle.ConstantTimeCompare([]byte(session.PublicID()), []byte(publicID)) == 1 {
+			delete(sessionStore, id)
+			return true
+		}
+	}
+	return false
+}
+
+// LogoutEverywhere invalidates every session of the user, on all devices.
+// It bumps the user's session generation so any session still carrying the old
+// generation is rejected immediately, then drops those sessions from the store.
+func LogoutEverywhere(userID string) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	logoutEverywhere(userID)
+}
+
+// logoutEverywhere is LogoutEverywhere with sessionMu already held
+func logoutEverywhere(userID string) {
+	userGenerations[userID]++
+	for id, session := range sessionStore {
+		if session.UserID == userID {
+			delete(sessionStore, id)
+		}
+	}
+}
+
+// ActiveUsers returns the IDs of users with a session that sent a heartbeat within the window.
+func ActiveUsers(within time.Duration) []string {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	var users []string
+	for _, session := range sessionStore {
+		if now.Sub(session.LastHeartbeat) <= within && !seen[session.UserID] && now.Before(session.ExpiresAt) {
+			seen[session.UserID] = true
+			users = append(users, session.UserID)
+		}
+	}
+	return users
+}
+
+// HeartbeatHandler records that the player page of the current session is open.
+// It must be wrapped in RequireAuth.
+func HeartbeatHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		updateSession(CurrentSession(r).ID, func(s *Session) { s.LastHeartbeat = now })
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// PruneSessions removes the sessions that were idle for longer than their sliding lifetime
+// or were signed out everywhere, and returns how many. Expired sessions can't be used
+// anyway, this frees their memory and keeps them out of the session file.
+func PruneSessions() int {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	now := time.Now()
+	pruned := 0
+	for id, session := range sessionStore {
+		if now.After(session.ExpiresAt) || session.Generation != userGenerations[session.UserID] {
+			delete(sessionStore, id)
+			pruned++
+		}
+	}
+	return pruned
+}
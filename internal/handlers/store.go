@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+	"github.com/jendahorak/bangerid/internal/spotify"
+)
+
+const sessionsFileName = "sessions.json"
+
+// storedSessions is the on-disk format of the session store
+type storedSessions struct {
+	Sessions    []*Session           `json:"sessions"`
+	Generations map[string]int       `json:"generations"`
+	Suspended   map[string]time.Time `json:"suspended,omitempty"`
+}
+
+// sessionsPath is the file the session store is persisted to, empty while running in
+// memory only. It is guarded by sessionMu, like the store itself.
+var sessionsPath string
+
+// LoadSessions restores the session store from the data directory and remembers the
+// directory so SaveSessions and PersistSessions write back to it. A missing file just
+// means nobody signed in yet.
+func LoadSessions(dir string) error {
+	path := filepath.Join(dir, sessionsFileName)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read sessions: %w", err)
+	}
+
+	var stored storedSessions
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	sessionsPath = path
+	for _, session := range stored.Sessions {
+		if session.Token != nil {
+			sessionStore[session.ID] = session
+		}
+	}
+	for userID, generation := range stored.Generations {
+		userGenerations[userID] = generation
+	}
+	for userID, at := range stored.Suspended {
+		suspended[userID] = at
+	}
+	log.Printf("Loaded %d sessions from %s", len(sessionStore), path)
+	return nil
+}
+
+// SaveSessions writes the session store to the data directory given to LoadSessions.
+// It does nothing when sessions are kept in memory only.
+func SaveSessions() error {
+	sessionMu.Lock()
+	if sessionsPath == "" {
+		sessionMu.Unlock()
+		return nil
+	}
+	path := sessionsPath
+	stored := storedSessions{Generations: userGenerations, Suspended: suspended}
+	for _, session := range sessionStore {
+		stored.Sessions = append(stored.Sessions, session)
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	sessionMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode sessions: %w", err)
+	}
+
+	// The file holds refresh tokens, WriteFileAtomic keeps it to the server's user
+	if err := fileutil.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write sessions: %w", err)
+	}
+	return nil
+}
+
+// PersistSessions saves the session store every interval until ctx is done, then once more.
+// Sessions change on every request (sliding expiry), so writing through would be wasteful.
+func PersistSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := SaveSessions(); err != nil {
+				log.Printf("Failed to save sessions: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		if err := SaveSessions(); err != nil {
+			log.Printf("Failed to save sessions: %v", err)
+		}
+	}
+}
+
+// UserAccessToken returns a fresh access token from the user's most recently used valid
+// session, for work done without a browser such as the sync command. It reports whether
+// the refresh token changed, in which case the store should be saved.
+func UserAccessToken(ctx context.Context, apps *OAuthApps, userID string) (string, bool, error) {
+	session, changed, err := freshUserSession(ctx, apps, userID)
+	if err != nil {
+		return "", false, err
+	}
+	return session.Token.AccessToken, changed, nil
+}
+
+// UserContext returns ctx with what RequireAuth puts in a request's context, from the
+// user's most recently used valid session, for requests authenticated another way such
+// as with an API token.
+func UserContext(ctx context.Context, apps *OAuthApps, userID string) (context.Context, error) {
+	session, _, err := freshUserSession(ctx, apps, userID)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, AccessTokenKey, session.Token.AccessToken)
+	ctx = context.WithValue(ctx, SessionKey, session)
+	return spotify.WithUser(ctx, userID), nil
+}
+
+// freshUserSession returns the user's most recently used valid session with a fresh
+// access token, and whether the refresh token changed
+func freshUserSession(ctx context.Context, apps *OAuthApps, userID string) (Session, bool, error) {
+	sessionMu.Lock()
+	if isSuspended(userID) {
+		sessionMu.Unlock()
+		return Session{}, false, ErrSuspended
+	}
+	var latest *Session
+	now := time.Now()
+	for _, session := range sessionStore {
+		if session.UserID != userID || now.After(session.ExpiresAt) || session.Generation != userGenerations[userID] {
+			continue
+		}
+		if latest == nil || session.LastSeen.After(latest.LastSeen) {
+			latest = session
+		}
+	}
+	sessionMu.Unlock()
+
+	if latest == nil {
+		return Session{}, false, fmt.Errorf("no stored session for user %s, sign in on the web first", userID)
+	}
+
+	session := *latest
+	previous := session.Token
+	if err := ensureFreshToken(ctx, apps, &session); err != nil {
+		return Session{}, false, fmt.Errorf("failed to refresh token: %w", err)
+	}
+	if session.Token != previous {
+		updateSession(session.ID, func(s *Session) { s.Token, s.Scopes = session.Token, session.Scopes })
+	}
+	return session, session.Token.RefreshToken != previous.RefreshToken, nil
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ErrSuspended is returned for users an admin suspended
+var ErrSuspended = errors.New("account is suspended")
+
+// suspended holds when users were suspended, keyed by Spotify user ID. Suspended users
+// can't sign in and get no background work. Guarded by sessionMu and persisted with
+// the sessions.
+var suspended = make(map[string]time.Time)
+
+// isSuspended reports whether the user is suspended. sessionMu must be held.
+func isSuspended(userID string) bool {
+	_, ok := suspended[userID]
+	return ok
+}
+
+// Suspended reports whether the user is suspended.
+func Suspended(userID string) bool {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	return isSuspended(userID)
+}
+
+// Suspend blocks the user from signing in and signs them out everywhere, which also
+// stops background work on their behalf. The change is saved right away rather than
+// with the next PersistSessions tick, so a restart can't lift it.
+func Suspend(userID string) error {
+	sessionMu.Lock()
+	if !isSuspended(userID) {
+		suspended[userID] = time.Now()
+	}
+	logoutEverywhere(userID)
+	sessionMu.Unlock()
+	return SaveSessions()
+}
+
+// Unsuspend lets the user sign in again.
+func Unsuspend(userID string) error {
+	sessionMu.Lock()
+	delete(suspended, userID)
+	sessionMu.Unlock()
+	return SaveSessions()
+}
+
+// Account is a user known to the session store, as listed for admins
+type Account struct {
+	UserID      string
+	DisplayName string    // from the most recent session, empty if there is none
+	Sessions    int       // active sessions
+	LastSeen    time.Time // zero without active sessions
+	SuspendedAt time.Time // zero unless suspended
+}
+
+// Accounts lists every user with an active session or a suspension, most recently
+// seen first.
+func Accounts() []Account {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	now := time.Now()
+	byUser := make(map[string]*Account)
+	account := func(userID string) *Account {
+		a, ok := byUser[userID]
+		if !ok {
+			a = &Account{UserID: userID}
+			byUser[userID] = a
+		}
+		return a
+	}
+
+	for _, session := range sessionStore {
+		if now.After(session.ExpiresAt) || session.Generation != userGenerations[session.UserID] {
+			continue
+		}
+		a := account(session.UserID)
+		a.Sessions++
+		if session.LastSeen.After(a.LastSeen) {
+			a.LastSeen = session.LastSeen
+			a.DisplayName = session.DisplayName
+		}
+	}
+	for userID, at := range suspended {
+		account(userID).SuspendedAt = at
+	}
+
+	accounts := make([]Account, 0, len(byUser))
+	// Sorted first so equally recent accounts (the suspended ones) list stably
+	for _, userID := range slices.Sorted(maps.Keys(byUser)) {
+		accounts = append(accounts, *byUser[userID])
+	}
+	sort.SliceStable(accounts, func(i, j int) bool {
+		return accounts[i].LastSeen.After(accounts[j].LastSeen)
+	})
+	return accounts
+}
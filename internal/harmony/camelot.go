@@ -0,0 +1,96 @@
+// Package harmony maps musical keys onto the Camelot wheel DJs use for harmonic mixing.
+package harmony
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Camelot is a position on the Camelot wheel: Number 1-12 and Letter 'A' (minor) or 'B' (major).
+// The zero value means the key is unknown.
+type Camelot struct {
+	Number int
+	Letter byte
+}
+
+// Camelot numbers indexed by pitch class (0=C .. 11=B)
+var (
+	majorNumbers = [12]int{8, 3, 10, 5, 12, 7, 2, 9, 4, 11, 6, 1}
+	minorNumbers = [12]int{5, 12, 7, 2, 9, 4, 11, 6, 1, 8, 3, 10}
+)
+
+// FromKey converts a pitch class (0=C .. 11=B, -1 unknown) and mode (1 major, 0 minor),
+// as found in Spotify's audio features, into Camelot notation.
+func FromKey(key, mode int) Camelot {
+	if key < 0 || key > 11 {
+		return Camelot{}
+	}
+	if mode == 1 {
+		return Camelot{Number: majorNumbers[key], Letter: 'B'}
+	}
+	return Camelot{Number: minorNumbers[key], Letter: 'A'}
+}
+
+// ErrInvalidKey is returned by Parse for anything that isn't Camelot notation.
+var ErrInvalidKey = errors.New("invalid Camelot key")
+
+// Parse reads notation like "8A" or "12b".
+func Parse(s string) (Camelot, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return Camelot{}, fmt.Errorf("%w %q", ErrInvalidKey, s)
+	}
+
+	letter := s[len(s)-1]
+	number, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || number < 1 || number > 12 || (letter != 'A' && letter != 'B') {
+		return Camelot{}, fmt.Errorf("%w %q", ErrInvalidKey, s)
+	}
+	return Camelot{Number: number, Letter: letter}, nil
+}
+
+// Known reports whether the key is known.
+func (c Camelot) Known() bool {
+	return c.Number != 0
+}
+
+// String returns the notation, e.g. "8A", or "" for an unknown key.
+func (c Camelot) String() string {
+	if !c.Known() {
+		return ""
+	}
+	return strconv.Itoa(c.Number) + string(c.Letter)
+}
+
+// Distance is the number of steps between two keys on the wheel: one step per number
+// around the circle plus one for switching between minor and major. Keys that are 0 or 1
+// steps apart mix cleanly. Unknown keys are treated as far away from everything.
+func Distance(a, b Camelot) int {
+	if !a.Known() || !b.Known() {
+		return 6
+	}
+
+	diff := a.Number - b.Number
+	if diff < 0 {
+		diff = -diff
+	}
+	steps := min(diff, 12-diff)
+	if a.Letter != b.Letter {
+		steps++
+	}
+	return steps
+}
+
+// Compatible reports whether b can be mixed into a harmonically: the same key, one step
+// around the wheel, or the relative major/minor.
+func Compatible(a, b Camelot) bool {
+	if !a.Known() || !b.Known() {
+		return false
+	}
+	if a.Letter == b.Letter {
+		return Distance(a, b) <= 1
+	}
+	return a.Number == b.Number
+}
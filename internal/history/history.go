@@ -0,0 +1,153 @@
+// Package history remembers when users last played their tracks. Spotify only hands out
+// the last 50 plays, so we keep our own record from plays started through bangerid and
+// from every recently played list we get to see. Users can import the years before from
+// their extended streaming history, which also tells how often they played what.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+)
+
+var (
+	mu       sync.Mutex
+	lastPlay = make(map[string]map[string]time.Time) // user ID -> bare track ID -> last play
+
+	// storeDir is where history is persisted, one JSON file per user.
+	// Empty while running in memory only.
+	storeDir string
+)
+
+// playsFor returns the user's last plays, creating an empty map if needed. mu must be held.
+func playsFor(userID string) map[string]time.Time {
+	plays, ok := lastPlay[userID]
+	if !ok {
+		plays = make(map[string]time.Time)
+		lastPlay[userID] = plays
+	}
+	return plays
+}
+
+// Load restores all persisted history from the data directory and saves every change
+// there from now on.
+func Load(dir string) error {
+	dir = filepath.Join(dir, "history")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list history: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	storeDir = dir
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		userID, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read history: %w", err)
+		}
+		var plays map[string]time.Time
+		if err := json.Unmarshal(data, &plays); err != nil {
+			// Losing history only makes old favorites resurface too eagerly, start over
+			slog.Warn("skipping persisted history", "user", userID, slog.Any("error", err))
+			continue
+		}
+		if plays != nil {
+			lastPlay[userID] = plays
+		}
+	}
+	if err := loadListening(); err != nil {
+		return err
+	}
+	slog.Info("history loaded", "dir", dir, "users", len(lastPlay), "listening", len(listening))
+	return nil
+}
+
+// save writes the user's history to the data directory, if there is one. mu must be held.
+func save(userID string) error {
+	if storeDir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(playsFor(userID))
+	if err != nil {
+		return fmt.Errorf("failed to encode history: %w", err)
+	}
+
+	path := filepath.Join(storeDir, url.PathEscape(userID)+".json")
+	if err := fileutil.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+// Record notes when the user played tracks, keyed by bare track ID. Plays older than
+// the ones already known are ignored.
+func Record(userID string, plays map[string]time.Time) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	known := playsFor(userID)
+	changed := false
+	for id, at := range plays {
+		if at.After(known[id]) {
+			known[id] = at
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return save(userID)
+}
+
+// LastPlayed returns when the user last played each track we know of, keyed by bare track ID.
+func LastPlayed(userID string) map[string]time.Time {
+	mu.Lock()
+	defer mu.Unlock()
+	return maps.Clone(playsFor(userID))
+}
+
+// Delete forgets the user's history, on disk too.
+func Delete(userID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(lastPlay, userID)
+	delete(listening, userID)
+	delete(playCounts, userID)
+	if storeDir == "" {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(storeDir, url.PathEscape(userID)+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete history: %w", err)
+	}
+	if err := os.Remove(listeningPath(userID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete listening: %w", err)
+	}
+	return nil
+}
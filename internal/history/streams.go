@@ -0,0 +1,310 @@
+package history
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+)
+
+// minStream is how long a play has to last to count, Spotify counts streams the same way
+const minStream = 30 * time.Second
+
+// ErrNotStreamingHistory is returned for files that aren't part of Spotify's extended
+// streaming history, like the account data's StreamingHistory0.json which lacks track IDs
+var ErrNotStreamingHistory = errors.New("the file isn't an extended streaming history")
+
+// Stream is one play of a track in Spotify's extended streaming history
+type Stream struct {
+	Time   time.Time     // when it ended
+	Played time.Duration // how much of the track was listened to
+	ID     string        // bare track ID
+	Name   string
+	Artist string
+}
+
+// exportStream is an entry of the export files. Podcast episodes and audiobooks are in
+// there too, without a track URI.
+type exportStream struct {
+	TS       string `json:"ts"`
+	MsPlayed int64  `json:"ms_played"`
+	Name     string `json:"master_metadata_track_name"`
+	Artist   string `json:"master_metadata_album_artist_name"`
+	URI      string `json:"spotify_track_uri"`
+}
+
+// ParseStreams reads one file of the extended streaming history Spotify sends on request,
+// endsong_0.json or the like in older exports, Streaming_History_Audio_2019-2020_3.json
+// in newer ones. Plays of anything but tracks are skipped. The files are large, they are
+// decoded an entry at a time.
+func ParseStreams(r io.Reader) ([]Stream, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, ErrNotStreamingHistory
+	}
+
+	var streams []Stream
+	for dec.More() {
+		var e exportStream
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("failed to parse streaming history: %w", err)
+		}
+		if e.TS == "" {
+			return nil, ErrNotStreamingHistory
+		}
+		id, ok := strings.CutPrefix(e.URI, "spotify:track:")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, e.TS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse streaming history: %w", err)
+		}
+		streams = append(streams, Stream{
+			Time:   t,
+			Played: time.Duration(e.MsPlayed) * time.Millisecond,
+			ID:     id,
+			Name:   e.Name,
+			Artist: e.Artist,
+		})
+	}
+	return streams, nil
+}
+
+// Listening is what a user's imported streaming history says about their listening
+type Listening struct {
+	Years      []Year    `json:"years"` // the earliest first
+	From       time.Time `json:"from"`  // the earliest play
+	Until      time.Time `json:"until"` // the latest play
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// Plays returns how many streams the history has in all
+func (l Listening) Plays() int {
+	plays := 0
+	for _, y := range l.Years {
+		plays += y.Plays
+	}
+	return plays
+}
+
+// Year is a year of listening
+type Year struct {
+	Year     int           `json:"year"`
+	Plays    int           `json:"plays"`    // streams, plays of minStream and longer
+	Listened time.Duration `json:"listened"` // all plays together, short ones too
+	Tracks   []TrackPlays  `json:"tracks"`   // the most played first
+	Artists  []ArtistPlays `json:"artists"`  // the most played first
+}
+
+// TrackPlays is how often a track was played in a year
+type TrackPlays struct {
+	ID       string        `json:"id"` // bare track ID
+	Name     string        `json:"name"`
+	Artist   string        `json:"artist"`
+	Plays    int           `json:"plays"`
+	Listened time.Duration `json:"listened"`
+}
+
+// ArtistPlays is how often an artist was played in a year
+type ArtistPlays struct {
+	Name     string        `json:"name"`
+	Plays    int           `json:"plays"`
+	Listened time.Duration `json:"listened"`
+}
+
+var (
+	listening  = make(map[string]*Listening)     // user ID -> imported streaming history
+	playCounts = make(map[string]map[string]int) // user ID -> bare track ID -> streams
+)
+
+// listeningPath is the file the user's imported streaming history is persisted to. It
+// lives apart from the last plays, which are saved far more often.
+func listeningPath(userID string) string {
+	return filepath.Join(storeDir, "listening", url.PathEscape(userID)+".json")
+}
+
+// loadListening restores the imported streaming histories. mu must be held.
+func loadListening() error {
+	dir := filepath.Join(storeDir, "listening")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create listening directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list listening: %w", err)
+	}
+
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		userID, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read listening: %w", err)
+		}
+		var l Listening
+		if err := json.Unmarshal(data, &l); err != nil {
+			// Importing the streaming history again brings it back
+			slog.Warn("skipping persisted listening", "user", userID, slog.Any("error", err))
+			continue
+		}
+		setListening(userID, &l)
+	}
+	return nil
+}
+
+// setListening keeps the user's imported streaming history. mu must be held.
+func setListening(userID string, l *Listening) {
+	counts := make(map[string]int)
+	for _, y := range l.Years {
+		for _, t := range y.Tracks {
+			counts[t.ID] += t.Plays
+		}
+	}
+	listening[userID] = l
+	playCounts[userID] = counts
+}
+
+// ImportStreams replaces the user's streaming history with the streams, which may come
+// from several files of one export, counting years in loc. Importing an export again
+// doesn't count a play twice, it's the newer export that counts. The last plays are
+// backfilled from the streams too.
+func ImportStreams(userID string, streams []Stream, loc *time.Location) (Listening, error) {
+	type playKey struct {
+		at time.Time
+		id string
+	}
+	seen := make(map[playKey]bool, len(streams))
+	years := make(map[int]*Year)
+	tracks := make(map[int]map[string]*TrackPlays)
+	artists := make(map[int]map[string]*ArtistPlays)
+	last := make(map[string]time.Time)
+	l := Listening{ImportedAt: time.Now()}
+
+	for _, s := range streams {
+		key := playKey{s.Time.UTC(), s.ID}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		n := s.Time.In(loc).Year()
+		y, ok := years[n]
+		if !ok {
+			y = &Year{Year: n}
+			years[n] = y
+			tracks[n] = make(map[string]*TrackPlays)
+			artists[n] = make(map[string]*ArtistPlays)
+		}
+		t, ok := tracks[n][s.ID]
+		if !ok {
+			t = &TrackPlays{ID: s.ID, Name: s.Name, Artist: s.Artist}
+			tracks[n][s.ID] = t
+		}
+		a, ok := artists[n][s.Artist]
+		if !ok {
+			a = &ArtistPlays{Name: s.Artist}
+			artists[n][s.Artist] = a
+		}
+
+		y.Listened += s.Played
+		t.Listened += s.Played
+		a.Listened += s.Played
+		if s.Played >= minStream {
+			y.Plays++
+			t.Plays++
+			a.Plays++
+			if s.Time.After(last[s.ID]) {
+				last[s.ID] = s.Time
+			}
+		}
+		if l.From.IsZero() || s.Time.Before(l.From) {
+			l.From = s.Time
+		}
+		if s.Time.After(l.Until) {
+			l.Until = s.Time
+		}
+	}
+
+	for n, y := range years {
+		for _, t := range tracks[n] {
+			y.Tracks = append(y.Tracks, *t)
+		}
+		slices.SortFunc(y.Tracks, func(a, b TrackPlays) int {
+			return cmp.Or(cmp.Compare(b.Plays, a.Plays), cmp.Compare(b.Listened, a.Listened), strings.Compare(a.ID, b.ID))
+		})
+		for _, a := range artists[n] {
+			y.Artists = append(y.Artists, *a)
+		}
+		slices.SortFunc(y.Artists, func(a, b ArtistPlays) int {
+			return cmp.Or(cmp.Compare(b.Plays, a.Plays), cmp.Compare(b.Listened, a.Listened), strings.Compare(a.Name, b.Name))
+		})
+		l.Years = append(l.Years, *y)
+	}
+	slices.SortFunc(l.Years, func(a, b Year) int { return cmp.Compare(a.Year, b.Year) })
+
+	if err := Record(userID, last); err != nil {
+		return l, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	setListening(userID, &l)
+	return l, saveListening(userID)
+}
+
+// ImportedListening returns the user's imported streaming history. It reports false
+// until one was imported.
+func ImportedListening(userID string) (Listening, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	l, ok := listening[userID]
+	if !ok {
+		return Listening{}, false
+	}
+	return *l, true
+}
+
+// PlayCount returns how often the user streamed a track, by bare track ID, as far as
+// the imported streaming history goes
+func PlayCount(userID, trackID string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return playCounts[userID][trackID]
+}
+
+// saveListening writes the user's imported streaming history to the data directory, if
+// there is one. mu must be held.
+func saveListening(userID string) error {
+	if storeDir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(listening[userID])
+	if err != nil {
+		return fmt.Errorf("failed to encode listening: %w", err)
+	}
+
+	path := listeningPath(userID)
+	if err := fileutil.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write listening: %w", err)
+	}
+	return nil
+}
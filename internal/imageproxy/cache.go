@@ -0,0 +1,141 @@
+package imageproxy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/metrics"
+)
+
+const (
+	// maxCacheBytes bounds the memory downloaded images take, the least recently served
+	// ones are dropped past it. About 1000 large covers or many more thumbnails.
+	maxCacheBytes = 64 << 20
+	// defaultFreshness is how long an image is served without asking the CDN again when
+	// the CDN doesn't say
+	defaultFreshness = 24 * time.Hour
+)
+
+var cacheResults = metrics.NewCounter(
+	"bangerid_image_cache_total",
+	"Proxied image requests by how the cache answered them: hit, revalidated or miss.",
+	"result",
+)
+
+// cachedImage is a downloaded image with what is needed to revalidate it upstream
+type cachedImage struct {
+	body         []byte
+	contentType  string
+	etag         string    // the CDN's, sent back in If-None-Match
+	lastModified string    // the CDN's, sent back in If-Modified-Since
+	modTime      time.Time // Last-Modified for browsers, the download time if the CDN has none
+	tag          string    // ETag for browsers, a hash of the body
+	freshUntil   time.Time
+	usedAt       time.Time
+}
+
+var (
+	cacheMu    sync.Mutex
+	cache      = make(map[string]*cachedImage)
+	cacheBytes int
+)
+
+// cacheLookup returns the cached image for a URL, fresh or not, nil if none
+func cacheLookup(imageURL string) *cachedImage {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	img, ok := cache[imageURL]
+	if !ok {
+		return nil
+	}
+	img.usedAt = time.Now()
+	return img
+}
+
+// cacheStore caches an image, replacing the one cached for its URL, and drops the least
+// recently used images past maxCacheBytes
+func cacheStore(imageURL string, img *cachedImage) {
+	if len(img.body) > maxCacheBytes {
+		return
+	}
+	img.usedAt = time.Now()
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if old, ok := cache[imageURL]; ok {
+		cacheBytes -= len(old.body)
+	}
+	cache[imageURL] = img
+	cacheBytes += len(img.body)
+
+	for cacheBytes > maxCacheBytes {
+		var oldestURL string
+		var oldest *cachedImage
+		for u, c := range cache {
+			if oldest == nil || c.usedAt.Before(oldest.usedAt) {
+				oldestURL, oldest = u, c
+			}
+		}
+		cacheBytes -= len(oldest.body)
+		delete(cache, oldestURL)
+	}
+}
+
+// newCachedImage builds the cache entry of a 200 response from the CDN
+func newCachedImage(resp *http.Response, body []byte, now time.Time) *cachedImage {
+	sum := sha256.Sum256(body)
+	img := &cachedImage{
+		body:         body,
+		contentType:  resp.Header.Get("Content-Type"),
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		modTime:      now,
+		tag:          `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`,
+	}
+	if t, err := http.ParseTime(img.lastModified); err == nil {
+		img.modTime = t
+	}
+	img.freshUntil = now.Add(freshness(resp.Header))
+	return img
+}
+
+// revalidated returns a copy of img the CDN confirmed unchanged with a 304
+func (img *cachedImage) revalidated(resp *http.Response, now time.Time) *cachedImage {
+	next := *img
+	// A 304 may carry updated validators
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		next.etag = etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		next.lastModified = lastModified
+	}
+	next.freshUntil = now.Add(freshness(resp.Header))
+	return &next
+}
+
+// conditional reports whether the image can be revalidated instead of downloaded again
+func (img *cachedImage) conditional() bool {
+	return img.etag != "" || img.lastModified != ""
+}
+
+// freshness is how long a response may be served from the cache according to its
+// Cache-Control max-age
+func freshness(h http.Header) time.Duration {
+	for directive := range strings.SplitSeq(h.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "no-cache" || directive == "no-store" {
+			return 0
+		}
+		if v, ok := strings.CutPrefix(directive, "max-age="); ok {
+			if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return defaultFreshness
+}
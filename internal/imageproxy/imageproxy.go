@@ -0,0 +1,222 @@
+// Package imageproxy serves Spotify images through our own origin. Proxied URLs are
+// signed with an HMAC and expire, so the proxy can't be used to fetch arbitrary images.
+// Downloaded images are kept in memory and revalidated with the CDN once stale.
+package imageproxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/metrics"
+)
+
+const maxImageBytes = 5 << 20 // album art is ~100 KB, anything bigger isn't
+
+// allowedHostSuffixes are the CDNs Spotify serves cover and profile images from.
+// Signing already keeps other URLs out, this is a second line of defense.
+var allowedHostSuffixes = []string{".scdn.co", ".spotifycdn.com"}
+
+var (
+	errExpired      = errors.New("image URL expired")
+	errBadSignature = errors.New("invalid image URL signature")
+	errBusy         = errors.New("image proxy busy")
+)
+
+// Downloads get their own client so a slow CDN can't hold a request forever
+var imageClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchSlots bounds how many images are downloaded at once, see SetConcurrency.
+// Nil while unbounded.
+var fetchSlots chan struct{}
+
+// SetConcurrency limits the proxy to n image downloads at a time, further requests wait
+// for a free slot. Call it before serving requests.
+func SetConcurrency(n int) {
+	fetchSlots = make(chan struct{}, n)
+	metrics.PoolSize.Set(float64(n), "images")
+}
+
+// Signer creates and checks signed proxy URLs.
+type Signer struct {
+	key  []byte
+	ttl  time.Duration
+	path string // where the proxy handler is mounted, e.g. "/img"
+}
+
+// NewSigner returns a signer for a proxy mounted at path whose URLs stay valid for
+// about ttl.
+func NewSigner(key []byte, ttl time.Duration, path string) *Signer {
+	return &Signer{key: key, ttl: ttl, path: path}
+}
+
+// URL returns the signed proxy URL of an image. Anything that isn't an absolute http(s)
+// URL, like our own /static paths, is returned unchanged.
+//
+// Expiry times are rounded to a multiple of the TTL between one and two TTLs from now,
+// so for a while the same image always gets the same URL and browsers can cache it.
+func (s *Signer) URL(imageURL string) string {
+	if !strings.HasPrefix(imageURL, "https://") && !strings.HasPrefix(imageURL, "http://") {
+		return imageURL
+	}
+
+	ttl := int64(s.ttl.Seconds())
+	expires := (time.Now().Unix()/ttl + 2) * ttl
+
+	q := url.Values{}
+	q.Set("url", imageURL)
+	q.Set("exp", strconv.FormatInt(expires, 10))
+	q.Set("sig", s.sign(imageURL, expires))
+	return s.path + "?" + q.Encode()
+}
+
+// sign computes the signature of an image URL with its expiry
+func (s *Signer) sign(imageURL string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%d\n%s", expires, imageURL)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature and expiry of a proxy request and returns the image URL
+func (s *Signer) verify(q url.Values) (string, time.Time, error) {
+	imageURL := q.Get("url")
+	expires, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil || imageURL == "" {
+		return "", time.Time{}, errBadSignature
+	}
+
+	want := s.sign(imageURL, expires)
+	if !hmac.Equal([]byte(want), []byte(q.Get("sig"))) {
+		return "", time.Time{}, errBadSignature
+	}
+
+	expiry := time.Unix(expires, 0)
+	if time.Now().After(expiry) {
+		return "", time.Time{}, errExpired
+	}
+	return imageURL, expiry, nil
+}
+
+// allowedHost reports whether an image URL points at one of Spotify's image CDNs
+func allowedHost(imageURL string) bool {
+	u, err := url.Parse(imageURL)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	for _, suffix := range allowedHostSuffixes {
+		if strings.HasSuffix(u.Hostname(), suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler serves images for URLs created by the signer. Unsigned, tampered with or
+// expired URLs get a 403.
+func (s *Signer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		imageURL, expiry, err := s.verify(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if !allowedHost(imageURL) {
+			http.Error(w, "image host not allowed", http.StatusForbidden)
+			return
+		}
+
+		img, err := fetch(r, imageURL)
+		if err != nil {
+			slog.Warn("image proxy fetch failed", "url", imageURL, slog.Any("error", err))
+			if errors.Is(err, errBusy) {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, "failed to fetch image", http.StatusBadGateway)
+			return
+		}
+
+		// Cache for as long as the URL is valid; the same URL always means the same image.
+		// Browsers revalidating it get a 304 from ServeContent when the validators match.
+		maxAge := int(time.Until(expiry).Seconds())
+		w.Header().Set("Content-Type", img.contentType)
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("ETag", img.tag)
+		http.ServeContent(w, r, "", img.modTime, bytes.NewReader(img.body))
+	})
+}
+
+// fetch returns an image from the cache while it is fresh. Once it isn't, the CDN is
+// asked with the validators it sent, and only downloads the image again if it changed.
+func fetch(r *http.Request, imageURL string) (*cachedImage, error) {
+	cached := cacheLookup(imageURL)
+	now := time.Now()
+	if cached != nil && now.Before(cached.freshUntil) {
+		cacheResults.Inc("hit")
+		return cached, nil
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, errors.New("invalid image URL")
+	}
+	if cached != nil && cached.conditional() {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	if fetchSlots != nil {
+		select {
+		case fetchSlots <- struct{}{}:
+			defer func() { <-fetchSlots }()
+		case <-r.Context().Done():
+			return nil, errBusy
+		}
+	}
+	metrics.PoolBusy.Add(1, "images")
+	defer metrics.PoolBusy.Add(-1, "images")
+
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		cacheResults.Inc("revalidated")
+		img := cached.revalidated(resp, now)
+		cacheStore(imageURL, img)
+		return img, nil
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return nil, fmt.Errorf("failed to fetch image: status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	if len(body) > maxImageBytes {
+		return nil, errors.New("image too large")
+	}
+
+	cacheResults.Inc("miss")
+	img := newCachedImage(resp, body, now)
+	cacheStore(imageURL, img)
+	return img, nil
+}
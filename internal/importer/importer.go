@@ -0,0 +1,289 @@
+// Package importer brings favorites over from other services, Deezer and Tidal, into
+// the Spotify liked songs. Favorites are read from a CSV export, which every migration
+// tool and both services' data downloads produce, or for Deezer straight from the public
+// API. Each is then looked up on Spotify, by ISRC when the export has it or else by title
+// and artist, so the user can review the matches before anything is liked.
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/spotify"
+	"golang.org/x/sync/errgroup"
+)
+
+// MaxEntries is the most favorites one import looks up, every one costs a Spotify search
+const MaxEntries = 1000
+
+// searchParallelism is how many favorites are looked up at the same time
+const searchParallelism = 4
+
+var (
+	// ErrNoColumns is returned for a CSV without a title and an artist column
+	ErrNoColumns = errors.New("the file has no title and artist columns")
+	// ErrNoFavorites is returned when there is nothing to import
+	ErrNoFavorites = errors.New("no favorites found")
+	// ErrPrivateProfile is returned for Deezer profiles whose favorites aren't public
+	ErrPrivateProfile = errors.New("the Deezer profile is private or doesn't exist")
+)
+
+// Entry is one favorite on the other service
+type Entry struct {
+	Title  string
+	Artist string
+	Album  string
+	ISRC   string // empty if the export doesn't have it
+}
+
+// Match is a favorite and the Spotify track found for it
+type Match struct {
+	Entry
+	Track *spotify.Track // nil if nothing was found
+	// Sure is set when the ISRC or the title and artist agree, other matches are only
+	// Spotify's best guess and left for the user to confirm
+	Sure bool
+}
+
+// columns are the header names exports use, lowercased, for each field
+var columns = map[string][]string{
+	"title":  {"title", "track", "track name", "track title", "song", "song name", "name"},
+	"artist": {"artist", "artists", "artist name", "artist name(s)", "artist(s)"},
+	"album":  {"album", "album name", "album title"},
+	"isrc":   {"isrc"},
+}
+
+// ParseCSV reads favorites from a CSV export with a header row. The columns are found by
+// their names, so exports of Deezer, Tidal, TuneMyMusic, Soundiiz and our own all work.
+func ParseCSV(r io.Reader) ([]Entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	index := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		for field, names := range columns {
+			if _, ok := index[field]; !ok && slices.Contains(names, name) {
+				index[field] = i
+			}
+		}
+	}
+	if _, ok := index["title"]; !ok {
+		return nil, ErrNoColumns
+	}
+	if _, ok := index["artist"]; !ok {
+		return nil, ErrNoColumns
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := index[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var entries []Entry
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		e := Entry{
+			Title:  field(record, "title"),
+			Artist: field(record, "artist"),
+			Album:  field(record, "album"),
+			ISRC:   strings.ToUpper(field(record, "isrc")),
+		}
+		if e.Title == "" || e.Artist == "" {
+			continue
+		}
+		entries = append(entries, e)
+		if len(entries) == MaxEntries {
+			break
+		}
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoFavorites
+	}
+	return entries, nil
+}
+
+// deezerBaseURL is Deezer's public API, which needs no key for public profiles
+const deezerBaseURL = "https://api.deezer.com"
+
+var deezerClient = &http.Client{Timeout: 15 * time.Second}
+
+// deezerProfile finds the user ID in a profile URL like https://www.deezer.com/en/profile/123
+var deezerProfile = regexp.MustCompile(`(?:^|/profile/)(\d+)(?:[/?#]|$)`)
+
+// DeezerUserID returns the Deezer user ID in a profile URL or a bare ID, false if there is
+// none.
+func DeezerUserID(profile string) (string, bool) {
+	m := deezerProfile.FindStringSubmatch(strings.TrimSpace(profile))
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// FetchDeezer reads the favorite tracks of a public Deezer profile, most recent first.
+// Deezer's lists don't include ISRCs, so these are matched by title and artist.
+func FetchDeezer(ctx context.Context, userID string) ([]Entry, error) {
+	var entries []Entry
+	next := deezerBaseURL + "/user/" + url.PathEscape(userID) + "/tracks?limit=100"
+	for next != "" && len(entries) < MaxEntries {
+		var page struct {
+			Data []struct {
+				Title  string `json:"title"`
+				Artist struct {
+					Name string `json:"name"`
+				} `json:"artist"`
+				Album struct {
+					Title string `json:"title"`
+				} `json:"album"`
+			} `json:"data"`
+			Next  string `json:"next"`
+			Error *struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := getDeezer(ctx, next, &page); err != nil {
+			return nil, err
+		}
+		if page.Error != nil {
+			// Deezer answers 200 with an error object, e.g. for private profiles
+			return nil, fmt.Errorf("%w: %s", ErrPrivateProfile, page.Error.Message)
+		}
+		for _, t := range page.Data {
+			entries = append(entries, Entry{Title: t.Title, Artist: t.Artist.Name, Album: t.Album.Title})
+		}
+		next = page.Next
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoFavorites
+	}
+	return entries[:min(len(entries), MaxEntries)], nil
+}
+
+func getDeezer(ctx context.Context, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := deezerClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch Deezer favorites: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch Deezer favorites: status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Resolve looks the favorites up on Spotify and returns a match for each, in order. A
+// failed search fails the whole import, the user can just try again.
+func Resolve(ctx context.Context, accessToken string, entries []Entry) ([]Match, error) {
+	matches := make([]Match, len(entries))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(searchParallelism)
+	for i, e := range entries {
+		g.Go(func() error {
+			m, err := resolve(ctx, accessToken, e)
+			matches[i] = m
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// resolve finds the Spotify track of one favorite
+func resolve(ctx context.Context, accessToken string, e Entry) (Match, error) {
+	m := Match{Entry: e}
+	if e.ISRC != "" {
+		results, err := spotify.Search(ctx, accessToken, "isrc:"+e.ISRC, []string{spotify.SearchTrack}, 1)
+		if err != nil {
+			return m, err
+		}
+		if len(results.Tracks) > 0 {
+			m.Track, m.Sure = &results.Tracks[0], true
+			return m, nil
+		}
+	}
+
+	// Exports list several artists in one field, searching for the main one finds more
+	artist, _, _ := strings.Cut(e.Artist, ",")
+	artist, _, _ = strings.Cut(artist, "&")
+	query := fmt.Sprintf("track:%s artist:%s", quote(e.Title), quote(strings.TrimSpace(artist)))
+	results, err := spotify.Search(ctx, accessToken, query, []string{spotify.SearchTrack}, 5)
+	if err != nil {
+		return m, err
+	}
+	for i, t := range results.Tracks {
+		if normalize(t.Name) == normalize(e.Title) && creditsArtist(t, e.Artist) {
+			m.Track, m.Sure = &results.Tracks[i], true
+			return m, nil
+		}
+	}
+	if len(results.Tracks) > 0 {
+		m.Track = &results.Tracks[0]
+	}
+	return m, nil
+}
+
+// quote makes a search term of a title or name, dropping what would end the quotes
+func quote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+}
+
+// decorations are the parts of titles services spell differently, like "(Remastered
+// 2011)", " - Radio Edit" or "[feat. X]"
+var decorations = regexp.MustCompile(`\s*(\(.*?\)|\[.*?\]|\s-\s.*$)`)
+
+// normalize reduces a title to what every service agrees on
+func normalize(title string) string {
+	title = decorations.ReplaceAllString(strings.ToLower(title), "")
+	return strings.Join(strings.FieldsFunc(title, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+	}), " ")
+}
+
+// creditsArtist reports whether one of the artists of an export, which may list several
+// like "A, B" or "A & B", is credited on the track
+func creditsArtist(t spotify.Track, artists string) bool {
+	for _, name := range strings.FieldsFunc(artists, func(r rune) bool { return r == ',' || r == '&' || r == ';' }) {
+		name = normalize(name)
+		for _, a := range t.Artists {
+			if normalize(a.Name) == name {
+				return true
+			}
+		}
+		if normalize(t.Artist) == name {
+			return true
+		}
+	}
+	return false
+}
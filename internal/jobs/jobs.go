@@ -0,0 +1,429 @@
+// Package jobs runs background work inside the server process. Jobs wait in a queue
+// until they are due, failed jobs are retried with exponential backoff and jobs that run
+// out of attempts are kept as dead letters for admins to look at. When a data directory
+// is configured the queue is persisted, so pending work survives a restart.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+
+	"github.com/jendahorak/bangerid/internal/errorreport"
+	"github.com/jendahorak/bangerid/internal/metrics"
+)
+
+const (
+	maxAttempts = 5
+	baseBackoff = 30 * time.Second // wait after the first failure, doubled after every further one
+	maxBackoff  = time.Hour
+	jobTimeout  = 10 * time.Minute
+	keepHistory = 50          // finished and dead jobs kept for the admin page, each
+	idleWait    = time.Minute // how long idle workers sleep, Enqueue wakes them earlier
+)
+
+// State is where a job is in its life
+type State string
+
+const (
+	StatePending  State = "pending" // waiting until RunAt
+	StateRunning  State = "running"
+	StateFinished State = "finished"
+	StateDead     State = "dead" // failed permanently or ran out of attempts
+)
+
+// Job is one unit of background work
+type Job struct {
+	ID   string            `json:"id"`
+	Kind string            `json:"kind"` // selects the handler, see Register
+	Key  string            `json:"key"`  // at most one pending job per key, see Enqueue
+	Args map[string]string `json:"args,omitempty"`
+
+	State     State     `json:"state"`
+	Attempts  int       `json:"attempts"`
+	RunAt     time.Time `json:"run_at"` // when a pending job is due
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Handler does the work of one kind of job. Returning an error retries the job later,
+// unless it is wrapped with Permanent.
+type Handler func(ctx context.Context, job Job) error
+
+// permanentError marks a failure that retrying won't fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error so the job goes straight to the dead letters instead of
+// being retried, e.g. when the user it was for is gone.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// storedJobs is the on-disk format of the queue
+type storedJobs struct {
+	Queue    []*Job `json:"queue"`
+	Finished []Job  `json:"finished"`
+	Dead     []Job  `json:"dead"`
+}
+
+var (
+	queuedJobs = metrics.NewGauge(
+		"bangerid_jobs_queued",
+		"Jobs waiting or running, by kind.",
+		"kind",
+	)
+	jobRuns = metrics.NewCounter(
+		"bangerid_job_runs_total",
+		"Job runs by kind and outcome (finished, retried or dead).",
+		"kind", "outcome",
+	)
+)
+
+var (
+	mu       sync.Mutex
+	handlers = make(map[string]Handler)
+	queue    []*Job // pending and running jobs
+	finished []Job  // most recent first, at most keepHistory
+	dead     []Job  // most recent first, at most keepHistory
+
+	// storePath is the file the queue is persisted to, empty while running in memory only
+	storePath string
+
+	// wake nudges idle workers when a job was added
+	wake = make(chan struct{}, 1)
+)
+
+// Register sets the handler for a kind of job. Call it before Start.
+func Register(kind string, h Handler) {
+	mu.Lock()
+	handlers[kind] = h
+	mu.Unlock()
+}
+
+// Load restores the queue from the data directory and saves every change there from now
+// on. Jobs that were running when the server stopped are run again.
+func Load(dir string) error {
+	path := filepath.Join(dir, "jobs.json")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read jobs: %w", err)
+	}
+
+	var stored storedJobs
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &stored); err != nil {
+			// The queue only holds work that gets scheduled again anyway, start empty
+			slog.Warn("discarding persisted jobs", "path", path, slog.Any("error", err))
+			stored = storedJobs{}
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	storePath = path
+	for _, job := range stored.Queue {
+		job.State = StatePending
+		queue = append(queue, job)
+	}
+	finished = append(finished, stored.Finished...)
+	dead = append(dead, stored.Dead...)
+	slog.Info("jobs loaded", "path", path, "queued", len(queue), "dead", len(dead))
+	return nil
+}
+
+// save writes the queue to the data directory, if there is one, and updates the queue
+// metrics. mu must be held.
+func save() {
+	// Every change to the queue is saved, so this is where the gauge follows it too
+	counts := make(map[string]int)
+	for _, job := range queue {
+		counts[job.Kind]++
+	}
+	for kind := range handlers {
+		queuedJobs.Set(float64(counts[kind]), kind)
+	}
+
+	if storePath == "" {
+		return
+	}
+
+	data, err := json.Marshal(storedJobs{Queue: queue, Finished: finished, Dead: dead})
+	if err != nil {
+		slog.Error("failed to encode jobs", slog.Any("error", err))
+		return
+	}
+
+	if err := fileutil.WriteFileAtomic(storePath, data); err != nil {
+		slog.Error("failed to write jobs", slog.Any("error", err))
+	}
+}
+
+// Enqueue adds a job that is due right away. If a job with the same key is already
+// waiting it is left alone instead, so scheduling the same work twice runs it once;
+// a job that is already running doesn't count, the new one picks up later changes.
+func Enqueue(kind, key string, args map[string]string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, job := range queue {
+		if job.Key == key && job.State == StatePending {
+			return
+		}
+	}
+
+	now := time.Now()
+	queue = append(queue, &Job{
+		ID:        newID(),
+		Kind:      kind,
+		Key:       key,
+		Args:      args,
+		State:     StatePending,
+		RunAt:     now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	save()
+	notify()
+}
+
+// Debounce adds a job that is due after delay. If a job with the same key is already
+// waiting it is pushed back to run after delay instead, so a burst of calls runs the
+// work once, delay after the last one.
+func Debounce(kind, key string, args map[string]string, delay time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	for _, job := range queue {
+		if job.Key == key && job.State == StatePending {
+			if due := now.Add(delay); due.After(job.RunAt) {
+				job.RunAt = due
+			}
+			job.UpdatedAt = now
+			save()
+			return
+		}
+	}
+
+	queue = append(queue, &Job{
+		ID:        newID(),
+		Kind:      kind,
+		Key:       key,
+		Args:      args,
+		State:     StatePending,
+		RunAt:     now.Add(delay),
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	save()
+	notify()
+}
+
+// Retry moves a dead job back into the queue with a fresh set of attempts. It reports
+// false if there is no dead job with that ID.
+func Retry(id string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	i := slices.IndexFunc(dead, func(j Job) bool { return j.ID == id })
+	if i < 0 {
+		return false
+	}
+	job := dead[i]
+	dead = slices.Delete(dead, i, i+1)
+
+	job.State = StatePending
+	job.Attempts = 0
+	job.RunAt = time.Now()
+	job.UpdatedAt = job.RunAt
+	queue = append(queue, &job)
+	save()
+	notify()
+	return true
+}
+
+// notify wakes up one idle worker, if any
+func notify() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// newID returns a random job ID
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start launches workers that run due jobs until ctx is done.
+func Start(ctx context.Context, workers int) {
+	metrics.PoolSize.Set(float64(workers), "jobs")
+	for i := 0; i < workers; i++ {
+		go worker(ctx)
+	}
+}
+
+// worker runs due jobs one at a time, sleeping until the next one is due otherwise
+func worker(ctx context.Context) {
+	for {
+		job, h, wait := next()
+		if job == nil {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+
+		metrics.PoolBusy.Add(1, "jobs")
+		jobCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+		err := run(jobCtx, h, *job)
+		cancel()
+		metrics.PoolBusy.Add(-1, "jobs")
+		finish(job, err)
+	}
+}
+
+// next claims the earliest due job, or returns how long to wait until one is due.
+// Jobs of kinds nobody registered stay queued.
+func next() (*Job, Handler, time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	var due *Job
+	wait := idleWait
+	for _, job := range queue {
+		if job.State != StatePending || handlers[job.Kind] == nil {
+			continue
+		}
+		if job.RunAt.After(now) {
+			wait = min(wait, job.RunAt.Sub(now))
+			continue
+		}
+		if due == nil || job.RunAt.Before(due.RunAt) {
+			due = job
+		}
+	}
+	if due == nil {
+		return nil, nil, wait
+	}
+
+	due.State = StateRunning
+	due.Attempts++
+	due.UpdatedAt = now
+	save()
+	return due, handlers[due.Kind], 0
+}
+
+// run calls the handler, turning a panic into an error so one bad job can't take
+// the worker down
+func run(ctx context.Context, h Handler, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errorreport.Panic(r, errorreport.Tags{"job": job.Kind})
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// finish records the outcome of a job run: done, retried after a backoff or dead
+func finish(job *Job, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	job.UpdatedAt = now
+
+	if err == nil {
+		job.State = StateFinished
+		job.LastError = ""
+		jobRuns.Inc(job.Kind, "finished")
+		removeFromQueue(job)
+		finished = prepend(finished, *job)
+		save()
+		return
+	}
+
+	job.LastError = err.Error()
+	if errors.As(err, new(permanentError)) || job.Attempts >= maxAttempts {
+		slog.Error("job failed for good", "kind", job.Kind, "key", job.Key, "attempts", job.Attempts, slog.Any("error", err))
+		errorreport.Error(err, errorreport.Tags{"job": job.Kind})
+		job.State = StateDead
+		jobRuns.Inc(job.Kind, "dead")
+		removeFromQueue(job)
+		dead = prepend(dead, *job)
+		save()
+		return
+	}
+
+	backoff := min(baseBackoff<<(job.Attempts-1), maxBackoff)
+	slog.Warn("job failed, retrying", "kind", job.Kind, "key", job.Key, "attempt", job.Attempts, "in", backoff, slog.Any("error", err))
+	job.State = StatePending
+	job.RunAt = now.Add(backoff)
+	jobRuns.Inc(job.Kind, "retried")
+	save()
+}
+
+// removeFromQueue drops a job from the queue. mu must be held.
+func removeFromQueue(job *Job) {
+	queue = slices.DeleteFunc(queue, func(j *Job) bool { return j == job })
+}
+
+// prepend adds a job in front of a history list, dropping the oldest beyond keepHistory
+func prepend(list []Job, job Job) []Job {
+	list = append([]Job{job}, list...)
+	if len(list) > keepHistory {
+		list = list[:keepHistory]
+	}
+	return list
+}
+
+// Status is a snapshot of the queue for the admin page
+type Status struct {
+	Queue    []Job // pending and running jobs, due first
+	Finished []Job // most recent first
+	Dead     []Job // most recent first
+}
+
+// Report returns the current state of the queue.
+func Report() Status {
+	mu.Lock()
+	defer mu.Unlock()
+
+	status := Status{
+		Finished: slices.Clone(finished),
+		Dead:     slices.Clone(dead),
+	}
+	for _, job := range queue {
+		status.Queue = append(status.Queue, *job)
+	}
+	slices.SortFunc(status.Queue, func(a, b Job) int {
+		return a.RunAt.Compare(b.RunAt)
+	})
+	return status
+}
@@ -0,0 +1,226 @@
+// Package kiosks keeps the displays users paired to show what they play, like a tablet
+// on the wall. Displays have no keyboard to sign in with, so they pair the way TVs do: a
+// display asks for a short code and shows it, the user enters it where they are signed
+// in, and the display collects a token that opens its read-only page and nothing else.
+// Only a hash of each token is kept. When a data directory is configured the paired
+// displays are persisted there, in a single file; pending pairings live in memory.
+package kiosks
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+	"github.com/jendahorak/bangerid/internal/shortcode"
+)
+
+// pairingTTL is how long a display's code can be entered
+const pairingTTL = 10 * time.Minute
+
+// Kiosk is a paired display
+type Kiosk struct {
+	ID       string    `json:"id"`   // to unpair it by, not a secret
+	Hash     string    `json:"hash"` // SHA-256 of the display's token, hex encoded
+	Name     string    `json:"name"` // what the user called it, e.g. "Kitchen"
+	PairedAt time.Time `json:"paired_at"`
+}
+
+// pairing is a display waiting for its code to be entered
+type pairing struct {
+	code      string
+	expiresAt time.Time
+	// Set once the user entered the code
+	userID string
+	name   string
+}
+
+var (
+	mu       sync.Mutex
+	kiosks   = make(map[string][]Kiosk)  // keyed by Spotify user ID
+	pairings = make(map[string]*pairing) // keyed by the secret of the display that asked
+
+	// storePath is the file paired displays are persisted to, empty while running in
+	// memory only
+	storePath string
+)
+
+// Load restores the paired displays from the data directory and saves every change there
+// from now on.
+func Load(dir string) error {
+	path := filepath.Join(dir, "kiosks.json")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read kiosks: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &kiosks); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	storePath = path
+	return nil
+}
+
+// RequestPairing starts pairing a display. It returns the code to show and a secret the
+// display keeps for collecting its token with Collect, so only it can.
+func RequestPairing() (code, secret string) {
+	code = shortcode.New(8)
+	secret = randomToken()
+
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	for s, p := range pairings {
+		if now.After(p.expiresAt) {
+			delete(pairings, s)
+		}
+	}
+	pairings[secret] = &pairing{code: code, expiresAt: now.Add(pairingTTL)}
+	return code, secret
+}
+
+// PendingCode returns the code of the display with the secret while it waits for it to
+// be entered. It reports false once the pairing expired.
+func PendingCode(secret string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	p, ok := pairings[secret]
+	if !ok || time.Now().After(p.expiresAt) {
+		return "", false
+	}
+	return p.code, true
+}
+
+// Approve pairs the display showing code with the user, under a name. It reports false
+// for codes no display is showing.
+func Approve(userID, code, name string) bool {
+	code = strings.ToUpper(strings.Join(strings.Fields(strings.ReplaceAll(code, "-", "")), ""))
+
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	for _, p := range pairings {
+		if p.userID == "" && !now.After(p.expiresAt) && subtle.ConstantTimeCompare([]byte(p.code), []byte(code)) == 1 {
+			p.userID, p.name = userID, name
+			return true
+		}
+	}
+	return false
+}
+
+// Collect returns the token of the display with the secret once its code was entered,
+// pairing it for good, the pairing is gone afterwards. The token is empty until then.
+func Collect(secret string) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	p, ok := pairings[secret]
+	if !ok || p.userID == "" {
+		return "", nil
+	}
+	delete(pairings, secret)
+
+	token := randomToken()
+	kiosks[p.userID] = append(kiosks[p.userID], Kiosk{
+		ID:       randomToken()[:12],
+		Hash:     hash(token),
+		Name:     p.name,
+		PairedAt: time.Now(),
+	})
+	return token, save()
+}
+
+// Lookup returns the user a display's token belongs to. It reports false for unknown
+// tokens, like those of unpaired displays.
+func Lookup(token string) (string, bool) {
+	h := []byte(hash(token))
+
+	mu.Lock()
+	defer mu.Unlock()
+	for userID, ks := range kiosks {
+		for _, k := range ks {
+			if subtle.ConstantTimeCompare([]byte(k.Hash), h) == 1 {
+				return userID, true
+			}
+		}
+	}
+	return "", false
+}
+
+// List returns the user's paired displays, the first paired first
+func List(userID string) []Kiosk {
+	mu.Lock()
+	defer mu.Unlock()
+	return slices.Clone(kiosks[userID])
+}
+
+// Unpair forgets one of the user's displays, it shows the pairing code again.
+func Unpair(userID, id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	kiosks[userID] = slices.DeleteFunc(kiosks[userID], func(k Kiosk) bool { return k.ID == id })
+	if len(kiosks[userID]) == 0 {
+		delete(kiosks, userID)
+	}
+	return save()
+}
+
+// Delete forgets all of the user's displays.
+func Delete(userID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(kiosks, userID)
+	for s, p := range pairings {
+		if p.userID == userID {
+			delete(pairings, s)
+		}
+	}
+	return save()
+}
+
+func randomToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// save writes all paired displays to the data directory, if there is one. mu must be
+// held.
+func save() error {
+	if storePath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(kiosks)
+	if err != nil {
+		return fmt.Errorf("failed to encode kiosks: %w", err)
+	}
+
+	if err := fileutil.WriteFileAtomic(storePath, data); err != nil {
+		return fmt.Errorf("failed to write kiosks: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package library
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+	"github.com/jendahorak/bangerid/internal/spotify"
+	"github.com/jendahorak/bangerid/internal/timeline"
+)
+
+// storeDir is the directory libraries are persisted to, one JSON file per user.
+// Empty while running in memory only. Guarded by mu.
+var storeDir string
+
+// Load restores every persisted library from the data directory and keeps saving
+// libraries there after each sync, so a restarted server serves warm data right away.
+func Load(dir string) error {
+	dir = filepath.Join(dir, "libraries")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create library directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list libraries: %w", err)
+	}
+
+	loaded := make(map[string]*Library)
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		userID, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+
+		lib, err := readLibrary(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			// One broken file shouldn't keep the server from starting, that user just syncs again
+			slog.Warn("skipping persisted library", "user", userID, slog.Any("error", err))
+			continue
+		}
+		loaded[userID] = lib
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	storeDir = dir
+	for userID, lib := range loaded {
+		libraries[userID] = lib
+	}
+	slog.Info("libraries loaded", "dir", dir, "count", len(loaded))
+	return nil
+}
+
+// LoadDemo installs the library stored at path (in the same format as persisted libraries)
+// as the library of userID, with every section marked as synced so it is never fetched.
+// Dates are shifted so the newest liked track was added just now and the sample doesn't
+// age while it is being shown.
+func LoadDemo(path, userID string) error {
+	lib, err := readLibrary(path)
+	if err != nil {
+		return fmt.Errorf("failed to load demo library: %w", err)
+	}
+
+	var newest time.Time
+	for _, t := range lib.Tracks {
+		if t.AddedAt.After(newest) {
+			newest = t.AddedAt
+		}
+	}
+	if !newest.IsZero() {
+		shift := time.Since(newest)
+		for i := range lib.Tracks {
+			if !lib.Tracks[i].AddedAt.IsZero() {
+				lib.Tracks[i].AddedAt = lib.Tracks[i].AddedAt.Add(shift)
+			}
+		}
+	}
+
+	for _, s := range EnabledSections() {
+		if _, ok := lib.SyncedAt[s]; !ok {
+			lib.SyncedAt[s] = time.Now()
+		}
+	}
+
+	mu.Lock()
+	libraries[userID] = lib
+	mu.Unlock()
+
+	// Seed the timeline too, the demo is never synced
+	return timeline.Observe(userID, lib.Tracks, time.Now())
+}
+
+// readLibrary decodes one persisted library
+func readLibrary(path string) (*Library, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lib Library
+	if err := json.Unmarshal(data, &lib); err != nil {
+		return nil, err
+	}
+	if lib.SyncedAt == nil {
+		lib.SyncedAt = make(map[Section]time.Time)
+	}
+	if lib.Features == nil {
+		lib.Features = make(map[string]spotify.AudioFeatures)
+	}
+	if lib.TrackArtists == nil {
+		lib.TrackArtists = make(map[string]spotify.Artist)
+	}
+	return &lib, nil
+}
+
+// Save writes the user's library to the data directory, if there is one.
+func Save(userID string) error {
+	mu.Lock()
+	if storeDir == "" {
+		mu.Unlock()
+		return nil
+	}
+	path := filepath.Join(storeDir, url.PathEscape(userID)+".json")
+	data, err := json.Marshal(libraryFor(userID))
+	mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode library: %w", err)
+	}
+
+	// Syncs of the same user can overlap, WriteFileAtomic gives each write its own
+	// temporary file
+	if err := fileutil.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write library: %w", err)
+	}
+	return nil
+}
+
+// Delete forgets the user's library, on disk too. A sync still running for the user
+// writes it back when it finishes, so suspend the user first.
+func Delete(userID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(libraries, userID)
+	for key := range failedSyncs {
+		if key.userID == userID {
+			delete(failedSyncs, key)
+		}
+	}
+	if storeDir == "" {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(storeDir, url.PathEscape(userID)+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete library: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Package metrics is a tiny registry of counters and gauges exposed in the Prometheus
+// text format, enough to graph and alert on without pulling in a client library.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metric is a counter or gauge with an optional set of labels.
+type Metric struct {
+	name   string
+	help   string
+	kind   string // "counter" or "gauge"
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64 // keyed by label values joined with \xff
+
+	// collect computes the values on every scrape instead, see NewGaugeFunc
+	collect func(set func(v float64, labelValues ...string))
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []*Metric
+)
+
+func register(name, help, kind string, labels []string) *Metric {
+	m := &Metric{name: name, help: help, kind: kind, labels: labels, values: make(map[string]float64)}
+
+	registryMu.Lock()
+	registry = append(registry, m)
+	registryMu.Unlock()
+	return m
+}
+
+// NewCounter registers a counter, a value that only goes up.
+func NewCounter(name, help string, labels ...string) *Metric {
+	return register(name, help, "counter", labels)
+}
+
+// NewGauge registers a gauge, a value that can go up and down.
+func NewGauge(name, help string, labels ...string) *Metric {
+	return register(name, help, "gauge", labels)
+}
+
+// NewGaugeFunc registers a gauge computed on every scrape, for values like ages that
+// change without anything happening. collect calls set once per label combination; the
+// ones it leaves out aren't exposed.
+func NewGaugeFunc(name, help string, collect func(set func(v float64, labelValues ...string)), labels ...string) *Metric {
+	m := register(name, help, "gauge", labels)
+	m.collect = collect
+	return m
+}
+
+// Worker pools report their configured size and how many of their workers are busy,
+// labelled by pool name, so it shows whether the concurrency settings fit the machine.
+var (
+	PoolSize = NewGauge("bangerid_worker_pool_size", "Configured concurrency of a background worker pool.", "pool")
+	PoolBusy = NewGauge("bangerid_worker_pool_busy", "Workers of a pool currently doing work.", "pool")
+)
+
+// Inc adds one. labelValues must match the labels the metric was registered with.
+func (m *Metric) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+// Add adds v (which must not be negative for counters).
+func (m *Metric) Add(v float64, labelValues ...string) {
+	m.mu.Lock()
+	m.values[strings.Join(labelValues, "\xff")] += v
+	m.mu.Unlock()
+}
+
+// Set replaces the value of a gauge.
+func (m *Metric) Set(v float64, labelValues ...string) {
+	m.mu.Lock()
+	m.values[strings.Join(labelValues, "\xff")] = v
+	m.mu.Unlock()
+}
+
+// Delete removes one label combination, e.g. for a user that no longer exists.
+func (m *Metric) Delete(labelValues ...string) {
+	m.mu.Lock()
+	delete(m.values, strings.Join(labelValues, "\xff"))
+	m.mu.Unlock()
+}
+
+// write appends the metric in the Prometheus text exposition format
+func (m *Metric) write(sb *strings.Builder) {
+	if m.collect != nil {
+		values := make(map[string]float64)
+		m.collect(func(v float64, labelValues ...string) {
+			values[strings.Join(labelValues, "\xff")] = v
+		})
+		m.mu.Lock()
+		m.values = values
+		m.mu.Unlock()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		sb.WriteString(m.name)
+		if len(m.labels) > 0 {
+			values := strings.Split(k, "\xff")
+			sb.WriteByte('{')
+			for i, label := range m.labels {
+				if i > 0 {
+					sb.WriteByte(',')
+				}
+				value := ""
+				if i < len(values) {
+					value = values[i]
+				}
+				fmt.Fprintf(sb, "%s=%q", label, value)
+			}
+			sb.WriteByte('}')
+		}
+		fmt.Fprintf(sb, " %g\n", m.values[k])
+	}
+}
+
+// Handler serves all registered metrics for Prometheus to scrape.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryMu.Lock()
+		metrics := append([]*Metric(nil), registry...)
+		registryMu.Unlock()
+
+		var sb strings.Builder
+		for _, m := range metrics {
+			m.write(&sb)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(sb.String()))
+	})
+}
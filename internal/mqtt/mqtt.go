@@ -0,0 +1,246 @@
+// Package mqtt publishes messages to an MQTT broker, like the Mosquitto add-on of Home
+// Assistant. It is a minimal MQTT 3.1.1 client: QoS 0 publishing with retained messages
+// and a last will, which is all that announcing state takes. It doesn't subscribe.
+//
+// The connection is made on the first publish and made again after it breaks, so a
+// broker that restarts only costs the messages published while it was down.
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 10 * time.Second
+
+// Packet types, shifted into the high nibble of the first byte
+const (
+	packetConnect    = 1 << 4
+	packetConnack    = 2 << 4
+	packetPublish    = 3 << 4
+	packetDisconnect = 14 << 4
+)
+
+// Config is how to reach the broker and what it announces when the client goes away
+type Config struct {
+	// Broker is the address, mqtt://host:1883 or mqtts://host:8883 for TLS. A bare
+	// host:port is plain MQTT.
+	Broker   string
+	ClientID string
+	Username string // may be empty, for brokers that allow anonymous clients
+	Password string
+	// WillTopic and WillPayload are published, retained, by the broker when the
+	// connection drops without a goodbye. Empty for none. OnlinePayload is published
+	// there by the client every time it connects, so the topic says whether it is around.
+	WillTopic     string
+	WillPayload   []byte
+	OnlinePayload []byte
+}
+
+// Client publishes to one broker. It is safe for concurrent use.
+type Client struct {
+	config Config
+	addr   string
+	tls    bool
+
+	mu   sync.Mutex
+	conn net.Conn // nil while disconnected
+}
+
+// New returns a client for the broker in config. It doesn't connect yet.
+func New(config Config) (*Client, error) {
+	broker := config.Broker
+	if !strings.Contains(broker, "://") {
+		broker = "mqtt://" + broker
+	}
+	u, err := url.Parse(broker)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid MQTT broker %q: expected mqtt://host:port", config.Broker)
+	}
+
+	c := &Client{config: config, addr: u.Host}
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		if u.Port() == "" {
+			c.addr = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	case "mqtts", "ssl", "tls":
+		c.tls = true
+		if u.Port() == "" {
+			c.addr = net.JoinHostPort(u.Hostname(), "8883")
+		}
+	default:
+		return nil, fmt.Errorf("invalid MQTT broker %q: unknown scheme %s", config.Broker, u.Scheme)
+	}
+	return c, nil
+}
+
+// Publish sends a message with QoS 0, connecting first if needed. Retained messages are
+// kept by the broker and handed to whoever subscribes later.
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte, retain bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	var flags byte
+	if retain {
+		flags = 1
+	}
+	var body bytes.Buffer
+	writeString(&body, topic)
+	body.Write(payload)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+	} else {
+		c.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	}
+	if _, err := c.conn.Write(packet(packetPublish|flags, body.Bytes())); err != nil {
+		// Dropped, the next publish reconnects
+		c.conn.Close()
+		c.conn = nil
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Close says goodbye to the broker, so it doesn't publish the last will.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	c.conn.Write(packet(packetDisconnect, nil))
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// connect dials the broker and sends CONNECT. mu must be held.
+func (c *Client) connect(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if c.tls {
+		host, _, _ := net.SplitHostPort(c.addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	// Variable header: protocol name and level, connect flags, keep alive. A keep alive
+	// of 0 turns it off, the client has nothing to say between publishes.
+	var body bytes.Buffer
+	writeString(&body, "MQTT")
+	body.WriteByte(4)
+	flags := byte(0x02) // clean session
+	if c.config.WillTopic != "" {
+		flags |= 0x04 | 0x20 // will, retained, QoS 0
+	}
+	if c.config.Username != "" {
+		flags |= 0x80
+		if c.config.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body.WriteByte(flags)
+	body.Write([]byte{0, 0})
+
+	writeString(&body, c.config.ClientID)
+	if c.config.WillTopic != "" {
+		writeString(&body, c.config.WillTopic)
+		writeString(&body, string(c.config.WillPayload))
+	}
+	if c.config.Username != "" {
+		writeString(&body, c.config.Username)
+		if c.config.Password != "" {
+			writeString(&body, c.config.Password)
+		}
+	}
+
+	if _, err := conn.Write(packet(packetConnect, body.Bytes())); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connack); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	if connack[0] != packetConnack || connack[3] != 0 {
+		conn.Close()
+		return connackError(connack[3])
+	}
+
+	if c.config.WillTopic != "" && c.config.OnlinePayload != nil {
+		var online bytes.Buffer
+		writeString(&online, c.config.WillTopic)
+		online.Write(c.config.OnlinePayload)
+		if _, err := conn.Write(packet(packetPublish|1, online.Bytes())); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	c.conn = conn
+	return nil
+}
+
+// connackError describes a refused connection by its return code
+func connackError(code byte) error {
+	switch code {
+	case 4:
+		return errors.New("MQTT broker refused the username or password")
+	case 5:
+		return errors.New("MQTT broker refused the client, it isn't authorized")
+	default:
+		return fmt.Errorf("MQTT broker refused the connection, code %d", code)
+	}
+}
+
+// packet frames a body with the fixed header: the type and flags, then the remaining
+// length in MQTT's variable length encoding
+func packet(header byte, body []byte) []byte {
+	b := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, body...)
+}
+
+// writeString writes a length prefixed UTF-8 string
+func writeString(b *bytes.Buffer, s string) {
+	b.Write([]byte{byte(len(s) >> 8), byte(len(s))})
+	b.WriteString(s)
+}
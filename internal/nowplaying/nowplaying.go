@@ -0,0 +1,202 @@
+// Package nowplaying keeps the player state of users fresh without asking Spotify once
+// per tab. However many pages, tabs or devices of a user want to know what is playing,
+// there is a single upstream poll per user: streaming subscribers (server-sent events,
+// WebSockets) get every result fanned out to them, and polling handlers share the result
+// of the last poll or of the one in flight.
+package nowplaying
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/metrics"
+)
+
+// fetchTimeout bounds a single upstream poll
+const fetchTimeout = 10 * time.Second
+
+var polls = metrics.NewCounter(
+	"bangerid_nowplaying_polls_total",
+	"Upstream player state polls, by result.",
+	"result",
+)
+
+// Fetch asks upstream for a user's current state
+type Fetch[T any] func(ctx context.Context, userID string) (T, error)
+
+// Poller coalesces the polling of a state of type T, per user
+type Poller[T any] struct {
+	interval time.Duration // how long a result stays fresh, and how often streams poll
+	fetch    Fetch[T]
+
+	mu    sync.Mutex
+	users map[string]*user[T]
+}
+
+// user is the shared polling state of one user. Guarded by the poller's mu.
+type user[T any] struct {
+	latest    T
+	err       error
+	fetchedAt time.Time
+	inflight  chan struct{} // closed when the poll in flight finishes, nil if none
+
+	subscribers map[chan T]struct{}
+	stop        chan struct{} // closed to end the stream loop, nil while nobody subscribes
+}
+
+// New returns a poller that fetches at most once per interval and user.
+func New[T any](interval time.Duration, fetch Fetch[T]) *Poller[T] {
+	return &Poller[T]{
+		interval: interval,
+		fetch:    fetch,
+		users:    make(map[string]*user[T]),
+	}
+}
+
+// userLocked returns the state of a user, creating it. mu must be held.
+func (p *Poller[T]) userLocked(userID string) *user[T] {
+	u, ok := p.users[userID]
+	if !ok {
+		p.forgetStaleLocked()
+		u = &user[T]{subscribers: make(map[chan T]struct{})}
+		p.users[userID] = u
+	}
+	return u
+}
+
+// forgetStaleLocked drops the users nobody streams whose state went stale, so the map
+// doesn't keep everyone who ever looked. mu must be held.
+func (p *Poller[T]) forgetStaleLocked() {
+	for userID, u := range p.users {
+		if u.stop == nil && u.inflight == nil && time.Since(u.fetchedAt) >= p.interval {
+			delete(p.users, userID)
+		}
+	}
+}
+
+// Get returns the user's state, fetched within the last interval. Concurrent callers wait
+// for the same poll instead of starting their own.
+func (p *Poller[T]) Get(ctx context.Context, userID string) (T, error) {
+	p.mu.Lock()
+	u := p.userLocked(userID)
+	if !u.fetchedAt.IsZero() && time.Since(u.fetchedAt) < p.interval {
+		latest, err := u.latest, u.err
+		p.mu.Unlock()
+		return latest, err
+	}
+	done := p.startFetchLocked(userID, u)
+	p.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return u.latest, u.err
+}
+
+// Subscribe streams the user's state, once per interval starting right away, until
+// unsubscribe is called. A subscriber that falls behind only gets the newest state.
+func (p *Poller[T]) Subscribe(userID string) (updates <-chan T, unsubscribe func()) {
+	ch := make(chan T, 1)
+
+	p.mu.Lock()
+	u := p.userLocked(userID)
+	u.subscribers[ch] = struct{}{}
+	if u.stop == nil {
+		u.stop = make(chan struct{})
+		go p.stream(userID, u, u.stop)
+	} else if !u.fetchedAt.IsZero() && u.err == nil {
+		// Don't keep a new tab waiting for the next tick
+		ch <- u.latest
+	}
+	p.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			delete(u.subscribers, ch)
+			if len(u.subscribers) == 0 {
+				close(u.stop)
+				u.stop = nil
+			}
+		})
+	}
+}
+
+// stream polls for the user's subscribers every interval until stop is closed
+func (p *Poller[T]) stream(userID string, u *user[T], stop chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.mu.Lock()
+		done := p.startFetchLocked(userID, u)
+		p.mu.Unlock()
+		select {
+		case <-done:
+		case <-stop:
+			return
+		}
+
+		p.mu.Lock()
+		if u.err == nil {
+			for ch := range u.subscribers {
+				send(ch, u.latest)
+			}
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// startFetchLocked starts a poll for the user unless one is in flight, and returns a
+// channel closed when it finishes. mu must be held.
+func (p *Poller[T]) startFetchLocked(userID string, u *user[T]) chan struct{} {
+	if u.inflight != nil {
+		return u.inflight
+	}
+	done := make(chan struct{})
+	u.inflight = done
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+		latest, err := p.fetch(ctx, userID)
+		if err != nil {
+			polls.Inc("error")
+			slog.Warn("failed to poll player state", "user", userID, slog.Any("error", err))
+		} else {
+			polls.Inc("ok")
+		}
+
+		p.mu.Lock()
+		u.latest, u.err, u.fetchedAt = latest, err, time.Now()
+		u.inflight = nil
+		close(done)
+		p.mu.Unlock()
+	}()
+	return done
+}
+
+// send hands a state to a subscriber, replacing one it hasn't read yet
+func send[T any](ch chan T, v T) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- v
+}
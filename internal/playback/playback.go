@@ -0,0 +1,63 @@
+// Package playback carries out the grid's play actions. Starting, pausing and resuming
+// go through the Player interface so the backend can be swapped: SpotifyConnect plays on
+// the user's Spotify devices and is what bangerid uses, other players, like one for a
+// local MPD or the 30 second previews, only need to implement the interface.
+package playback
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoDevice is returned when there is nothing to play on
+var ErrNoDevice = errors.New("no device to play on")
+
+// Player starts and stops playback for a user
+type Player interface {
+	// Play replaces what plays with items
+	Play(ctx context.Context, target Target, items Items) error
+	// Queue plays a track or episode after what plays, without interrupting it
+	Queue(ctx context.Context, target Target, uri string) error
+	// Pause pauses what plays, Resume continues it where it stopped
+	Pause(ctx context.Context, target Target) error
+	Resume(ctx context.Context, target Target) error
+	// Next and Previous skip within what plays
+	Next(ctx context.Context, target Target) error
+	Previous(ctx context.Context, target Target) error
+	// Seek jumps to a position in the track playing
+	Seek(ctx context.Context, target Target, position time.Duration) error
+	// SetVolume sets the loudness, from 0 to 100 percent
+	SetVolume(ctx context.Context, target Target, percent int) error
+	// SetShuffle plays what plays in random order, or back in order
+	SetShuffle(ctx context.Context, target Target, shuffle bool) error
+	// SetRepeat loops the track or what plays, see the Repeat modes
+	SetRepeat(ctx context.Context, target Target, mode Repeat) error
+}
+
+// Repeat is a repeat mode
+type Repeat string
+
+const (
+	RepeatOff     Repeat = "off"
+	RepeatTrack   Repeat = "track"   // the track playing, over and over
+	RepeatContext Repeat = "context" // the album, playlist or tracks playing, from the start when they end
+)
+
+// Target is whose playback to control and where
+type Target struct {
+	AccessToken string // the user's Spotify token
+	// DeviceID is the device the page plays on, e.g. the in-browser player. Empty for
+	// whichever device the user is playing on.
+	DeviceID string
+	// FindDevice allows looking for a device when DeviceID is empty, it needs the
+	// user-read-playback-state scope for Spotify
+	FindDevice bool
+}
+
+// Items is what to play: tracks or episodes in order, or a whole album, artist,
+// playlist or audiobook
+type Items struct {
+	URIs       []string
+	ContextURI string // takes precedence over URIs
+}
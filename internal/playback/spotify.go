@@ -0,0 +1,80 @@
+package playback
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// SpotifyConnect plays on the user's Spotify Connect devices, the in-browser Web Playback
+// SDK player being one of them
+type SpotifyConnect struct{}
+
+// Play starts items on the target device. Without one, e.g. while the in-browser player
+// is still connecting, it plays on whichever of the user's devices is active.
+func (SpotifyConnect) Play(ctx context.Context, target Target, items Items) error {
+	deviceID := target.DeviceID
+	if deviceID == "" && target.FindDevice {
+		devices, err := spotify.GetDevices(ctx, target.AccessToken)
+		if err != nil {
+			slog.Warn("failed to fetch devices", slog.Any("error", err))
+		} else if len(devices) > 0 {
+			deviceID = devices[0].ID
+		}
+	}
+	if deviceID == "" {
+		return ErrNoDevice
+	}
+
+	if items.ContextURI != "" {
+		return spotify.PlayContext(ctx, target.AccessToken, deviceID, items.ContextURI)
+	}
+	return spotify.PlayTracks(ctx, target.AccessToken, deviceID, items.URIs)
+}
+
+// Queue adds to the queue of the target device, or the active one
+func (SpotifyConnect) Queue(ctx context.Context, target Target, uri string) error {
+	return spotify.AddToQueue(ctx, target.AccessToken, target.DeviceID, uri)
+}
+
+// Pause pauses the target device, or the active one
+func (SpotifyConnect) Pause(ctx context.Context, target Target) error {
+	return spotify.PauseTrack(ctx, target.AccessToken, target.DeviceID)
+}
+
+// Resume continues playback on the target device, or the active one
+func (SpotifyConnect) Resume(ctx context.Context, target Target) error {
+	return spotify.ResumePlayback(ctx, target.AccessToken, target.DeviceID)
+}
+
+// Next skips to the next track on the target device, or the active one
+func (SpotifyConnect) Next(ctx context.Context, target Target) error {
+	return spotify.SkipToNext(ctx, target.AccessToken, target.DeviceID)
+}
+
+// Previous skips to the previous track on the target device, or the active one
+func (SpotifyConnect) Previous(ctx context.Context, target Target) error {
+	return spotify.SkipToPrevious(ctx, target.AccessToken, target.DeviceID)
+}
+
+// Seek jumps to a position on the target device, or the active one
+func (SpotifyConnect) Seek(ctx context.Context, target Target, position time.Duration) error {
+	return spotify.SeekToPosition(ctx, target.AccessToken, target.DeviceID, int(position.Milliseconds()))
+}
+
+// SetVolume sets the volume of the target device, or the active one
+func (SpotifyConnect) SetVolume(ctx context.Context, target Target, percent int) error {
+	return spotify.SetVolume(ctx, target.AccessToken, target.DeviceID, percent)
+}
+
+// SetShuffle turns shuffle on or off on the target device, or the active one
+func (SpotifyConnect) SetShuffle(ctx context.Context, target Target, shuffle bool) error {
+	return spotify.SetShuffle(ctx, target.AccessToken, target.DeviceID, shuffle)
+}
+
+// SetRepeat sets the repeat mode of the target device, or the active one
+func (SpotifyConnect) SetRepeat(ctx context.Context, target Target, mode Repeat) error {
+	return spotify.SetRepeat(ctx, target.AccessToken, target.DeviceID, string(mode))
+}
@@ -0,0 +1,110 @@
+// Package prefs stores per-user preferences. When a data directory is configured they
+// are persisted there, in a single file since there is little of them.
+package prefs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+)
+
+// Prefs are the settings a user can change
+type Prefs struct {
+	// SortLanguage is the BCP 47 tag names are sorted by; empty follows the browser
+	SortLanguage string `json:"sort_language,omitempty"`
+	// TileSize names the grid density, e.g. "cozy"; empty is the default one
+	TileSize string `json:"tile_size,omitempty"`
+	// TimeZone is the IANA name of the user's time zone, e.g. "Europe/Prague", detected
+	// from the browser until the user confirms one in the settings; empty is UTC
+	TimeZone          string `json:"time_zone,omitempty"`
+	TimeZoneConfirmed bool   `json:"time_zone_confirmed,omitempty"`
+}
+
+// Location returns the user's time zone, dates like when tracks were liked are told in
+// it. UTC when none is known.
+func (p Prefs) Location() *time.Location {
+	if p.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+var (
+	mu    sync.Mutex
+	prefs = make(map[string]Prefs) // keyed by Spotify user ID
+
+	// storePath is the file preferences are persisted to, empty while running in memory only
+	storePath string
+)
+
+// Load restores the preferences from the data directory and saves every change there from now on.
+func Load(dir string) error {
+	path := filepath.Join(dir, "prefs.json")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read preferences: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &prefs); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	storePath = path
+	return nil
+}
+
+// Get returns the user's preferences, the zero value if they never changed any.
+func Get(userID string) Prefs {
+	mu.Lock()
+	defer mu.Unlock()
+	return prefs[userID]
+}
+
+// Set replaces the user's preferences.
+func Set(userID string, p Prefs) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	prefs[userID] = p
+	return save()
+}
+
+// Delete forgets the user's preferences.
+func Delete(userID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(prefs, userID)
+	return save()
+}
+
+// save writes all preferences to the data directory, if there is one. mu must be held.
+func save() error {
+	if storePath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return fmt.Errorf("failed to encode preferences: %w", err)
+	}
+
+	if err := fileutil.WriteFileAtomic(storePath, data); err != nil {
+		return fmt.Errorf("failed to write preferences: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// systemPrompt tells the model how to answer. It only ever sees the user's request,
+// never their library.
+const systemPrompt = `You turn requests for music into a JSON filter over someone's liked songs.
+Answer with a single JSON object and nothing else. Leave out what the request doesn't ask for.
+Fields:
+- year_from, year_to: release years, e.g. "90s" is 1990 to 1999
+- genres: Spotify genre words, e.g. ["rock"], ["house", "techno"]
+- artists: artist names, only when the request names them
+- min_energy, max_energy: 0 to 1, "bangers" and workouts want at least 0.7, calm music at most 0.4
+- min_valence, max_valence: 0 to 1, how happy it sounds, sad is at most 0.35, happy at least 0.65
+- min_danceability: 0 to 1
+- min_tempo, max_tempo: BPM, running is roughly 150 to 180
+Example: "sad 90s bangers for a rainy run" is
+{"year_from": 1990, "year_to": 1999, "min_energy": 0.7, "max_valence": 0.35, "min_tempo": 140}`
+
+// OpenAI interprets prompts with a chat completions API, OpenAI's own or a compatible
+// server's such as a local model's
+type OpenAI struct {
+	baseURL string // e.g. https://api.openai.com/v1
+	apiKey  string // may be empty for local servers
+	model   string
+	client  *http.Client
+}
+
+// NewOpenAI returns an interpreter for the chat completions API under baseURL, asking model.
+func NewOpenAI(baseURL, apiKey, model string) *OpenAI {
+	return &OpenAI{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model          string        `json:"model"`
+	Messages       []chatMessage `json:"messages"`
+	Temperature    float64       `json:"temperature"`
+	ResponseFormat struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// Interpret asks the model for the filter a prompt describes
+func (o *OpenAI) Interpret(ctx context.Context, prompt string) (Filter, error) {
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		return Filter{}, ErrEmptyPrompt
+	}
+
+	request := chatRequest{
+		Model: o.model,
+		Messages: []chatMessage{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: prompt},
+		},
+	}
+	request.ResponseFormat.Type = "json_object"
+	body, err := json.Marshal(request)
+	if err != nil {
+		return Filter{}, fmt.Errorf("failed to encode completion request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return Filter{}, fmt.Errorf("failed to create completion request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return Filter{}, fmt.Errorf("failed to request completion: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Filter{}, fmt.Errorf("completion request failed with status %d: %s", resp.StatusCode, detail)
+	}
+
+	var completion chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return Filter{}, fmt.Errorf("failed to decode completion: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return Filter{}, fmt.Errorf("completion has no choices")
+	}
+
+	// Some servers ignore response_format and wrap the JSON in a Markdown code block
+	content := strings.TrimSpace(completion.Choices[0].Message.Content)
+	content = strings.TrimPrefix(content, "```json")
+	content = strings.Trim(content, "`\n ")
+
+	var filter Filter
+	if err := json.Unmarshal([]byte(content), &filter); err != nil {
+		return Filter{}, fmt.Errorf("model answered with an invalid filter: %w", err)
+	}
+	return filter.clamp(), nil
+}
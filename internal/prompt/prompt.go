@@ -0,0 +1,142 @@
+// Package prompt turns free-text requests for music, like "sad 90s bangers for a rainy
+// run", into a Filter the user's library is searched with. Understanding the request is
+// left to a language model behind the Interpreter interface so providers can be swapped;
+// OpenAI talks to the OpenAI chat completions API and the many servers compatible with it.
+package prompt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// ErrEmptyPrompt is returned for prompts with nothing to interpret
+var ErrEmptyPrompt = errors.New("empty prompt")
+
+// Interpreter turns a prompt into a filter over the library
+type Interpreter interface {
+	Interpret(ctx context.Context, prompt string) (Filter, error)
+}
+
+// Filter describes the tracks a prompt asks for. Zero values don't filter. Energy,
+// valence (how happy a track sounds) and danceability range from 0 to 1, tempo is in BPM.
+// Tracks without audio features only pass filters that don't look at features.
+type Filter struct {
+	YearFrom        int      `json:"year_from,omitempty"`
+	YearTo          int      `json:"year_to,omitempty"`
+	Genres          []string `json:"genres,omitempty"`  // any of them, matched as substrings, e.g. "rock" matches "indie rock"
+	Artists         []string `json:"artists,omitempty"` // any of them, by name
+	MinEnergy       float64  `json:"min_energy,omitempty"`
+	MaxEnergy       float64  `json:"max_energy,omitempty"`
+	MinValence      float64  `json:"min_valence,omitempty"`
+	MaxValence      float64  `json:"max_valence,omitempty"`
+	MinDanceability float64  `json:"min_danceability,omitempty"`
+	MinTempo        float64  `json:"min_tempo,omitempty"`
+	MaxTempo        float64  `json:"max_tempo,omitempty"`
+}
+
+// usesFeatures reports whether the filter looks at audio features
+func (f Filter) usesFeatures() bool {
+	return f.MinEnergy > 0 || f.MaxEnergy > 0 || f.MinValence > 0 || f.MaxValence > 0 ||
+		f.MinDanceability > 0 || f.MinTempo > 0 || f.MaxTempo > 0
+}
+
+// Empty reports whether the filter lets every track through
+func (f Filter) Empty() bool {
+	return f.YearFrom == 0 && f.YearTo == 0 && len(f.Genres) == 0 && len(f.Artists) == 0 && !f.usesFeatures()
+}
+
+// Matches reports whether a track is what the filter asks for. genres are the genres of
+// the artists credited on it, empty if unknown.
+func (f Filter) Matches(track spotify.Track, genres []string) bool {
+	if f.YearFrom > 0 && track.Year < f.YearFrom || f.YearTo > 0 && track.Year > f.YearTo {
+		return false
+	}
+	if len(f.Artists) > 0 && !slices.ContainsFunc(f.Artists, func(name string) bool {
+		return slices.ContainsFunc(track.Artists, func(a spotify.TrackArtist) bool {
+			return strings.EqualFold(a.Name, name)
+		}) || strings.EqualFold(track.Artist, name)
+	}) {
+		return false
+	}
+	if len(f.Genres) > 0 && !slices.ContainsFunc(f.Genres, func(want string) bool {
+		return slices.ContainsFunc(genres, func(g string) bool {
+			return strings.Contains(strings.ToLower(g), strings.ToLower(want))
+		})
+	}) {
+		return false
+	}
+
+	if !f.usesFeatures() {
+		return true
+	}
+	a := track.Features
+	if a == nil {
+		return false
+	}
+	return atLeast(a.Energy, f.MinEnergy) && atMost(a.Energy, f.MaxEnergy) &&
+		atLeast(a.Valence, f.MinValence) && atMost(a.Valence, f.MaxValence) &&
+		atLeast(a.Danceability, f.MinDanceability) &&
+		atLeast(a.Tempo, f.MinTempo) && atMost(a.Tempo, f.MaxTempo)
+}
+
+// atLeast and atMost compare against a bound, a zero bound passes everything
+func atLeast(v, bound float64) bool { return bound == 0 || v >= bound }
+func atMost(v, bound float64) bool  { return bound == 0 || v <= bound }
+
+// String describes the filter in words, to show what a prompt was understood as
+func (f Filter) String() string {
+	var parts []string
+	switch {
+	case f.YearFrom > 0 && f.YearTo > 0 && f.YearFrom == f.YearTo:
+		parts = append(parts, fmt.Sprintf("from %d", f.YearFrom))
+	case f.YearFrom > 0 && f.YearTo > 0:
+		parts = append(parts, fmt.Sprintf("from %d to %d", f.YearFrom, f.YearTo))
+	case f.YearFrom > 0:
+		parts = append(parts, fmt.Sprintf("from %d on", f.YearFrom))
+	case f.YearTo > 0:
+		parts = append(parts, fmt.Sprintf("up to %d", f.YearTo))
+	}
+	if len(f.Genres) > 0 {
+		parts = append(parts, strings.Join(f.Genres, " or "))
+	}
+	if len(f.Artists) > 0 {
+		parts = append(parts, "by "+strings.Join(f.Artists, " or "))
+	}
+	parts = appendRange(parts, "energy", f.MinEnergy, f.MaxEnergy, "%.0f%%", 100)
+	parts = appendRange(parts, "mood", f.MinValence, f.MaxValence, "%.0f%%", 100)
+	parts = appendRange(parts, "danceability", f.MinDanceability, 0, "%.0f%%", 100)
+	parts = appendRange(parts, "tempo", f.MinTempo, f.MaxTempo, "%.0f BPM", 1)
+	if len(parts) == 0 {
+		return "anything"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// appendRange describes the bounds of a feature, scaled for display
+func appendRange(parts []string, name string, lo, hi float64, format string, scale float64) []string {
+	switch {
+	case lo > 0 && hi > 0:
+		return append(parts, fmt.Sprintf("%s "+format+"–"+format, name, lo*scale, hi*scale))
+	case lo > 0:
+		return append(parts, fmt.Sprintf("%s at least "+format, name, lo*scale))
+	case hi > 0:
+		return append(parts, fmt.Sprintf("%s at most "+format, name, hi*scale))
+	}
+	return parts
+}
+
+// clamp keeps what a model came up with within the ranges the filter expects
+func (f Filter) clamp() Filter {
+	unit := func(v float64) float64 { return min(max(v, 0), 1) }
+	f.MinEnergy, f.MaxEnergy = unit(f.MinEnergy), unit(f.MaxEnergy)
+	f.MinValence, f.MaxValence = unit(f.MinValence), unit(f.MaxValence)
+	f.MinDanceability = unit(f.MinDanceability)
+	f.MinTempo, f.MaxTempo = max(f.MinTempo, 0), max(f.MaxTempo, 0)
+	f.YearFrom, f.YearTo = max(f.YearFrom, 0), max(f.YearTo, 0)
+	return f
+}
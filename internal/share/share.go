@@ -0,0 +1,196 @@
+// Package share keeps the public share links users create for their library. Sharing is
+// opt-in: a user has no link until they create one, and deleting it makes the old URL
+// stop working for good. When a data directory is configured links are persisted there,
+// in a single file since there is little of them.
+package share
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+)
+
+// Link is a user's public share link
+type Link struct {
+	Token     string    `json:"token"`          // the unguessable part of the URL
+	Slug      string    `json:"slug,omitempty"` // the address the user claimed, e.g. "jenda" for /u/jenda
+	Name      string    `json:"name"`           // shown on the page, the user's display name when they created it
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Errors returned by SetSlug
+var (
+	ErrNoLink       = errors.New("no share link to name")
+	ErrInvalidSlug  = errors.New("addresses are 3 to 30 letters, digits and dashes")
+	ErrReservedSlug = errors.New("that address is reserved")
+	ErrSlugTaken    = errors.New("that address is taken")
+)
+
+// slugPattern is what a slug looks like: lowercase letters, digits and inner dashes
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,28}[a-z0-9]$`)
+
+// reservedSlugs can't be claimed, they would read as the app speaking or be confused
+// with its pages
+var reservedSlugs = []string{
+	"about", "admin", "api", "app", "bangerid", "demo", "help", "home", "login", "logout",
+	"me", "new", "official", "root", "security", "settings", "share", "spotify", "static",
+	"stats", "support", "system", "u", "user", "www",
+}
+
+var (
+	mu    sync.Mutex
+	links = make(map[string]Link) // keyed by Spotify user ID
+	// byHash finds the user of a token by its hash, so Lookup never compares the tokens
+	// themselves, which would tell by its timing how much of a guess was right
+	byHash = make(map[string]string)
+
+	// storePath is the file links are persisted to, empty while running in memory only
+	storePath string
+)
+
+// Load restores the share links from the data directory and saves every change there from now on.
+func Load(dir string) error {
+	path := filepath.Join(dir, "shares.json")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read share links: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &links); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	for userID, link := range links {
+		byHash[hash(link.Token)] = userID
+	}
+	storePath = path
+	return nil
+}
+
+// Get returns the user's share link. It reports false if they haven't created one.
+func Get(userID string) (Link, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	link, ok := links[userID]
+	return link, ok
+}
+
+// Create returns the user's share link, creating one under name if they have none yet.
+func Create(userID, name string) (Link, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if link, ok := links[userID]; ok {
+		return link, nil
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	link := Link{Token: base64.RawURLEncoding.EncodeToString(b), Name: name, CreatedAt: time.Now()}
+	links[userID] = link
+	byHash[hash(link.Token)] = userID
+	return link, save()
+}
+
+// Lookup returns the user a share token belongs to. It reports false for unknown tokens.
+func Lookup(token string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	userID, ok := byHash[hash(token)]
+	return userID, ok
+}
+
+// SetSlug claims an address for the user's share link, or releases it for an empty slug.
+// Slugs are lowercased; one nobody else has, that isn't reserved, can be claimed.
+func SetSlug(userID, slug string) error {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	link, ok := links[userID]
+	if !ok {
+		return ErrNoLink
+	}
+	if slug != "" {
+		if !slugPattern.MatchString(slug) || strings.Contains(slug, "--") {
+			return ErrInvalidSlug
+		}
+		if slices.Contains(reservedSlugs, slug) {
+			return ErrReservedSlug
+		}
+		for other, l := range links {
+			if other != userID && l.Slug == slug {
+				return ErrSlugTaken
+			}
+		}
+	}
+
+	link.Slug = slug
+	links[userID] = link
+	return save()
+}
+
+// LookupSlug returns the user who claimed a slug. It reports false for unclaimed ones.
+func LookupSlug(slug string) (string, bool) {
+	slug = strings.ToLower(slug)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for userID, link := range links {
+		if link.Slug != "" && link.Slug == slug {
+			return userID, true
+		}
+	}
+	return "", false
+}
+
+// Delete removes the user's share link, if they have one. Its slug is free to claim again.
+func Delete(userID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if link, ok := links[userID]; ok {
+		delete(byHash, hash(link.Token))
+		delete(links, userID)
+	}
+	return save()
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// save writes all links to the data directory, if there is one. mu must be held.
+func save() error {
+	if storePath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(links)
+	if err != nil {
+		return fmt.Errorf("failed to encode share links: %w", err)
+	}
+
+	if err := fileutil.WriteFileAtomic(storePath, data); err != nil {
+		return fmt.Errorf("failed to write share links: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+// Package shortcode makes the short codes users type by hand to link something to their
+// account, like a chat with a bot or a display on the wall.
+package shortcode
+
+import "crypto/rand"
+
+// alphabet leaves out letters that are easily mistaken for each other. It has 32 of them,
+// so a random byte picks each one equally often.
+const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// New returns a random code of n characters
+func New(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
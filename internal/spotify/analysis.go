@@ -0,0 +1,54 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// AudioAnalysis is Spotify's breakdown of a track into its structure. Times are in
+// seconds from the start of the track. Only the parts we draw are decoded, the full
+// response is hundreds of kilobytes.
+type AudioAnalysis struct {
+	Track struct {
+		Duration float64 `json:"duration"`
+		Tempo    float64 `json:"tempo"`
+	} `json:"track"`
+	Sections []AnalysisSection `json:"sections"` // verse, chorus, bridge... large changes in feel
+	Segments []AnalysisSegment `json:"segments"` // short sounds of roughly even timbre
+	Beats    []TimeInterval    `json:"beats"`
+	Bars     []TimeInterval    `json:"bars"`
+}
+
+// TimeInterval is a span of a track
+type TimeInterval struct {
+	Start      float64 `json:"start"`
+	Duration   float64 `json:"duration"`
+	Confidence float64 `json:"confidence"` // 0..1
+}
+
+// AnalysisSection is one section of a track with its own tempo, key and loudness
+type AnalysisSection struct {
+	TimeInterval
+	Loudness float64 `json:"loudness"` // dB
+	Tempo    float64 `json:"tempo"`    // BPM
+	Key      int     `json:"key"`      // pitch class 0=C .. 11=B, -1 if unknown
+	Mode     int     `json:"mode"`     // 1 major, 0 minor, -1 if unknown
+}
+
+// AnalysisSegment is one segment of a track
+type AnalysisSegment struct {
+	TimeInterval
+	LoudnessMax float64 `json:"loudness_max"` // peak loudness in dB
+}
+
+// GetAudioAnalysis fetches the structure of a track by its bare ID. Analyses never
+// change, so they are cached like audio features (see ResourceAudioAnalysis).
+func GetAudioAnalysis(ctx context.Context, accessToken, trackID string) (*AudioAnalysis, error) {
+	var analysis AudioAnalysis
+	endpoint := apiBaseURL + "/audio-analysis/" + url.PathEscape(trackID)
+	if err := getCachedJSON(ctx, accessToken, ResourceAudioAnalysis, trackID, endpoint, &analysis); err != nil {
+		return nil, fmt.Errorf("failed to fetch audio analysis: %w", err)
+	}
+	return &analysis, nil
+}
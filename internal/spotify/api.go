@@ -0,0 +1,76 @@
+package spotify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const apiBaseURL = "https://api.spotify.com/v1"
+
+// httpClient is shared by all Spotify API calls
+var httpClient = &http.Client{}
+
+// doRequest performs an authorized request against the Spotify API.
+// body is marshaled as JSON when not nil. The response body is returned for any 2xx status.
+func doRequest(ctx context.Context, accessToken, method, url string, body any) ([]byte, error) {
+	if body == nil {
+		return doRawRequest(ctx, accessToken, method, url, "", nil)
+	}
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	return doRawRequest(ctx, accessToken, method, url, "application/json", bytes.NewReader(jsonBody))
+}
+
+// doRawRequest is doRequest for bodies that aren't JSON, like uploaded images.
+// contentType is only sent when body is not nil.
+func doRawRequest(ctx context.Context, accessToken, method, url, contentType string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add authorization header with the access token
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	recordCall(ctx, url)
+
+	resp, err := httpClient.Do(req)
+	recordHealth(ctx, resp, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("spotify API error %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return respBody, nil
+}
+
+// getJSON performs an authorized GET request and decodes the JSON response into v
+func getJSON(ctx context.Context, accessToken, url string, v any) error {
+	body, err := doRequest(ctx, accessToken, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
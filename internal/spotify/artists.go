@@ -0,0 +1,161 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ArtistAlbumsResponse matches the /artists/{id}/albums response structure
+type ArtistAlbumsResponse struct {
+	Items []apiAlbum `json:"items"`
+	Next  *string    `json:"next"` // URL to next page, null if last page
+}
+
+// artistResponse matches the /artists/{id} response structure, and the entries of
+// /artists?ids= and /me/following
+type artistResponse struct {
+	ID         string   `json:"id"`
+	URI        string   `json:"uri"`
+	Name       string   `json:"name"`
+	Images     []Image  `json:"images"`
+	Genres     []string `json:"genres"`
+	Popularity int      `json:"popularity"`
+}
+
+// toArtist converts an API artist into our model, with image as its picture
+func (a artistResponse) toArtist(image string) Artist {
+	return Artist{
+		ID:         a.ID,
+		URI:        a.URI,
+		Name:       a.Name,
+		Image:      image,
+		Genres:     a.Genres,
+		Popularity: a.Popularity,
+	}
+}
+
+// artistsResponse matches the /artists?ids= response structure. Unknown IDs come back
+// as null entries.
+type artistsResponse struct {
+	Artists []*artistResponse `json:"artists"`
+}
+
+// artistsBatchSize is the most IDs /artists accepts per request
+const artistsBatchSize = 50
+
+// GetArtist fetches a single artist. Responses are cached, see ResourceArtist.
+func GetArtist(ctx context.Context, accessToken, artistID string) (*Artist, error) {
+	var raw artistResponse
+	endpoint := apiBaseURL + "/artists/" + url.PathEscape(artistID)
+	if err := getCachedJSON(ctx, accessToken, ResourceArtist, artistID, endpoint, &raw); err != nil {
+		return nil, fmt.Errorf("failed to fetch artist: %w", err)
+	}
+
+	var image string
+	if len(raw.Images) > 0 {
+		// The largest image, it heads the artist page
+		image = raw.Images[0].URL
+	}
+	artist := raw.toArtist(image)
+	return &artist, nil
+}
+
+// GetArtists fetches the given artists with their genres and popularity, keyed by ID,
+// e.g. to enrich tracks that only carry artist names. Images are the smallest size, for
+// tiles. IDs are requested in batches of 50, several at once (see SetBatchParallelism),
+// and share GetArtist's cache. Artists Spotify doesn't know are left out.
+func GetArtists(ctx context.Context, accessToken string, ids []string) (map[string]Artist, error) {
+	artists := make(map[string]Artist, len(ids))
+	add := func(raw artistResponse) {
+		image, _ := smallestImage(raw.Images)
+		artists[raw.ID] = raw.toArtist(image)
+	}
+
+	var missing []string
+	for _, id := range ids {
+		var raw artistResponse
+		if cacheGet(ResourceArtist, id, &raw) {
+			add(raw)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	var artistsMu sync.Mutex
+	err := inBatches(ctx, missing, artistsBatchSize, func(ctx context.Context, batch []string) error {
+		var response artistsResponse
+		endpoint := apiBaseURL + "/artists?ids=" + url.QueryEscape(strings.Join(batch, ","))
+		if err := getJSON(ctx, accessToken, endpoint, &response); err != nil {
+			return fmt.Errorf("failed to fetch artists: %w", err)
+		}
+
+		artistsMu.Lock()
+		defer artistsMu.Unlock()
+		for _, raw := range response.Artists {
+			if raw == nil {
+				continue
+			}
+			add(*raw)
+			cachePut(ResourceArtist, raw.ID, raw)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return artists, nil
+}
+
+// relatedArtistsResponse matches the /artists/{id}/related-artists response structure
+type relatedArtistsResponse struct {
+	Artists []artistResponse `json:"artists"`
+}
+
+// GetRelatedArtists fetches the artists Spotify considers similar to an artist, most
+// similar first (up to 20). Artists without images are left out, like in the library.
+// Responses are cached, see ResourceRelated.
+func GetRelatedArtists(ctx context.Context, accessToken, artistID string) ([]Artist, error) {
+	var response relatedArtistsResponse
+	endpoint := apiBaseURL + "/artists/" + url.PathEscape(artistID) + "/related-artists"
+	if err := getCachedJSON(ctx, accessToken, ResourceRelated, artistID, endpoint, &response); err != nil {
+		return nil, fmt.Errorf("failed to fetch related artists: %w", err)
+	}
+
+	var artists []Artist
+	for _, raw := range response.Artists {
+		if image, ok := smallestImage(raw.Images); ok {
+			artists = append(artists, raw.toArtist(image))
+		}
+	}
+	return artists, nil
+}
+
+// FetchArtistAlbums retrieves the artist's discography: their albums, singles and EPs.
+// Appearances on other artists' records and compilations are left out.
+func FetchArtistAlbums(ctx context.Context, accessToken, artistID string) ([]Album, error) {
+	var albums []Album
+	endpoint := apiBaseURL + "/artists/" + url.PathEscape(artistID) + "/albums?include_groups=album,single&limit=50&market=from_token"
+
+	for endpoint != "" {
+		var response ArtistAlbumsResponse
+		if err := getJSON(ctx, accessToken, endpoint, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch artist albums: %w", err)
+		}
+
+		for _, item := range response.Items {
+			if album, ok := item.toAlbum(); ok {
+				albums = append(albums, album)
+			}
+		}
+
+		endpoint = ""
+		if response.Next != nil {
+			endpoint = *response.Next
+		}
+	}
+
+	return albums, nil
+}
@@ -0,0 +1,34 @@
+package spotify
+
+import (
+	"context"
+
+	"github.com/jendahorak/bangerid/internal/metrics"
+	"golang.org/x/sync/errgroup"
+)
+
+// batchParallelism is how many batches inBatches fetches at the same time
+var batchParallelism = 2
+
+// SetBatchParallelism sets how many batches of an enrichment, like audio features or
+// artist details, are fetched at the same time. Call it before making requests.
+func SetBatchParallelism(n int) {
+	batchParallelism = n
+	metrics.PoolSize.Set(float64(n), "enrich")
+}
+
+// inBatches calls fetch for consecutive batches of at most size ids, batchParallelism
+// of them at a time. The first error cancels the batches still running and is returned.
+func inBatches(ctx context.Context, ids []string, size int, fetch func(ctx context.Context, batch []string) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(batchParallelism)
+	for start := 0; start < len(ids); start += size {
+		batch := ids[start:min(start+size, len(ids))]
+		g.Go(func() error {
+			metrics.PoolBusy.Add(1, "enrich")
+			defer metrics.PoolBusy.Add(-1, "enrich")
+			return fetch(ctx, batch)
+		})
+	}
+	return g.Wait()
+}
@@ -0,0 +1,43 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// maxNewReleases is the most albums /browse/new-releases returns per page
+const maxNewReleases = 50
+
+// newReleasesResponse matches the /browse/new-releases response structure
+type newReleasesResponse struct {
+	Albums struct {
+		Items []apiAlbum `json:"items"`
+	} `json:"albums"`
+}
+
+// GetNewReleases fetches the albums and singles Spotify features as newly released in
+// country, a code as in User, newest first. Without a country Spotify picks releases for
+// no market in particular. limit is clamped to 1..50. Releases without a cover are left
+// out.
+func GetNewReleases(ctx context.Context, accessToken, country string, limit int) ([]Album, error) {
+	query := url.Values{"limit": {strconv.Itoa(min(max(limit, 1), maxNewReleases))}}
+	if country != "" {
+		query.Set("country", country)
+	}
+	endpoint := apiBaseURL + "/browse/new-releases?" + query.Encode()
+
+	var response newReleasesResponse
+	if err := getJSON(ctx, accessToken, endpoint, &response); err != nil {
+		return nil, fmt.Errorf("failed to fetch new releases: %w", err)
+	}
+
+	var albums []Album
+	for _, item := range response.Albums.Items {
+		if album, ok := item.toAlbum(); ok {
+			albums = append(albums, album)
+		}
+	}
+	return albums, nil
+}
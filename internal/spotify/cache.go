@@ -0,0 +1,147 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Resource is a kind of catalog data the client caches. Catalog data like track
+// details or audio features barely ever changes, so re-running enrichment over a
+// library shouldn't have to fetch it again.
+type Resource string
+
+const (
+	ResourceTrack         Resource = "track"
+	ResourceAudioFeatures Resource = "audio-features"
+	ResourceAudioAnalysis Resource = "audio-analysis"
+	ResourceArtist        Resource = "artist" // genres and popularity drift, keep it shorter
+	ResourceRelated       Resource = "related-artists"
+)
+
+// Default freshness per resource, can be overridden with SetCacheTTL
+var cacheTTLs = map[Resource]time.Duration{
+	ResourceTrack:         7 * 24 * time.Hour,
+	ResourceAudioFeatures: 30 * 24 * time.Hour,
+	ResourceAudioAnalysis: 30 * 24 * time.Hour,
+	ResourceArtist:        24 * time.Hour,
+	ResourceRelated:       24 * time.Hour,
+}
+
+// maxCacheEntries bounds memory use; expired entries are pruned when it is reached, and
+// then the ones closest to expiring, cacheEvictions at a time
+const (
+	maxCacheEntries = 50000
+	cacheEvictions  = maxCacheEntries / 10
+)
+
+type cacheEntry struct {
+	data      []byte // JSON, so callers always get their own copy
+	expiresAt time.Time
+}
+
+var (
+	cacheMu       sync.Mutex
+	responseCache = make(map[string]cacheEntry)
+)
+
+// SetCacheTTL changes how long responses for a resource are considered fresh.
+// A zero TTL disables caching for that resource.
+func SetCacheTTL(resource Resource, ttl time.Duration) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cacheTTLs[resource] = ttl
+}
+
+// cacheGet decodes the cached value for resource/key into v and reports whether it was found and fresh
+func cacheGet(resource Resource, key string, v any) bool {
+	cacheMu.Lock()
+	entry, ok := responseCache[string(resource)+":"+key]
+	cacheMu.Unlock()
+
+	if !ok || time.Now().After(entry.expiresAt) {
+		return false
+	}
+	return json.Unmarshal(entry.data, v) == nil
+}
+
+// cachePut stores a value for resource/key according to the resource's TTL
+func cachePut(resource Resource, key string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	ttl := cacheTTLs[resource]
+	if ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+	cacheKey := string(resource) + ":" + key
+	if _, ok := responseCache[cacheKey]; !ok && len(responseCache) >= maxCacheEntries {
+		evictCache(now)
+	}
+	responseCache[cacheKey] = cacheEntry{data: data, expiresAt: now.Add(ttl)}
+}
+
+// evictCache makes room in a full cache: it drops the expired entries, and when that
+// isn't enough the cacheEvictions entries closest to expiring, they would be fetched
+// again soonest anyway. Evicting a batch keeps a full cache from sorting on every put.
+// cacheMu must be held.
+func evictCache(now time.Time) {
+	for k, entry := range responseCache {
+		if now.After(entry.expiresAt) {
+			delete(responseCache, k)
+		}
+	}
+	if len(responseCache) < maxCacheEntries {
+		return
+	}
+
+	keys := slices.Collect(maps.Keys(responseCache))
+	slices.SortFunc(keys, func(a, b string) int {
+		return responseCache[a].expiresAt.Compare(responseCache[b].expiresAt)
+	})
+	for _, k := range keys[:min(cacheEvictions, len(keys))] {
+		delete(responseCache, k)
+	}
+}
+
+// PruneCache drops the expired cached responses and returns how many and their size.
+// They are only pruned on demand otherwise, once the cache is full.
+func PruneCache() (entries int, bytes int64) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	now := time.Now()
+	for k, entry := range responseCache {
+		if now.After(entry.expiresAt) {
+			entries++
+			bytes += int64(len(entry.data))
+			delete(responseCache, k)
+		}
+	}
+	return entries, bytes
+}
+
+// getCachedJSON is getJSON for catalog resources: it serves fresh cached responses
+// and only hits the API when the cache has nothing for key. The cache is shared by all
+// users, so url must not depend on whose token it is fetched with, like market=from_token
+// does: everybody would get the market of whoever fetched first.
+func getCachedJSON(ctx context.Context, accessToken string, resource Resource, key, url string, v any) error {
+	if cacheGet(resource, key, v) {
+		return nil
+	}
+	if err := getJSON(ctx, accessToken, url, v); err != nil {
+		return err
+	}
+	cachePut(resource, key, v)
+	return nil
+}
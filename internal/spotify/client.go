@@ -1,19 +1,109 @@
 package spotify
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Track represents a simplified Spotify track for our grid
 type Track struct {
 	ID         string
 	Name       string
-	Artist     string
+	Artist     string        // first (main) artist, see Artists for everyone credited
+	Artists    []TrackArtist // all credited artists in Spotify's order
+	Album      string
+	AlbumID    string
+	Year       int // release year of the album, 0 if unknown
+	Duration   time.Duration
 	AlbumImage string
+	CoverImage string // largest album image, for the detail panel
+	// MediumImage is the album image closest to 300px, for large tiles. Empty for tracks
+	// fetched before it was kept.
+	MediumImage string `json:",omitempty"`
+	PreviewURL  string // 30 second MP3 preview, empty for many tracks
+	// ISRC is the recording's international code, which finds the same song on other
+	// services. Empty for tracks fetched before it was kept.
+	ISRC     string         `json:",omitempty"`
+	AddedAt  time.Time      // when the user liked the track (or added it to the playlist), zero if unknown
+	Features *AudioFeatures `json:",omitempty"` // nil unless merged in with MergeAudioFeatures
+}
+
+// TrackArtist is one artist credited on a track
+type TrackArtist struct {
+	ID   string
+	Name string
+}
+
+// ArtistNames lists everyone credited on the track, e.g. "Daft Punk, Pharrell Williams"
+func (t Track) ArtistNames() string {
+	if len(t.Artists) == 0 {
+		return t.Artist
+	}
+	names := make([]string, len(t.Artists))
+	for i, a := range t.Artists {
+		names[i] = a.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Featured returns the artists credited after the main one
+func (t Track) Featured() []TrackArtist {
+	if len(t.Artists) < 2 {
+		return nil
+	}
+	return t.Artists[1:]
+}
+
+// Credits formats the artists the way they're usually printed, e.g. "Daft Punk feat. Pharrell Williams, Nile Rodgers"
+func (t Track) Credits() string {
+	featured := t.Featured()
+	if len(featured) == 0 {
+		return t.Artist
+	}
+	names := make([]string, len(featured))
+	for i, a := range featured {
+		names[i] = a.Name
+	}
+	return t.Artist + " feat. " + strings.Join(names, ", ")
+}
+
+// HasArtist reports whether the artist with the given ID is credited on the track
+func (t Track) HasArtist(artistID string) bool {
+	for _, a := range t.Artists {
+		if a.ID == artistID {
+			return true
+		}
+	}
+	return false
+}
+
+// User is the Spotify account an access token belongs to
+type User struct {
+	ID          string  `json:"id"`
+	DisplayName string  `json:"display_name"`
+	Product     string  `json:"product"` // "premium", "free" or "open"; needs user-read-private
+	Country     string  `json:"country"` // ISO 3166-1 alpha-2 code; needs user-read-private
+	Images      []Image `json:"images"`  // the profile picture, empty if the user has none
+}
+
+// Avatar returns the user's profile picture, the smallest Spotify has, or "" if they
+// have none
+func (u User) Avatar() string {
+	image, _ := smallestImage(u.Images)
+	return image
+}
+
+// Premium reports whether the account can stream through the Web Playback SDK
+func (u User) Premium() bool {
+	return u.Product == "premium"
 }
 
 type LinkedFrom struct {
@@ -21,26 +111,41 @@ type LinkedFrom struct {
 	URI string `json:"uri"`
 }
 
+// Image is one size of a cover or avatar image
+type Image struct {
+	URL    string `json:"url"`
+	Height int    `json:"height"`
+	Width  int    `json:"width"`
+}
+
+// apiTrack is a full track object as returned by the Spotify API
+type apiTrack struct {
+	ID          string      `json:"id"`
+	URI         string      `json:"uri"`
+	Name        string      `json:"name"`
+	PreviewURL  string      `json:"preview_url"`
+	DurationMs  int         `json:"duration_ms"`
+	LinkedFrom  *LinkedFrom `json:"linked_from"`
+	ExternalIDs struct {
+		ISRC string `json:"isrc"`
+	} `json:"external_ids"`
+	Artists []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"artists"`
+	Album struct {
+		ID          string  `json:"id"`
+		Name        string  `json:"name"`
+		ReleaseDate string  `json:"release_date"` // "2024-03-01", "2024-03" or "2024"
+		Images      []Image `json:"images"`
+	} `json:"album"`
+}
+
 // SavedTracksResponse matches Spotify's API response structure
 type SavedTracksResponse struct {
 	Items []struct {
-		AddedAt string `json:"added_at"`
-		Track   struct {
-			ID         string      `json:"id"`
-			URI        string      `json:"uri"`
-			Name       string      `json:"name"`
-			LinkedFrom *LinkedFrom `json:"linked_from"`
-			Artists    []struct {
-				Name string `json:"name"`
-			} `json:"artists"`
-			Album struct {
-				Images []struct {
-					URL    string `json:"url"`
-					Height int    `json:"height"`
-					Width  int    `json:"width"`
-				} `json:"images"`
-			} `json:"album"`
-		} `json:"track"`
+		AddedAt string   `json:"added_at"`
+		Track   apiTrack `json:"track"`
 	} `json:"items"`
 	Next   *string `json:"next"`  // URL to next page, null if last page
 	Total  int     `json:"total"` // Total number of liked tracks
@@ -48,85 +153,121 @@ type SavedTracksResponse struct {
 	Offset int     `json:"offset"`
 }
 
-// FetchLikedTracks retrieves all of the user's saved/liked tracks from Spotify
-func FetchLikedTracks(accessToken string) ([]Track, error) {
-	var allTracks []Track
-	url := "https://api.spotify.com/v1/me/tracks?limit=50&market=from_token"
+// toTrack simplifies an API track for the grid. It reports false for tracks without
+// album art, which we can't show as a tile: lists leave them out, see logSkipped.
+func (t apiTrack) toTrack() (Track, bool) {
+	stableURI := t.URI
 
-	// Create HTTP client
-	client := &http.Client{}
+	if t.LinkedFrom != nil && t.LinkedFrom.URI != "" {
+		stableURI = t.LinkedFrom.URI
+	}
 
-	for url != "" {
-		// Create request
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
-		}
+	track := Track{
+		ID:         stableURI,
+		Name:       t.Name,
+		Album:      t.Album.Name,
+		AlbumID:    t.Album.ID,
+		Duration:   time.Duration(t.DurationMs) * time.Millisecond,
+		PreviewURL: t.PreviewURL,
+		ISRC:       t.ExternalIDs.ISRC,
+	}
 
-		// Add authorization header with the access token
-		req.Header.Set("Authorization", "Bearer "+accessToken)
+	for _, a := range t.Artists {
+		track.Artists = append(track.Artists, TrackArtist{ID: a.ID, Name: a.Name})
+	}
+	// The first artist is the main one, the grid shows just that
+	if len(t.Artists) > 0 {
+		track.Artist = t.Artists[0].Name
+	}
 
-		// Make the request
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch tracks: %w", err)
-		}
-		defer resp.Body.Close()
+	if len(t.Album.ReleaseDate) >= 4 {
+		track.Year, _ = strconv.Atoi(t.Album.ReleaseDate[:4])
+	}
 
-		// Check for errors
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("spotify API error %d: %s", resp.StatusCode, string(body))
-		}
+	image, ok := smallestImage(t.Album.Images)
+	if !ok {
+		return track, false
+	}
+	track.AlbumImage = image
+	track.CoverImage = t.Album.Images[0].URL
+	track.MediumImage = mediumImage(t.Album.Images)
 
-		// Parse JSON response
-		var response SavedTracksResponse
-		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			return nil, fmt.Errorf("failed to decode response: %w", err)
+	return track, true
+}
+
+// logSkipped notes a track a list leaves out for lack of album art
+func logSkipped(track Track) {
+	slog.Debug("skipping track without album art", "track", track.ID, "name", track.Name)
+}
+
+// IDFromURI returns the bare Spotify ID of a URI like "spotify:track:4uLU6hMCjMI75M1A2tKUQC"
+func IDFromURI(uri string) string {
+	return uri[strings.LastIndex(uri, ":")+1:]
+}
+
+// BareID returns the track's ID without the spotify:track: prefix, as the API takes it
+func (t Track) BareID() string {
+	return IDFromURI(t.ID)
+}
+
+// smallestImage picks the image to use for a 64px tile. It reports false if there are no images.
+func smallestImage(images []Image) (string, bool) {
+	if len(images) == 0 {
+		return "", false
+	}
+
+	// Try to find exact 64x64 match first
+	for _, img := range images {
+		if img.Height == 64 && img.Width == 64 {
+			return img.URL, true
 		}
+	}
 
-		// Extract simplified track data
-		for _, item := range response.Items {
-			stableURI := item.Track.URI
+	// Fallback to last image (usually smallest) or first (if only one exists)
+	// Images are ordered: [0]=largest, [last]=smallest (typically 64x64)
+	return images[len(images)-1].URL, true
+}
 
-			if item.Track.LinkedFrom != nil && item.Track.LinkedFrom.URI != "" {
-				stableURI = item.Track.LinkedFrom.URI
-			}
+// mediumImageSize is the width of Spotify's middle album image
+const mediumImageSize = 300
 
-			track := Track{
-				ID:   stableURI,
-				Name: item.Track.Name,
-			}
+// mediumImage picks the image closest to mediumImageSize wide. There must be at least one.
+func mediumImage(images []Image) string {
+	best := images[0]
+	for _, img := range images[1:] {
+		if abs(img.Width-mediumImageSize) < abs(best.Width-mediumImageSize) {
+			best = img
+		}
+	}
+	return best.URL
+}
 
-			// Get first artist name
-			if len(item.Track.Artists) > 0 {
-				track.Artist = item.Track.Artists[0].Name
-			}
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// FetchLikedTracks retrieves all of the user's saved/liked tracks from Spotify
+func FetchLikedTracks(ctx context.Context, accessToken string) ([]Track, error) {
+	var allTracks []Track
+	url := apiBaseURL + "/me/tracks?limit=50&market=from_token"
+
+	for url != "" {
+		var response SavedTracksResponse
+		if err := getJSON(ctx, accessToken, url, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch tracks: %w", err)
+		}
 
-			// Get smallest album image (usually the last one in the array)
-			// Images are ordered: [0]=largest, [last]=smallest (typically 64x64)
-			images := item.Track.Album.Images
-			if len(images) > 0 {
-				// Try to find exact 64x64 match first
-				found := false
-				for _, img := range images {
-					if img.Height == 64 && img.Width == 64 {
-						track.AlbumImage = img.URL
-						found = true
-						break
-					}
-				}
-				// Fallback to last image (usually smallest) or first (if only one exists)
-				if !found {
-					track.AlbumImage = images[len(images)-1].URL
-				}
+		// Extract simplified track data, skipping tracks without album art
+		for _, item := range response.Items {
+			if track, ok := item.Track.toTrack(); ok {
+				track.AddedAt, _ = time.Parse(time.RFC3339, item.AddedAt)
+				allTracks = append(allTracks, track)
 			} else {
-				// Log missing images to debug console
-				fmt.Printf("Warning: Track '%s' (ID: %s) has no album images - SKIPPING\n", track.Name, track.ID)
-				continue // Skip this track entirely
+				logSkipped(track)
 			}
-
-			allTracks = append(allTracks, track)
 		}
 
 		// Check if there's a next page
@@ -140,39 +281,419 @@ func FetchLikedTracks(accessToken string) ([]Track, error) {
 	return allTracks, nil
 }
 
-// PlayTrack starts playback of a specific track on a specific device
-func PlayTrack(accessToken, deviceID, trackURI string) error {
-	url := fmt.Sprintf("https://api.spotify.com/v1/me/player/play?device_id=%s", deviceID)
+// savedTracksBatch is the most IDs /me/tracks accepts per save, removal or check
+const savedTracksBatch = 50
+
+// SaveTracks adds tracks to the user's liked songs, given their bare IDs. Requires the
+// user-library-modify scope.
+func SaveTracks(ctx context.Context, accessToken string, ids []string) error {
+	return changeSavedTracks(ctx, accessToken, http.MethodPut, ids)
+}
+
+// RemoveSavedTracks removes tracks from the user's liked songs, given their bare IDs.
+// Requires the user-library-modify scope.
+func RemoveSavedTracks(ctx context.Context, accessToken string, ids []string) error {
+	return changeSavedTracks(ctx, accessToken, http.MethodDelete, ids)
+}
+
+// changeSavedTracks saves (PUT) or removes (DELETE) liked tracks in batches
+func changeSavedTracks(ctx context.Context, accessToken, method string, ids []string) error {
+	for start := 0; start < len(ids); start += savedTracksBatch {
+		end := min(start+savedTracksBatch, len(ids))
+		endpoint := apiBaseURL + "/me/tracks?ids=" + url.QueryEscape(strings.Join(ids[start:end], ","))
+		if _, err := doRequest(ctx, accessToken, method, endpoint, nil); err != nil {
+			return fmt.Errorf("failed to update liked tracks: %w", err)
+		}
+	}
+	return nil
+}
+
+// CheckSavedTracks reports for each of the bare track IDs whether the user likes it, in
+// the same order. Needs the user-library-read scope.
+func CheckSavedTracks(ctx context.Context, accessToken string, ids []string) ([]bool, error) {
+	saved := make([]bool, 0, len(ids))
+	for start := 0; start < len(ids); start += savedTracksBatch {
+		end := min(start+savedTracksBatch, len(ids))
+		var batch []bool
+		endpoint := apiBaseURL + "/me/tracks/contains?ids=" + url.QueryEscape(strings.Join(ids[start:end], ","))
+		if err := getJSON(ctx, accessToken, endpoint, &batch); err != nil {
+			return nil, fmt.Errorf("failed to check liked tracks: %w", err)
+		}
+		if len(batch) != end-start {
+			return nil, fmt.Errorf("failed to check liked tracks: got %d answers for %d tracks", len(batch), end-start)
+		}
+		saved = append(saved, batch...)
+	}
+	return saved, nil
+}
+
+// GetTrack fetches a single track's details. Responses are cached, see ResourceTrack.
+// They are shared between users, so the track is fetched for no market in particular:
+// it isn't relinked and its ID is the one asked for, the one liked tracks are keyed by.
+func GetTrack(ctx context.Context, accessToken, trackID string) (*Track, error) {
+	var raw apiTrack
+	endpoint := apiBaseURL + "/tracks/" + url.PathEscape(trackID)
+	if err := getCachedJSON(ctx, accessToken, ResourceTrack, trackID, endpoint, &raw); err != nil {
+		return nil, fmt.Errorf("failed to fetch track: %w", err)
+	}
+
+	track, _ := raw.toTrack()
+	return &track, nil
+}
+
+// PlayContext starts playback of an album, artist or playlist on a specific device
+func PlayContext(ctx context.Context, accessToken, deviceID, contextURI string) error {
+	endpoint := apiBaseURL + "/me/player/play?device_id=" + url.QueryEscape(deviceID)
+
+	bodyData := map[string]string{
+		"context_uri": contextURI,
+	}
+
+	if _, err := doRequest(ctx, accessToken, http.MethodPut, endpoint, bodyData); err != nil {
+		return fmt.Errorf("failed to start playback: %w", err)
+	}
+
+	return nil
+}
+
+// PlayTrack starts playback of a specific track on a specific device, from the start.
+// ResumePlayback continues a paused one where it stopped.
+func PlayTrack(ctx context.Context, accessToken, deviceID, trackURI string) error {
+	return PlayTracks(ctx, accessToken, deviceID, []string{trackURI})
+}
+
+// PlayTracks plays the given tracks in order on a specific device, like an ad-hoc queue
+func PlayTracks(ctx context.Context, accessToken, deviceID string, trackURIs []string) error {
+	endpoint := apiBaseURL + "/me/player/play?device_id=" + url.QueryEscape(deviceID)
 
-	// Create the body: {"uris": ["spotify:track:track_uri"]}
+	// Create the body: {"uris": ["spotify:track:track_uri", ...]}
 	bodyData := map[string][]string{
-		"uris": {trackURI},
+		"uris": trackURIs,
 	}
-	jsonBody, err := json.Marshal(bodyData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal play request: %w", err)
+
+	if _, err := doRequest(ctx, accessToken, http.MethodPut, endpoint, bodyData); err != nil {
+		return fmt.Errorf("failed to start playback: %w", err)
+	}
+
+	return nil
+}
+
+// PauseTrack pauses playback on a specific device, the active one if deviceID is empty
+func PauseTrack(ctx context.Context, accessToken, deviceID string) error {
+	endpoint := apiBaseURL + "/me/player/pause"
+	if deviceID != "" {
+		endpoint += "?device_id=" + url.QueryEscape(deviceID)
+	}
+
+	if _, err := doRequest(ctx, accessToken, http.MethodPut, endpoint, nil); err != nil {
+		return fmt.Errorf("failed to pause playback: %w", err)
+	}
+
+	return nil
+}
+
+// ResumePlayback resumes whatever was paused on a specific device, the active one if
+// deviceID is empty
+func ResumePlayback(ctx context.Context, accessToken, deviceID string) error {
+	endpoint := apiBaseURL + "/me/player/play"
+	if deviceID != "" {
+		endpoint += "?device_id=" + url.QueryEscape(deviceID)
 	}
 
-	// Create request
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonBody))
+	if _, err := doRequest(ctx, accessToken, http.MethodPut, endpoint, nil); err != nil {
+		return fmt.Errorf("failed to resume playback: %w", err)
+	}
+
+	return nil
+}
+
+// SkipToNext skips to the next track in the queue of a specific device, the active one
+// if deviceID is empty
+func SkipToNext(ctx context.Context, accessToken, deviceID string) error {
+	return skip(ctx, accessToken, "next", deviceID)
+}
+
+// SkipToPrevious skips to the previous track of a specific device, the active one if
+// deviceID is empty
+func SkipToPrevious(ctx context.Context, accessToken, deviceID string) error {
+	return skip(ctx, accessToken, "previous", deviceID)
+}
+
+func skip(ctx context.Context, accessToken, direction, deviceID string) error {
+	endpoint := apiBaseURL + "/me/player/" + direction
+	if deviceID != "" {
+		endpoint += "?device_id=" + url.QueryEscape(deviceID)
+	}
+
+	if _, err := doRequest(ctx, accessToken, http.MethodPost, endpoint, nil); err != nil {
+		return fmt.Errorf("failed to skip to %s track: %w", direction, err)
+	}
+
+	return nil
+}
+
+// SeekToPosition jumps to positionMs milliseconds into the track playing on a specific
+// device, the active one if deviceID is empty
+func SeekToPosition(ctx context.Context, accessToken, deviceID string, positionMs int) error {
+	endpoint := apiBaseURL + "/me/player/seek?position_ms=" + strconv.Itoa(positionMs)
+	if deviceID != "" {
+		endpoint += "&device_id=" + url.QueryEscape(deviceID)
+	}
+
+	if _, err := doRequest(ctx, accessToken, http.MethodPut, endpoint, nil); err != nil {
+		return fmt.Errorf("failed to seek: %w", err)
+	}
+
+	return nil
+}
+
+// SetVolume sets the volume of a specific device, the active one if deviceID is empty,
+// to percent (0 to 100)
+func SetVolume(ctx context.Context, accessToken, deviceID string, percent int) error {
+	endpoint := apiBaseURL + "/me/player/volume?volume_percent=" + strconv.Itoa(min(max(percent, 0), 100))
+	if deviceID != "" {
+		endpoint += "&device_id=" + url.QueryEscape(deviceID)
+	}
+
+	if _, err := doRequest(ctx, accessToken, http.MethodPut, endpoint, nil); err != nil {
+		return fmt.Errorf("failed to set volume: %w", err)
+	}
+
+	return nil
+}
+
+// AddToQueue adds a track or episode to the end of the queue of a specific device, the
+// active one if deviceID is empty, after what plays now
+func AddToQueue(ctx context.Context, accessToken, deviceID, uri string) error {
+	endpoint := apiBaseURL + "/me/player/queue?uri=" + url.QueryEscape(uri)
+	if deviceID != "" {
+		endpoint += "&device_id=" + url.QueryEscape(deviceID)
+	}
+
+	if _, err := doRequest(ctx, accessToken, http.MethodPost, endpoint, nil); err != nil {
+		return fmt.Errorf("failed to add to queue: %w", err)
+	}
+
+	return nil
+}
+
+// Queue is what plays now and next on the user's active device
+type Queue struct {
+	Current *Track  // nil when nothing plays
+	Next    []Track // in order. Episodes are included, without album art.
+}
+
+// GetQueue fetches the queue of the user's active device: what was queued, then the rest
+// of the album, playlist or list of tracks playing. Needs the user-read-playback-state
+// scope.
+func GetQueue(ctx context.Context, accessToken string) (*Queue, error) {
+	var raw struct {
+		CurrentlyPlaying *apiTrack  `json:"currently_playing"`
+		Queue            []apiTrack `json:"queue"`
+	}
+	if err := getJSON(ctx, accessToken, apiBaseURL+"/me/player/queue", &raw); err != nil {
+		return nil, fmt.Errorf("failed to fetch queue: %w", err)
+	}
+
+	// Tracks without album art are kept, the queue shows them without a cover
+	queue := &Queue{}
+	if raw.CurrentlyPlaying != nil && raw.CurrentlyPlaying.URI != "" {
+		track := raw.CurrentlyPlaying.toQueued()
+		queue.Current = &track
+	}
+	for _, t := range raw.Queue {
+		if t.URI != "" {
+			queue.Next = append(queue.Next, t.toQueued())
+		}
+	}
+	return queue, nil
+}
+
+// toQueued simplifies a track or episode of the queue, which may have no album art
+func (t apiTrack) toQueued() Track {
+	if len(t.Album.Images) == 0 {
+		track := Track{ID: t.URI, Name: t.Name, Duration: time.Duration(t.DurationMs) * time.Millisecond}
+		for _, a := range t.Artists {
+			track.Artists = append(track.Artists, TrackArtist{ID: a.ID, Name: a.Name})
+		}
+		if len(t.Artists) > 0 {
+			track.Artist = t.Artists[0].Name
+		}
+		return track
+	}
+	track, _ := t.toTrack()
+	return track
+}
+
+// SetShuffle turns shuffle on or off on a specific device, the active one if deviceID is
+// empty
+func SetShuffle(ctx context.Context, accessToken, deviceID string, state bool) error {
+	endpoint := apiBaseURL + "/me/player/shuffle?state=" + strconv.FormatBool(state)
+	if deviceID != "" {
+		endpoint += "&device_id=" + url.QueryEscape(deviceID)
+	}
+
+	if _, err := doRequest(ctx, accessToken, http.MethodPut, endpoint, nil); err != nil {
+		return fmt.Errorf("failed to set shuffle: %w", err)
+	}
+
+	return nil
+}
+
+// SetRepeat sets the repeat mode of a specific device, the active one if deviceID is
+// empty: "track" repeats the song, "context" the album, playlist or list of tracks
+// playing, "off" neither
+func SetRepeat(ctx context.Context, accessToken, deviceID, mode string) error {
+	endpoint := apiBaseURL + "/me/player/repeat?state=" + url.QueryEscape(mode)
+	if deviceID != "" {
+		endpoint += "&device_id=" + url.QueryEscape(deviceID)
+	}
+
+	if _, err := doRequest(ctx, accessToken, http.MethodPut, endpoint, nil); err != nil {
+		return fmt.Errorf("failed to set repeat mode: %w", err)
+	}
+
+	return nil
+}
+
+// Device is a Spotify Connect device playback can be started on
+type Device struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Type          string `json:"type"` // e.g. "Computer", "Smartphone", "Speaker"
+	Active        bool   `json:"is_active"`
+	Restricted    bool   `json:"is_restricted"` // can't be controlled through the API
+	VolumePercent int    `json:"volume_percent"`
+	// SupportsVolume is false for devices whose volume can't be set through the API
+	SupportsVolume bool `json:"supports_volume"`
+}
+
+// GetDevices lists the user's available devices, the active one first. Restricted
+// devices are left out, playback can't be started on them. Needs the
+// user-read-playback-state scope.
+func GetDevices(ctx context.Context, accessToken string) ([]Device, error) {
+	var response struct {
+		Devices []Device `json:"devices"`
+	}
+	if err := getJSON(ctx, accessToken, apiBaseURL+"/me/player/devices", &response); err != nil {
+		return nil, fmt.Errorf("failed to fetch devices: %w", err)
+	}
+
+	devices := slices.DeleteFunc(response.Devices, func(d Device) bool { return d.Restricted || d.ID == "" })
+	slices.SortStableFunc(devices, func(a, b Device) int {
+		switch {
+		case a.Active == b.Active:
+			return 0
+		case a.Active:
+			return -1
+		}
+		return 1
+	})
+	return devices, nil
+}
+
+// PlaybackState is what the user's Spotify is playing and where, on any device
+type PlaybackState struct {
+	Device     Device
+	Playing    bool // false while paused
+	Progress   time.Duration
+	Shuffle    bool
+	Repeat     string // "off", "track" or "context"
+	ContextURI string // the album, playlist or artist playing, empty for a list of tracks
+	Track      *Track // nil while playing something that isn't a track, like an ad
+}
+
+// GetPlaybackState fetches what the user's Spotify is playing. It returns nil without an
+// error when nothing is, on any device. Needs the user-read-playback-state scope.
+func GetPlaybackState(ctx context.Context, accessToken string) (*PlaybackState, error) {
+	body, err := doRequest(ctx, accessToken, http.MethodGet, apiBaseURL+"/me/player?market=from_token", nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to fetch playback state: %w", err)
+	}
+	// 204 No Content: no active device
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	var raw struct {
+		Device       Device    `json:"device"`
+		IsPlaying    bool      `json:"is_playing"`
+		ProgressMs   int       `json:"progress_ms"`
+		ShuffleState bool      `json:"shuffle_state"`
+		RepeatState  string    `json:"repeat_state"`
+		Item         *apiTrack `json:"item"`
+		Context      *struct {
+			URI string `json:"uri"`
+		} `json:"context"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode playback state: %w", err)
+	}
+
+	state := &PlaybackState{
+		Device:   raw.Device,
+		Playing:  raw.IsPlaying,
+		Progress: time.Duration(raw.ProgressMs) * time.Millisecond,
+		Shuffle:  raw.ShuffleState,
+		Repeat:   raw.RepeatState,
 	}
+	if raw.Context != nil {
+		state.ContextURI = raw.Context.URI
+	}
+	if raw.Item != nil && raw.Item.ID != "" {
+		// Tracks without album art are still worth naming here
+		track, _ := raw.Item.toTrack()
+		state.Track = &track
+	}
+	return state, nil
+}
 
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Content-Type", "application/json")
+// CurrentlyPlaying is the track the user's Spotify is playing, a lighter look than
+// PlaybackState without the device and play modes
+type CurrentlyPlaying struct {
+	Playing  bool // false while paused
+	Progress time.Duration
+	Track    *Track // nil while playing something that isn't a track, like an ad
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// GetCurrentlyPlaying fetches the track the user's Spotify is playing. It returns nil
+// without an error when nothing is. Needs the user-read-currently-playing scope.
+func GetCurrentlyPlaying(ctx context.Context, accessToken string) (*CurrentlyPlaying, error) {
+	body, err := doRequest(ctx, accessToken, http.MethodGet, apiBaseURL+"/me/player/currently-playing?market=from_token", nil)
 	if err != nil {
-		return fmt.Errorf("failed to perform play request: %w", err)
+		return nil, fmt.Errorf("failed to fetch currently playing track: %w", err)
+	}
+	// 204 No Content: nothing playing
+	if len(body) == 0 {
+		return nil, nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("spotify play error %d: %s", resp.StatusCode, string(respBody))
+	var raw struct {
+		IsPlaying  bool      `json:"is_playing"`
+		ProgressMs int       `json:"progress_ms"`
+		Item       *apiTrack `json:"item"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode currently playing track: %w", err)
 	}
 
-	return nil
+	current := &CurrentlyPlaying{
+		Playing:  raw.IsPlaying,
+		Progress: time.Duration(raw.ProgressMs) * time.Millisecond,
+	}
+	if raw.Item != nil && raw.Item.ID != "" {
+		track, _ := raw.Item.toTrack()
+		current.Track = &track
+	}
+	return current, nil
+}
+
+// GetCurrentUser fetches the profile of the user the access token belongs to
+func GetCurrentUser(ctx context.Context, accessToken string) (*User, error) {
+	var user User
+	if err := getJSON(ctx, accessToken, apiBaseURL+"/me", &user); err != nil {
+		return nil, fmt.Errorf("failed to fetch user profile: %w", err)
+	}
+
+	return &user, nil
 }
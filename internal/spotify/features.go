@@ -0,0 +1,83 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// audioFeaturesBatchSize is the most IDs /audio-features accepts per request
+const audioFeaturesBatchSize = 100
+
+// AudioFeatures are Spotify's computed audio attributes of a track
+type AudioFeatures struct {
+	ID           string  `json:"id"`
+	Tempo        float64 `json:"tempo"` // BPM
+	Key          int     `json:"key"`   // pitch class 0=C .. 11=B, -1 if unknown
+	Mode         int     `json:"mode"`  // 1 major, 0 minor
+	Energy       float64 `json:"energy"`
+	Danceability float64 `json:"danceability"`
+	Valence      float64 `json:"valence"`
+	Loudness     float64 `json:"loudness"` // dB
+}
+
+// AudioFeaturesResponse matches the /audio-features response structure.
+// Tracks without features come back as null entries.
+type AudioFeaturesResponse struct {
+	AudioFeatures []*AudioFeatures `json:"audio_features"`
+}
+
+// GetAudioFeatures fetches audio features for the given bare track IDs, keyed by ID.
+// IDs are requested in batches of 100, several at once (see SetBatchParallelism), and
+// cached (see ResourceAudioFeatures), so only tracks we haven't seen recently cost an
+// API call. Tracks without features are left out.
+func GetAudioFeatures(ctx context.Context, accessToken string, ids []string) (map[string]AudioFeatures, error) {
+	features := make(map[string]AudioFeatures, len(ids))
+
+	var missing []string
+	for _, id := range ids {
+		var f AudioFeatures
+		if cacheGet(ResourceAudioFeatures, id, &f) {
+			features[id] = f
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	var featuresMu sync.Mutex
+	err := inBatches(ctx, missing, audioFeaturesBatchSize, func(ctx context.Context, batch []string) error {
+		var response AudioFeaturesResponse
+		endpoint := apiBaseURL + "/audio-features?ids=" + url.QueryEscape(strings.Join(batch, ","))
+		if err := getJSON(ctx, accessToken, endpoint, &response); err != nil {
+			return fmt.Errorf("failed to fetch audio features: %w", err)
+		}
+
+		featuresMu.Lock()
+		defer featuresMu.Unlock()
+		for _, f := range response.AudioFeatures {
+			if f == nil {
+				continue
+			}
+			features[f.ID] = *f
+			cachePut(ResourceAudioFeatures, f.ID, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return features, nil
+}
+
+// MergeAudioFeatures sets Features on every track there are features for, matching the
+// tracks' URIs against the bare IDs features are keyed by
+func MergeAudioFeatures(tracks []Track, features map[string]AudioFeatures) {
+	for i := range tracks {
+		if f, ok := features[IDFromURI(tracks[i].ID)]; ok {
+			tracks[i].Features = &f
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// failuresForDegraded is how many calls in a row have to fail before Spotify counts as
+// degraded, a single 502 is Spotify being Spotify
+const failuresForDegraded = 3
+
+// defaultRetryAfter is how long to back off from a 429 without a Retry-After header
+const defaultRetryAfter = 30 * time.Second
+
+// Health is how Spotify's API has been answering lately, across all users
+type Health struct {
+	// RateLimitedUntil is when Spotify allows calls again after answering 429, zero or in
+	// the past when it doesn't limit us
+	RateLimitedUntil time.Time
+	// FailingSince is when the calls that have been failing since, with server errors or
+	// not at all, started to. Zero while Spotify answers, or for the first few failures.
+	FailingSince time.Time
+}
+
+var (
+	healthMu     sync.Mutex
+	health       Health
+	failures     int       // calls failed in a row
+	firstFailure time.Time // when the first of them did
+)
+
+// CurrentHealth returns how Spotify's API has been answering lately
+func CurrentHealth() Health {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	return health
+}
+
+// recordHealth notes how a call went: resp is nil if it didn't go through at all
+func recordHealth(ctx context.Context, resp *http.Response, err error) {
+	// A request given up on by its caller says nothing about Spotify
+	if err != nil && (errors.Is(err, context.Canceled) || ctx.Err() != nil) {
+		return
+	}
+
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	now := time.Now()
+	switch {
+	case resp != nil && resp.StatusCode == http.StatusTooManyRequests:
+		wait := defaultRetryAfter
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			wait = time.Duration(seconds) * time.Second
+		}
+		health.RateLimitedUntil = now.Add(wait)
+	case resp == nil || resp.StatusCode >= 500:
+		if failures == 0 {
+			firstFailure = now
+		}
+		failures++
+		if failures >= failuresForDegraded {
+			health.FailingSince = firstFailure
+		}
+	default:
+		// Client errors are our mistakes or the user's, Spotify answered
+		failures = 0
+		health.FailingSince = time.Time{}
+	}
+}
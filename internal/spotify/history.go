@@ -0,0 +1,69 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// maxRecentlyPlayed bounds how far back FetchRecentlyPlayed pages. Spotify currently
+// stops at 50 plays, but the endpoint is cursor based and may hand out more one day.
+const maxRecentlyPlayed = 500
+
+// Play is one entry of the user's listening history
+type Play struct {
+	TrackID  string // bare Spotify ID
+	PlayedAt time.Time
+	Track    Track // the track as it was played, without AddedAt; zero if it can't be shown
+}
+
+// RecentlyPlayedResponse matches the /me/player/recently-played response structure.
+// Pages are cursor based: next already carries the "before" cursor of the oldest play.
+type RecentlyPlayedResponse struct {
+	Items []struct {
+		PlayedAt string   `json:"played_at"`
+		Track    apiTrack `json:"track"`
+	} `json:"items"`
+	Next    *string `json:"next"` // URL to the page of older plays, null if there are none
+	Cursors *struct {
+		Before string `json:"before"`
+	} `json:"cursors"`
+}
+
+// FetchRecentlyPlayed returns the tracks the user played most recently, newest first, as
+// far back as Spotify keeps them. It needs the user-read-recently-played scope.
+func FetchRecentlyPlayed(ctx context.Context, accessToken string) ([]Play, error) {
+	var plays []Play
+	endpoint := apiBaseURL + "/me/player/recently-played?limit=50"
+
+	for endpoint != "" && len(plays) < maxRecentlyPlayed {
+		var response RecentlyPlayedResponse
+		if err := getJSON(ctx, accessToken, endpoint, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch recently played tracks: %w", err)
+		}
+
+		for _, item := range response.Items {
+			playedAt, err := time.Parse(time.RFC3339, item.PlayedAt)
+			if err != nil {
+				continue
+			}
+			// Liked tracks are keyed by the linked_from ID, so match that
+			id := item.Track.ID
+			if item.Track.LinkedFrom != nil && item.Track.LinkedFrom.ID != "" {
+				id = item.Track.LinkedFrom.ID
+			}
+			play := Play{TrackID: id, PlayedAt: playedAt}
+			if track, ok := item.Track.toTrack(); ok {
+				play.Track = track
+			}
+			plays = append(plays, play)
+		}
+
+		// An empty page or a missing cursor means there is nothing older
+		endpoint = ""
+		if response.Next != nil && len(response.Items) > 0 && response.Cursors != nil && response.Cursors.Before != "" {
+			endpoint = *response.Next
+		}
+	}
+	return plays, nil
+}
@@ -0,0 +1,225 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Album represents a simplified saved album for the albums grid
+type Album struct {
+	ID     string
+	URI    string
+	Name   string
+	Artist string
+	Image  string
+	Type   string // "album", "single" or "compilation"
+	Year   int    // release year, 0 if unknown
+}
+
+// Artist represents a simplified followed artist for the artists grid
+type Artist struct {
+	ID    string
+	URI   string
+	Name  string
+	Image string
+	// Genres and Popularity (0 to 100) are Spotify's, empty for artists cached before we kept them
+	Genres     []string `json:",omitempty"`
+	Popularity int      `json:",omitempty"`
+}
+
+// apiAlbum is a simplified album object as returned by the Spotify API
+type apiAlbum struct {
+	ID          string  `json:"id"`
+	URI         string  `json:"uri"`
+	Name        string  `json:"name"`
+	AlbumType   string  `json:"album_type"`
+	ReleaseDate string  `json:"release_date"` // "2024-03-01", "2024-03" or "2024"
+	Images      []Image `json:"images"`
+	Artists     []struct {
+		Name string `json:"name"`
+	} `json:"artists"`
+}
+
+// toAlbum converts an API album into our tile model. It reports false if the album has no cover.
+func (a apiAlbum) toAlbum() (Album, bool) {
+	image, ok := smallestImage(a.Images)
+	if !ok {
+		return Album{}, false
+	}
+
+	album := Album{
+		ID:    a.ID,
+		URI:   a.URI,
+		Name:  a.Name,
+		Image: image,
+		Type:  a.AlbumType,
+	}
+	if len(a.Artists) > 0 {
+		album.Artist = a.Artists[0].Name
+	}
+	if len(a.ReleaseDate) >= 4 {
+		album.Year, _ = strconv.Atoi(a.ReleaseDate[:4])
+	}
+	return album, true
+}
+
+// SavedAlbumsResponse matches the /me/albums response structure
+type SavedAlbumsResponse struct {
+	Items []struct {
+		AddedAt string   `json:"added_at"`
+		Album   apiAlbum `json:"album"`
+	} `json:"items"`
+	Next *string `json:"next"` // URL to next page, null if last page
+}
+
+// FollowedArtistsResponse matches the /me/following response structure.
+// Unlike other library endpoints it is cursor based and wrapped in an "artists" object.
+type FollowedArtistsResponse struct {
+	Artists struct {
+		Items   []artistResponse `json:"items"`
+		Next    *string          `json:"next"` // URL to next page (already carries the "after" cursor)
+		Cursors struct {
+			After string `json:"after"`
+		} `json:"cursors"`
+	} `json:"artists"`
+}
+
+// FetchSavedAlbums retrieves all albums in the user's library
+func FetchSavedAlbums(ctx context.Context, accessToken string) ([]Album, error) {
+	var albums []Album
+	url := apiBaseURL + "/me/albums?limit=50&market=from_token"
+
+	for url != "" {
+		var response SavedAlbumsResponse
+		if err := getJSON(ctx, accessToken, url, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch saved albums: %w", err)
+		}
+
+		for _, item := range response.Items {
+			// Albums without a cover have nothing to show as a tile
+			if album, ok := item.Album.toAlbum(); ok {
+				albums = append(albums, album)
+			}
+		}
+
+		url = ""
+		if response.Next != nil {
+			url = *response.Next
+		}
+	}
+
+	return albums, nil
+}
+
+// GetAlbum fetches a single album's details
+func GetAlbum(ctx context.Context, accessToken, albumID string) (*Album, error) {
+	var raw apiAlbum
+	endpoint := apiBaseURL + "/albums/" + url.PathEscape(albumID) + "?market=from_token"
+	if err := getJSON(ctx, accessToken, endpoint, &raw); err != nil {
+		return nil, fmt.Errorf("failed to fetch album: %w", err)
+	}
+
+	album, _ := raw.toAlbum()
+	return &album, nil
+}
+
+// savedAlbumsBatch is the most IDs /me/albums accepts per save
+const savedAlbumsBatch = 20
+
+// SaveAlbums adds albums to the user's library, given their bare IDs. Requires the
+// user-library-modify scope.
+func SaveAlbums(ctx context.Context, accessToken string, ids []string) error {
+	for start := 0; start < len(ids); start += savedAlbumsBatch {
+		end := min(start+savedAlbumsBatch, len(ids))
+		endpoint := apiBaseURL + "/me/albums?ids=" + url.QueryEscape(strings.Join(ids[start:end], ","))
+		if _, err := doRequest(ctx, accessToken, http.MethodPut, endpoint, nil); err != nil {
+			return fmt.Errorf("failed to save albums: %w", err)
+		}
+	}
+	return nil
+}
+
+// albumTracksPage is one page of an album's tracks. Album tracks come without the
+// album object, the caller fills it in from the album itself.
+type albumTracksPage struct {
+	Items []apiTrack `json:"items"`
+	Next  *string    `json:"next"` // URL to next page, null if last page
+}
+
+// AlbumResponse matches the /albums/{id} response structure, which includes the first page of tracks
+type AlbumResponse struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	ReleaseDate string          `json:"release_date"`
+	Images      []Image         `json:"images"`
+	Tracks      albumTracksPage `json:"tracks"`
+}
+
+// FetchAlbumTracks retrieves all tracks of an album in disc and track order
+func FetchAlbumTracks(ctx context.Context, accessToken, albumID string) ([]Track, error) {
+	var album AlbumResponse
+	endpoint := apiBaseURL + "/albums/" + url.PathEscape(albumID) + "?market=from_token"
+	if err := getJSON(ctx, accessToken, endpoint, &album); err != nil {
+		return nil, fmt.Errorf("failed to fetch album: %w", err)
+	}
+
+	var tracks []Track
+	page := album.Tracks
+	for {
+		for _, item := range page.Items {
+			item.Album.ID = album.ID
+			item.Album.Name = album.Name
+			item.Album.ReleaseDate = album.ReleaseDate
+			item.Album.Images = album.Images
+			if track, ok := item.toTrack(); ok {
+				tracks = append(tracks, track)
+			} else {
+				logSkipped(track)
+			}
+		}
+
+		if page.Next == nil {
+			break
+		}
+		next := *page.Next
+		page = albumTracksPage{}
+		if err := getJSON(ctx, accessToken, next, &page); err != nil {
+			return nil, fmt.Errorf("failed to fetch album tracks: %w", err)
+		}
+	}
+
+	return tracks, nil
+}
+
+// FetchFollowedArtists retrieves all artists the user follows. Requires the user-follow-read scope.
+func FetchFollowedArtists(ctx context.Context, accessToken string) ([]Artist, error) {
+	var artists []Artist
+	url := apiBaseURL + "/me/following?type=artist&limit=50"
+
+	for url != "" {
+		var response FollowedArtistsResponse
+		if err := getJSON(ctx, accessToken, url, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch followed artists: %w", err)
+		}
+
+		for _, item := range response.Artists.Items {
+			image, ok := smallestImage(item.Images)
+			if !ok {
+				continue // Nothing to show as a tile
+			}
+
+			artists = append(artists, item.toArtist(image))
+		}
+
+		url = ""
+		if response.Artists.Next != nil && response.Artists.Cursors.After != "" {
+			url = *response.Artists.Next
+		}
+	}
+
+	return artists, nil
+}
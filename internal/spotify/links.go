@@ -0,0 +1,60 @@
+package spotify
+
+import "strings"
+
+// openBaseURL is where Spotify's web player and share links live
+const openBaseURL = "https://open.spotify.com"
+
+// OpenURL turns a URI like "spotify:track:4uLU6hMCjMI75M1A2tKUQC" into its canonical
+// share link, https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC. It returns an
+// empty string for anything that isn't a spotify: URI.
+func OpenURL(uri string) string {
+	parts := strings.Split(uri, ":")
+	if len(parts) != 3 || parts[0] != "spotify" || parts[1] == "" || parts[2] == "" {
+		return ""
+	}
+	return openBaseURL + "/" + parts[1] + "/" + parts[2]
+}
+
+// TrackURI returns the URI of the track with the given bare ID
+func TrackURI(trackID string) string {
+	return "spotify:track:" + trackID
+}
+
+// URI returns the track's spotify: URI. Tracks are keyed by it, so it's the same as ID.
+func (t Track) URI() string {
+	return t.ID
+}
+
+// URL returns the track's open.spotify.com link
+func (t Track) URL() string {
+	return OpenURL(t.ID)
+}
+
+// URI returns the artist's spotify: URI
+func (a TrackArtist) URI() string {
+	return "spotify:artist:" + a.ID
+}
+
+// URL returns the artist's open.spotify.com link
+func (a TrackArtist) URL() string {
+	return OpenURL(a.URI())
+}
+
+// URL returns the album's open.spotify.com link
+func (a Album) URL() string {
+	return OpenURL(a.URI)
+}
+
+// URL returns the artist's open.spotify.com link
+func (a Artist) URL() string {
+	return OpenURL(a.URI)
+}
+
+// URL returns the playlist's open.spotify.com link
+func (p Playlist) URL() string {
+	if p.ExternalURLs.Spotify != "" {
+		return p.ExternalURLs.Spotify
+	}
+	return OpenURL(p.URI)
+}
@@ -0,0 +1,344 @@
+package spotify
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Playlist is a playlist of the user, either one we created or changed or one from their library
+type Playlist struct {
+	ID           string `json:"id"`
+	URI          string `json:"uri"`
+	Name         string `json:"name"`
+	SnapshotID   string `json:"snapshot_id"` // changes with every edit, see cachedPlaylistTracks
+	ExternalURLs struct {
+		Spotify string `json:"spotify"`
+	} `json:"external_urls"`
+	Images []Image `json:"images"` // null for empty playlists without a custom cover
+	Owner  struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"display_name"`
+	} `json:"owner"`
+	Tracks struct {
+		Total int `json:"total"`
+	} `json:"tracks"`
+	Collaborative bool `json:"collaborative"` // others than the owner may change it
+}
+
+// Image returns the playlist's smallest cover image, good enough for a grid tile
+func (p Playlist) Image() string {
+	image, _ := smallestImage(p.Images)
+	return image
+}
+
+// UserPlaylistsResponse matches the /me/playlists response structure
+type UserPlaylistsResponse struct {
+	Items []*Playlist `json:"items"` // Spotify occasionally sends null items
+	Next  *string     `json:"next"`  // URL to next page, null if last page
+}
+
+const (
+	// playlistAddBatch is the most tracks Spotify accepts per request adding or removing items
+	playlistAddBatch = 100
+	// MaxCoverBytes is the largest JPEG Spotify accepts as a playlist cover,
+	// measured before base64 encoding (the encoded limit is 256 KB)
+	MaxCoverBytes = 256 * 1024 * 3 / 4
+)
+
+// CreatePlaylist creates a new playlist owned by the user, empty until tracks are added.
+// Private playlists need the playlist-modify-private scope, public ones
+// playlist-modify-public.
+func CreatePlaylist(ctx context.Context, accessToken, userID, name, description string, public bool) (*Playlist, error) {
+	endpoint := apiBaseURL + "/users/" + url.PathEscape(userID) + "/playlists"
+
+	bodyData := map[string]any{
+		"name":        name,
+		"description": description,
+		"public":      public,
+	}
+
+	body, err := doRequest(ctx, accessToken, http.MethodPost, endpoint, bodyData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create playlist: %w", err)
+	}
+
+	var playlist Playlist
+	if err := json.Unmarshal(body, &playlist); err != nil {
+		return nil, fmt.Errorf("failed to decode playlist: %w", err)
+	}
+	return &playlist, nil
+}
+
+// FetchUserPlaylists retrieves all playlists the user owns or follows, in the order their
+// Spotify library shows them. Private ones need the playlist-read-private scope.
+func FetchUserPlaylists(ctx context.Context, accessToken string) ([]Playlist, error) {
+	var playlists []Playlist
+	endpoint := apiBaseURL + "/me/playlists?limit=50"
+
+	for endpoint != "" {
+		var response UserPlaylistsResponse
+		if err := getJSON(ctx, accessToken, endpoint, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch playlists: %w", err)
+		}
+
+		for _, item := range response.Items {
+			if item == nil {
+				continue
+			}
+			playlists = append(playlists, *item)
+		}
+
+		endpoint = ""
+		if response.Next != nil {
+			endpoint = *response.Next
+		}
+	}
+
+	return playlists, nil
+}
+
+// PlaylistTracksResponse matches the /playlists/{id}/tracks response structure
+type PlaylistTracksResponse struct {
+	Items []struct {
+		AddedAt string `json:"added_at"`
+		IsLocal bool   `json:"is_local"`
+		Track   *struct {
+			apiTrack
+			Type string `json:"type"` // "track" or "episode"
+		} `json:"track"` // null for tracks that are no longer available
+	} `json:"items"`
+	Next *string `json:"next"` // URL to next page, null if last page
+}
+
+// FetchPlaylistTracks retrieves all tracks of a playlist in playlist order. Podcast episodes
+// and local files are skipped, they can't be shown as tiles. The tracks are cached per
+// playlist version, so unchanged playlists cost one small request.
+func FetchPlaylistTracks(ctx context.Context, accessToken, playlistID string) ([]Track, error) {
+	var playlist Playlist
+	endpoint := apiBaseURL + "/playlists/" + url.PathEscape(playlistID) + "?fields=snapshot_id"
+	if err := getJSON(ctx, accessToken, endpoint, &playlist); err != nil {
+		return nil, fmt.Errorf("failed to fetch playlist: %w", err)
+	}
+
+	return cachedPlaylistTracks(playlistID, playlist.SnapshotID, func() ([]Track, error) {
+		return fetchPlaylistTracks(ctx, accessToken, playlistID)
+	})
+}
+
+// PlaylistTracksAt is FetchPlaylistTracks for a playlist from FetchUserPlaylists, which
+// lists the snapshot of each: cached tracks cost no request at all
+func PlaylistTracksAt(ctx context.Context, accessToken string, playlist Playlist) ([]Track, error) {
+	return cachedPlaylistTracks(playlist.ID, playlist.SnapshotID, func() ([]Track, error) {
+		return fetchPlaylistTracks(ctx, accessToken, playlist.ID)
+	})
+}
+
+// fetchPlaylistTracks pages through the playlist's tracks, see FetchPlaylistTracks. The
+// snapshot cache shares them between users, so they are fetched for no market in
+// particular: relinked for one user's market they would carry its albums to everybody.
+func fetchPlaylistTracks(ctx context.Context, accessToken, playlistID string) ([]Track, error) {
+	var tracks []Track
+	endpoint := apiBaseURL + "/playlists/" + url.PathEscape(playlistID) + "/tracks?limit=100"
+
+	for endpoint != "" {
+		var response PlaylistTracksResponse
+		if err := getJSON(ctx, accessToken, endpoint, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch playlist tracks: %w", err)
+		}
+
+		for _, item := range response.Items {
+			if item.Track == nil || item.IsLocal || item.Track.Type != "track" {
+				continue
+			}
+			if track, ok := item.Track.toTrack(); ok {
+				track.AddedAt, _ = time.Parse(time.RFC3339, item.AddedAt)
+				tracks = append(tracks, track)
+			} else {
+				logSkipped(track)
+			}
+		}
+
+		endpoint = ""
+		if response.Next != nil {
+			endpoint = *response.Next
+		}
+	}
+
+	return tracks, nil
+}
+
+// AddPlaylistTracks appends tracks to a playlist, in order, as many requests as Spotify
+// needs for them. It returns the playlist's snapshot ID after the last one. A failed
+// request isn't retried, the tracks might have been added anyway, and the error tells
+// how many were added before.
+func AddPlaylistTracks(ctx context.Context, accessToken, playlistID string, trackURIs []string) (string, error) {
+	endpoint := apiBaseURL + "/playlists/" + url.PathEscape(playlistID) + "/tracks"
+	defer forgetPlaylist(playlistID)
+
+	var snapshotID string
+	for start := 0; start < len(trackURIs); start += playlistAddBatch {
+		end := min(start+playlistAddBatch, len(trackURIs))
+
+		bodyData := map[string][]string{
+			"uris": trackURIs[start:end],
+		}
+		body, err := doRequest(ctx, accessToken, http.MethodPost, endpoint, bodyData)
+		if err != nil {
+			return snapshotID, fmt.Errorf("failed to add tracks to playlist after %d of %d: %w", start, len(trackURIs), err)
+		}
+
+		var response struct {
+			SnapshotID string `json:"snapshot_id"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return snapshotID, fmt.Errorf("failed to decode playlist snapshot: %w", err)
+		}
+		snapshotID = response.SnapshotID
+	}
+	return snapshotID, nil
+}
+
+// FetchPlaylistItems retrieves a playlist with the URIs of all its items in playlist
+// order. Unlike FetchPlaylistTracks nothing is skipped, local files and podcast episodes
+// are there too, so an index is a position in the playlist. Items that are no longer
+// available have an empty URI.
+func FetchPlaylistItems(ctx context.Context, accessToken, playlistID string) (*Playlist, []string, error) {
+	var playlist Playlist
+	endpoint := apiBaseURL + "/playlists/" + url.PathEscape(playlistID) +
+		"?fields=id,uri,name,snapshot_id,external_urls,collaborative,owner(id,display_name),tracks.total"
+	if err := getJSON(ctx, accessToken, endpoint, &playlist); err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch playlist: %w", err)
+	}
+
+	var uris []string
+	endpoint = apiBaseURL + "/playlists/" + url.PathEscape(playlistID) + "/tracks?limit=100&fields=items(track(uri)),next"
+	for endpoint != "" {
+		var response struct {
+			Items []struct {
+				Track *struct {
+					URI string `json:"uri"`
+				} `json:"track"`
+			} `json:"items"`
+			Next *string `json:"next"`
+		}
+		if err := getJSON(ctx, accessToken, endpoint, &response); err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch playlist items: %w", err)
+		}
+
+		for _, item := range response.Items {
+			var uri string
+			if item.Track != nil {
+				uri = item.Track.URI
+			}
+			uris = append(uris, uri)
+		}
+
+		endpoint = ""
+		if response.Next != nil {
+			endpoint = *response.Next
+		}
+	}
+
+	return &playlist, uris, nil
+}
+
+// RemovePlaylistTracks removes every occurrence of the tracks from a playlist, as of the
+// given snapshot so tracks added since stay, or of its latest version when snapshotID
+// is empty. It returns the snapshot ID after the removal.
+func RemovePlaylistTracks(ctx context.Context, accessToken, playlistID, snapshotID string, trackURIs []string) (string, error) {
+	endpoint := apiBaseURL + "/playlists/" + url.PathEscape(playlistID) + "/tracks"
+	defer forgetPlaylist(playlistID)
+
+	for start := 0; start < len(trackURIs); start += playlistAddBatch {
+		end := min(start+playlistAddBatch, len(trackURIs))
+
+		tracks := make([]map[string]string, 0, end-start)
+		for _, uri := range trackURIs[start:end] {
+			tracks = append(tracks, map[string]string{"uri": uri})
+		}
+		bodyData := map[string]any{"tracks": tracks}
+		if snapshotID != "" {
+			bodyData["snapshot_id"] = snapshotID
+		}
+		body, err := doRequest(ctx, accessToken, http.MethodDelete, endpoint, bodyData)
+		if err != nil {
+			return snapshotID, fmt.Errorf("failed to remove tracks from playlist: %w", err)
+		}
+
+		var response struct {
+			SnapshotID string `json:"snapshot_id"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return snapshotID, fmt.Errorf("failed to decode playlist snapshot: %w", err)
+		}
+		snapshotID = response.SnapshotID
+	}
+	return snapshotID, nil
+}
+
+// InsertPlaylistTrack adds a track to a playlist at a position, 0 being the first
+func InsertPlaylistTrack(ctx context.Context, accessToken, playlistID, trackURI string, position int) error {
+	endpoint := apiBaseURL + "/playlists/" + url.PathEscape(playlistID) + "/tracks"
+	defer forgetPlaylist(playlistID)
+
+	bodyData := map[string]any{
+		"uris":     []string{trackURI},
+		"position": position,
+	}
+	if _, err := doRequest(ctx, accessToken, http.MethodPost, endpoint, bodyData); err != nil {
+		return fmt.Errorf("failed to add track to playlist: %w", err)
+	}
+	return nil
+}
+
+// ReorderPlaylistItems moves the item at position rangeStart of a playlist to before the
+// one at insertBefore, positions counted from 0 as in FetchPlaylistItems: insertBefore 0
+// makes it the first, the playlist's length the last. With a snapshotID the positions
+// are those of that version of the playlist, so a move made on a stale view doesn't
+// shuffle the wrong item. It returns the snapshot ID after the move.
+func ReorderPlaylistItems(ctx context.Context, accessToken, playlistID string, rangeStart, insertBefore int, snapshotID string) (string, error) {
+	endpoint := apiBaseURL + "/playlists/" + url.PathEscape(playlistID) + "/tracks"
+	defer forgetPlaylist(playlistID)
+
+	bodyData := map[string]any{
+		"range_start":   rangeStart,
+		"insert_before": insertBefore,
+		"range_length":  1,
+	}
+	if snapshotID != "" {
+		bodyData["snapshot_id"] = snapshotID
+	}
+	body, err := doRequest(ctx, accessToken, http.MethodPut, endpoint, bodyData)
+	if err != nil {
+		return snapshotID, fmt.Errorf("failed to reorder playlist: %w", err)
+	}
+
+	var response struct {
+		SnapshotID string `json:"snapshot_id"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return snapshotID, fmt.Errorf("failed to decode playlist snapshot: %w", err)
+	}
+	return response.SnapshotID, nil
+}
+
+// UploadPlaylistCover replaces the cover image of a playlist with a JPEG of at most MaxCoverBytes.
+// It needs the ugc-image-upload scope.
+func UploadPlaylistCover(ctx context.Context, accessToken, playlistID string, jpeg []byte) error {
+	if len(jpeg) > MaxCoverBytes {
+		return fmt.Errorf("cover image is %d bytes, Spotify accepts at most %d", len(jpeg), MaxCoverBytes)
+	}
+
+	endpoint := apiBaseURL + "/playlists/" + url.PathEscape(playlistID) + "/images"
+	encoded := base64.StdEncoding.EncodeToString(jpeg)
+	if _, err := doRawRequest(ctx, accessToken, http.MethodPut, endpoint, "image/jpeg", strings.NewReader(encoded)); err != nil {
+		return fmt.Errorf("failed to upload playlist cover: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxRecommendationSeeds is the most seeds /recommendations takes, tracks, artists and
+// genres combined
+const maxRecommendationSeeds = 5
+
+// recommendationsLimit is how many tracks we ask for, the most Spotify returns
+const recommendationsLimit = 100
+
+var (
+	// ErrNoSeeds is returned for recommendations without any seed
+	ErrNoSeeds = errors.New("recommendations need at least one seed")
+	// ErrTooManySeeds is returned for more than maxRecommendationSeeds seeds
+	ErrTooManySeeds = fmt.Errorf("recommendations take at most %d seeds", maxRecommendationSeeds)
+	// ErrInvalidTunable is returned for tunables Spotify doesn't know
+	ErrInvalidTunable = errors.New("unknown recommendation tunable")
+)
+
+// RecommendationSeeds are what recommendations are based on: bare track and artist IDs
+// and genre names like "house", at most maxRecommendationSeeds in total
+type RecommendationSeeds struct {
+	Tracks  []string
+	Artists []string
+	Genres  []string
+}
+
+// count returns the number of seeds
+func (s RecommendationSeeds) count() int {
+	return len(s.Tracks) + len(s.Artists) + len(s.Genres)
+}
+
+// tunableAttributes are the audio attributes recommendations can be tuned by, each with
+// a min_, max_ and target_ form, e.g. target_energy=0.8 or min_tempo=120
+var tunableAttributes = []string{
+	"acousticness", "danceability", "duration_ms", "energy", "instrumentalness", "key",
+	"liveness", "loudness", "mode", "popularity", "speechiness", "tempo",
+	"time_signature", "valence",
+}
+
+// ValidTunable reports whether name is a tunable /recommendations accepts
+func ValidTunable(name string) bool {
+	for _, prefix := range []string{"min_", "max_", "target_"} {
+		if attribute, ok := strings.CutPrefix(name, prefix); ok {
+			return slices.Contains(tunableAttributes, attribute)
+		}
+	}
+	return false
+}
+
+// RecommendationsResponse matches the /recommendations response structure
+type RecommendationsResponse struct {
+	Tracks []apiTrack `json:"tracks"`
+}
+
+// GetRecommendations returns tracks similar to the seeds, narrowed down by tunables
+// such as {"target_energy": 0.8, "min_tempo": 120} (see ValidTunable).
+func GetRecommendations(ctx context.Context, accessToken string, seeds RecommendationSeeds, tunables map[string]float64) ([]Track, error) {
+	switch n := seeds.count(); {
+	case n == 0:
+		return nil, ErrNoSeeds
+	case n > maxRecommendationSeeds:
+		return nil, ErrTooManySeeds
+	}
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(recommendationsLimit))
+	query.Set("market", "from_token")
+	for param, values := range map[string][]string{
+		"seed_tracks":  seeds.Tracks,
+		"seed_artists": seeds.Artists,
+		"seed_genres":  seeds.Genres,
+	} {
+		if len(values) > 0 {
+			query.Set(param, strings.Join(values, ","))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(tunables)) {
+		if !ValidTunable(name) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTunable, name)
+		}
+		query.Set(name, strconv.FormatFloat(tunables[name], 'f', -1, 64))
+	}
+
+	var response RecommendationsResponse
+	if err := getJSON(ctx, accessToken, apiBaseURL+"/recommendations?"+query.Encode(), &response); err != nil {
+		return nil, fmt.Errorf("failed to fetch recommendations: %w", err)
+	}
+
+	tracks := make([]Track, 0, len(response.Tracks))
+	for _, item := range response.Tracks {
+		if track, ok := item.toTrack(); ok {
+			tracks = append(tracks, track)
+		} else {
+			logSkipped(track)
+		}
+	}
+	return tracks, nil
+}
@@ -0,0 +1,93 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Types of items Search can look for
+const (
+	SearchTrack  = "track"
+	SearchAlbum  = "album"
+	SearchArtist = "artist"
+)
+
+// maxSearchLimit is the most results /search returns per type
+const maxSearchLimit = 50
+
+// ErrInvalidSearch is returned for an empty query or types Search doesn't know
+var ErrInvalidSearch = errors.New("invalid search")
+
+// SearchResults are the matches of a search, in Spotify's order of relevance. Types that
+// weren't searched for are empty.
+type SearchResults struct {
+	Tracks  []Track
+	Albums  []Album
+	Artists []Artist
+}
+
+// searchResponse matches the /search response structure. Only the requested types are set.
+type searchResponse struct {
+	Tracks struct {
+		Items []apiTrack `json:"items"`
+	} `json:"tracks"`
+	Albums struct {
+		Items []apiAlbum `json:"items"`
+	} `json:"albums"`
+	Artists struct {
+		Items []artistResponse `json:"items"`
+	} `json:"artists"`
+}
+
+// Search looks through Spotify's whole catalog, not just the user's library, for items
+// of the given types (SearchTrack, SearchAlbum, SearchArtist) matching query. limit is
+// per type and clamped to 1..50. Results without images are left out, like in the library.
+func Search(ctx context.Context, accessToken, query string, types []string, limit int) (*SearchResults, error) {
+	query = strings.TrimSpace(query)
+	if query == "" || len(types) == 0 {
+		return nil, fmt.Errorf("%w: need a query and at least one type", ErrInvalidSearch)
+	}
+	for _, t := range types {
+		if !slices.Contains([]string{SearchTrack, SearchAlbum, SearchArtist}, t) {
+			return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidSearch, t)
+		}
+	}
+
+	params := url.Values{
+		"q":      {query},
+		"type":   {strings.Join(types, ",")},
+		"limit":  {strconv.Itoa(min(max(limit, 1), maxSearchLimit))},
+		"market": {"from_token"},
+	}
+	var response searchResponse
+	if err := getJSON(ctx, accessToken, apiBaseURL+"/search?"+params.Encode(), &response); err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+
+	results := &SearchResults{}
+	for _, item := range response.Tracks.Items {
+		if track, ok := item.toTrack(); ok {
+			results.Tracks = append(results.Tracks, track)
+		} else {
+			logSkipped(track)
+		}
+	}
+	for _, item := range response.Albums.Items {
+		if album, ok := item.toAlbum(); ok {
+			results.Albums = append(results.Albums, album)
+		}
+	}
+	for _, item := range response.Artists.Items {
+		image, ok := smallestImage(item.Images)
+		if !ok {
+			continue
+		}
+		results.Artists = append(results.Artists, item.toArtist(image))
+	}
+	return results, nil
+}
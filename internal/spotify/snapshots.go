@@ -0,0 +1,102 @@
+package spotify
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxSnapshotTracks bounds memory use by the tracks of all cached playlists together. When
+// it is reached the playlists read least recently are dropped until the new one fits, a
+// playlist larger than all of it isn't cached at all.
+const maxSnapshotTracks = 200000
+
+// playlistSnapshot is the cached content of a playlist at one of its snapshots.
+// Spotify gives every version of a playlist a new snapshot_id, so as long as the
+// snapshot_id we see is unchanged the cached tracks are exact and need no TTL.
+type playlistSnapshot struct {
+	snapshotID string
+	tracks     []Track
+	readAt     time.Time // when it was cached or last read from the cache
+}
+
+var (
+	snapshotMu     sync.Mutex
+	snapshotCache  = make(map[string]*playlistSnapshot) // keyed by playlist ID
+	snapshotTracks int                                  // of all cached playlists together
+)
+
+// cachedPlaylistTracks returns the playlist's tracks for the given snapshot, calling
+// fetch only when the cached copy belongs to a different snapshot (or there is none).
+//
+// The snapshot ID must have been obtained with the requesting user's own token, which
+// is what makes sharing the cache between users safe: a user who can't see a private
+// playlist never learns its snapshot ID.
+func cachedPlaylistTracks(playlistID, snapshotID string, fetch func() ([]Track, error)) ([]Track, error) {
+	snapshotMu.Lock()
+	if cached, ok := snapshotCache[playlistID]; ok && snapshotID != "" && cached.snapshotID == snapshotID {
+		cached.readAt = time.Now()
+		tracks := append([]Track(nil), cached.tracks...)
+		snapshotMu.Unlock()
+		return tracks, nil
+	}
+	snapshotMu.Unlock()
+
+	tracks, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshotID != "" && len(tracks) <= maxSnapshotTracks {
+		snapshotMu.Lock()
+		dropSnapshot(playlistID)
+		evictSnapshots(len(tracks))
+		snapshotCache[playlistID] = &playlistSnapshot{snapshotID: snapshotID, tracks: tracks, readAt: time.Now()}
+		snapshotTracks += len(tracks)
+		snapshotMu.Unlock()
+	}
+	return append([]Track(nil), tracks...), nil
+}
+
+// evictSnapshots drops the playlists read least recently until n more tracks fit.
+// snapshotMu must be held.
+func evictSnapshots(n int) {
+	if snapshotTracks+n <= maxSnapshotTracks {
+		return
+	}
+	ids := slices.Collect(maps.Keys(snapshotCache))
+	slices.SortFunc(ids, func(a, b string) int {
+		return snapshotCache[a].readAt.Compare(snapshotCache[b].readAt)
+	})
+	for _, id := range ids {
+		if snapshotTracks+n <= maxSnapshotTracks {
+			return
+		}
+		dropSnapshot(id)
+	}
+}
+
+// dropSnapshot forgets the cached contents of a playlist. snapshotMu must be held.
+func dropSnapshot(playlistID string) {
+	if cached, ok := snapshotCache[playlistID]; ok {
+		snapshotTracks -= len(cached.tracks)
+		delete(snapshotCache, playlistID)
+	}
+}
+
+// PlaylistCached reports whether the tracks of a playlist from FetchUserPlaylists are
+// cached at the snapshot it was listed with
+func PlaylistCached(playlist Playlist) bool {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	cached, ok := snapshotCache[playlist.ID]
+	return ok && playlist.SnapshotID != "" && cached.snapshotID == playlist.SnapshotID
+}
+
+// forgetPlaylist drops the cached contents of a playlist, e.g. after we modified it ourselves
+func forgetPlaylist(playlistID string) {
+	snapshotMu.Lock()
+	dropSnapshot(playlistID)
+	snapshotMu.Unlock()
+}
@@ -0,0 +1,266 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Audiobook represents a simplified saved audiobook for the audiobooks grid
+type Audiobook struct {
+	ID     string
+	URI    string
+	Name   string
+	Author string
+	Image  string
+}
+
+// Episode represents a simplified saved podcast episode for the episodes grid
+type Episode struct {
+	ID          string
+	URI         string
+	Name        string
+	Show        string
+	Image       string
+	ReleaseDate string        `json:",omitempty"` // e.g. 2024-05-01, only set when listing a show's episodes
+	Duration    time.Duration `json:",omitempty"`
+}
+
+// Show represents a simplified saved podcast for the shows grid
+type Show struct {
+	ID        string
+	URI       string
+	Name      string
+	Publisher string
+	Image     string
+}
+
+// apiAudiobook is an audiobook object as returned by the Spotify API
+type apiAudiobook struct {
+	ID      string  `json:"id"`
+	URI     string  `json:"uri"`
+	Name    string  `json:"name"`
+	Images  []Image `json:"images"`
+	Authors []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+}
+
+// SavedAudiobooksResponse matches the /me/audiobooks response structure. Spotify has
+// returned items both bare and wrapped in an "audiobook" object, so both are accepted.
+type SavedAudiobooksResponse struct {
+	Items []struct {
+		apiAudiobook
+		Audiobook *apiAudiobook `json:"audiobook"`
+	} `json:"items"`
+	Next *string `json:"next"` // URL to next page, null if last page
+}
+
+// SavedEpisodesResponse matches the /me/episodes response structure
+type SavedEpisodesResponse struct {
+	Items []struct {
+		AddedAt string `json:"added_at"`
+		Episode struct {
+			ID     string  `json:"id"`
+			URI    string  `json:"uri"`
+			Name   string  `json:"name"`
+			Images []Image `json:"images"`
+			Show   struct {
+				Name   string  `json:"name"`
+				Images []Image `json:"images"`
+			} `json:"show"`
+		} `json:"episode"`
+	} `json:"items"`
+	Next *string `json:"next"` // URL to next page, null if last page
+}
+
+// apiShow is a show object as returned by the Spotify API
+type apiShow struct {
+	ID        string  `json:"id"`
+	URI       string  `json:"uri"`
+	Name      string  `json:"name"`
+	Publisher string  `json:"publisher"`
+	Images    []Image `json:"images"`
+}
+
+// showEpisodesPage is one page of a show's episodes. They come without the show object,
+// the caller fills it in from the show itself.
+type showEpisodesPage struct {
+	Items []*struct {
+		ID          string  `json:"id"`
+		URI         string  `json:"uri"`
+		Name        string  `json:"name"`
+		Images      []Image `json:"images"`
+		ReleaseDate string  `json:"release_date"`
+		DurationMS  int     `json:"duration_ms"`
+	} `json:"items"`
+	Next *string `json:"next"` // URL to next page, null if last page
+}
+
+// SavedShowsResponse matches the /me/shows response structure
+type SavedShowsResponse struct {
+	Items []struct {
+		AddedAt string  `json:"added_at"`
+		Show    apiShow `json:"show"`
+	} `json:"items"`
+	Next *string `json:"next"` // URL to next page, null if last page
+}
+
+// FetchSavedAudiobooks retrieves all audiobooks in the user's library. Requires the user-library-read scope.
+func FetchSavedAudiobooks(ctx context.Context, accessToken string) ([]Audiobook, error) {
+	var audiobooks []Audiobook
+	url := apiBaseURL + "/me/audiobooks?limit=50"
+
+	for url != "" {
+		var response SavedAudiobooksResponse
+		if err := getJSON(ctx, accessToken, url, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch saved audiobooks: %w", err)
+		}
+
+		for _, item := range response.Items {
+			book := item.apiAudiobook
+			if item.Audiobook != nil {
+				book = *item.Audiobook
+			}
+
+			image, ok := smallestImage(book.Images)
+			if !ok {
+				continue // Nothing to show as a tile
+			}
+
+			audiobook := Audiobook{
+				ID:    book.ID,
+				URI:   book.URI,
+				Name:  book.Name,
+				Image: image,
+			}
+			if len(book.Authors) > 0 {
+				audiobook.Author = book.Authors[0].Name
+			}
+			audiobooks = append(audiobooks, audiobook)
+		}
+
+		url = ""
+		if response.Next != nil {
+			url = *response.Next
+		}
+	}
+
+	return audiobooks, nil
+}
+
+// FetchSavedEpisodes retrieves all podcast episodes the user saved. Requires the
+// user-library-read and user-read-playback-position scopes.
+func FetchSavedEpisodes(ctx context.Context, accessToken string) ([]Episode, error) {
+	var episodes []Episode
+	url := apiBaseURL + "/me/episodes?limit=50&market=from_token"
+
+	for url != "" {
+		var response SavedEpisodesResponse
+		if err := getJSON(ctx, accessToken, url, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch saved episodes: %w", err)
+		}
+
+		for _, item := range response.Items {
+			// Episodes usually share the show's artwork, but some have their own
+			image, ok := smallestImage(item.Episode.Images)
+			if !ok {
+				if image, ok = smallestImage(item.Episode.Show.Images); !ok {
+					continue // Nothing to show as a tile
+				}
+			}
+
+			episodes = append(episodes, Episode{
+				ID:    item.Episode.ID,
+				URI:   item.Episode.URI,
+				Name:  item.Episode.Name,
+				Show:  item.Episode.Show.Name,
+				Image: image,
+			})
+		}
+
+		url = ""
+		if response.Next != nil {
+			url = *response.Next
+		}
+	}
+
+	return episodes, nil
+}
+
+// FetchSavedShows retrieves all podcasts the user follows. Requires the user-library-read scope.
+func FetchSavedShows(ctx context.Context, accessToken string) ([]Show, error) {
+	var shows []Show
+	url := apiBaseURL + "/me/shows?limit=50"
+
+	for url != "" {
+		var response SavedShowsResponse
+		if err := getJSON(ctx, accessToken, url, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch saved shows: %w", err)
+		}
+
+		for _, item := range response.Items {
+			image, ok := smallestImage(item.Show.Images)
+			if !ok {
+				continue // Nothing to show as a tile
+			}
+
+			shows = append(shows, Show{
+				ID:        item.Show.ID,
+				URI:       item.Show.URI,
+				Name:      item.Show.Name,
+				Publisher: item.Show.Publisher,
+				Image:     image,
+			})
+		}
+
+		url = ""
+		if response.Next != nil {
+			url = *response.Next
+		}
+	}
+
+	return shows, nil
+}
+
+// GetShowEpisodes fetches the episodes of a show, newest first, following pages until it
+// has limit of them (all of them for zero). Show and, for episodes without artwork of
+// their own, Image are left for the caller to fill in from the show. Episodes the market
+// can't play come back null and are left out.
+func GetShowEpisodes(ctx context.Context, accessToken, showID string, limit int) ([]Episode, error) {
+	var episodes []Episode
+	endpoint := apiBaseURL + "/shows/" + url.PathEscape(showID) + "/episodes?limit=50&market=from_token"
+
+	for endpoint != "" {
+		var page showEpisodesPage
+		if err := getJSON(ctx, accessToken, endpoint, &page); err != nil {
+			return nil, fmt.Errorf("failed to fetch show episodes: %w", err)
+		}
+
+		for _, item := range page.Items {
+			if item == nil {
+				continue
+			}
+			image, _ := smallestImage(item.Images)
+			episodes = append(episodes, Episode{
+				ID:          item.ID,
+				URI:         item.URI,
+				Name:        item.Name,
+				Image:       image,
+				ReleaseDate: item.ReleaseDate,
+				Duration:    time.Duration(item.DurationMS) * time.Millisecond,
+			})
+			if limit > 0 && len(episodes) == limit {
+				return episodes, nil
+			}
+		}
+
+		endpoint = ""
+		if page.Next != nil {
+			endpoint = *page.Next
+		}
+	}
+
+	return episodes, nil
+}
@@ -0,0 +1,107 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// TimeRange is the period Spotify computes a user's top items over
+type TimeRange string
+
+const (
+	ShortTerm  TimeRange = "short_term"  // about the last 4 weeks
+	MediumTerm TimeRange = "medium_term" // about the last 6 months
+	LongTerm   TimeRange = "long_term"   // about the last year
+)
+
+// Valid reports whether r is one of the time ranges Spotify knows
+func (r TimeRange) Valid() bool {
+	return r == ShortTerm || r == MediumTerm || r == LongTerm
+}
+
+// TopTracksResponse matches the /me/top/tracks response structure
+type TopTracksResponse struct {
+	Items []apiTrack `json:"items"`
+	Next  *string    `json:"next"` // URL to next page, null if last page
+}
+
+// TopArtistsResponse matches the /me/top/artists response structure
+type TopArtistsResponse struct {
+	Items []struct {
+		ID     string  `json:"id"`
+		URI    string  `json:"uri"`
+		Name   string  `json:"name"`
+		Images []Image `json:"images"`
+	} `json:"items"`
+	Next *string `json:"next"` // URL to next page, null if last page
+}
+
+// topEndpoint returns the first page URL of the user's top items of a type
+func topEndpoint(itemType string, timeRange TimeRange) string {
+	return apiBaseURL + "/me/top/" + itemType + "?limit=50&time_range=" + url.QueryEscape(string(timeRange))
+}
+
+// FetchTopTracks retrieves the user's most played tracks over the time range, most played
+// first. Spotify ranks at most a few hundred. Requires the user-top-read scope.
+func FetchTopTracks(ctx context.Context, accessToken string, timeRange TimeRange) ([]Track, error) {
+	var tracks []Track
+	endpoint := topEndpoint("tracks", timeRange)
+
+	for endpoint != "" {
+		var response TopTracksResponse
+		if err := getJSON(ctx, accessToken, endpoint, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch top tracks: %w", err)
+		}
+
+		for _, item := range response.Items {
+			if track, ok := item.toTrack(); ok {
+				tracks = append(tracks, track)
+			} else {
+				logSkipped(track)
+			}
+		}
+
+		endpoint = ""
+		if response.Next != nil {
+			endpoint = *response.Next
+		}
+	}
+
+	return tracks, nil
+}
+
+// FetchTopArtists retrieves the user's most played artists over the time range, most
+// played first. Requires the user-top-read scope.
+func FetchTopArtists(ctx context.Context, accessToken string, timeRange TimeRange) ([]Artist, error) {
+	var artists []Artist
+	endpoint := topEndpoint("artists", timeRange)
+
+	for endpoint != "" {
+		var response TopArtistsResponse
+		if err := getJSON(ctx, accessToken, endpoint, &response); err != nil {
+			return nil, fmt.Errorf("failed to fetch top artists: %w", err)
+		}
+
+		for _, item := range response.Items {
+			image, ok := smallestImage(item.Images)
+			if !ok {
+				continue // Nothing to show as a tile
+			}
+
+			artists = append(artists, Artist{
+				ID:    item.ID,
+				URI:   item.URI,
+				Name:  item.Name,
+				Image: image,
+			})
+		}
+
+		endpoint = ""
+		if response.Next != nil {
+			endpoint = *response.Next
+		}
+	}
+
+	return artists, nil
+}
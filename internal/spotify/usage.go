@@ -0,0 +1,138 @@
+package spotify
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type userKey struct{}
+
+// WithUser attributes the API calls made with ctx to a Spotify user for usage accounting
+func WithUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+// userFromContext returns the user set by WithUser, or "" for calls made on nobody's behalf
+func userFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userKey{}).(string)
+	return userID
+}
+
+// Usage counts the Spotify API calls made on behalf of one user.
+// Cache hits don't count, only requests that actually went out.
+type Usage struct {
+	UserID     string
+	Today      map[string]int // calls per endpoint class since midnight UTC
+	TodayTotal int
+	Total      int // calls since the server started
+	LastCall   time.Time
+}
+
+var (
+	usageMu  sync.Mutex
+	usage    = make(map[string]*Usage)
+	usageDay string // the UTC date Today counters belong to
+)
+
+// recordCall counts one API request against the user in ctx
+func recordCall(ctx context.Context, rawURL string) {
+	userID := userFromContext(ctx)
+	if userID == "" {
+		userID = "(none)"
+	}
+	class := endpointClass(rawURL)
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	// Start fresh daily counters when the UTC day changes
+	now := time.Now()
+	if day := now.UTC().Format(time.DateOnly); day != usageDay {
+		for _, u := range usage {
+			u.Today = make(map[string]int)
+			u.TodayTotal = 0
+		}
+		usageDay = day
+	}
+
+	u, ok := usage[userID]
+	if !ok {
+		u = &Usage{UserID: userID, Today: make(map[string]int)}
+		usage[userID] = u
+	}
+	u.Today[class]++
+	u.TodayTotal++
+	u.Total++
+	u.LastCall = now
+}
+
+// UsageReport returns the usage of every user that made calls, busiest today first
+func UsageReport() []Usage {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	report := make([]Usage, 0, len(usage))
+	for _, u := range usage {
+		copied := *u
+		copied.Today = make(map[string]int, len(u.Today))
+		for class, n := range u.Today {
+			copied.Today[class] = n
+		}
+		report = append(report, copied)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].TodayTotal > report[j].TodayTotal
+	})
+	return report
+}
+
+// endpointClasses groups API paths (relative to /v1/) by feature; the first matching prefix wins
+var endpointClasses = []struct {
+	prefix string
+	class  string
+}{
+	{"me/player/recently-played", "history"},
+	{"me/player", "player"},
+	{"me/top", "history"},
+	{"me/playlists", "playlists"},
+	{"me/tracks", "library"},
+	{"me/albums", "library"},
+	{"me/following", "library"},
+	{"me/shows", "library"},
+	{"me/episodes", "library"},
+	{"me/audiobooks", "library"},
+	{"me", "profile"},
+	{"playlists", "playlists"},
+	{"users", "playlists"},
+	{"audio-features", "audio"},
+	{"audio-analysis", "audio"},
+	{"recommendations", "recommendations"},
+	{"search", "search"},
+	{"browse", "browse"},
+	{"tracks", "catalog"},
+	{"albums", "catalog"},
+	{"artists", "catalog"},
+	{"shows", "catalog"},
+	{"episodes", "catalog"},
+}
+
+// endpointClass maps an API URL to the feature class it is counted under
+func endpointClass(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "other"
+	}
+	path := strings.TrimPrefix(u.Path, "/v1/")
+
+	for _, c := range endpointClasses {
+		if path == c.prefix || strings.HasPrefix(path, c.prefix+"/") {
+			return c.class
+		}
+	}
+	return "other"
+}
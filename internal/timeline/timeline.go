@@ -0,0 +1,279 @@
+// Package timeline keeps a log of when tracks entered and left each user's liked songs,
+// so the library can be shown as it was at any date and its growth charted over time.
+//
+// Spotify only tells us when the tracks that are liked now were added, so the log is
+// seeded from that on the first sync and removals are learned from later syncs. A removal
+// is dated when the sync noticed it, which is as precise as the sync interval.
+package timeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jendahorak/bangerid/internal/fileutil"
+	"github.com/jendahorak/bangerid/internal/spotify"
+)
+
+// Event is a track being liked or, with Removed set, unliked
+type Event struct {
+	TrackID string    `json:"track"` // track URI, like spotify.Track.ID
+	At      time.Time `json:"at"`
+	Removed bool      `json:"removed,omitempty"`
+}
+
+// userTimeline is the log of one user
+type userTimeline struct {
+	Events []Event `json:"events"` // sorted by At
+	// Tracks has every track that ever appeared in the log, keyed by URI, so tracks
+	// that were unliked since can still be shown
+	Tracks map[string]spotify.Track `json:"tracks"`
+}
+
+var (
+	mu        sync.Mutex
+	timelines = make(map[string]*userTimeline) // keyed by Spotify user ID
+
+	// storeDir is where timelines are persisted, one JSON file per user.
+	// Empty while running in memory only.
+	storeDir string
+)
+
+// timelineFor returns the user's timeline, creating an empty one if needed. mu must be held.
+func timelineFor(userID string) *userTimeline {
+	t, ok := timelines[userID]
+	if !ok {
+		t = &userTimeline{Tracks: make(map[string]spotify.Track)}
+		timelines[userID] = t
+	}
+	return t
+}
+
+// Load restores all persisted timelines from the data directory and saves every change
+// there from now on.
+func Load(dir string) error {
+	dir = filepath.Join(dir, "timeline")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create timeline directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list timelines: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	storeDir = dir
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		userID, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read timeline: %w", err)
+		}
+		var t userTimeline
+		if err := json.Unmarshal(data, &t); err != nil {
+			// Removals can't be fetched again, so refuse to start rather than overwrite
+			// the file on the next sync
+			return fmt.Errorf("failed to parse timeline of %s: %w", userID, err)
+		}
+		if t.Tracks == nil {
+			t.Tracks = make(map[string]spotify.Track)
+		}
+		timelines[userID] = &t
+	}
+	slog.Info("timelines loaded", "dir", dir, "users", len(timelines))
+	return nil
+}
+
+// save writes the user's timeline to the data directory, if there is one. mu must be held.
+func save(userID string) error {
+	if storeDir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(timelineFor(userID))
+	if err != nil {
+		return fmt.Errorf("failed to encode timeline: %w", err)
+	}
+
+	path := filepath.Join(storeDir, url.PathEscape(userID)+".json")
+	if err := fileutil.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write timeline: %w", err)
+	}
+	return nil
+}
+
+// liked replays the events up to and including at and returns when each track that was
+// liked at that moment was added, keyed by URI
+func (t *userTimeline) liked(at time.Time) map[string]time.Time {
+	liked := make(map[string]time.Time)
+	for _, e := range t.Events {
+		if e.At.After(at) {
+			break
+		}
+		if e.Removed {
+			delete(liked, e.TrackID)
+		} else {
+			liked[e.TrackID] = e.At
+		}
+	}
+	return liked
+}
+
+// Observe compares the user's liked tracks from a fresh sync with the log and records
+// what was added and removed since the last one.
+func Observe(userID string, tracks []spotify.Track, now time.Time) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	t := timelineFor(userID)
+	before := t.liked(now)
+	changed := false
+
+	seen := make(map[string]bool, len(tracks))
+	for _, track := range tracks {
+		seen[track.ID] = true
+		t.Tracks[track.ID] = track
+		if _, ok := before[track.ID]; ok {
+			continue
+		}
+
+		// Date the like from Spotify's added_at, unless that's missing or not after the
+		// track's last event in the log, which would put the like before its own removal
+		at := track.AddedAt
+		if at.IsZero() || at.After(now) || !at.After(lastEvent(t.Events, track.ID)) {
+			at = now
+		}
+		t.Events = append(t.Events, Event{TrackID: track.ID, At: at})
+		changed = true
+	}
+
+	for id := range before {
+		if !seen[id] {
+			t.Events = append(t.Events, Event{TrackID: id, At: now, Removed: true})
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	slices.SortStableFunc(t.Events, func(a, b Event) int {
+		return a.At.Compare(b.At)
+	})
+	return save(userID)
+}
+
+// lastEvent returns when the track last appeared in the log, zero if never
+func lastEvent(events []Event, trackID string) time.Time {
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].TrackID == trackID {
+			return events[i].At
+		}
+	}
+	return time.Time{}
+}
+
+// AsOf returns the user's liked tracks as they were at the given time, most recently
+// added first like Spotify lists them. AddedAt is when the track was liked back then.
+func AsOf(userID string, at time.Time) []spotify.Track {
+	mu.Lock()
+	defer mu.Unlock()
+
+	t := timelineFor(userID)
+	var tracks []spotify.Track
+	for id, addedAt := range t.liked(at) {
+		track := t.Tracks[id]
+		track.AddedAt = addedAt
+		tracks = append(tracks, track)
+	}
+	slices.SortFunc(tracks, func(a, b spotify.Track) int {
+		if c := b.AddedAt.Compare(a.AddedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return tracks
+}
+
+// Month is the state of the library at the end of one calendar month
+type Month struct {
+	Start   time.Time // first instant of the month, in the time zone asked for
+	Liked   int       // liked tracks at the end of the month
+	Added   int
+	Removed int
+}
+
+// Growth returns the size of the user's library month by month, from the month of the
+// first like up to and including the month of now, with months in loc: a song liked on
+// the evening of June 30th in New York was liked in June there, not in July. It's empty
+// until the first sync.
+func Growth(userID string, now time.Time, loc *time.Location) []Month {
+	mu.Lock()
+	defer mu.Unlock()
+
+	events := timelineFor(userID).Events
+	if len(events) == 0 {
+		return nil
+	}
+
+	var months []Month
+	liked := make(map[string]bool)
+	i := 0
+	for start := monthStart(events[0].At, loc); !start.After(now); start = start.AddDate(0, 1, 0) {
+		month := Month{Start: start}
+		end := start.AddDate(0, 1, 0)
+		for ; i < len(events) && events[i].At.Before(end); i++ {
+			e := events[i]
+			if e.Removed {
+				delete(liked, e.TrackID)
+				month.Removed++
+			} else {
+				liked[e.TrackID] = true
+				month.Added++
+			}
+		}
+		month.Liked = len(liked)
+		months = append(months, month)
+	}
+	return months
+}
+
+// monthStart returns the first instant of the month t falls in, in loc
+func monthStart(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+}
+
+// Delete forgets the user's timeline, on disk too.
+func Delete(userID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(timelines, userID)
+	if storeDir == "" {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(storeDir, url.PathEscape(userID)+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete timeline: %w", err)
+	}
+	return nil
+}
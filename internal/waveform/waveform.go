@@ -0,0 +1,181 @@
+// Package waveform turns the 30 second preview MP3s Spotify offers for some tracks
+// into a small amplitude strip that can be drawn as an SVG.
+package waveform
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/jendahorak/bangerid/internal/metrics"
+)
+
+// Buckets is the number of bars in a waveform strip
+const Buckets = 120
+
+const (
+	maxPreviewBytes  = 4 << 20   // previews are ~350 KB, anything bigger isn't one
+	retryFailedAfter = time.Hour // don't hammer previews that failed to decode
+	queueSize        = 256       // requests beyond this are dropped and retried later
+)
+
+type job struct {
+	trackID    string
+	previewURL string
+}
+
+var (
+	mu      sync.Mutex
+	peaks   = make(map[string][]float64) // computed strips, keyed by track ID
+	pending = make(map[string]bool)      // queued or being computed
+	failed  = make(map[string]time.Time) // when computing last failed
+
+	queue   = make(chan job, queueSize)
+	startMu sync.Once
+
+	// Downloads get their own client so a slow CDN can't block a worker forever
+	previewClient = &http.Client{Timeout: 20 * time.Second}
+)
+
+// Start launches the background workers that download and analyze previews.
+// Calling it more than once has no effect.
+func Start(workers int) {
+	startMu.Do(func() {
+		metrics.PoolSize.Set(float64(workers), "waveform")
+		for i := 0; i < workers; i++ {
+			go worker()
+		}
+	})
+}
+
+// Get returns the computed waveform of a track: Buckets values between 0 and 1.
+func Get(trackID string) ([]float64, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	p, ok := peaks[trackID]
+	return p, ok
+}
+
+// Request queues a track's preview for analysis unless its waveform is already
+// known, in progress or recently failed. It never blocks.
+func Request(trackID, previewURL string) {
+	if previewURL == "" {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, done := peaks[trackID]; done || pending[trackID] {
+		return
+	}
+	if failedAt, ok := failed[trackID]; ok && time.Since(failedAt) < retryFailedAfter {
+		return
+	}
+
+	select {
+	case queue <- job{trackID: trackID, previewURL: previewURL}:
+		pending[trackID] = true
+	default:
+		// Queue full, the detail panel will ask again
+	}
+}
+
+// worker computes waveforms from the queue until the process exits
+func worker() {
+	for j := range queue {
+		metrics.PoolBusy.Add(1, "waveform")
+		p, err := compute(j.previewURL)
+		metrics.PoolBusy.Add(-1, "waveform")
+
+		mu.Lock()
+		delete(pending, j.trackID)
+		if err != nil {
+			failed[j.trackID] = time.Now()
+		} else {
+			peaks[j.trackID] = p
+			delete(failed, j.trackID)
+		}
+		mu.Unlock()
+
+		if err != nil {
+			slog.Warn("waveform failed", "track", j.trackID, slog.Any("error", err))
+		}
+	}
+}
+
+// compute downloads a preview and reduces it to Buckets peak amplitudes
+func compute(previewURL string) ([]float64, error) {
+	resp, err := previewClient.Get(previewURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download preview: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("preview download error %d", resp.StatusCode)
+	}
+
+	decoder, err := mp3.NewDecoder(io.LimitReader(resp.Body, maxPreviewBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode preview: %w", err)
+	}
+
+	// The decoder yields 16-bit little endian stereo samples (4 bytes per frame)
+	pcm, err := io.ReadAll(decoder)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("failed to decode preview: %w", err)
+	}
+	frames := len(pcm) / 4
+	if frames < Buckets {
+		return nil, fmt.Errorf("preview too short")
+	}
+
+	result := make([]float64, Buckets)
+	perBucket := frames / Buckets
+	loudest := 0.0
+	for b := 0; b < Buckets; b++ {
+		// RMS of the bucket reads closer to perceived loudness than the raw peak
+		var sum float64
+		for f := b * perBucket; f < (b+1)*perBucket; f++ {
+			left := float64(int16(binary.LittleEndian.Uint16(pcm[f*4:])))
+			right := float64(int16(binary.LittleEndian.Uint16(pcm[f*4+2:])))
+			sample := (left + right) / 2
+			sum += sample * sample
+		}
+		result[b] = math.Sqrt(sum / float64(perBucket))
+		loudest = math.Max(loudest, result[b])
+	}
+
+	// Normalize so quiet masters still fill the strip
+	if loudest > 0 {
+		for b := range result {
+			result[b] /= loudest
+		}
+	}
+	return result, nil
+}
+
+// SVG draws a waveform as a strip of centered bars, width x height user units
+func SVG(peaks []float64, width, height int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg class="waveform" viewBox="0 0 %d %d" preserveAspectRatio="none" xmlns="http://www.w3.org/2000/svg" role="img" aria-label="Waveform of the track preview">`, width, height)
+
+	barWidth := float64(width) / float64(len(peaks))
+	for i, p := range peaks {
+		barHeight := math.Max(1, p*float64(height))
+		fmt.Fprintf(&sb, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f"/>`,
+			float64(i)*barWidth, (float64(height)-barHeight)/2, barWidth*0.7, barHeight)
+	}
+
+	sb.WriteString(`</svg>`)
+	return sb.String()
+}
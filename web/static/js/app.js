@@ -1,13 +1,43 @@
 window.spotifyDeviceId = "";
 window.spotifyPlayer = null;
+
+// Report events to the server so client-side playback problems show up in its logs.
+// sendBeacon survives page unloads and doesn't block anything.
+function reportEvent(type, fields = {}) {
+  const body = JSON.stringify([{ type, ...fields }]);
+  navigator.sendBeacon("/events", new Blob([body], { type: "application/json" }));
+}
+// Fetch an access token for the SDK from the server. A forced fetch makes the server
+// refresh the token even if it thinks the current one is still valid.
+async function fetchPlayerToken(force = false) {
+  const resp = await fetch("/player/token", {
+    method: force ? "POST" : "GET",
+    headers: { "HX-Request": "true" },
+  });
+  if (!resp.ok) throw new Error(`token request failed: ${resp.status}`);
+  const { access_token } = await resp.json();
+  window.spotifyToken = access_token;
+  return access_token;
+}
+
 window.onSpotifyWebPlaybackSDKReady = () => {
-  const token = window.spotifyToken;
-  if (!token) return;
+  if (!window.spotifyToken) return;
+
+  // The page embeds a token for the first connect; afterwards the SDK asks again
+  // whenever it needs one (roughly hourly) and we get a fresh one from the server
+  let useEmbeddedToken = true;
 
   window.spotifyPlayer = new Spotify.Player({
     name: "Bangerid Web Player",
     getOAuthToken: (cb) => {
-      cb(token);
+      if (useEmbeddedToken) {
+        useEmbeddedToken = false;
+        cb(window.spotifyToken);
+        return;
+      }
+      fetchPlayerToken()
+        .then(cb)
+        .catch((err) => console.error("Failed to get player token", err));
     },
     volume: 0.5,
   });
@@ -16,26 +46,48 @@ window.onSpotifyWebPlaybackSDKReady = () => {
   window.spotifyPlayer.addListener("ready", ({ device_id }) => {
     console.log("Ready with Device ID", device_id);
     window.spotifyDeviceId = device_id;
+    reportEvent("device_ready", { device_id });
   });
 
   // Not Ready
   window.spotifyPlayer.addListener("not_ready", ({ device_id }) => {
     console.log("Device ID has gone offline", device_id);
+    reportEvent("device_offline", { device_id });
   });
 
   window.spotifyPlayer.addListener("initialization_error", ({ message }) => {
     console.error("Failed to initialize", message);
+    reportEvent("sdk_error", { kind: "initialization", message });
   });
 
   window.spotifyPlayer.addListener("authentication_error", ({ message }) => {
     console.error("Failed to authenticate", message);
+    reportEvent("sdk_error", { kind: "authentication", message });
+
+    // The token was rejected: have the server refresh it and reconnect, instead of
+    // leaving a dead player behind
+    fetchPlayerToken(true)
+      .then(() => {
+        window.spotifyPlayer.disconnect();
+        window.spotifyPlayer.connect();
+      })
+      .catch((err) => console.error("Failed to recover player", err));
   });
 
   window.spotifyPlayer.addListener("account_error", ({ message }) => {
     console.error("Failed to validate Spotify account", message);
+    reportEvent("sdk_error", { kind: "account", message });
+  });
+
+  window.spotifyPlayer.addListener("playback_error", ({ message }) => {
+    console.error("Failed to perform playback", message);
+    reportEvent("sdk_error", { kind: "playback", message });
   });
 
   window.spotifyPlayer.addListener("player_state_changed", (state) => {
+    // The server renders the now-playing bar, have it look again
+    htmx.trigger(document.body, "playback-changed");
+
     if (!state || !state.track_window.current_track) return;
 
     const currentTrack = state.track_window.current_track;
@@ -65,6 +117,16 @@ window.onSpotifyWebPlaybackSDKReady = () => {
       }
     });
 
+    // Remember where playback is so the progress bars can move between state changes
+    window.playback = {
+      uris: activeURIs,
+      position: state.position,
+      duration: state.duration,
+      paused: state.paused,
+      at: performance.now(),
+    };
+    renderProgress();
+
     // 4. Update the active card
     if (activeCard) {
       // Remove loading state once SDK confirms playback
@@ -91,9 +153,15 @@ document.addEventListener("click", (e) => {
 
   if (button) {
     if (button.classList.contains("pause-btn")) {
-      if (window.spotifyPlayer) {
+      if (window.spotifyPlayer && window.spotifyDeviceId) {
         window.spotifyPlayer.togglePlay();
+        return;
       }
+      // Without the in-browser player, pause or resume whichever device is playing
+      const paused = button.classList.toggle("is-paused");
+      htmx
+        .ajax("POST", paused ? "/pause" : "/play", { swap: "none" })
+        .then(() => htmx.trigger(document.body, "playback-changed"));
       return;
     }
 
@@ -125,11 +193,205 @@ document.addEventListener("click", (e) => {
   }
 });
 
+// Picking the paused track again resumes it where it stopped instead of starting it over
+document.body.addEventListener("htmx:configRequest", (e) => {
+  const card = e.detail.elt;
+  if (!card.classList.contains("song-card") || !window.playback) return;
+  if (window.playback.paused && window.playback.uris.has(card.dataset.trackId)) {
+    e.detail.path = "/play";
+  }
+});
+
 // Add loading state when HTMX request completes but SDK hasn't confirmed yet
 document.body.addEventListener("htmx:afterRequest", (e) => {
   const card = e.detail.elt;
-  if (card && card.classList.contains("song-card")) {
+  if (card && card.classList.contains("song-card") && card.dataset.trackId) {
     // Add loading state that will be removed when SDK fires player_state_changed
     card.classList.add("is-loading");
+
+    // Show the details of the track that was just picked
+    const trackId = card.dataset.trackId.split(":").pop();
+    htmx.ajax("GET", `/tracks/${trackId}`, "#track-detail");
+  }
+});
+
+// Error responses that carry a toast, e.g. a like Spotify refused, show it for a while.
+// htmx doesn't swap them, the element that made the request rolled itself back already.
+document.body.addEventListener("htmx:responseError", (e) => {
+  const xhr = e.detail.xhr;
+  const toasts = document.getElementById("toasts");
+  if (!toasts || !(xhr.getResponseHeader("Content-Type") || "").startsWith("text/html")) return;
+
+  const template = document.createElement("template");
+  template.innerHTML = xhr.responseText;
+  const toast = template.content.querySelector(".toast");
+  if (!toast) return;
+  toasts.append(toast);
+  setTimeout(() => toast.remove(), 6000);
+});
+
+// Show the grid of another track source, e.g. "playlist:<id>" or "" for the liked songs.
+// It goes through the filter form so search, sort and export keep applying to it.
+function showSource(source) {
+  document.getElementById("grid-source").value = source;
+  htmx.trigger(".mix-filter", "submit");
+}
+
+// Highlight the library section tab whose grid is shown
+document.body.addEventListener("click", (e) => {
+  const tab = e.target.closest(".section-tab");
+  if (!tab) return;
+
+  document
+    .querySelectorAll(".section-tab.is-active")
+    .forEach((el) => el.classList.remove("is-active"));
+  tab.classList.add("is-active");
+});
+
+// Copy the share link next to the button and confirm it on the button itself
+function copyLink(button) {
+  const input = button.parentElement.querySelector("input");
+  navigator.clipboard
+    .writeText(input.value)
+    .then(() => {
+      button.textContent = "Copied";
+      setTimeout(() => (button.textContent = "Copy"), 1500);
+    })
+    .catch(() => input.select());
+}
+
+// Ctrl-K (Cmd-K on macOS) opens the command palette, Enter runs its first result and
+// running any result closes it
+document.addEventListener("keydown", (e) => {
+  const palette = document.getElementById("command-palette");
+  if (!palette) return;
+
+  if (e.key === "k" && (e.ctrlKey || e.metaKey)) {
+    e.preventDefault();
+    palette.showModal();
+    palette.querySelector("input").select();
+    return;
+  }
+  if (e.key === "Enter" && palette.open && e.target.matches("#command-palette input")) {
+    e.preventDefault();
+    const first = palette.querySelector(".command-result");
+    if (first) first.click();
   }
 });
+document.body.addEventListener("click", (e) => {
+  if (e.target.closest(".command-result")) {
+    document.getElementById("command-palette").close();
+  }
+});
+
+// Tracks picked for the DJ set builder, kept across reloads
+window.setSelection = JSON.parse(localStorage.getItem("setSelection") || "[]");
+
+function renderSetTray() {
+  const tray = document.getElementById("set-tray");
+  if (!tray) return;
+  tray.hidden = window.setSelection.length === 0;
+  document.getElementById("set-count").textContent = window.setSelection.length;
+  localStorage.setItem("setSelection", JSON.stringify(window.setSelection));
+}
+
+function addToSet(trackId) {
+  if (!window.setSelection.includes(trackId)) {
+    window.setSelection.push(trackId);
+  }
+  renderSetTray();
+}
+
+function clearSet() {
+  window.setSelection = [];
+  renderSetTray();
+}
+
+document.addEventListener("DOMContentLoaded", renderSetTray);
+
+// Measure how long the grid takes from request to tiles on screen
+let gridRequestedAt = 0;
+document.body.addEventListener("htmx:beforeRequest", (e) => {
+  if (e.detail.target && e.detail.target.id === "songs-grid") {
+    gridRequestedAt = performance.now();
+  }
+});
+document.body.addEventListener("htmx:afterSwap", (e) => {
+  if (e.detail.target && e.detail.target.id === "songs-grid" && gridRequestedAt) {
+    reportEvent("grid_rendered", {
+      duration_ms: Math.round(performance.now() - gridRequestedAt),
+      tiles: e.detail.target.querySelectorAll(".song-card").length,
+    });
+    gridRequestedAt = 0;
+  }
+});
+
+// formatDuration mirrors the server's duration template helper, e.g. 3:07
+function formatDuration(ms) {
+  const s = Math.floor(ms / 1000);
+  const pad = (n) => String(n).padStart(2, "0");
+  if (s >= 3600) {
+    return `${Math.floor(s / 3600)}:${pad(Math.floor(s / 60) % 60)}:${pad(s % 60)}`;
+  }
+  return `${Math.floor(s / 60)}:${pad(s % 60)}`;
+}
+
+// Move the progress bars of the playing tile and, if it shows that track, the detail
+// panel. The SDK only reports the position on state changes, so extrapolate from the
+// last one while playing.
+function renderProgress() {
+  const pb = window.playback;
+  if (!pb) return;
+
+  let position = pb.position;
+  if (!pb.paused) position += performance.now() - pb.at;
+
+  document.querySelectorAll(".song-card.is-playing, .detail-progress").forEach((el) => {
+    if (!pb.uris.has(el.dataset.trackId)) {
+      el.style.removeProperty("--progress");
+      return;
+    }
+    // The SDK's duration is exact; the one from the library is the fallback
+    const duration = pb.duration || parseInt(el.dataset.durationMs) || 0;
+    if (!duration) return;
+    const elapsed = Math.min(position, duration);
+    el.style.setProperty("--progress", `${(elapsed / duration) * 100}%`);
+
+    const label = el.querySelector(".progress-elapsed");
+    if (label) label.textContent = formatDuration(elapsed);
+  });
+}
+setInterval(renderProgress, 500);
+
+// Let the server know the player page is open, so background refreshes run only for
+// people actually using the app. Hidden tabs stop pinging.
+function sendHeartbeat() {
+  if (!window.spotifyToken || document.visibilityState !== "visible") return;
+  fetch("/heartbeat", { method: "POST", headers: { "HX-Request": "true" } }).catch(
+    () => {},
+  );
+}
+sendHeartbeat();
+setInterval(sendHeartbeat, 60 * 1000);
+document.addEventListener("visibilitychange", sendHeartbeat);
+
+// Download the grid as shown, filters and sort order included, as a CSV track list
+function downloadCSV() {
+  const filters = new URLSearchParams(new FormData(document.querySelector(".mix-filter")));
+  location.href = "/export/apple-music.csv?" + filters;
+}
+
+// Sign in to Apple Music with MusicKit and save the grid as shown as a playlist there
+async function exportToAppleMusic(button) {
+  button.disabled = true;
+  try {
+    const userToken = await MusicKit.getInstance().authorize();
+    const values = Object.fromEntries(new FormData(document.querySelector(".mix-filter")));
+    values.music_user_token = userToken;
+    await htmx.ajax("POST", "/export/apple-music", { target: "#track-detail", values });
+  } catch (err) {
+    console.error("Apple Music sign-in failed", err);
+  } finally {
+    button.disabled = false;
+  }
+}